@@ -15,7 +15,8 @@ var (
 
 func main() {
 	debugFlag := pflag.BoolP("debug", "d", false, "Aktivera debug-loggning (skriver debuglogg.txt)")
-	mockFlag := pflag.Bool("mock", false, "Kör i mock-läge med simulerade VMs")
+	mockFlag := pflag.Bool("mock", false, "Kör i mock-läge mot en inbyggd vCenter-simulator (vcsim)")
+	scenarioFlag := pflag.String("scenario", "", "Scenariofil (JSON) för de simulerade gästerna i mock-läge")
 	versionFlag := pflag.BoolP("version", "v", false, "Visa versionsinformation och avsluta")
 
 	pflag.Parse()
@@ -25,7 +26,12 @@ func main() {
 		return
 	}
 
-	app := gui.NewApp(*debugFlag, *mockFlag)
+	if *scenarioFlag != "" && !*mockFlag {
+		fmt.Fprintln(os.Stderr, "--scenario kräver --mock")
+		os.Exit(2)
+	}
+
+	app := gui.NewApp(*debugFlag, *mockFlag, *scenarioFlag)
 	if app == nil {
 		fmt.Fprintln(os.Stderr, "kunde inte skapa GUI-applikationen")
 		os.Exit(1)
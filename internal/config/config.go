@@ -23,14 +23,112 @@ type DefaultsConfig struct {
 	IsoDatastorePath     string `json:"iso_datastore_path"`
 	SkipMemoryInSnapshot bool   `json:"skip_memory_in_snapshot"`
 	GuestUsername        string `json:"guest_username,omitempty"`
+	TargetProfile        string `json:"target_profile,omitempty"` // preselected profile on the upgrade screen
+}
+
+// TargetProfile describes one upgrade target: the ISO to use, what the guest
+// must report once the upgrade is done and which source versions may use it
+type TargetProfile struct {
+	Name           string                    `json:"name"`
+	ISOPath        string                    `json:"iso_path"`
+	TargetOS       string                    `json:"target_os"`                 // must be contained in guestFullName, e.g. "Windows Server 2022"
+	TargetBuild    int                       `json:"target_build,omitempty"`    // expected OS build number, e.g. 20348
+	AllowedSources []string                  `json:"allowed_sources,omitempty"` // guestFullName substrings allowed to upgrade (empty = any)
+	Editions       map[string]EditionMapping `json:"editions,omitempty"`        // keyed by edition, overrides the built-in catalog (custom media)
+}
+
+// EditionMapping is the product key and install.wim image indexes used when
+// upgrading one edition. Zero values keep the built-in catalog value.
+type EditionMapping struct {
+	ProductKey        string `json:"product_key"`
+	ImageIndexCore    int    `json:"image_index_core"`
+	ImageIndexDesktop int    `json:"image_index_desktop"`
 }
 
 // UpgradeConfig for the "upgrade" section
 type UpgradeConfig struct {
-	Parallel       int  `json:"parallel"`
-	Reboot         bool `json:"reboot"`
-	TimeoutMinutes int  `json:"timeout_minutes"`
-	PrecheckDiskGB int  `json:"precheck_disk_gb"`
+	Parallel       int            `json:"parallel"`
+	Reboot         bool           `json:"reboot"`
+	TimeoutMinutes int            `json:"timeout_minutes"`
+	PrecheckDiskGB int            `json:"precheck_disk_gb"`
+	Rollback       RollbackConfig `json:"rollback"`
+
+	// Limits on concurrent upgrades on top of Parallel, so one ESXi host,
+	// datastore or cluster does not take every snapshot and setup.exe at
+	// once (0 = no limit)
+	MaxPerHost      int `json:"max_per_host,omitempty"`
+	MaxPerDatastore int `json:"max_per_datastore,omitempty"`
+	MaxPerCluster   int `json:"max_per_cluster,omitempty"`
+
+	// CompatScan runs setup.exe /Compat ScanOnly before the snapshot and
+	// stops the VM on hard compatibility blocks
+	CompatScan bool `json:"compat_scan"`
+
+	// Inventory captures roles, services, programs, listening ports and
+	// network settings before setup and compares them after the upgrade
+	Inventory bool `json:"inventory"`
+
+	// Health are the checks run in the guest once the upgrade is done
+	Health HealthConfig `json:"health"`
+
+	// Waves rolls a run out in growing batches after a canary group
+	Waves WaveConfig `json:"waves"`
+
+	// ProductKeys replaces the built-in KMS client keys, e.g. with MAK or
+	// organisation KMS keys
+	ProductKeys []ProductKeyOverride `json:"product_keys,omitempty"`
+}
+
+// ProductKeyOverride is the product key used for one edition, for every
+// target or only for one target build
+type ProductKeyOverride struct {
+	Edition     string `json:"edition"`                // "Standard" or "Datacenter"
+	TargetBuild int    `json:"target_build,omitempty"` // 0 = all targets
+	ProductKey  string `json:"product_key"`
+}
+
+// RollbackConfig controls automatic revert to the pre-upgrade snapshot.
+// Rollback is opt-in and only uses a snapshot created by the same run.
+type RollbackConfig struct {
+	Enabled bool     `json:"enabled"`
+	On      []string `json:"on,omitempty"` // failure classes: "setup", "os-check", "boot" (empty = all)
+}
+
+// HealthConfig selects the post-upgrade health checks. A failed check fails
+// the VM but keeps the upgrade, there is no rollback.
+type HealthConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Services      []string `json:"services,omitempty"`  // services that must be running, e.g. "Netlogon"
+	SecureChannel bool     `json:"secure_channel"`      // Test-ComputerSecureChannel on domain members
+	DNS           bool     `json:"dns"`                 // DNSNames resolve
+	DNSNames      []string `json:"dns_names,omitempty"` // names that must resolve (empty = the computer's domain)
+	Gateway       bool     `json:"gateway"`             // the default gateway answers ping
+	Activation    bool     `json:"activation"`          // Windows is licensed
+	PendingReboot bool     `json:"pending_reboot"`      // no reboot is pending
+}
+
+// WaveConfig splits a run into rollout waves: a canary group first, then
+// batches that grow by a factor. A wave with more failures than allowed
+// halts the run, and the operator confirms every new wave.
+type WaveConfig struct {
+	Enabled           bool `json:"enabled"`
+	CanarySize        int  `json:"canary_size"`                   // VMs in the first wave (0 = 1)
+	GrowthFactor      int  `json:"growth_factor,omitempty"`       // each wave is this many times the previous (0 = 2)
+	MaxWaveSize       int  `json:"max_wave_size,omitempty"`       // 0 = no limit
+	MaxFailures       int  `json:"max_failures"`                  // failed VMs a wave may have (0 = none)
+	MaxFailurePercent int  `json:"max_failure_percent,omitempty"` // share of a wave that may fail (0 = not used)
+}
+
+// HookConfig is a script run around the upgrade of every VM: a PowerShell
+// file uploaded to and run in the guest, or a command run on the operator
+// machine with the VM's name, FQDN and IP address in its environment
+type HookConfig struct {
+	Name           string `json:"name"`
+	When           string `json:"when"`                      // "pre" (before setup) or "post" (after the ready signal)
+	GuestScript    string `json:"guest_script,omitempty"`    // local .ps1 file run in the guest
+	Command        string `json:"command,omitempty"`         // command line run on the operator machine
+	TimeoutMinutes int    `json:"timeout_minutes,omitempty"` // 0 = 10 minutes
+	OnFailure      string `json:"on_failure,omitempty"`      // "fail" (default) or "continue"
 }
 
 // TimeoutConfig contains detailed timeout settings
@@ -39,6 +137,7 @@ type TimeoutConfig struct {
 	SignalFilesMinutes  int `json:"signal_files_minutes"`
 	TargetOSMinutes     int `json:"target_os_minutes"`
 	PowerOffMinutes     int `json:"poweroff_minutes"`
+	CompatScanMinutes   int `json:"compat_scan_minutes"`
 }
 
 // LoggingConfig for the "logging" section
@@ -55,12 +154,14 @@ type UIConfig struct {
 
 // AppConfig represents the configuration file structure
 type AppConfig struct {
-	VCenter  VCenterConfig  `json:"vcenter"`
-	Defaults DefaultsConfig `json:"defaults"`
-	Upgrade  UpgradeConfig  `json:"upgrade"`
-	Timeouts TimeoutConfig  `json:"timeouts"`
-	Logging  LoggingConfig  `json:"logging"`
-	UI       UIConfig       `json:"ui"`
+	VCenter  VCenterConfig   `json:"vcenter"`
+	Defaults DefaultsConfig  `json:"defaults"`
+	Upgrade  UpgradeConfig   `json:"upgrade"`
+	Timeouts TimeoutConfig   `json:"timeouts"`
+	Logging  LoggingConfig   `json:"logging"`
+	UI       UIConfig        `json:"ui"`
+	Profiles []TargetProfile `json:"profiles"`
+	Hooks    []HookConfig    `json:"hooks,omitempty"`
 }
 
 const configFileName = "conf.json"
@@ -74,6 +175,16 @@ func GetConfigPath() (string, error) {
 	return filepath.Join(homeDir, configFileName), nil
 }
 
+// GetConfigDir returns the directory holding the configuration file and the
+// other state files kept next to it
+func GetConfigDir() (string, error) {
+	path, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(path), nil
+}
+
 // Load reads the configuration from file
 func Load() (*AppConfig, error) {
 	path, err := GetConfigPath()
@@ -100,6 +211,7 @@ func Load() (*AppConfig, error) {
 	}
 
 	cfg.applyTimeoutDefaults()
+	cfg.applyProfileDefaults()
 
 	return &cfg, nil
 }
@@ -143,12 +255,27 @@ func createDefaultConfig() *AppConfig {
 			Reboot:         true,
 			TimeoutMinutes: 150, // Windows upgrade can take 60-90 min, + snapshot + reboot = 150 min total
 			PrecheckDiskGB: 10,
+			Inventory:      true,
+			Rollback: RollbackConfig{
+				Enabled: false,
+				On:      []string{"setup", "os-check", "boot"},
+			},
+			Health: HealthConfig{
+				Enabled:       false,
+				Services:      []string{"LanmanServer", "LanmanWorkstation", "Dnscache", "EventLog"},
+				SecureChannel: true,
+				DNS:           true,
+				Gateway:       true,
+				Activation:    true,
+				PendingReboot: true,
+			},
 		},
 		Timeouts: TimeoutConfig{
 			SignalScriptSeconds: 30,
 			SignalFilesMinutes:  30,
 			TargetOSMinutes:     20,
 			PowerOffMinutes:     5,
+			CompatScanMinutes:   45,
 		},
 		Logging: LoggingConfig{
 			Level: "info",
@@ -158,6 +285,49 @@ func createDefaultConfig() *AppConfig {
 			Language: "en", // Default to English
 			DarkMode: false,
 		},
+		Profiles: defaultProfiles(),
+	}
+}
+
+// defaultProfiles returns the built-in target profiles
+func defaultProfiles() []TargetProfile {
+	return []TargetProfile{
+		{
+			Name:           "Windows Server 2022",
+			ISOPath:        "[datastore1] iso/windows-server-2022.iso",
+			TargetOS:       "Windows Server 2022",
+			TargetBuild:    20348,
+			AllowedSources: []string{"Windows Server 2016", "Windows Server 2019"},
+		},
+		{
+			Name:           "Windows Server 2025",
+			ISOPath:        "[datastore1] iso/windows-server-2025.iso",
+			TargetOS:       "Windows Server 2025",
+			TargetBuild:    26100,
+			AllowedSources: []string{"Windows Server 2016", "Windows Server 2019", "Windows Server 2022"},
+		},
+	}
+}
+
+// Profile returns the named target profile, or nil if there is none
+func (cfg *AppConfig) Profile(name string) *TargetProfile {
+	for i := range cfg.Profiles {
+		if cfg.Profiles[i].Name == name {
+			return &cfg.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// applyProfileDefaults gives configurations written before target profiles
+// existed the built-in ones, using the saved ISO path for the first
+func (cfg *AppConfig) applyProfileDefaults() {
+	if len(cfg.Profiles) > 0 {
+		return
+	}
+	cfg.Profiles = defaultProfiles()
+	if cfg.Defaults.IsoDatastorePath != "" {
+		cfg.Profiles[0].ISOPath = cfg.Defaults.IsoDatastorePath
 	}
 }
 
@@ -176,4 +346,7 @@ func (cfg *AppConfig) applyTimeoutDefaults() {
 	if cfg.Timeouts.PowerOffMinutes == 0 {
 		cfg.Timeouts.PowerOffMinutes = defaults.PowerOffMinutes
 	}
+	if cfg.Timeouts.CompatScanMinutes == 0 {
+		cfg.Timeouts.CompatScanMinutes = defaults.CompatScanMinutes
+	}
 }
@@ -1,19 +1,28 @@
 package gui
 
 import (
+	"context"
 	"fmt"
 	"image/color"
 	"log"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
 	"github.com/skabbio1976/osupgrader-gui/internal/config"
 	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/journal"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
 	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
-	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/object"
 )
 
+// mockVMCount är antalet VMs i simulatorn i mock mode
+const mockVMCount = 100
+
 // Custom themes för att undvika deprecated warnings
 type darkTheme struct{}
 
@@ -58,13 +67,17 @@ type App struct {
 	config        *config.AppConfig
 	client        *vcenter.Client
 	vms           []vcenter.VMInfo
-	guestPassword string       // Hålls i minnet, sparas ej
-	mockMode      bool         // Mock mode för testing
-	tr            Translations // Current translations
+	guestPassword string             // Hålls i minnet, sparas ej
+	mockMode      bool               // Mock mode mot inbyggd vCenter-simulator
+	sim           *vcenter.Simulator // Simulatorn i mock mode
+	scenarioPath  string             // Scenariofil för simulerade gäster
+	scenario      *upgrade.Scenario  // Simulerade gäster, nil utanför mock mode
+	tr            Translations       // Current translations
+	journal       *journal.Journal   // Körjournal bredvid conf.json
 }
 
 // NewApp skapar en ny GUI-applikation
-func NewApp(debugMode bool, mockMode bool) *App {
+func NewApp(debugMode bool, mockMode bool, scenarioPath string) *App {
 	// Initialisera debug-loggning om debug mode är aktiverad
 	if debugMode {
 		if err := debug.Init(); err != nil {
@@ -73,7 +86,7 @@ func NewApp(debugMode bool, mockMode bool) *App {
 			debug.Log("OSUpgrader GUI Started")
 			debug.Log("Debug log path: %s", debug.GetLogPath())
 			if mockMode {
-				debug.Log("Mock mode ENABLED - using fake VMs")
+				debug.Log("Mock mode ENABLED - using vCenter simulator")
 			}
 		}
 	}
@@ -83,8 +96,9 @@ func NewApp(debugMode bool, mockMode bool) *App {
 	applyDPIScale()
 
 	a := &App{
-		fyneApp:  app.NewWithID("com.example.osupgrader"),
-		mockMode: mockMode,
+		fyneApp:      app.NewWithID("com.example.osupgrader"),
+		mockMode:     mockMode,
+		scenarioPath: scenarioPath,
 	}
 
 	a.window = a.fyneApp.NewWindow("OSUpgrader - Windows Server Upgrade Tool")
@@ -101,6 +115,18 @@ func NewApp(debugMode bool, mockMode bool) *App {
 	}
 	a.config = cfg
 
+	// Öppna körjournalen så avbrutna körningar kan återupptas. Simulatorns
+	// VMs finns bara så länge appen kör, så mock mode journalförs inte.
+	if !mockMode {
+		j, err := journal.Open()
+		if err != nil {
+			debug.LogError("JournalOpen", err)
+		} else {
+			debug.Log("Journal loaded: %d run(s)", len(j.Runs))
+		}
+		a.journal = j
+	}
+
 	// Applicera tema från config
 	if a.config.UI.DarkMode {
 		a.fyneApp.Settings().SetTheme(&darkTheme{})
@@ -138,15 +164,29 @@ func (a *App) Run() {
 		debug.Log("Application window closed")
 	})
 
-	// Om mock mode, generera fake VMs och gå direkt till VM selection
+	// Om mock mode, starta simulatorn och gå direkt till VM selection. Går
+	// simulatorn inte att starta avslutas appen: mock mode har ingen journal
+	// och får aldrig fortsätta mot ett riktigt vCenter.
 	if a.mockMode {
-		a.generateMockVMs()
-		a.showVMSelectionScreen()
+		if err := a.startSimulator(); err != nil {
+			debug.LogError("StartSimulator", err)
+			msg := fmt.Errorf(a.tr.ErrorSimulatorFailed, err)
+			a.window.SetContent(container.NewCenter(widget.NewLabel(msg.Error())))
+			d := dialog.NewError(msg, a.window)
+			d.SetOnClosed(a.fyneApp.Quit)
+			d.Show()
+		} else {
+			a.showVMSelectionScreen()
+		}
 	} else {
 		a.showLoginScreen()
 	}
 
 	a.window.ShowAndRun()
+
+	if a.sim != nil {
+		a.sim.Close()
+	}
 }
 
 // GetConfig returnerar applikationens konfiguration
@@ -179,48 +219,64 @@ func (a *App) GetWindow() fyne.Window {
 	return a.window
 }
 
-// generateMockVMs genererar fake VMs för testing
-func (a *App) generateMockVMs() {
-	debug.Log("Generating mock VMs (srv001-srv100)...")
+// startSimulator startar den inbyggda vCenter-simulatorn (vcsim), lägger
+// simulerade ISO:er för profilerna på dess datastores och loggar in mot
+// den som mot ett riktigt vCenter. Inställningarna för vCenter rörs inte.
+// Scenariot sätts först när inloggningen mot simulatorn lyckats: det får
+// aldrig gälla för riktiga VMs.
+func (a *App) startSimulator() (err error) {
+	defer func() {
+		if err != nil {
+			a.scenario = nil
+		}
+	}()
 
-	folders := []string{"Production/WebServers", "Production/DatabaseServers", "Development/TestServers", "Staging/AppServers"}
-	domains := []string{
-		"verylong.production.subdomain.corporate.infrastructure.example.com",
-		"extremelylong.development.testing.integration.environment.example.com",
-		"superlongname.staging.application.deployment.infrastructure.example.com",
-		"incrediblylong.corporate.business.application.management.example.com",
-	}
-	oses := []string{
-		"Microsoft Windows Server 2016 (64-bit)",
-		"Microsoft Windows Server 2019 (64-bit)",
-		"Microsoft Windows Server 2012 R2 (64-bit)",
+	scenario := upgrade.DefaultScenario()
+	if a.scenarioPath != "" {
+		s, err := upgrade.LoadScenario(a.scenarioPath)
+		if err != nil {
+			return err
+		}
+		scenario = s
+		debug.Log("Scenario loaded from %s: %d VM(s) with own behaviour", a.scenarioPath, len(s.VMs))
 	}
 
-	mockVMs := make([]vcenter.VMInfo, 0, 100)
-
-	for i := 1; i <= 100; i++ {
-		vmName := fmt.Sprintf("srv%03d", i)
-		folder := folders[i%len(folders)]
-		domain := domains[i%len(domains)]
-		os := oses[i%len(oses)]
-
-		// Skapa FQDN från vmName och domain
-		fqdn := fmt.Sprintf("%s.%s", vmName, domain)
+	debug.Log("Starting vCenter simulator (%d VMs)...", mockVMCount)
+	sim, err := vcenter.StartSimulator(mockVMCount)
+	if err != nil {
+		return err
+	}
+	a.sim = sim
 
-		mockVM := vcenter.VMInfo{
-			Name:   vmName,
-			Folder: folder,
-			Domain: fqdn,
-			OS:     os,
-			Ref:    types.ManagedObjectReference{Type: "VirtualMachine", Value: fmt.Sprintf("vm-%d", i)},
+	for _, p := range a.config.Profiles {
+		if err := sim.WriteDatastoreFile(p.ISOPath, upgrade.BuildMediaISO(p.TargetOS, p.TargetBuild)); err != nil {
+			debug.Log("Simulator: no ISO for profile %s: %v", p.Name, err)
 		}
+	}
 
-		mockVMs = append(mockVMs, mockVM)
-
-		// Logga varje mock VM
-		debug.Log("Mock VM created: Name=%s, Folder=%s, Domain=%s, OS=%s", vmName, folder, fqdn, os)
+	cfg := sim.Config()
+	client, err := vcenter.Login(&cfg, sim.Password())
+	if err != nil {
+		return err
+	}
+	vms, err := vcenter.GetVMInfos()
+	if err != nil {
+		return err
 	}
+	a.SetClient(client)
+	a.SetVMs(vms)
+	a.scenario = scenario
+	debug.Log("Time compression of simulated guests: %dx", scenario.TimeCompression)
+	debug.Log("vCenter simulator running at %s: %d VMs", cfg.Host, len(vms))
+	return nil
+}
 
-	a.vms = mockVMs
-	debug.Log("Mock VM generation complete: %d VMs created", len(mockVMs))
+// upgradeVM returnerar VM:en som prechecks och uppgradering körs mot. I mock
+// mode är det en simulerad Windows-gäst i simulatorns VM, enligt scenariot.
+func (a *App) upgradeVM(ctx context.Context, info vcenter.VMInfo, profile config.TargetProfile) (upgrade.VM, error) {
+	vm := upgrade.NewVM(object.NewVirtualMachine(a.GetClient().GetVim(), info.Ref))
+	if a.scenario == nil {
+		return vm, nil
+	}
+	return a.scenario.NewVM(ctx, vm, info.Name, profile)
 }
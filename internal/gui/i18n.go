@@ -24,6 +24,7 @@ type Translations struct {
 	ErrorSSPIFailed         string
 	ErrorLoginFailed        string
 	ErrorLoadVMsFailed      string
+	ErrorSimulatorFailed    string
 
 	// VM Selection screen
 	VMSelectionTitle        string
@@ -42,6 +43,15 @@ type Translations struct {
 	ColumnFolder            string
 	ColumnDomain            string
 	ColumnOS                string
+	ColumnStage             string
+	StagePlaceholder        string
+	AssignStage             string
+	StagesButton            string
+	StagesTitle             string
+	StagesInfo              string
+	StageWaitHealthy        string
+	StageEntry              string // "%d. %s (%d VMs)"
+	NoStages                string
 	Refreshing              string
 	RefreshingMessage       string
 	ErrorRefreshVMs         string
@@ -52,7 +62,35 @@ type Translations struct {
 	GuestAdminUser          string
 	GuestAdminPassword      string
 	ISODatastorePath        string
+	TargetProfile           string
+	SelectProfile           string
+	HooksInvalid            string
+	HooksConfigured         string // "Hooks: %d before setup, %d after the upgrade"
 	CreateSnapshot          string
+	ScheduleEnabled         string
+	ScheduleStart           string
+	ScheduleEnd             string
+	SchedulePlaceholder     string
+	ScheduleEndPlaceholder  string
+	ScheduleInvalid         string
+	ScheduleNoEnd           string
+	ScheduledRun            string // "Run scheduled: start %s, window end %s, %d VMs"
+	ScheduleCountdown       string // "Scheduled start %s - starts in %s"
+	ScheduleWindowOpen      string // "Maintenance window open, no new VMs start after %s"
+	WindowClosed            string // "Maintenance window closed at %s, %d VM(s) not started..."
+	WavesInvalid            string
+	StagesInvalid           string
+	RolloutPlan             string // "Rollout plan: %s"
+	BatchStarting           string // "=== %s (%d of %d): %d VM(s) ==="
+	BatchFinished           string // "Finished %s: %d succeeded, %d failed, %d cancelled"
+	NextWaveTitle           string
+	NextWaveMessage         string
+	WaitingForWave          string // "Waiting for confirmation to start %s"
+	RolloutHalted           string // "After %s: %v. %d VM(s) not started..."
+	BatchWave               string // "wave %d"
+	BatchStage              string // "stage %s"
+	StageNone               string
+	ConcurrencyLimits       string // "Concurrency limits: %s per host, %s per datastore, %s per cluster"
 	SnapshotNamePrefix      string
 	StartUpgrade            string
 	Back                    string
@@ -68,6 +106,20 @@ type Translations struct {
 	ISOValidationFailed     string
 	ISOOK                   string
 	ISOValidated            string
+	ISOInfoButton           string
+	ISOInfoTitle            string
+	ISOReading              string
+	ISOMedia                string // "ISO: %s"
+	ISOImages               string // "Images: %s"
+	ISOMetadataFailed       string // "could not read ISO metadata: %v"
+	ISOBuildMismatch        string // "ISO is build %d but profile %s targets build %d"
+	ColumnIndex             string
+	ColumnEdition           string
+	ColumnInstallType       string
+	ColumnLanguages         string
+	ColumnCategory          string
+	ColumnBefore            string
+	ColumnAfter             string
 	StartingUpgrade         string // "Starting upgrade of %d servers..."
 	UpgradeCompleted        string // "✓ DONE (%s) - Upgrade completed!"
 	UpgradeFailed           string // "❌ FAILED (%s): %v"
@@ -80,6 +132,130 @@ type Translations struct {
 	SummaryFailed           string // "Failed: %d"
 	AllSuccessful           string
 	SomeFailed              string
+	SummaryCancelled        string // "Cancelled: %d"
+	SummaryNotStarted       string // "Not started (window closed): %d"
+	SummaryHalted           string // "Not started (rollout halted): %d"
+	UpgradeCancelled        string // "⏹ CANCELLED (%s): %v"
+
+	// Per-VM status and cancellation
+	CancelAll               string
+	CancelVM                string
+	RetryFailed             string
+	RetryStarting           string // "Retrying %d failed VM(s) from the step they failed in"
+	RetryNoResult           string // "%s has no result to continue from and starts over"
+	StatusQueued            string
+	StatusScheduled         string // "Scheduled %s"
+	StatusWindowClosed      string
+	StatusWaveQueued        string // "Queued (%s)"
+	StatusHalted            string
+	StatusWaitingLimit      string // "Waiting for %s"
+	StatusRunningStep       string // "Running: " + Setup phase
+	StatusRunning           string
+	StatusCancelling        string
+	StatusCancelled         string
+	StatusDone              string
+	StatusFailed            string // "Failed: " + error
+	StatusDeselected        string
+	StatusRollingBack       string
+	StatusRolledBack        string // "↩ Rolled back to %s"
+	RolledBack              string // "↩ ROLLED BACK (%s) to snapshot %s, original OS verified"
+	RollbackFailed          string // "⚠ ROLLBACK FAILED (%s): %v"
+	StatusCompatBlocked     string // "✗ Blocked by %d compatibility issues"
+	CompatScanResult        string // "[%s] Compatibility scan: %s"
+	CompatHardBlock         string // "Hard block: %s"
+	StatusHealthFailed      string // "⚠ Upgraded, %d health checks failed"
+	InventoryChangesButton  string // "Changes (%d)"
+	InventoryChangesTitle   string // "Changes on %s"
+	InventoryChangeCount    string // "%d changes between before setup and after the upgrade"
+	InventoryNoChanges      string
+	InventoryResult         string // "[%s] Guest inventory: %d changes"
+	InventoryRemoved        string
+	InventoryFeature        string
+	InventoryService        string
+	InventoryProgram        string
+	InventoryPort           string
+	InventoryNetwork        string
+	HealthResult            string // "[%s] Health checks: %s"
+	GuestLogsLink           string
+	GuestLogsCollected      string // "[%s] Setup logs copied to %s"
+	StatusSetupError        string // "✗ Setup %s (%s)"
+	SetupErrorDecoded       string // "[%s] Setup result %s: %s"
+	SetupRemediation        string // "Suggested fix: %s"
+	SetupCategoryCompat     string
+	SetupCategoryDriver     string
+	SetupCategoryDiskSpace  string
+	SetupCategoryMedia      string
+	SetupCategoryOther      string
+	PhaseDownlevel          string
+	PhaseSafeOS             string
+	PhaseFirstBoot          string
+	PhaseSecondBoot         string
+	EventStepStarted        string // "[%s] ▶ %s"
+	EventStepFailed         string // "[%s] ✗ %s: %v"
+	EventWarning            string // "[%s] ⚠ %s"
+	EventGuestOutput        string // "[%s] guest: %s"
+	EventHookOutput         string // "[%s] hook %s"
+	EventMessage            string // "[%s] %s"
+	DashColumnVM            string
+	DashColumnStatus        string
+	DashColumnStep          string
+	DashColumnElapsed       string
+	DashColumnMessage       string
+	DashColumnWarnings      string
+	DashboardHint           string
+	RunLogButton            string
+	RunLogTitle             string
+	DetailsTitle            string // "Details for %s"
+	DetailsTimeline         string
+	DetailsEvents           string
+	DetailsGuestLogs        string
+	DetailsLogsFolder       string
+	DetailsNoGuestOutput    string
+	DetailsColumnStep       string
+	DetailsColumnStatus     string
+	DetailsColumnStart      string
+	DetailsColumnDuration   string
+	DetailsColumnNote       string
+	DetailsAttempts         string
+	AttemptEntry            string // "Attempt %d from %s, %s: %s"
+	AttemptRunning          string
+	AttemptInterrupted      string
+	VMAttempt               string // "%s (attempt %d)"
+	StepPending             string
+	StepInProgress          string
+	StepCompleted           string
+	StepFailed              string
+	StepSkipped             string
+	StepCancelled           string
+
+	// Preflight (dry run)
+	PreflightButton         string
+	PreflightRunning        string // "Running preflight on %d VMs..."
+	PreflightTitle          string
+	PreflightSummary        string // "%d of %d VMs ready"
+	PreflightApply          string
+	PreflightSelection      string // "%d of %d VMs selected for the upgrade"
+	ColumnPower             string
+	ColumnTools             string
+	ColumnSourceOS          string
+	ColumnCredentials       string
+	ColumnCDROM             string
+	ColumnDisk              string
+	ColumnDatastore         string
+	ColumnMedia             string
+	ColumnISO               string
+	ColumnReady             string
+	ReadyYes                string
+	ReadyNo                 string
+
+	// Reattach to unfinished runs
+	ReattachTitle           string
+	ReattachMessage         string // "Run %s started %s (ISO %s) was interrupted with %d unfinished VM(s)..."
+	ReattachScheduled       string // "Scheduled start %s, window end %s."
+	ReattachButton          string
+	DiscardButton           string
+	LaterButton             string
+	ReattachInfo            string // "Resuming interrupted run %s..."
 
 	// Snapshot management screen
 	SnapshotsTitle          string
@@ -116,10 +292,40 @@ type Translations struct {
 	ISOPath                 string
 	ParallelUpgrades        string
 	ParallelUpgradesInfo    string
+	MaxPerHost              string
+	MaxPerDatastore         string
+	MaxPerCluster           string
+	ConcurrencyInfo         string
 	TimeoutMinutes          string
 	DiskPrecheckGB          string
 	SkipMemoryInSnapshot    string
 	RebootAfterUpgrade      string
+	RollbackEnabled         string
+	RollbackInfo            string
+	RollbackOnSetup         string
+	RollbackOnOSCheck       string
+	RollbackOnBoot          string
+	CompatScanEnabled       string
+	CompatScanInfo          string
+	CompatScanMinutes       string
+	InventoryEnabled        string
+	InventoryInfo           string
+	HealthEnabled           string
+	HealthInfo              string
+	HealthServices          string
+	HealthSecureChannel     string
+	HealthDNS               string
+	HealthDNSNames          string
+	HealthGateway           string
+	HealthActivation        string
+	HealthPendingReboot     string
+	WavesEnabled            string
+	WavesInfo               string
+	WaveCanarySize          string
+	WaveGrowthFactor        string
+	WaveMaxSize             string
+	WaveMaxFailures         string
+	WaveMaxFailurePct       string
 	SignalScriptSeconds     string
 	SignalFilesMinutes      string
 	OSVersionPollingMinutes string
@@ -157,6 +363,7 @@ var englishTranslations = Translations{
 	ErrorSSPIFailed:         "SSPI login failed: %v - NOTE: SSPI/Kerberos only works on Windows and requires you to be logged in with a domain account",
 	ErrorLoginFailed:        "login failed: %v",
 	ErrorLoadVMsFailed:      "could not load VMs: %v",
+	ErrorSimulatorFailed:    "could not start the vCenter simulator: %v",
 
 	// VM Selection screen
 	VMSelectionTitle:        "Select VMs to Upgrade",
@@ -175,6 +382,15 @@ var englishTranslations = Translations{
 	ColumnFolder:            "Folder",
 	ColumnDomain:            "Domain",
 	ColumnOS:                "OS",
+	ColumnStage:             "Stage",
+	StagePlaceholder:        "Stage, e.g. database",
+	AssignStage:             "Set stage for selected",
+	StagesButton:            "Stage order...",
+	StagesTitle:             "Stage order",
+	StagesInfo:              "Stages are upgraded one after another in this order. VMs without a stage go last.",
+	StageWaitHealthy:        "Wait until all are upgraded and healthy",
+	StageEntry:              "%d. %s (%d VMs)",
+	NoStages:                "No stages yet. Enter a stage name and set it for the selected VMs.",
 	Refreshing:              "Refreshing...",
 	RefreshingMessage:       "Fetching VM list from vCenter...",
 	ErrorRefreshVMs:         "could not fetch VMs: %v",
@@ -185,7 +401,35 @@ var englishTranslations = Translations{
 	GuestAdminUser:          "Guest admin user",
 	GuestAdminPassword:      "Guest admin password",
 	ISODatastorePath:        "ISO datastore path",
+	TargetProfile:           "Target profile",
+	SelectProfile:           "Select a target profile (profiles are defined in conf.json)",
+	HooksInvalid:            "Invalid hook in conf.json",
+	HooksConfigured:         "Hooks: %d before setup, %d after the upgrade",
 	CreateSnapshot:          "Create snapshot before upgrade",
+	ScheduleEnabled:         "Schedule the start in a maintenance window",
+	ScheduleStart:           "Start",
+	ScheduleEnd:             "Window end",
+	SchedulePlaceholder:     "YYYY-MM-DD HH:MM or HH:MM",
+	ScheduleEndPlaceholder:  "optional, YYYY-MM-DD HH:MM or HH:MM",
+	ScheduleInvalid:         "Invalid schedule",
+	ScheduleNoEnd:           "none",
+	ScheduledRun:            "Run scheduled: start %s, window end %s, %d VMs",
+	ScheduleCountdown:       "Scheduled start %s - starts in %s",
+	ScheduleWindowOpen:      "Maintenance window open, no new VMs start after %s",
+	WindowClosed:            "Maintenance window closed at %s, %d VM(s) not started. They are kept in the journal and can be scheduled again.",
+	WavesInvalid:            "Invalid wave settings",
+	StagesInvalid:           "Invalid stages",
+	RolloutPlan:             "Rollout plan: %s",
+	BatchStarting:           "=== %s (%d of %d): %d VM(s) ===",
+	BatchFinished:           "Finished %s: %d succeeded, %d failed, %d cancelled",
+	NextWaveTitle:           "Start next wave?",
+	NextWaveMessage:         "Finished %s: %d succeeded, %d failed.\n\nStart %s with %d VM(s)?",
+	WaitingForWave:          "Waiting for confirmation to start %s",
+	RolloutHalted:           "After %s: %v. %d VM(s) not started, they are kept in the journal.",
+	BatchWave:               "wave %d",
+	BatchStage:              "stage %s",
+	StageNone:               "VMs without a stage",
+	ConcurrencyLimits:       "Concurrency limits: %s per host, %s per datastore, %s per cluster",
 	SnapshotNamePrefix:      "Snapshot name prefix",
 	StartUpgrade:            "Start upgrade",
 	Back:                    "Back",
@@ -201,6 +445,20 @@ var englishTranslations = Translations{
 	ISOValidationFailed:     "ISO validation failed",
 	ISOOK:                   "ISO OK. Starting upgrades...",
 	ISOValidated:            "ISO validated",
+	ISOInfoButton:           "ISO info",
+	ISOInfoTitle:            "Installation media",
+	ISOReading:              "Reading ISO metadata...",
+	ISOMedia:                "ISO: %s",
+	ISOImages:               "Images: %s",
+	ISOMetadataFailed:       "WARNING: could not read ISO metadata, edition and language are not verified: %v",
+	ISOBuildMismatch:        "ISO is build %d but profile %s targets build %d",
+	ColumnIndex:             "Index",
+	ColumnEdition:           "Edition",
+	ColumnInstallType:       "Type",
+	ColumnLanguages:         "Languages",
+	ColumnCategory:          "Category",
+	ColumnBefore:            "Before",
+	ColumnAfter:             "After",
 	StartingUpgrade:         "Starting upgrade of %d servers...\n\n",
 	UpgradeCompleted:        "✓ DONE (%s) - Upgrade completed!",
 	UpgradeFailed:           "❌ FAILED (%s): %v",
@@ -213,6 +471,130 @@ var englishTranslations = Translations{
 	SummaryFailed:           "Failed: %d",
 	AllSuccessful:           "Status: All upgrades completed successfully!",
 	SomeFailed:              "Status: Some upgrades failed, see log above for details",
+	SummaryCancelled:        "Cancelled: %d",
+	SummaryNotStarted:       "Not started (window closed): %d",
+	SummaryHalted:           "Not started (rollout halted): %d",
+	UpgradeCancelled:        "⏹ CANCELLED (%s): %v",
+
+	// Per-VM status and cancellation
+	CancelAll:               "Cancel all",
+	CancelVM:                "Cancel",
+	RetryFailed:             "Retry failed",
+	RetryStarting:           "Retrying %d failed VM(s) from the step they failed in",
+	RetryNoResult:           "%s has no result to continue from and starts over",
+	StatusQueued:            "Queued",
+	StatusScheduled:         "Scheduled %s",
+	StatusWindowClosed:      "Not started, maintenance window closed",
+	StatusWaveQueued:        "Queued (%s)",
+	StatusHalted:            "Not started, rollout halted",
+	StatusWaitingLimit:      "Waiting for %s",
+	StatusRunningStep:       "Running: ",
+	StatusRunning:           "Running",
+	StatusCancelling:        "Cancelling...",
+	StatusCancelled:         "⏹ Cancelled",
+	StatusDone:              "✓ Done",
+	StatusFailed:            "❌ Failed: ",
+	StatusDeselected:        "Deselected after preflight",
+	StatusRollingBack:       "Rolling back to snapshot...",
+	StatusRolledBack:        "↩ Failed, rolled back to %s",
+	RolledBack:              "↩ ROLLED BACK (%s) to snapshot %s, original OS verified",
+	RollbackFailed:          "⚠ ROLLBACK FAILED (%s): %v - check the VM manually",
+	StatusCompatBlocked:     "✗ Blocked by %d compatibility issues",
+	CompatScanResult:        "[%s] Compatibility scan: %s",
+	CompatHardBlock:         "Hard block: %s",
+	StatusHealthFailed:      "⚠ Upgraded, %d health checks failed",
+	InventoryChangesButton:  "Changes (%d)",
+	InventoryChangesTitle:   "Changes on %s",
+	InventoryChangeCount:    "%d changes between before setup and after the upgrade",
+	InventoryNoChanges:      "No changes between the inventory before setup and after the upgrade.",
+	InventoryResult:         "[%s] Guest inventory: %d changes",
+	InventoryRemoved:        "removed",
+	InventoryFeature:        "Role/feature",
+	InventoryService:        "Service",
+	InventoryProgram:        "Program",
+	InventoryPort:           "Port",
+	InventoryNetwork:        "Network",
+	HealthResult:            "[%s] Health checks: %s",
+	GuestLogsLink:           "Logs",
+	GuestLogsCollected:      "[%s] Setup logs copied to %s",
+	StatusSetupError:        "✗ Setup %s (%s)",
+	SetupErrorDecoded:       "[%s] Setup result %s: %s",
+	SetupRemediation:        "Suggested fix: %s",
+	SetupCategoryCompat:     "compatibility block",
+	SetupCategoryDriver:     "driver rollback",
+	SetupCategoryDiskSpace:  "disk space",
+	SetupCategoryMedia:      "media mismatch",
+	SetupCategoryOther:      "other",
+	PhaseDownlevel:          "Downlevel",
+	PhaseSafeOS:             "Safe OS",
+	PhaseFirstBoot:          "First boot",
+	PhaseSecondBoot:         "Second boot",
+	EventStepStarted:        "[%s] ▶ %s",
+	EventStepFailed:         "[%s] ✗ %s: %v",
+	EventWarning:            "[%s] ⚠ %s",
+	EventGuestOutput:        "[%s] guest: %s",
+	EventHookOutput:         "[%s] hook %s",
+	EventMessage:            "[%s] %s",
+	DashColumnVM:            "VM",
+	DashColumnStatus:        "Status",
+	DashColumnStep:          "Step",
+	DashColumnElapsed:       "Elapsed",
+	DashColumnMessage:       "Last message",
+	DashColumnWarnings:      "Warnings",
+	DashboardHint:           "Double-click a VM for its step timeline and guest logs.",
+	RunLogButton:            "Run log",
+	RunLogTitle:             "Run log",
+	DetailsTitle:            "Details for %s",
+	DetailsTimeline:         "Step timeline",
+	DetailsEvents:           "Events",
+	DetailsGuestLogs:        "Guest logs",
+	DetailsLogsFolder:       "Setup logs copied to:",
+	DetailsNoGuestOutput:    "No output from the guest in this run.",
+	DetailsColumnStep:       "Step",
+	DetailsColumnStatus:     "Status",
+	DetailsColumnStart:      "Started",
+	DetailsColumnDuration:   "Duration",
+	DetailsColumnNote:       "Error or warning",
+	DetailsAttempts:         "Attempts",
+	AttemptEntry:            "Attempt %d from %s, %s: %s",
+	AttemptRunning:          "running",
+	AttemptInterrupted:      "interrupted",
+	VMAttempt:               "%s (attempt %d)",
+	StepPending:             "Pending",
+	StepInProgress:          "Running",
+	StepCompleted:           "Completed",
+	StepFailed:              "Failed",
+	StepSkipped:             "Skipped",
+	StepCancelled:           "Cancelled",
+
+	// Preflight (dry run)
+	PreflightButton:         "Preflight (dry run)",
+	PreflightRunning:        "Running preflight on %d VMs (nothing is changed)...",
+	PreflightTitle:          "Readiness report",
+	PreflightSummary:        "Preflight: %d of %d VMs ready",
+	PreflightApply:          "Use selection",
+	PreflightSelection:      "%d of %d VMs selected for the upgrade",
+	ColumnPower:             "Power",
+	ColumnTools:             "VMware Tools",
+	ColumnSourceOS:          "Source OS",
+	ColumnCredentials:       "Credentials",
+	ColumnCDROM:             "CD/DVD",
+	ColumnDisk:              "Disk",
+	ColumnDatastore:         "Datastore",
+	ColumnMedia:             "Edition/language",
+	ColumnISO:               "ISO",
+	ColumnReady:             "Ready",
+	ReadyYes:                "Yes",
+	ReadyNo:                 "No",
+
+	// Reattach to unfinished runs
+	ReattachTitle:           "Unfinished upgrade run",
+	ReattachMessage:         "Run %s started %s (ISO %s) was interrupted with %d unfinished VM(s). Reattach to continue monitoring them from the step they were in?",
+	ReattachScheduled:       "Scheduled start %s, window end %s.",
+	ReattachButton:          "Reattach",
+	DiscardButton:           "Discard",
+	LaterButton:             "Later",
+	ReattachInfo:            "Resuming interrupted run %s - completed steps will not be repeated. Enter the guest password and click 'Start upgrade' to continue.",
 
 	// Snapshot management screen
 	SnapshotsTitle:          "Manage Snapshots",
@@ -249,10 +631,40 @@ var englishTranslations = Translations{
 	ISOPath:                 "ISO datastore path",
 	ParallelUpgrades:        "Parallel upgrades",
 	ParallelUpgradesInfo:    "Number of VMs upgraded simultaneously (higher value = faster for many VMs)",
+	MaxPerHost:              "Max per ESXi host (0 = no limit)",
+	MaxPerDatastore:         "Max per datastore (0 = no limit)",
+	MaxPerCluster:           "Max per cluster (0 = no limit)",
+	ConcurrencyInfo:         "Upgrades that would exceed a limit wait in the queue while VMs on other hosts and datastores go first. Placement is read from the VM inventory.",
 	TimeoutMinutes:          "Timeout (minutes)",
 	DiskPrecheckGB:          "Disk precheck (GB)",
 	SkipMemoryInSnapshot:    "Skip memory in snapshot",
 	RebootAfterUpgrade:      "Reboot after upgrade",
+	RollbackEnabled:         "Revert to the pre-upgrade snapshot on failure",
+	RollbackInfo:            "Only the snapshot created by the same run is used. The VM is powered on again and the original OS must be reported before the rollback counts as successful.",
+	RollbackOnSetup:         "When the upgrade script or setup.exe fails",
+	RollbackOnOSCheck:       "When the target OS is not reported in time",
+	RollbackOnBoot:          "When the VM does not come back after the reboot",
+	CompatScanEnabled:       "Run a compatibility scan before the snapshot",
+	CompatScanInfo:          "Runs setup.exe /Compat ScanOnly from the ISO. Nothing is installed; VMs with hard blocks stop before the snapshot and the blocks are listed in the log.",
+	CompatScanMinutes:       "Compatibility scan (minutes)",
+	InventoryEnabled:        "Capture a guest inventory before and after the upgrade",
+	InventoryInfo:           "Roles/features, services and start modes, installed programs, listening ports and network settings are read before setup and again after the upgrade. The differences are shown per VM and saved with the run.",
+	HealthEnabled:           "Run health checks after the upgrade",
+	HealthInfo:              "Read-only checks in the upgraded guest. A failed check marks the VM as failed but keeps the upgrade (no rollback); warnings are only logged.",
+	HealthServices:          "Services that must be running (comma-separated)",
+	HealthSecureChannel:     "Domain secure channel (Test-ComputerSecureChannel)",
+	HealthDNS:               "DNS resolution",
+	HealthDNSNames:          "Names to resolve (comma-separated, empty = the computer's domain)",
+	HealthGateway:           "Default gateway answers ping",
+	HealthActivation:        "Windows is activated",
+	HealthPendingReboot:     "No reboot pending",
+	WavesEnabled:            "Roll out in waves, a canary group first",
+	WavesInfo:               "The first wave is the canary group, each following wave grows by the factor. A wave with more failed VMs than allowed halts the run; the remaining VMs stay queued in the journal. You confirm before every new wave.",
+	WaveCanarySize:          "Canary group (VMs)",
+	WaveGrowthFactor:        "Growth factor",
+	WaveMaxSize:             "Largest wave (0 = no limit)",
+	WaveMaxFailures:         "Failed VMs allowed per wave",
+	WaveMaxFailurePct:       "Failed share allowed per wave (%, 0 = not used)",
 	SignalScriptSeconds:     "Signal script (seconds)",
 	SignalFilesMinutes:      "Signal files (minutes)",
 	OSVersionPollingMinutes: "OS version polling (minutes)",
@@ -290,6 +702,7 @@ var swedishTranslations = Translations{
 	ErrorSSPIFailed:         "SSPI-inloggning misslyckades: %v - OBS: SSPI/Kerberos fungerar endast på Windows och kräver att du är inloggad med ett domänkonto",
 	ErrorLoginFailed:        "inloggning misslyckades: %v",
 	ErrorLoadVMsFailed:      "kunde inte hämta VMs: %v",
+	ErrorSimulatorFailed:    "kunde inte starta vCenter-simulatorn: %v",
 
 	// VM Selection screen
 	VMSelectionTitle:        "Välj VMs att uppgradera",
@@ -308,6 +721,15 @@ var swedishTranslations = Translations{
 	ColumnFolder:            "Folder",
 	ColumnDomain:            "Domain",
 	ColumnOS:                "OS",
+	ColumnStage:             "Etapp",
+	StagePlaceholder:        "Etapp, t.ex. databas",
+	AssignStage:             "Sätt etapp för valda",
+	StagesButton:            "Etappordning...",
+	StagesTitle:             "Etappordning",
+	StagesInfo:              "Etapperna uppgraderas en i taget i denna ordning. VMs utan etapp går sist.",
+	StageWaitHealthy:        "Vänta tills alla är uppgraderade och friska",
+	StageEntry:              "%d. %s (%d VMs)",
+	NoStages:                "Inga etapper ännu. Skriv ett etappnamn och sätt det för de valda VMs.",
 	Refreshing:              "Uppdaterar...",
 	RefreshingMessage:       "Hämtar VM-lista från vCenter...",
 	ErrorRefreshVMs:         "kunde inte hämta VMs: %v",
@@ -318,7 +740,35 @@ var swedishTranslations = Translations{
 	GuestAdminUser:          "Guest admin user",
 	GuestAdminPassword:      "Guest admin lösenord",
 	ISODatastorePath:        "ISO datastore path",
+	TargetProfile:           "Målprofil",
+	SelectProfile:           "Välj en målprofil (profiler definieras i conf.json)",
+	HooksInvalid:            "Felaktig hook i conf.json",
+	HooksConfigured:         "Hooks: %d före setup, %d efter uppgraderingen",
 	CreateSnapshot:          "Skapa snapshot före uppgradering",
+	ScheduleEnabled:         "Schemalägg starten i ett underhållsfönster",
+	ScheduleStart:           "Start",
+	ScheduleEnd:             "Fönstret stänger",
+	SchedulePlaceholder:     "ÅÅÅÅ-MM-DD TT:MM eller TT:MM",
+	ScheduleEndPlaceholder:  "valfritt, ÅÅÅÅ-MM-DD TT:MM eller TT:MM",
+	ScheduleInvalid:         "Ogiltig schemaläggning",
+	ScheduleNoEnd:           "inget",
+	ScheduledRun:            "Körning schemalagd: start %s, fönstret stänger %s, %d VMs",
+	ScheduleCountdown:       "Schemalagd start %s - startar om %s",
+	ScheduleWindowOpen:      "Underhållsfönstret är öppet, inga nya VMs startar efter %s",
+	WindowClosed:            "Underhållsfönstret stängde %s, %d VM(s) startades inte. De finns kvar i journalen och kan schemaläggas igen.",
+	WavesInvalid:            "Ogiltiga våginställningar",
+	StagesInvalid:           "Ogiltiga etapper",
+	RolloutPlan:             "Utrullningsplan: %s",
+	BatchStarting:           "=== %s (%d av %d): %d VM(s) ===",
+	BatchFinished:           "Klar med %s: %d lyckades, %d misslyckades, %d avbröts",
+	NextWaveTitle:           "Starta nästa våg?",
+	NextWaveMessage:         "Klar med %s: %d lyckades, %d misslyckades.\n\nStarta %s med %d VM(s)?",
+	WaitingForWave:          "Väntar på bekräftelse för att starta %s",
+	RolloutHalted:           "Efter %s: %v. %d VM(s) startades inte, de finns kvar i journalen.",
+	BatchWave:               "våg %d",
+	BatchStage:              "etapp %s",
+	StageNone:               "VMs utan etapp",
+	ConcurrencyLimits:       "Gränser för samtidiga uppgraderingar: %s per host, %s per datastore, %s per kluster",
 	SnapshotNamePrefix:      "Snapshot-prefix",
 	StartUpgrade:            "Starta uppgradering",
 	Back:                    "Tillbaka",
@@ -334,6 +784,20 @@ var swedishTranslations = Translations{
 	ISOValidationFailed:     "ISO-validering misslyckades",
 	ISOOK:                   "ISO OK. Startar uppgraderingar...",
 	ISOValidated:            "ISO validerad",
+	ISOInfoButton:           "ISO-info",
+	ISOInfoTitle:            "Installationsmedia",
+	ISOReading:              "Läser ISO-metadata...",
+	ISOMedia:                "ISO: %s",
+	ISOImages:               "Avbilder: %s",
+	ISOMetadataFailed:       "VARNING: kunde inte läsa ISO-metadata, edition och språk kontrolleras inte: %v",
+	ISOBuildMismatch:        "ISO:n har build %d men profilen %s har mål-build %d",
+	ColumnIndex:             "Index",
+	ColumnEdition:           "Edition",
+	ColumnInstallType:       "Typ",
+	ColumnLanguages:         "Språk",
+	ColumnCategory:          "Kategori",
+	ColumnBefore:            "Före",
+	ColumnAfter:             "Efter",
 	StartingUpgrade:         "Startar uppgradering av %d servrar...\n\n",
 	UpgradeCompleted:        "✓ KLAR (%s) - Uppgradering slutförd!",
 	UpgradeFailed:           "❌ MISSLYCKADES (%s): %v",
@@ -346,6 +810,130 @@ var swedishTranslations = Translations{
 	SummaryFailed:           "Misslyckades: %d",
 	AllSuccessful:           "Status: Alla uppgraderingar slutförda utan fel!",
 	SomeFailed:              "Status: Vissa uppgraderingar misslyckades, se logg ovan för detaljer",
+	SummaryCancelled:        "Avbrutna: %d",
+	SummaryNotStarted:       "Ej startade (fönstret stängt): %d",
+	SummaryHalted:           "Ej startade (utrullningen stoppad): %d",
+	UpgradeCancelled:        "⏹ AVBRUTEN (%s): %v",
+
+	// Per-VM status and cancellation
+	CancelAll:               "Avbryt alla",
+	CancelVM:                "Avbryt",
+	RetryFailed:             "Försök igen med misslyckade",
+	RetryStarting:           "Försöker igen med %d misslyckade VM(s) från steget där de misslyckades",
+	RetryNoResult:           "%s har inget resultat att fortsätta från och börjar om",
+	StatusQueued:            "I kö",
+	StatusScheduled:         "Schemalagd %s",
+	StatusWindowClosed:      "Ej startad, underhållsfönstret stängt",
+	StatusWaveQueued:        "Köad (%s)",
+	StatusHalted:            "Ej startad, utrullningen stoppad",
+	StatusWaitingLimit:      "Väntar på %s",
+	StatusRunningStep:       "Kör: ",
+	StatusRunning:           "Kör",
+	StatusCancelling:        "Avbryter...",
+	StatusCancelled:         "⏹ Avbruten",
+	StatusDone:              "✓ Klar",
+	StatusFailed:            "❌ Misslyckades: ",
+	StatusDeselected:        "Bortvald efter preflight",
+	StatusRollingBack:       "Återställer till snapshot...",
+	StatusRolledBack:        "↩ Misslyckades, återställd till %s",
+	RolledBack:              "↩ ÅTERSTÄLLD (%s) till snapshot %s, ursprungligt OS verifierat",
+	RollbackFailed:          "⚠ ÅTERSTÄLLNING MISSLYCKADES (%s): %v - kontrollera VM:en manuellt",
+	StatusCompatBlocked:     "✗ Blockerad av %d kompatibilitetsproblem",
+	CompatScanResult:        "[%s] Kompatibilitetsskanning: %s",
+	CompatHardBlock:         "Hårt block: %s",
+	StatusHealthFailed:      "⚠ Uppgraderad, %d hälsokontroller misslyckades",
+	InventoryChangesButton:  "Ändringar (%d)",
+	InventoryChangesTitle:   "Ändringar på %s",
+	InventoryChangeCount:    "%d ändringar mellan före setup och efter uppgraderingen",
+	InventoryNoChanges:      "Inga ändringar mellan inventeringen före setup och efter uppgraderingen.",
+	InventoryResult:         "[%s] Gästinventering: %d ändringar",
+	InventoryRemoved:        "borttagen",
+	InventoryFeature:        "Roll/funktion",
+	InventoryService:        "Tjänst",
+	InventoryProgram:        "Program",
+	InventoryPort:           "Port",
+	InventoryNetwork:        "Nätverk",
+	HealthResult:            "[%s] Hälsokontroller: %s",
+	GuestLogsLink:           "Loggar",
+	GuestLogsCollected:      "[%s] Setup-loggar kopierade till %s",
+	StatusSetupError:        "✗ Setup %s (%s)",
+	SetupErrorDecoded:       "[%s] Setup-resultat %s: %s",
+	SetupRemediation:        "Föreslagen åtgärd: %s",
+	SetupCategoryCompat:     "kompatibilitetsblock",
+	SetupCategoryDriver:     "drivrutinsåterställning",
+	SetupCategoryDiskSpace:  "diskutrymme",
+	SetupCategoryMedia:      "fel media",
+	SetupCategoryOther:      "övrigt",
+	PhaseDownlevel:          "Downlevel",
+	PhaseSafeOS:             "Safe OS",
+	PhaseFirstBoot:          "Första start",
+	PhaseSecondBoot:         "Andra start",
+	EventStepStarted:        "[%s] ▶ %s",
+	EventStepFailed:         "[%s] ✗ %s: %v",
+	EventWarning:            "[%s] ⚠ %s",
+	EventGuestOutput:        "[%s] gäst: %s",
+	EventHookOutput:         "[%s] hook %s",
+	EventMessage:            "[%s] %s",
+	DashColumnVM:            "VM",
+	DashColumnStatus:        "Status",
+	DashColumnStep:          "Steg",
+	DashColumnElapsed:       "Tid",
+	DashColumnMessage:       "Senaste meddelande",
+	DashColumnWarnings:      "Varningar",
+	DashboardHint:           "Dubbelklicka på en VM för dess stegtidslinje och gästloggar.",
+	RunLogButton:            "Körlogg",
+	RunLogTitle:             "Körlogg",
+	DetailsTitle:            "Detaljer för %s",
+	DetailsTimeline:         "Stegtidslinje",
+	DetailsEvents:           "Händelser",
+	DetailsGuestLogs:        "Gästloggar",
+	DetailsLogsFolder:       "Setup-loggar kopierade till:",
+	DetailsNoGuestOutput:    "Ingen utdata från gästen i denna körning.",
+	DetailsColumnStep:       "Steg",
+	DetailsColumnStatus:     "Status",
+	DetailsColumnStart:      "Startat",
+	DetailsColumnDuration:   "Tid",
+	DetailsColumnNote:       "Fel eller varning",
+	DetailsAttempts:         "Försök",
+	AttemptEntry:            "Försök %d från %s, %s: %s",
+	AttemptRunning:          "pågår",
+	AttemptInterrupted:      "avbrutet",
+	VMAttempt:               "%s (försök %d)",
+	StepPending:             "Väntar",
+	StepInProgress:          "Kör",
+	StepCompleted:           "Klart",
+	StepFailed:              "Misslyckades",
+	StepSkipped:             "Överhoppat",
+	StepCancelled:           "Avbrutet",
+
+	// Preflight (dry run)
+	PreflightButton:         "Preflight (torrkörning)",
+	PreflightRunning:        "Kör preflight på %d VMs (inget ändras)...",
+	PreflightTitle:          "Beredskapsrapport",
+	PreflightSummary:        "Preflight: %d av %d VMs redo",
+	PreflightApply:          "Använd urval",
+	PreflightSelection:      "%d av %d VMs valda för uppgraderingen",
+	ColumnPower:             "Ström",
+	ColumnTools:             "VMware Tools",
+	ColumnSourceOS:          "Käll-OS",
+	ColumnCredentials:       "Inloggning",
+	ColumnCDROM:             "CD/DVD",
+	ColumnDisk:              "Disk",
+	ColumnDatastore:         "Datastore",
+	ColumnMedia:             "Edition/språk",
+	ColumnISO:               "ISO",
+	ColumnReady:             "Redo",
+	ReadyYes:                "Ja",
+	ReadyNo:                 "Nej",
+
+	// Reattach to unfinished runs
+	ReattachTitle:           "Ofärdig uppgraderingskörning",
+	ReattachMessage:         "Körning %s startad %s (ISO %s) avbröts med %d ofärdiga VM(s). Återanslut för att fortsätta övervaka dem från steget de var i?",
+	ReattachScheduled:       "Schemalagd start %s, fönstret stänger %s.",
+	ReattachButton:          "Återanslut",
+	DiscardButton:           "Kasta",
+	LaterButton:             "Senare",
+	ReattachInfo:            "Återupptar avbruten körning %s - slutförda steg upprepas inte. Ange guest-lösenordet och klicka 'Starta uppgradering' för att fortsätta.",
 
 	// Snapshot management screen
 	SnapshotsTitle:          "Hantera snapshots",
@@ -382,10 +970,40 @@ var swedishTranslations = Translations{
 	ISOPath:                 "ISO datastore path",
 	ParallelUpgrades:        "Parallella uppgraderingar",
 	ParallelUpgradesInfo:    "Antal VMs som uppgraderas samtidigt (högt värde = snabbare för många VMs)",
+	MaxPerHost:              "Max per ESXi-host (0 = ingen gräns)",
+	MaxPerDatastore:         "Max per datastore (0 = ingen gräns)",
+	MaxPerCluster:           "Max per kluster (0 = ingen gräns)",
+	ConcurrencyInfo:         "Uppgraderingar som skulle överskrida en gräns väntar i kön medan VMs på andra hostar och datastores går före. Placeringen läses från VM-inventeringen.",
 	TimeoutMinutes:          "Timeout (minuter)",
 	DiskPrecheckGB:          "Disk precheck (GB)",
 	SkipMemoryInSnapshot:    "Hoppa över minne i snapshot",
 	RebootAfterUpgrade:      "Starta om efter uppgradering",
+	RollbackEnabled:         "Återställ till snapshoten före uppgradering vid fel",
+	RollbackInfo:            "Endast snapshoten som skapades av samma körning används. VM:en startas igen och det ursprungliga OS:et måste rapporteras innan återställningen räknas som lyckad.",
+	RollbackOnSetup:         "När uppgraderingsscriptet eller setup.exe misslyckas",
+	RollbackOnOSCheck:       "När mål-OS inte rapporteras i tid",
+	RollbackOnBoot:          "När VM:en inte kommer tillbaka efter omstarten",
+	CompatScanEnabled:       "Kör en kompatibilitetsskanning före snapshoten",
+	CompatScanInfo:          "Kör setup.exe /Compat ScanOnly från ISO:n. Inget installeras; VM:ar med hårda block stoppas före snapshoten och blocken listas i loggen.",
+	CompatScanMinutes:       "Kompatibilitetsskanning (minuter)",
+	InventoryEnabled:        "Inventera gästen före och efter uppgraderingen",
+	InventoryInfo:           "Roller/funktioner, tjänster och starttyper, installerade program, lyssnande portar och nätverksinställningar läses före setup och igen efter uppgraderingen. Skillnaderna visas per VM och sparas med körningen.",
+	HealthEnabled:           "Kör hälsokontroller efter uppgraderingen",
+	HealthInfo:              "Kontroller i den uppgraderade gästen som inte ändrar något. En misslyckad kontroll markerar VM:en som misslyckad men behåller uppgraderingen (ingen återställning); varningar loggas bara.",
+	HealthServices:          "Tjänster som måste köra (kommaseparerade)",
+	HealthSecureChannel:     "Säker kanal till domänen (Test-ComputerSecureChannel)",
+	HealthDNS:               "DNS-uppslag",
+	HealthDNSNames:          "Namn att slå upp (kommaseparerade, tomt = datorns domän)",
+	HealthGateway:           "Standardgatewayen svarar på ping",
+	HealthActivation:        "Windows är aktiverat",
+	HealthPendingReboot:     "Ingen väntande omstart",
+	WavesEnabled:            "Rulla ut i vågor, en kanariegrupp först",
+	WavesInfo:               "Första vågen är kanariegruppen, varje följande våg växer med faktorn. En våg med fler misslyckade VMs än tillåtet stoppar körningen; resterande VMs ligger kvar som köade i journalen. Du bekräftar innan varje ny våg.",
+	WaveCanarySize:          "Kanariegrupp (VMs)",
+	WaveGrowthFactor:        "Tillväxtfaktor",
+	WaveMaxSize:             "Största våg (0 = ingen gräns)",
+	WaveMaxFailures:         "Tillåtna misslyckade VMs per våg",
+	WaveMaxFailurePct:       "Tillåten andel misslyckade per våg (%, 0 = används inte)",
 	SignalScriptSeconds:     "Signal script (sekunder)",
 	SignalFilesMinutes:      "Signal filer (minuter)",
 	OSVersionPollingMinutes: "OS-version polling (minuter)",
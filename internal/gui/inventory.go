@@ -0,0 +1,89 @@
+package gui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+)
+
+// inventoryCategory översätter en kategori i gästinventeringen
+func (a *App) inventoryCategory(category string) string {
+	switch category {
+	case upgrade.InventoryFeature:
+		return a.tr.InventoryFeature
+	case upgrade.InventoryService:
+		return a.tr.InventoryService
+	case upgrade.InventoryProgram:
+		return a.tr.InventoryProgram
+	case upgrade.InventoryPort:
+		return a.tr.InventoryPort
+	case upgrade.InventoryNetwork:
+		return a.tr.InventoryNetwork
+	}
+	return category
+}
+
+// showInventoryChanges visar vad som ändrats i gästen mellan inventeringen
+// före setup och den efter uppgraderingen, en rad per ändring
+func (a *App) showInventoryChanges(vmName string, changes []upgrade.InventoryChange) {
+	title := fmt.Sprintf(a.tr.InventoryChangesTitle, vmName)
+	if len(changes) == 0 {
+		dialog.ShowInformation(title, a.tr.InventoryNoChanges, a.window)
+		return
+	}
+
+	headers := []string{a.tr.ColumnCategory, a.tr.ColumnName, a.tr.ColumnBefore, a.tr.ColumnAfter}
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(changes) + 1, len(headers)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("Template")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+			if id.Row == 0 {
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				label.SetText(headers[id.Col])
+				return
+			}
+			label.TextStyle = fyne.TextStyle{}
+			c := changes[id.Row-1]
+			switch id.Col {
+			case 0:
+				label.SetText(a.inventoryCategory(c.Category))
+			case 1:
+				label.SetText(c.Name)
+			case 2:
+				if c.Kind == upgrade.ChangeAdded {
+					label.SetText("-")
+				} else {
+					label.SetText(c.Before)
+				}
+			case 3:
+				if c.Kind == upgrade.ChangeRemoved {
+					label.SetText(a.tr.InventoryRemoved)
+				} else {
+					label.SetText(c.After)
+				}
+			}
+		},
+	)
+	table.SetColumnWidth(0, 120)
+	table.SetColumnWidth(1, 300)
+	table.SetColumnWidth(2, 220)
+	table.SetColumnWidth(3, 220)
+
+	summary := widget.NewLabel(fmt.Sprintf(a.tr.InventoryChangeCount, len(changes)))
+	content := container.NewBorder(summary, nil, nil, nil, table)
+
+	d := dialog.NewCustom(title, a.tr.CloseButton, content, a.window)
+	d.Resize(fyne.NewSize(900, 500))
+	d.Show()
+}
@@ -0,0 +1,91 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+)
+
+// showISOInfo läser ISO:ns metadata från datastoren och visar version,
+// språk och vilka editioner/index som finns i install.wim
+func (a *App) showISOInfo(isoPath string) {
+	if strings.TrimSpace(isoPath) == "" {
+		dialog.ShowError(fmt.Errorf("%s", a.tr.FillAllFields), a.window)
+		return
+	}
+
+	progress := dialog.NewCustomWithoutButtons(a.tr.ISOInfoTitle, widget.NewLabel(a.tr.ISOReading), a.window)
+	progress.Show()
+
+	go func() {
+		ctx := context.Background()
+		if err := upgrade.ValidateISOPath(ctx, isoPath); err != nil {
+			progress.Hide()
+			dialog.ShowError(err, a.window)
+			return
+		}
+		meta, err := upgrade.ReadISOMetadata(ctx, isoPath)
+		progress.Hide()
+		if err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+		a.showISOInfoDialog(meta)
+	}()
+}
+
+// showISOInfoDialog visar en tabell med avbilderna i install.wim
+func (a *App) showISOInfoDialog(meta *upgrade.ISOMetadata) {
+	headers := []string{a.tr.ColumnIndex, a.tr.ColumnName, a.tr.ColumnEdition, a.tr.ColumnInstallType, a.tr.ColumnLanguages}
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(meta.Images) + 1, len(headers)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("Template")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			label := cell.(*widget.Label)
+			if id.Row == 0 {
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				label.SetText(headers[id.Col])
+				return
+			}
+			label.TextStyle = fyne.TextStyle{}
+			img := meta.Images[id.Row-1]
+			switch id.Col {
+			case 0:
+				label.SetText(strconv.Itoa(img.Index))
+			case 1:
+				label.SetText(img.Name)
+			case 2:
+				label.SetText(img.EditionID)
+			case 3:
+				label.SetText(img.InstallationType)
+			case 4:
+				label.SetText(strings.Join(img.Languages, ", "))
+			}
+		},
+	)
+	table.SetColumnWidth(0, 60)
+	table.SetColumnWidth(1, 320)
+	table.SetColumnWidth(2, 160)
+	table.SetColumnWidth(3, 120)
+	table.SetColumnWidth(4, 120)
+
+	summary := widget.NewLabel(fmt.Sprintf("%s\n%s", meta.Path, meta.Summary()))
+	content := container.NewBorder(summary, nil, nil, nil, table)
+
+	d := dialog.NewCustom(a.tr.ISOInfoTitle, a.tr.CloseButton, content, a.window)
+	d.Resize(fyne.NewSize(860, 400))
+	d.Show()
+}
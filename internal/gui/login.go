@@ -101,6 +101,7 @@ func (a *App) showLoginScreen() {
 
 				// Byt till VM-selection-skärm
 				a.showVMSelectionScreen()
+				a.offerReattach()
 			}()
 		} else {
 			// Lösenordsinloggning
@@ -144,6 +145,7 @@ func (a *App) showLoginScreen() {
 
 				// Byt till VM-selection-skärm
 				a.showVMSelectionScreen()
+				a.offerReattach()
 			}()
 		}
 	})
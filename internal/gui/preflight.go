@@ -0,0 +1,197 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+)
+
+// runPreflight kör alla prechecks mot VMs utan att ändra något.
+// Rapporterna returneras i samma ordning som vmInfos.
+func (a *App) runPreflight(vmInfos []vcenter.VMInfo, base upgrade.UpgradeOptions) []*upgrade.PreflightReport {
+	ctx := context.Background()
+
+	// ISO:n är gemensam för alla VMs och kontrolleras bara en gång
+	isoCheck := upgrade.PreflightISO(ctx, base.ISOPath, base.Profile)
+
+	maxWorkers := a.config.Upgrade.Parallel
+	if maxWorkers <= 0 {
+		maxWorkers = 10
+	}
+	sem := make(chan struct{}, maxWorkers)
+
+	reports := make([]*upgrade.PreflightReport, len(vmInfos))
+	var wg sync.WaitGroup
+	for i, info := range vmInfos {
+		wg.Add(1)
+		go func(i int, info vcenter.VMInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			opts := base
+			opts.VMInfo = info
+			vm, err := a.upgradeVM(ctx, info, opts.Profile)
+			if err != nil {
+				report := &upgrade.PreflightReport{VMName: info.Name}
+				report.Add(upgrade.PreflightCheck{Name: upgrade.CheckPower, Result: upgrade.CheckFailed, Detail: err.Error()})
+				reports[i] = report
+				return
+			}
+			report := upgrade.PreflightVM(ctx, vm, opts)
+			report.Add(isoCheck)
+			reports[i] = report
+		}(i, info)
+	}
+	wg.Wait()
+	return reports
+}
+
+// preflightHeader returnerar kolumnrubriken för en precheck
+func (a *App) preflightHeader(check string) string {
+	switch check {
+	case upgrade.CheckPower:
+		return a.tr.ColumnPower
+	case upgrade.CheckTools:
+		return a.tr.ColumnTools
+	case upgrade.CheckSourceOS:
+		return a.tr.ColumnSourceOS
+	case upgrade.CheckCredentials:
+		return a.tr.ColumnCredentials
+	case upgrade.CheckCDROM:
+		return a.tr.ColumnCDROM
+	case upgrade.CheckDisk:
+		return a.tr.ColumnDisk
+	case upgrade.CheckDatastore:
+		return a.tr.ColumnDatastore
+	case upgrade.CheckMedia:
+		return a.tr.ColumnMedia
+	case upgrade.CheckISO:
+		return a.tr.ColumnISO
+	}
+	return check
+}
+
+// preflightCell formaterar en precheck för tabellen
+func preflightCell(c *upgrade.PreflightCheck) string {
+	if c == nil {
+		return "-"
+	}
+	switch c.Result {
+	case upgrade.CheckPassed:
+		return "✓ " + c.Detail
+	case upgrade.CheckWarning:
+		return "⚠ " + c.Detail
+	}
+	return "✗ " + c.Detail
+}
+
+// showPreflightDialog visar beredskapstabellen. VMs som inte klarade
+// kontrollerna är avmarkerade från början; onApply anropas med de VMs som
+// operatören valde bort.
+func (a *App) showPreflightDialog(reports []*upgrade.PreflightReport, deselected map[string]bool, onApply func(deselected map[string]bool)) {
+	checks := upgrade.PreflightCheckNames()
+
+	// Utgå från tidigare val, men välj bort allt som inte är redo
+	include := make(map[string]bool, len(reports))
+	ready := 0
+	for _, r := range reports {
+		include[r.VMName] = !deselected[r.VMName] && r.Ready()
+		if r.Ready() {
+			ready++
+		}
+	}
+
+	// Kolumner: välj, namn, en per check, redo
+	cols := len(checks) + 3
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(reports) + 1, cols
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("Template")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return container.NewStack(label, widget.NewCheck("", nil))
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			c := cell.(*fyne.Container)
+			label := c.Objects[0].(*widget.Label)
+			check := c.Objects[1].(*widget.Check)
+			label.Show()
+			check.Hide()
+
+			// Header rad
+			if id.Row == 0 {
+				label.TextStyle = fyne.TextStyle{Bold: true}
+				switch {
+				case id.Col == 0:
+					label.SetText(a.tr.ColumnSelect)
+				case id.Col == 1:
+					label.SetText(a.tr.ColumnName)
+				case id.Col == cols-1:
+					label.SetText(a.tr.ColumnReady)
+				default:
+					label.SetText(a.preflightHeader(checks[id.Col-2]))
+				}
+				return
+			}
+
+			label.TextStyle = fyne.TextStyle{}
+			r := reports[id.Row-1]
+			switch {
+			case id.Col == 0:
+				label.Hide()
+				check.Show()
+				vmName := r.VMName
+				check.OnChanged = nil
+				check.SetChecked(include[vmName])
+				check.OnChanged = func(checked bool) {
+					include[vmName] = checked
+				}
+			case id.Col == 1:
+				label.SetText(r.VMName)
+			case id.Col == cols-1:
+				if r.Ready() {
+					label.SetText("✓ " + a.tr.ReadyYes)
+				} else {
+					label.SetText("✗ " + a.tr.ReadyNo)
+				}
+			default:
+				label.SetText(preflightCell(r.Check(checks[id.Col-2])))
+			}
+		},
+	)
+	table.SetColumnWidth(0, 60)
+	table.SetColumnWidth(1, 180)
+	for i := range checks {
+		table.SetColumnWidth(i+2, 160)
+	}
+	table.SetColumnWidth(cols-1, 90)
+
+	summary := widget.NewLabel(fmt.Sprintf(a.tr.PreflightSummary, ready, len(reports)))
+	content := container.NewBorder(summary, nil, nil, nil, table)
+
+	d := dialog.NewCustomConfirm(a.tr.PreflightTitle, a.tr.PreflightApply, a.tr.CloseButton, content, func(apply bool) {
+		if !apply {
+			return
+		}
+		result := make(map[string]bool)
+		for name, inc := range include {
+			if !inc {
+				result[name] = true
+			}
+		}
+		debug.Log("Preflight selection applied: %d of %d VMs deselected", len(result), len(reports))
+		onApply(result)
+	}, a.window)
+	d.Resize(fyne.NewSize(1200, 500))
+	d.Show()
+}
@@ -0,0 +1,70 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+)
+
+// offerReattach frågar om avbrutna körningar i journalen ska återupptas.
+// Körningarna erbjuds en i taget, nyaste först.
+func (a *App) offerReattach() {
+	runs := a.journal.Unfinished()
+	if len(runs) == 0 {
+		return
+	}
+	run := runs[len(runs)-1]
+
+	// Lista ofärdiga VMs och det steg de senast var i
+	var lines []string
+	for _, rec := range run.Pending() {
+		step := "-"
+		if res := rec.Result(); res != nil {
+			step = res.NextStep()
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s)", rec.Name, step))
+	}
+	debug.Log("Unfinished run %s found with %d pending VM(s)", run.ID, len(lines))
+
+	text := fmt.Sprintf(a.tr.ReattachMessage, run.ID, run.Started.Format("2006-01-02 15:04"), run.ISOPath, len(lines))
+	if w := run.Window(); !w.Start.IsZero() || !w.End.IsZero() {
+		// Schemalagda körningar visar sitt fönster, starten kan ha passerat
+		start := "-"
+		if !w.Start.IsZero() {
+			start = w.Start.Format(upgrade.WindowTimeFormat)
+		}
+		text += " " + fmt.Sprintf(a.tr.ReattachScheduled, start, a.windowEndText(w))
+	}
+	message := widget.NewLabel(text)
+	message.Wrapping = fyne.TextWrapWord
+	vmList := widget.NewLabel(strings.Join(lines, "\n"))
+	vmScroll := container.NewVScroll(vmList)
+	vmScroll.SetMinSize(fyne.NewSize(400, 150))
+
+	var d dialog.Dialog
+	reattachBtn := widget.NewButton(a.tr.ReattachButton, func() {
+		d.Hide()
+		a.showUpgradeScreen(nil, nil, run)
+	})
+	reattachBtn.Importance = widget.HighImportance
+	discardBtn := widget.NewButton(a.tr.DiscardButton, func() {
+		d.Hide()
+		if err := a.journal.FinishRun(run.ID); err != nil {
+			debug.LogError("JournalFinishRun", err, "Run", run.ID)
+		}
+		a.offerReattach()
+	})
+	laterBtn := widget.NewButton(a.tr.LaterButton, func() {
+		d.Hide()
+	})
+
+	content := container.NewBorder(message, container.NewHBox(reattachBtn, discardBtn, laterBtn), nil, nil, vmScroll)
+	d = dialog.NewCustomWithoutButtons(a.tr.ReattachTitle, content, a.window)
+	d.Show()
+}
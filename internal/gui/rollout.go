@@ -0,0 +1,354 @@
+package gui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/journal"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+)
+
+// rolloutJob är en VM i en körning
+type rolloutJob struct {
+	vmName   string
+	vmInfo   vcenter.VMInfo
+	resume   *journal.VMRecord      // journalens post i en återupptagen körning
+	previous *upgrade.UpgradeResult // förra försöket vid ett nytt försök
+	ctx      context.Context
+}
+
+// rolloutResult är hur uppgraderingen av en VM gick
+type rolloutResult struct {
+	vmName string
+	result *upgrade.UpgradeResult
+	err    error
+}
+
+// vmOutcome är utfallet för en VM i en körning
+type vmOutcome int
+
+const (
+	outcomeSucceeded  vmOutcome = iota
+	outcomeFailed               // uppgraderingen misslyckades
+	outcomeCancelled            // avbruten av operatören
+	outcomeNotStarted           // fönstret stängde innan VM:en startade
+)
+
+// outcome avgör utfallet av ett resultat
+func (r rolloutResult) outcome() vmOutcome {
+	switch {
+	case errors.Is(r.err, upgrade.ErrWindowClosed):
+		return outcomeNotStarted
+	case errors.Is(r.err, upgrade.ErrCancelled):
+		return outcomeCancelled
+	case r.err != nil:
+		return outcomeFailed
+	}
+	return outcomeSucceeded
+}
+
+// rolloutCounts räknar en körnings VMs per utfall
+type rolloutCounts struct {
+	completed   int // VMs med ett utfall, också de som aldrig startades
+	failed      int
+	cancelled   int
+	notStarted  int // fönstret stängde
+	halted      int // utrullningen stoppades före deras omgång
+	failedNames []string
+}
+
+// succeeded är antalet VMs som uppgraderades
+func (c rolloutCounts) succeeded() int {
+	return c.completed - c.failed - c.cancelled - c.notStarted - c.halted
+}
+
+// rolloutView är det på uppgraderingsskärmen som en körning visar sig i.
+// Anropen kommer från körningens egen goroutine, i tur och ordning.
+type rolloutView interface {
+	// batchStarting anropas när en omgång köas hos schemaläggaren
+	batchStarting(index int, b upgrade.Batch)
+	// vmFinished anropas för varje VM som fått ett resultat
+	vmFinished(res rolloutResult, outcome vmOutcome, counts rolloutCounts)
+	// batchFinished anropas efter varje omgång utom den sista
+	batchFinished(b upgrade.Batch, succeeded, failed, skipped int)
+	// confirmNext frågar operatören om nästa våg får starta
+	confirmNext(done, next upgrade.Batch, succeeded, failed, skipped int) bool
+	// vmHalted anropas för varje VM som inte startas för att utrullningen
+	// stoppades, cancelled om den också var avbruten
+	vmHalted(vmName string, cancelled bool)
+}
+
+// rollout kör en körnings VMs etapp för etapp och våg för våg. Workers tar
+// VMs från schemaläggaren inom gränserna per host, datastore och kluster.
+// Efter varje våg avgör brytaren om körningen får fortsätta och operatören
+// bekräftar nästa våg; en etapp med hälsogrind måste bli helt frisk först.
+type rollout struct {
+	config  config.UpgradeConfig
+	window  upgrade.MaintenanceWindow
+	stages  []upgrade.Stage
+	batches []upgrade.Batch
+	jobs    map[string]rolloutJob
+	sched   *upgrade.Scheduler
+	journal *journal.Journal
+	runID   string // "" om körningen inte journalförs
+	view    rolloutView
+
+	// upgradeVM uppgraderar en VM, den anropas från workers
+	upgradeVM func(job rolloutJob, workerID int) (*upgrade.UpgradeResult, error)
+
+	counts  rolloutCounts
+	healthy []int // uppgraderade och friska VMs per etapp
+	results chan rolloutResult
+	wg      sync.WaitGroup
+}
+
+// newRollout planerar körningen av jobs i stages, med schemaläggarens och
+// vågornas inställningar i cfg
+func newRollout(cfg config.UpgradeConfig, window upgrade.MaintenanceWindow, stages []upgrade.Stage, jobs []rolloutJob, sched *upgrade.Scheduler, view rolloutView) *rollout {
+	ro := &rollout{
+		config:  cfg,
+		window:  window,
+		stages:  stages,
+		batches: upgrade.PlanBatches(stages, cfg.Waves),
+		jobs:    make(map[string]rolloutJob, len(jobs)),
+		sched:   sched,
+		view:    view,
+		healthy: make([]int, len(stages)),
+	}
+	for _, job := range jobs {
+		ro.jobs[job.vmName] = job
+	}
+	return ro
+}
+
+// run startar workers, rullar ut omgångarna och väntar in workers. När
+// utrullningen stoppas returneras orsaken och omgången den stoppades
+// efter; VMs i senare omgångar startas inte.
+func (ro *rollout) run(workers int) (stoppedAfter upgrade.Batch, err error) {
+	ro.results = make(chan rolloutResult, len(ro.jobs))
+	for w := 1; w <= workers; w++ {
+		ro.wg.Add(1)
+		go ro.worker(w)
+	}
+
+	last, err := ro.runBatches()
+	ro.sched.Close()
+	ro.wg.Wait()
+
+	if err != nil {
+		ro.halt(last)
+	}
+	return ro.batches[last], err
+}
+
+// worker uppgraderar VMs som schemaläggaren delar ut tills den stängs
+func (ro *rollout) worker(id int) {
+	defer ro.wg.Done()
+	debug.Log("Worker %d started", id)
+	for {
+		vmInfo, release, ok := ro.sched.Next(ro.skipSlot)
+		if !ok {
+			break
+		}
+		job := ro.jobs[vmInfo.Name]
+		debug.Log("Worker %d processing VM: %s", id, job.vmName)
+		ro.results <- ro.runJob(job, release, id)
+	}
+	debug.Log("Worker %d finished", id)
+}
+
+// skipSlot säger att avbrutna VMs och VMs efter fönstret inte rörs och
+// inte tar någon plats
+func (ro *rollout) skipSlot(vm vcenter.VMInfo) bool {
+	return ro.jobs[vm.Name].ctx.Err() != nil || ro.window.Closed(time.Now())
+}
+
+// runJob uppgraderar en VM och släpper dess platser i schemaläggaren
+func (ro *rollout) runJob(job rolloutJob, release func(), workerID int) rolloutResult {
+	defer release()
+
+	// Avbruten medan den låg i kön - rör inte VM:en
+	if job.ctx.Err() != nil {
+		err := fmt.Errorf("%w before start", upgrade.ErrCancelled)
+		ro.setJournalStatus(job.vmName, journal.VMCancelled, err)
+		return rolloutResult{vmName: job.vmName, err: err}
+	}
+
+	// Fönstret har stängt - VM:en startas inte utan ligger kvar som köad
+	// i journalen
+	if ro.window.Closed(time.Now()) {
+		return rolloutResult{vmName: job.vmName, err: upgrade.ErrWindowClosed}
+	}
+
+	res, err := ro.upgradeVM(job, workerID)
+	if err != nil && res != nil {
+		err = fmt.Errorf("%s: %w", res.FailedStep(), err)
+	}
+	return rolloutResult{vmName: job.vmName, result: res, err: err}
+}
+
+// runBatches köar omgångarna en i taget och väntar in varje omgångs
+// resultat. Den returnerar den sista omgången som kördes och, om
+// utrullningen stoppades där, varför.
+func (ro *rollout) runBatches() (int, error) {
+	for i, batch := range ro.batches {
+		ro.view.batchStarting(i, batch)
+		for _, vmName := range batch.VMs {
+			ro.sched.Add(ro.jobs[vmName].vmInfo)
+		}
+
+		before := ro.counts
+		for range batch.VMs {
+			ro.record(batch, <-ro.results)
+		}
+		if i == len(ro.batches)-1 {
+			break
+		}
+
+		failed := ro.counts.failed - before.failed
+		skipped := ro.counts.cancelled + ro.counts.notStarted - before.cancelled - before.notStarted
+		succeeded := len(batch.VMs) - failed - skipped
+		ro.view.batchFinished(batch, succeeded, failed, skipped)
+		if err := ro.checkGates(batch, failed, skipped); err != nil {
+			return i, err
+		}
+		if !ro.config.Waves.Enabled || ro.window.Closed(time.Now()) || ro.allCancelled() {
+			// Utan vågor följer nästa etapp direkt, och annars blir resten
+			// ändå inte startad, ingen bekräftelse behövs
+			continue
+		}
+		if !ro.view.confirmNext(batch, ro.batches[i+1], succeeded, failed, skipped) {
+			return i, fmt.Errorf("%w by the operator", upgrade.ErrWaveHalted)
+		}
+	}
+	return len(ro.batches) - 1, nil
+}
+
+// checkGates avgör om utrullningen får fortsätta efter en omgång: vågens
+// brytare och, efter en etapps sista våg, etappens hälsogrind
+func (ro *rollout) checkGates(b upgrade.Batch, failed, skipped int) error {
+	if ro.config.Waves.Enabled {
+		if err := upgrade.CheckWave(ro.config.Waves, failed, len(b.VMs)-skipped); err != nil {
+			return err
+		}
+	}
+	if b.Gate {
+		return upgrade.CheckStageGate(ro.stages[b.Stage], ro.healthy[b.Stage])
+	}
+	return nil
+}
+
+// record räknar en VM:s resultat och visar det
+func (ro *rollout) record(b upgrade.Batch, res rolloutResult) {
+	outcome := res.outcome()
+	ro.counts.completed++
+	switch outcome {
+	case outcomeNotStarted:
+		ro.counts.notStarted++
+	case outcomeCancelled:
+		ro.counts.cancelled++
+	case outcomeFailed:
+		ro.counts.failed++
+		ro.counts.failedNames = append(ro.counts.failedNames, res.vmName)
+	case outcomeSucceeded:
+		ro.healthy[b.Stage]++
+	}
+	ro.view.vmFinished(res, outcome, ro.counts)
+}
+
+// halt avslutar VMs i omgångarna efter last utan att starta dem. De ligger
+// kvar som köade i journalen så körningen kan återanslutas, utom de som
+// redan avbrutits.
+func (ro *rollout) halt(last int) {
+	for _, batch := range ro.batches[last+1:] {
+		for _, vmName := range batch.VMs {
+			ro.counts.completed++
+			cancelled := ro.jobs[vmName].ctx.Err() != nil
+			if cancelled {
+				ro.counts.cancelled++
+				ro.setJournalStatus(vmName, journal.VMCancelled, fmt.Errorf("%w before start", upgrade.ErrCancelled))
+			} else {
+				ro.counts.halted++
+			}
+			ro.view.vmHalted(vmName, cancelled)
+		}
+	}
+}
+
+// allCancelled säger om alla körningens VMs är avbrutna. Då avbryts också
+// väntan på fönstret och på nästa våg.
+func (ro *rollout) allCancelled() bool {
+	for _, job := range ro.jobs {
+		if job.ctx.Err() == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// setJournalStatus sätter en VM:s status i journalen om körningen
+// journalförs
+func (ro *rollout) setJournalStatus(vmName, status string, cause error) {
+	if ro.runID == "" {
+		return
+	}
+	if err := ro.journal.SetVMStatus(ro.runID, vmName, status, cause); err != nil {
+		debug.LogError("JournalSetVMStatus", err, "VM", vmName)
+	}
+}
+
+// runInput är det operatören angav för en körning
+type runInput struct {
+	guestUser, guestPass, isoPath, profile string
+	createSnapshot                         bool
+}
+
+// lastRun är skärmens förra körning. "Försök igen" kör de VMs som
+// misslyckades i samma körning i journalen, med samma inmatning, och
+// fortsätter varje VM från dess senaste resultat.
+type lastRun struct {
+	runID   string
+	input   runInput
+	failed  []string
+	results map[string]*upgrade.UpgradeResult // senaste resultatet per VM
+}
+
+// record sparar resultatet av en VM till ett nytt försök
+func (l *lastRun) record(res rolloutResult) {
+	if res.result == nil {
+		return
+	}
+	if l.results == nil {
+		l.results = make(map[string]*upgrade.UpgradeResult)
+	}
+	l.results[res.vmName] = res.result
+}
+
+// selectRunNames väljer körningens VMs bland de valda, i ordning: vid ett
+// nytt försök bara de i retrying, annars de som inte valts bort efter
+// preflight. dropped är de bortvalda som annars hade körts.
+func selectRunNames(selected []string, deselected map[string]bool, retrying []string) (names, dropped []string) {
+	retry := make(map[string]bool, len(retrying))
+	for _, vmName := range retrying {
+		retry[vmName] = true
+	}
+	for _, vmName := range selected {
+		switch {
+		case len(retrying) > 0:
+			if retry[vmName] {
+				names = append(names, vmName)
+			}
+		case deselected[vmName]:
+			dropped = append(dropped, vmName)
+		default:
+			names = append(names, vmName)
+		}
+	}
+	return names, dropped
+}
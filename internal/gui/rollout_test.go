@@ -0,0 +1,238 @@
+package gui
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+)
+
+// fakeRolloutView records what a rollout shows and answers the wave
+// confirmations
+type fakeRolloutView struct {
+	confirm  bool
+	confirms int
+	outcomes map[string]vmOutcome
+	halted   []string
+}
+
+func (v *fakeRolloutView) batchStarting(index int, b upgrade.Batch) {}
+
+func (v *fakeRolloutView) vmFinished(res rolloutResult, outcome vmOutcome, counts rolloutCounts) {
+	if v.outcomes == nil {
+		v.outcomes = make(map[string]vmOutcome)
+	}
+	v.outcomes[res.vmName] = outcome
+}
+
+func (v *fakeRolloutView) batchFinished(b upgrade.Batch, succeeded, failed, skipped int) {}
+
+func (v *fakeRolloutView) confirmNext(done, next upgrade.Batch, succeeded, failed, skipped int) bool {
+	v.confirms++
+	return v.confirm
+}
+
+func (v *fakeRolloutView) vmHalted(vmName string, cancelled bool) {
+	v.halted = append(v.halted, vmName)
+}
+
+func TestRollout(t *testing.T) {
+	vms := []string{"srv01", "srv02", "srv03", "srv04"}
+	canary := config.WaveConfig{Enabled: true, CanarySize: 1, GrowthFactor: 2} // 1, 2, 1
+
+	tests := []struct {
+		name      string
+		stages    []upgrade.Stage
+		waves     config.WaveConfig
+		failing   []string
+		cancelled []string
+		decline   bool // the operator stops at the first confirmation
+
+		wantErr      string // "" when the rollout runs to the end
+		wantUpgraded []string
+		wantHalted   []string
+		wantCounts   rolloutCounts
+		wantConfirms int
+	}{
+		{
+			name:         "waves",
+			stages:       []upgrade.Stage{{VMs: vms}},
+			waves:        canary,
+			wantUpgraded: vms,
+			wantCounts:   rolloutCounts{completed: 4},
+			wantConfirms: 2,
+		},
+		{
+			name:         "circuit breaker",
+			stages:       []upgrade.Stage{{VMs: vms}},
+			waves:        canary,
+			failing:      []string{"srv01"},
+			wantErr:      "1 of 1 VMs failed",
+			wantUpgraded: []string{"srv01"},
+			wantHalted:   []string{"srv02", "srv03", "srv04"},
+			wantCounts:   rolloutCounts{completed: 4, failed: 1, halted: 3, failedNames: []string{"srv01"}},
+		},
+		{
+			name:         "failures within the limit",
+			stages:       []upgrade.Stage{{VMs: vms}},
+			waves:        config.WaveConfig{Enabled: true, CanarySize: 1, GrowthFactor: 2, MaxFailures: 1},
+			failing:      []string{"srv01"},
+			wantUpgraded: vms,
+			wantCounts:   rolloutCounts{completed: 4, failed: 1, failedNames: []string{"srv01"}},
+			wantConfirms: 2,
+		},
+		{
+			name:         "operator stops",
+			stages:       []upgrade.Stage{{VMs: vms}},
+			waves:        canary,
+			decline:      true,
+			wantErr:      "by the operator",
+			wantUpgraded: []string{"srv01"},
+			wantHalted:   []string{"srv02", "srv03", "srv04"},
+			wantCounts:   rolloutCounts{completed: 4, halted: 3},
+			wantConfirms: 1,
+		},
+		{
+			name:         "halted VM already cancelled",
+			stages:       []upgrade.Stage{{VMs: vms}},
+			waves:        canary,
+			failing:      []string{"srv01"},
+			cancelled:    []string{"srv03"},
+			wantErr:      "1 of 1 VMs failed",
+			wantUpgraded: []string{"srv01"},
+			wantHalted:   []string{"srv02", "srv03", "srv04"},
+			wantCounts:   rolloutCounts{completed: 4, failed: 1, cancelled: 1, halted: 2, failedNames: []string{"srv01"}},
+		},
+		{
+			name:         "stage gate",
+			stages:       []upgrade.Stage{{Name: "db", VMs: []string{"srv01"}, WaitHealthy: true}, {Name: "app", VMs: []string{"srv02", "srv03"}}},
+			failing:      []string{"srv01"},
+			wantErr:      "stage db has 0 of 1 VMs upgraded and healthy",
+			wantUpgraded: []string{"srv01"},
+			wantHalted:   []string{"srv02", "srv03"},
+			wantCounts:   rolloutCounts{completed: 3, failed: 1, halted: 2, failedNames: []string{"srv01"}},
+		},
+		{
+			name:         "stages without gate",
+			stages:       []upgrade.Stage{{Name: "db", VMs: []string{"srv01"}}, {Name: "app", VMs: []string{"srv02", "srv03"}}},
+			failing:      []string{"srv01"},
+			wantUpgraded: []string{"srv01", "srv02", "srv03"},
+			wantCounts:   rolloutCounts{completed: 3, failed: 1, failedNames: []string{"srv01"}},
+		},
+		{
+			name:         "cancelled before start",
+			stages:       []upgrade.Stage{{VMs: vms}},
+			cancelled:    []string{"srv02"},
+			wantUpgraded: []string{"srv01", "srv03", "srv04"},
+			wantCounts:   rolloutCounts{completed: 4, cancelled: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var jobs []rolloutJob
+			for _, st := range tt.stages {
+				for _, vmName := range st.VMs {
+					ctx, cancel := context.WithCancel(context.Background())
+					if containsString(tt.cancelled, vmName) {
+						cancel()
+					} else {
+						defer cancel()
+					}
+					jobs = append(jobs, rolloutJob{vmName: vmName, vmInfo: vcenter.VMInfo{Name: vmName}, ctx: ctx})
+				}
+			}
+
+			cfg := config.UpgradeConfig{Waves: tt.waves}
+			view := &fakeRolloutView{confirm: !tt.decline}
+			ro := newRollout(cfg, upgrade.MaintenanceWindow{}, tt.stages, jobs, upgrade.NewScheduler(cfg), view)
+
+			var mu sync.Mutex
+			var upgraded []string
+			ro.upgradeVM = func(job rolloutJob, workerID int) (*upgrade.UpgradeResult, error) {
+				mu.Lock()
+				upgraded = append(upgraded, job.vmName)
+				mu.Unlock()
+				res := upgrade.NewUpgradeResult(job.vmName)
+				if containsString(tt.failing, job.vmName) {
+					return res, errors.New("setup failed")
+				}
+				return res, nil
+			}
+
+			_, err := ro.run(2)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("error %v, want none", err)
+			case tt.wantErr != "" && (!errors.Is(err, upgrade.ErrWaveHalted) || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error %v, want %v with %q", err, upgrade.ErrWaveHalted, tt.wantErr)
+			}
+
+			sort.Strings(upgraded)
+			if !reflect.DeepEqual(upgraded, tt.wantUpgraded) {
+				t.Errorf("upgraded %v, want %v", upgraded, tt.wantUpgraded)
+			}
+			if !reflect.DeepEqual(view.halted, tt.wantHalted) {
+				t.Errorf("halted %v, want %v", view.halted, tt.wantHalted)
+			}
+			if !reflect.DeepEqual(ro.counts, tt.wantCounts) {
+				t.Errorf("counts %+v, want %+v", ro.counts, tt.wantCounts)
+			}
+			if view.confirms != tt.wantConfirms {
+				t.Errorf("%d confirmations, want %d", view.confirms, tt.wantConfirms)
+			}
+			for _, vmName := range tt.failing {
+				if view.outcomes[vmName] != outcomeFailed {
+					t.Errorf("%s outcome %v, want failed", vmName, view.outcomes[vmName])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectRunNames(t *testing.T) {
+	selected := []string{"srv01", "srv02", "srv03"}
+
+	tests := []struct {
+		name        string
+		deselected  map[string]bool
+		retrying    []string
+		wantNames   []string
+		wantDropped []string
+	}{
+		{name: "all", wantNames: selected},
+		{name: "deselected", deselected: map[string]bool{"srv02": true}, wantNames: []string{"srv01", "srv03"}, wantDropped: []string{"srv02"}},
+		{name: "retry", retrying: []string{"srv03", "srv01"}, wantNames: []string{"srv01", "srv03"}},
+		{name: "retry ignores deselection", deselected: map[string]bool{"srv01": true}, retrying: []string{"srv01"}, wantNames: []string{"srv01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, dropped := selectRunNames(selected, tt.deselected, tt.retrying)
+			if !reflect.DeepEqual(names, tt.wantNames) || !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("selectRunNames() = %v, %v, want %v, %v", names, dropped, tt.wantNames, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestLastRunRecord(t *testing.T) {
+	var last lastRun
+	res := upgrade.NewUpgradeResult("srv01")
+	last.record(rolloutResult{vmName: "srv01", result: res, err: errors.New("setup failed")})
+	last.record(rolloutResult{vmName: "srv02", err: upgrade.ErrWindowClosed})
+
+	if last.results["srv01"] != res {
+		t.Errorf("srv01 result %v, want the recorded one", last.results["srv01"])
+	}
+	if _, ok := last.results["srv02"]; ok {
+		t.Errorf("srv02 has a result without ever starting")
+	}
+}
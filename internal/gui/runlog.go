@@ -0,0 +1,77 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+)
+
+// Högsta antal rader i körloggen
+const maxRunLogLines = 5000
+
+// runLogBuffer är uppgraderingsskärmens körlogg. Raderna hålls i en
+// begränsad buffert och visas först när användaren öppnar loggen, så en
+// lång körning med många VMs varken växer utan gräns eller ritar om all
+// text per rad.
+type runLogBuffer struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// add lägger till text, en rad per radbrytning. En avslutande radbrytning
+// ger ingen tom rad, två ger en.
+func (l *runLogBuffer) add(text string) {
+	text = strings.TrimSuffix(text, "\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range strings.Split(text, "\n") {
+		l.lines = appendCapped(l.lines, line, maxRunLogLines)
+	}
+}
+
+// logf lägger till en rad med klockslag
+func (l *runLogBuffer) logf(format string, args ...interface{}) {
+	l.add(fmt.Sprintf("[%s] ", time.Now().Format("15:04:05")) + fmt.Sprintf(format, args...))
+}
+
+// text returnerar loggen som den ser ut nu
+func (l *runLogBuffer) text() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// runLogObserver skriver händelser som operatören behöver se efter körningen
+// till körloggen, märkta med VM:ens namn som i detaljvyn: misslyckade steg,
+// varningar och hookarnas utdata. Startade steg, meddelanden och gästens
+// utdata visas bara i VM:ens detaljer, mätvärden och förloppskopior bara i
+// debugloggen.
+func (a *App) runLogObserver(l *runLogBuffer) upgrade.Observer {
+	return upgrade.ObserverFunc(func(e upgrade.Event) {
+		switch e.Kind {
+		case upgrade.EventStepFinished, upgrade.EventWarning, upgrade.EventHookOutput:
+			if line := a.eventLogLine(e); line != "" {
+				l.add(line)
+			}
+		}
+	})
+}
+
+// showRunLog visar körloggen, markerbar och kopierbar som VM-detaljernas
+// händelser. Dialogen visar loggen när den öppnades.
+func (a *App) showRunLog(l *runLogBuffer) {
+	text := widget.NewMultiLineEntry()
+	text.Wrapping = fyne.TextWrapWord
+	text.SetText(l.text())
+	text.CursorRow = strings.Count(text.Text, "\n")
+
+	dlg := dialog.NewCustom(a.tr.RunLogTitle, a.tr.CloseButton, text, a.window)
+	dlg.Resize(fyne.NewSize(950, 550))
+	dlg.Show()
+}
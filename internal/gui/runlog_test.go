@@ -0,0 +1,74 @@
+package gui
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+)
+
+// TestRunLogObserver checks which events reach the run log, tagged with the
+// VM they are about
+func TestRunLogObserver(t *testing.T) {
+	a := &App{tr: GetTranslations("en")}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		event upgrade.Event
+		want  string // "" when the event stays out of the run log
+	}{
+		{
+			name:  "hook output",
+			event: upgrade.Event{Kind: upgrade.EventHookOutput, Message: "drain: node drained"},
+			want:  "[srv01] hook drain: node drained",
+		},
+		{
+			name:  "step failed",
+			event: upgrade.Event{Kind: upgrade.EventStepFinished, Step: upgrade.StepSetup, Status: upgrade.StatusFailed, Err: errors.New("exit code 1")},
+			want:  "[srv01] ✗ " + upgrade.StepSetup + ": exit code 1",
+		},
+		{
+			name:  "warning",
+			event: upgrade.Event{Kind: upgrade.EventWarning, Message: "unmount ISO failed"},
+			want:  "[srv01] ⚠ unmount ISO failed",
+		},
+		{
+			name:  "step completed",
+			event: upgrade.Event{Kind: upgrade.EventStepFinished, Step: upgrade.StepSetup, Status: upgrade.StatusCompleted},
+		},
+		{
+			name:  "step started",
+			event: upgrade.Event{Kind: upgrade.EventStepStarted, Step: upgrade.StepSetup},
+		},
+		{
+			name:  "message",
+			event: upgrade.Event{Kind: upgrade.EventMessage, Message: "Waiting 60 seconds"},
+		},
+		{
+			name:  "guest output",
+			event: upgrade.Event{Kind: upgrade.EventGuestOutput, Message: "setup 40%"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runLog := &runLogBuffer{}
+			tt.event.VM, tt.event.Time = "srv01", now
+			a.runLogObserver(runLog).OnEvent(tt.event)
+
+			got := runLog.text()
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("run log %q, want nothing", got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("run log %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package gui
 
 import (
 	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -9,6 +10,7 @@ import (
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
 	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
 )
 
 func (a *App) showSettingsDialog() {
@@ -49,6 +51,14 @@ func (a *App) showSettingsDialog() {
 	parallelEntry.SetText(strconv.Itoa(a.config.Upgrade.Parallel))
 	parallelEntry.SetPlaceHolder("10")
 
+	// Gränser per ESXi-host, datastore och kluster
+	maxPerHostEntry := widget.NewEntry()
+	maxPerHostEntry.SetText(strconv.Itoa(a.config.Upgrade.MaxPerHost))
+	maxPerDatastoreEntry := widget.NewEntry()
+	maxPerDatastoreEntry.SetText(strconv.Itoa(a.config.Upgrade.MaxPerDatastore))
+	maxPerClusterEntry := widget.NewEntry()
+	maxPerClusterEntry.SetText(strconv.Itoa(a.config.Upgrade.MaxPerCluster))
+
 	timeoutEntry := widget.NewEntry()
 	timeoutEntry.SetText(strconv.Itoa(a.config.Upgrade.TimeoutMinutes))
 	timeoutEntry.SetPlaceHolder("90")
@@ -62,6 +72,107 @@ func (a *App) showSettingsDialog() {
 	rebootCheck := widget.NewCheck(a.tr.RebootAfterUpgrade, nil)
 	rebootCheck.SetChecked(a.config.Upgrade.Reboot)
 
+	// Automatisk rollback till snapshoten vid valda feltyper
+	rollbackClasses := map[string]*widget.Check{
+		upgrade.FailureSetup:   widget.NewCheck(a.tr.RollbackOnSetup, nil),
+		upgrade.FailureOSCheck: widget.NewCheck(a.tr.RollbackOnOSCheck, nil),
+		upgrade.FailureBoot:    widget.NewCheck(a.tr.RollbackOnBoot, nil),
+	}
+	for class, check := range rollbackClasses {
+		check.SetChecked(len(a.config.Upgrade.Rollback.On) == 0)
+		for _, c := range a.config.Upgrade.Rollback.On {
+			if c == class {
+				check.SetChecked(true)
+			}
+		}
+	}
+	rollbackCheck := widget.NewCheck(a.tr.RollbackEnabled, func(checked bool) {
+		for _, check := range rollbackClasses {
+			if checked {
+				check.Enable()
+			} else {
+				check.Disable()
+			}
+		}
+	})
+	rollbackCheck.SetChecked(a.config.Upgrade.Rollback.Enabled)
+	rollbackCheck.OnChanged(rollbackCheck.Checked)
+	rollbackInfo := widget.NewLabel(a.tr.RollbackInfo)
+	rollbackInfo.Wrapping = fyne.TextWrapWord
+
+	// Kompatibilitetsskanning med setup.exe innan snapshoten tas
+	compatScanCheck := widget.NewCheck(a.tr.CompatScanEnabled, nil)
+	compatScanCheck.SetChecked(a.config.Upgrade.CompatScan)
+	compatScanInfo := widget.NewLabel(a.tr.CompatScanInfo)
+	compatScanInfo.Wrapping = fyne.TextWrapWord
+
+	// Inventering av gästen före och efter uppgraderingen
+	inventoryCheck := widget.NewCheck(a.tr.InventoryEnabled, nil)
+	inventoryCheck.SetChecked(a.config.Upgrade.Inventory)
+	inventoryInfo := widget.NewLabel(a.tr.InventoryInfo)
+	inventoryInfo.Wrapping = fyne.TextWrapWord
+
+	// Utrullning i vågor med en kanariegrupp först
+	waves := a.config.Upgrade.Waves
+	canaryEntry := widget.NewEntry()
+	canaryEntry.SetText(strconv.Itoa(waves.CanarySize))
+	canaryEntry.SetPlaceHolder("1")
+	growthEntry := widget.NewEntry()
+	growthEntry.SetText(strconv.Itoa(waves.GrowthFactor))
+	growthEntry.SetPlaceHolder("2")
+	maxWaveEntry := widget.NewEntry()
+	maxWaveEntry.SetText(strconv.Itoa(waves.MaxWaveSize))
+	maxFailuresEntry := widget.NewEntry()
+	maxFailuresEntry.SetText(strconv.Itoa(waves.MaxFailures))
+	maxFailurePctEntry := widget.NewEntry()
+	maxFailurePctEntry.SetText(strconv.Itoa(waves.MaxFailurePercent))
+	waveEntries := []*widget.Entry{canaryEntry, growthEntry, maxWaveEntry, maxFailuresEntry, maxFailurePctEntry}
+	wavesCheck := widget.NewCheck(a.tr.WavesEnabled, func(checked bool) {
+		for _, e := range waveEntries {
+			if checked {
+				e.Enable()
+			} else {
+				e.Disable()
+			}
+		}
+	})
+	wavesCheck.SetChecked(waves.Enabled)
+	wavesCheck.OnChanged(wavesCheck.Checked)
+	wavesInfo := widget.NewLabel(a.tr.WavesInfo)
+	wavesInfo.Wrapping = fyne.TextWrapWord
+
+	// Hälsokontroller i gästen efter uppgraderingen
+	health := a.config.Upgrade.Health
+	healthServicesEntry := widget.NewEntry()
+	healthServicesEntry.SetText(strings.Join(health.Services, ", "))
+	healthDNSNamesEntry := widget.NewEntry()
+	healthDNSNamesEntry.SetText(strings.Join(health.DNSNames, ", "))
+	healthSecureChannelCheck := widget.NewCheck(a.tr.HealthSecureChannel, nil)
+	healthSecureChannelCheck.SetChecked(health.SecureChannel)
+	healthDNSCheck := widget.NewCheck(a.tr.HealthDNS, nil)
+	healthDNSCheck.SetChecked(health.DNS)
+	healthGatewayCheck := widget.NewCheck(a.tr.HealthGateway, nil)
+	healthGatewayCheck.SetChecked(health.Gateway)
+	healthActivationCheck := widget.NewCheck(a.tr.HealthActivation, nil)
+	healthActivationCheck.SetChecked(health.Activation)
+	healthPendingRebootCheck := widget.NewCheck(a.tr.HealthPendingReboot, nil)
+	healthPendingRebootCheck.SetChecked(health.PendingReboot)
+	healthWidgets := []fyne.Disableable{healthServicesEntry, healthDNSNamesEntry, healthSecureChannelCheck,
+		healthDNSCheck, healthGatewayCheck, healthActivationCheck, healthPendingRebootCheck}
+	healthCheck := widget.NewCheck(a.tr.HealthEnabled, func(checked bool) {
+		for _, w := range healthWidgets {
+			if checked {
+				w.Enable()
+			} else {
+				w.Disable()
+			}
+		}
+	})
+	healthCheck.SetChecked(health.Enabled)
+	healthCheck.OnChanged(healthCheck.Checked)
+	healthInfo := widget.NewLabel(a.tr.HealthInfo)
+	healthInfo.Wrapping = fyne.TextWrapWord
+
 	// Dark mode toggle
 	darkModeCheck := widget.NewCheck(a.tr.DarkMode, func(checked bool) {
 		if checked {
@@ -90,6 +201,8 @@ func (a *App) showSettingsDialog() {
 	// Info text för parallella uppgraderingar
 	parallelInfo := widget.NewLabel(a.tr.ParallelUpgradesInfo)
 	parallelInfo.Wrapping = fyne.TextWrapWord
+	concurrencyInfo := widget.NewLabel(a.tr.ConcurrencyInfo)
+	concurrencyInfo.Wrapping = fyne.TextWrapWord
 
 	// Timeout-inställningar
 	signalScriptTimeoutEntry := widget.NewEntry()
@@ -104,6 +217,9 @@ func (a *App) showSettingsDialog() {
 	powerOffEntry := widget.NewEntry()
 	powerOffEntry.SetText(strconv.Itoa(a.config.Timeouts.PowerOffMinutes))
 
+	compatScanEntry := widget.NewEntry()
+	compatScanEntry.SetText(strconv.Itoa(a.config.Timeouts.CompatScanMinutes))
+
 	labeled := func(label string, obj fyne.CanvasObject) fyne.CanvasObject {
 		if label == "" {
 			return obj
@@ -123,10 +239,48 @@ func (a *App) showSettingsDialog() {
 	upgradeTab := container.NewVScroll(container.NewVBox(
 		labeled(a.tr.ParallelUpgrades, parallelEntry),
 		parallelInfo,
+		container.NewGridWithColumns(3,
+			labeled(a.tr.MaxPerHost, maxPerHostEntry),
+			labeled(a.tr.MaxPerDatastore, maxPerDatastoreEntry),
+			labeled(a.tr.MaxPerCluster, maxPerClusterEntry),
+		),
+		concurrencyInfo,
+		widget.NewSeparator(),
+		wavesCheck,
+		wavesInfo,
+		container.NewGridWithColumns(2,
+			labeled(a.tr.WaveCanarySize, canaryEntry),
+			labeled(a.tr.WaveGrowthFactor, growthEntry),
+			labeled(a.tr.WaveMaxSize, maxWaveEntry),
+			labeled(a.tr.WaveMaxFailures, maxFailuresEntry),
+			labeled(a.tr.WaveMaxFailurePct, maxFailurePctEntry),
+		),
 		labeled(a.tr.TimeoutMinutes, timeoutEntry),
 		labeled(a.tr.DiskPrecheckGB, diskCheckEntry),
 		skipMemoryCheck,
 		rebootCheck,
+		widget.NewSeparator(),
+		compatScanCheck,
+		compatScanInfo,
+		widget.NewSeparator(),
+		rollbackCheck,
+		rollbackInfo,
+		rollbackClasses[upgrade.FailureSetup],
+		rollbackClasses[upgrade.FailureOSCheck],
+		rollbackClasses[upgrade.FailureBoot],
+		widget.NewSeparator(),
+		inventoryCheck,
+		inventoryInfo,
+		widget.NewSeparator(),
+		healthCheck,
+		healthInfo,
+		labeled(a.tr.HealthServices, healthServicesEntry),
+		healthSecureChannelCheck,
+		healthDNSCheck,
+		labeled(a.tr.HealthDNSNames, healthDNSNamesEntry),
+		healthGatewayCheck,
+		healthActivationCheck,
+		healthPendingRebootCheck,
 	))
 
 	timeoutGrid := container.NewGridWithColumns(2,
@@ -134,6 +288,7 @@ func (a *App) showSettingsDialog() {
 		labeled(a.tr.SignalFilesMinutes, signalFilesTimeoutEntry),
 		labeled(a.tr.OSVersionPollingMinutes, targetOsEntry),
 		labeled(a.tr.PowerOffTimeoutMinutes, powerOffEntry),
+		labeled(a.tr.CompatScanMinutes, compatScanEntry),
 	)
 
 	timeoutsTab := container.NewVScroll(container.NewVBox(
@@ -167,10 +322,49 @@ func (a *App) showSettingsDialog() {
 		a.config.Defaults.IsoDatastorePath = isoPathEntry.Text
 		a.config.Defaults.SkipMemoryInSnapshot = skipMemoryCheck.Checked
 		a.config.Upgrade.Reboot = rebootCheck.Checked
+		a.config.Upgrade.CompatScan = compatScanCheck.Checked
+		a.config.Upgrade.Inventory = inventoryCheck.Checked
+		a.config.Upgrade.Rollback.Enabled = rollbackCheck.Checked
+		a.config.Upgrade.Rollback.On = nil
+		for _, class := range []string{upgrade.FailureSetup, upgrade.FailureOSCheck, upgrade.FailureBoot} {
+			if rollbackClasses[class].Checked {
+				a.config.Upgrade.Rollback.On = append(a.config.Upgrade.Rollback.On, class)
+			}
+		}
+		if len(a.config.Upgrade.Rollback.On) == 0 {
+			// Tom lista betyder alla feltyper i konfigurationen
+			a.config.Upgrade.Rollback.Enabled = false
+		}
+
+		a.config.Upgrade.Health = config.HealthConfig{
+			Enabled:       healthCheck.Checked,
+			Services:      splitList(healthServicesEntry.Text),
+			SecureChannel: healthSecureChannelCheck.Checked,
+			DNS:           healthDNSCheck.Checked,
+			DNSNames:      splitList(healthDNSNamesEntry.Text),
+			Gateway:       healthGatewayCheck.Checked,
+			Activation:    healthActivationCheck.Checked,
+			PendingReboot: healthPendingRebootCheck.Checked,
+		}
 
 		if parallel, err := strconv.Atoi(parallelEntry.Text); err == nil {
 			a.config.Upgrade.Parallel = parallel
 		}
+		a.config.Upgrade.Waves.Enabled = wavesCheck.Checked
+		for entry, field := range map[*widget.Entry]*int{
+			maxPerHostEntry:      &a.config.Upgrade.MaxPerHost,
+			maxPerDatastoreEntry: &a.config.Upgrade.MaxPerDatastore,
+			maxPerClusterEntry:   &a.config.Upgrade.MaxPerCluster,
+			canaryEntry:          &a.config.Upgrade.Waves.CanarySize,
+			growthEntry:          &a.config.Upgrade.Waves.GrowthFactor,
+			maxWaveEntry:         &a.config.Upgrade.Waves.MaxWaveSize,
+			maxFailuresEntry:     &a.config.Upgrade.Waves.MaxFailures,
+			maxFailurePctEntry:   &a.config.Upgrade.Waves.MaxFailurePercent,
+		} {
+			if value, err := strconv.Atoi(entry.Text); err == nil && value >= 0 {
+				*field = value
+			}
+		}
 		if timeout, err := strconv.Atoi(timeoutEntry.Text); err == nil {
 			a.config.Upgrade.TimeoutMinutes = timeout
 		}
@@ -190,6 +384,9 @@ func (a *App) showSettingsDialog() {
 		if value, err := strconv.Atoi(powerOffEntry.Text); err == nil {
 			a.config.Timeouts.PowerOffMinutes = value
 		}
+		if value, err := strconv.Atoi(compatScanEntry.Text); err == nil {
+			a.config.Timeouts.CompatScanMinutes = value
+		}
 
 		// Handle language change
 		newLang := "en"
@@ -231,3 +428,14 @@ func (a *App) showSettingsDialog() {
 	settingsDialog.Resize(fyne.NewSize(900, 700))
 	settingsDialog.Show()
 }
+
+// splitList delar en kommaseparerad lista och hoppar över tomma poster
+func splitList(text string) []string {
+	var list []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -2,21 +2,28 @@ package gui
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
 	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/journal"
 	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
 	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
-	"github.com/vmware/govmomi/object"
 )
 
-func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
+// showUpgradeScreen visar uppgraderingsskärmen för valda VMs, uppdelade i
+// etapper som körs i ordning. Om resume är satt återupptas en avbruten
+// körning från journalen i stället, med dess etapper.
+func (a *App) showUpgradeScreen(selectedVMs map[string]bool, stages []upgrade.Stage, resume *journal.Run) {
 	// Räkna valda VMs
 	var selectedNames []string
 	for name, checked := range selectedVMs {
@@ -25,6 +32,29 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 		}
 	}
 
+	// Återupptagen körning: journalens ofärdiga VMs
+	resumeRecords := make(map[string]*journal.VMRecord)
+	if resume != nil {
+		selectedNames = nil
+		for _, rec := range resume.Pending() {
+			selectedNames = append(selectedNames, rec.Name)
+			resumeRecords[rec.Name] = rec
+		}
+		stages = resume.StageList()
+	}
+
+	// Etappernas ordning, VMs utan etapp sist och i namnordning
+	sort.Strings(selectedNames)
+	stages = upgrade.OrderStages(stages, selectedNames)
+	selectedNames = nil
+	stageOf := make(map[string]string)
+	for _, st := range stages {
+		selectedNames = append(selectedNames, st.VMs...)
+		for _, vmName := range st.VMs {
+			stageOf[vmName] = st.Name
+		}
+	}
+
 	// Titel
 	title := widget.NewLabelWithStyle(
 		fmt.Sprintf(a.tr.UpgradeVMs, len(selectedNames)),
@@ -34,7 +64,9 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 
 	// Formulär för guest credentials - förifylla med sparade värden
 	guestUserEntry := widget.NewEntry()
-	if a.config.Defaults.GuestUsername != "" {
+	if resume != nil && resume.GuestUsername != "" {
+		guestUserEntry.SetText(resume.GuestUsername)
+	} else if a.config.Defaults.GuestUsername != "" {
 		guestUserEntry.SetText(a.config.Defaults.GuestUsername)
 	} else {
 		guestUserEntry.SetText("Administrator")
@@ -52,9 +84,63 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 	}
 	isoPathEntry.SetPlaceHolder("[datastore1] iso/windows-server-2022.iso")
 
+	// Målprofil - bestämmer ISO, tillåtna käll-OS och vilket OS som räknas som klart
+	var profileNames []string
+	for _, p := range a.config.Profiles {
+		profileNames = append(profileNames, p.Name)
+	}
+	profileSelect := widget.NewSelect(profileNames, func(name string) {
+		if p := a.config.Profile(name); p != nil && p.ISOPath != "" {
+			isoPathEntry.SetText(p.ISOPath)
+		}
+	})
+	switch {
+	case resume != nil && a.config.Profile(resume.Profile) != nil:
+		profileSelect.SetSelected(resume.Profile)
+	case a.config.Profile(a.config.Defaults.TargetProfile) != nil:
+		profileSelect.SetSelected(a.config.Defaults.TargetProfile)
+	case len(profileNames) > 0:
+		profileSelect.SetSelected(profileNames[0])
+	}
+	if resume != nil {
+		isoPathEntry.SetText(resume.ISOPath)
+	}
+
 	// Snapshot-alternativ
 	createSnapshotCheck := widget.NewCheck(a.tr.CreateSnapshot, nil)
 	createSnapshotCheck.SetChecked(true)
+	if resume != nil {
+		createSnapshotCheck.SetChecked(resume.CreateSnapshot)
+	}
+
+	// Schemaläggning: start och slut på underhållsfönstret
+	scheduleStartEntry := widget.NewEntry()
+	scheduleStartEntry.SetPlaceHolder(a.tr.SchedulePlaceholder)
+	scheduleEndEntry := widget.NewEntry()
+	scheduleEndEntry.SetPlaceHolder(a.tr.ScheduleEndPlaceholder)
+	scheduleCheck := widget.NewCheck(a.tr.ScheduleEnabled, func(checked bool) {
+		if checked {
+			scheduleStartEntry.Enable()
+			scheduleEndEntry.Enable()
+		} else {
+			scheduleStartEntry.Disable()
+			scheduleEndEntry.Disable()
+		}
+	})
+	if resume != nil {
+		// Schemat för en körning som väntade när appen stängdes
+		w := resume.Window()
+		if !w.Start.IsZero() {
+			scheduleStartEntry.SetText(w.Start.Format(upgrade.WindowTimeFormat))
+			scheduleCheck.SetChecked(true)
+		}
+		if !w.End.IsZero() {
+			scheduleEndEntry.SetText(w.End.Format(upgrade.WindowTimeFormat))
+			scheduleCheck.SetChecked(true)
+		}
+	}
+	scheduleCheck.OnChanged(scheduleCheck.Checked)
+	scheduleLabel := widget.NewLabel("")
 
 	// Progress-widget
 	progressBar := widget.NewProgressBar()
@@ -62,17 +148,31 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 	progressBar.Max = float64(len(selectedNames))
 
 	statusLabel := widget.NewLabel(fmt.Sprintf(a.tr.ReadyToStart, len(selectedNames)))
-	logText := widget.NewMultiLineEntry()
-	logText.Wrapping = fyne.TextWrapWord
-	// Disable() borttaget så texten är läsbar och användaren kan markera/kopiera
+	// Körloggen visas bakom Körlogg-knappen
+	runLog := &runLogBuffer{}
 
 	// Skriv ut valda servrar direkt när skärmen laddas
-	logText.SetText(fmt.Sprintf("=== VALDA SERVRAR (%d st) ===\n", len(selectedNames)))
+	runLog.add(fmt.Sprintf("=== VALDA SERVRAR (%d st) ===", len(selectedNames)))
 	for i, vmName := range selectedNames {
-		logText.SetText(logText.Text + fmt.Sprintf("%d. %s\n", i+1, vmName))
+		if stageOf[vmName] != "" {
+			runLog.add(fmt.Sprintf("%d. %s [%s]", i+1, vmName, stageOf[vmName]))
+			continue
+		}
+		runLog.add(fmt.Sprintf("%d. %s", i+1, vmName))
+	}
+	runLog.add("===========================\n\n")
+	if resume != nil {
+		runLog.add(fmt.Sprintf(a.tr.ReattachInfo+"\n\n", resume.ID))
 	}
-	logText.SetText(logText.Text + "===========================\n\n")
-	logText.SetText(logText.Text + a.tr.FillInDetails)
+	runLog.add(a.tr.FillInDetails)
+
+	// Tabell med status per VM och avbryt-knapp, dubbelklick visar detaljer
+	vmRows := newVMStatusList(selectedNames, a.tr)
+	vmRows.onChanges = a.showInventoryChanges
+	vmRows.onDetails = a.showVMDetails
+
+	// VMs som operatören valt bort efter preflight
+	deselected := make(map[string]bool)
 
 	// Logga också valda servrar
 	debug.Log("=== UPGRADE SCREEN - VALDA SERVRAR (%d st) ===", len(selectedNames))
@@ -83,7 +183,19 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 	// Deklarera knappar först för att undvika scope-problem
 	var startBtn *widget.Button
 	var backBtn *widget.Button
+	var cancelAllBtn *widget.Button
+	var preflightBtn *widget.Button
+	var retryBtn *widget.Button
+
+	// Förra körningen, för att försöka igen med de VMs som misslyckades.
+	// retryNames sätts av "Försök igen" precis innan körningen startas.
+	var retryNames []string
+	var last lastRun
+
 	startBtn = widget.NewButton(a.tr.StartUpgrade, func() {
+		retrying := retryNames
+		retryNames = nil
+
 		guestUser := guestUserEntry.Text
 		guestPass := guestPassEntry.Text
 		isoPath := isoPathEntry.Text
@@ -100,170 +212,405 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 			dialog.ShowError(fmt.Errorf("%s", a.tr.FillAllFields), a.window)
 			return
 		}
+		profile := a.config.Profile(profileSelect.Selected)
+		if profile == nil {
+			dialog.ShowError(fmt.Errorf("%s", a.tr.SelectProfile), a.window)
+			return
+		}
+		a.config.Defaults.TargetProfile = profile.Name
+		debug.Log("GUI target profile: %s", profile.Name)
+
+		// Hooks, vågor och etapper kontrolleras innan någon VM rörs
+		if err := a.checkRunConfig(stages); err != nil {
+			dialog.ShowError(err, a.window)
+			return
+		}
+
+		// Underhållsfönstret läses innan något startas
+		var window upgrade.MaintenanceWindow
+		if scheduleCheck.Checked {
+			var err error
+			if window, err = parseWindow(scheduleStartEntry.Text, scheduleEndEntry.Text, time.Now()); err != nil {
+				dialog.ShowError(fmt.Errorf("%s: %v", a.tr.ScheduleInvalid, err), a.window)
+				return
+			}
+		}
+
+		if len(a.config.Hooks) > 0 {
+			runLog.logf(a.tr.HooksConfigured,
+				len(upgrade.HooksFor(a.config.Hooks, upgrade.HookPre)), len(upgrade.HooksFor(a.config.Hooks, upgrade.HookPost)))
+		}
 
 		// Validera ISO först
 		statusLabel.SetText(a.tr.ValidatingISO)
-		logText.SetText(logText.Text + fmt.Sprintf("[%s] %s: %s\n", time.Now().Format("15:04:05"), a.tr.ValidatingISO, isoPath))
+		runLog.logf("%s: %s", a.tr.ValidatingISO, isoPath)
 
 		go func() {
-			ctx := context.Background()
-			if err := upgrade.ValidateISOPath(ctx, isoPath); err != nil {
+			if err := a.checkISO(isoPath, *profile, runLog); err != nil {
 				statusLabel.SetText(a.tr.ISOValidationFailed)
-				logText.SetText(logText.Text + fmt.Sprintf("[%s] ERROR: %v\n", time.Now().Format("15:04:05"), err))
+				runLog.logf("ERROR: %v", err)
 				dialog.ShowError(fmt.Errorf("%s: %v", a.tr.ISOValidationFailed, err), a.window)
 				return
 			}
 
+			// VMs som valts bort efter preflight ingår inte i körningen, och
+			// ett nytt försök gäller bara de som misslyckades
+			runNames, dropped := selectRunNames(selectedNames, deselected, retrying)
+			if resume != nil {
+				// Bortvald VM i återupptagen körning ska inte erbjudas igen
+				for _, vmName := range dropped {
+					if err := a.journal.SetVMStatus(resume.ID, vmName, journal.VMCancelled, fmt.Errorf("%w: deselected after preflight", upgrade.ErrCancelled)); err != nil {
+						debug.LogError("JournalSetVMStatus", err, "VM", vmName)
+					}
+				}
+			}
+			if len(runNames) == 0 {
+				statusLabel.SetText(a.tr.NoVMsSelected)
+				return
+			}
+
 			statusLabel.SetText(a.tr.ISOOK)
-			logText.SetText(logText.Text + fmt.Sprintf("[%s] %s\n", time.Now().Format("15:04:05"), a.tr.ISOValidated))
-			logText.SetText(logText.Text + fmt.Sprintf("[%s] "+a.tr.StartingUpgrade, time.Now().Format("15:04:05"), len(selectedNames)))
+			runLog.logf("%s", a.tr.ISOValidated)
+			runLog.logf(a.tr.StartingUpgrade, len(runNames))
+
+			if len(retrying) > 0 {
+				runLog.logf(a.tr.RetryStarting, len(runNames))
+			}
 
 			// Logga start till debug-logg
-			debug.Log("=== STARTAR UPPGRADERING AV %d SERVRAR ===", len(selectedNames))
+			debug.Log("=== STARTAR UPPGRADERING AV %d SERVRAR ===", len(runNames))
+			input := runInput{
+				guestUser:      guestUser,
+				guestPass:      guestPass,
+				isoPath:        isoPath,
+				profile:        profile.Name,
+				createSnapshot: createSnapshotCheck.Checked,
+			}
 
 			startBtn.Disable()
 			backBtn.Disable()
+			preflightBtn.Disable()
+			retryBtn.Disable()
+			scheduleCheck.Disable()
+			scheduleStartEntry.Disable()
+			scheduleEndEntry.Disable()
+
+			// Varje VM får en egen context under en gemensam för hela
+			// körningen så den kan avbrytas ensam eller tillsammans med
+			// resten. VMInfo hittas i den laddade listan, journalen används
+			// för återupptagna körningar om VM:en inte finns där.
+			batchCtx, cancelBatch := context.WithCancel(context.Background())
+			defer cancelBatch()
+			jobs := make([]rolloutJob, 0, len(runNames))
+			for _, vmName := range runNames {
+				vmCtx, cancelVM := context.WithCancel(batchCtx)
+				vmRows.reset(vmName)
+				vmRows.setCancel(vmName, cancelVM)
+				job := rolloutJob{vmName: vmName, resume: resumeRecords[vmName], ctx: vmCtx}
+				job.vmInfo = a.lookupVMInfo(vmName, job.resume)
+				if len(retrying) > 0 {
+					if job.previous = last.results[vmName]; job.previous == nil {
+						runLog.logf(a.tr.RetryNoResult, vmName)
+					}
+				}
+				jobs = append(jobs, job)
+			}
+			// Tiderna i tabellen räknar medan körningen pågår
+			stopClock := make(chan struct{})
+			defer close(stopClock)
+			go vmRows.tick(stopClock)
+			cancelAllBtn.Enable()
+			progressBar.Max = float64(len(runNames))
+			progressBar.SetValue(0)
 
 			// Parallell uppgradering med worker pool
 			maxWorkers := a.config.Upgrade.Parallel
 			if maxWorkers <= 0 {
 				maxWorkers = 10 // Fallback om config är felaktig
 			}
-			if maxWorkers > len(selectedNames) {
-				maxWorkers = len(selectedNames)
+			if maxWorkers > len(runNames) {
+				maxWorkers = len(runNames)
 			}
 
-			debug.Log("Starting parallel upgrade with %d workers for %d VMs (config.Upgrade.Parallel=%d)", maxWorkers, len(selectedNames), a.config.Upgrade.Parallel)
+			debug.Log("Starting parallel upgrade with %d workers for %d VMs (config.Upgrade.Parallel=%d)", maxWorkers, len(runNames), a.config.Upgrade.Parallel)
 
-			// Channels och counters
-			type upgradeJob struct {
-				vmName string
-				vmInfo vcenter.VMInfo
+			// Etapperna i ordning, var och en i vågor om det är påslaget
+			runStages := upgrade.OrderStages(stages, runNames)
+			var retry *lastRun
+			if len(retrying) > 0 {
+				retry = &last
 			}
+			runID := a.journalRun(input, window, runStages, jobs, resume, retry)
 
-			type upgradeResult struct {
-				vmName string
-				err    error
+			// Schemaläggaren delar ut VMs till workers inom gränserna per
+			// host, datastore och kluster
+			sched := upgrade.NewScheduler(a.config.Upgrade)
+			sched.Waiting = func(vm vcenter.VMInfo, resource string) {
+				debug.Log("VM %s waits for %s", vm.Name, resource)
+				vmRows.setStatus(vm.Name, fmt.Sprintf(a.tr.StatusWaitingLimit, resource))
+			}
+			if sched.Limited() {
+				runLog.logf(a.tr.ConcurrencyLimits,
+					limitText(a.config.Upgrade.MaxPerHost), limitText(a.config.Upgrade.MaxPerDatastore), limitText(a.config.Upgrade.MaxPerCluster))
 			}
 
-			jobs := make(chan upgradeJob, len(selectedNames))
-			results := make(chan upgradeResult, len(selectedNames))
-			var wg sync.WaitGroup
-			var mu sync.Mutex // För thread-safe GUI updates
-
-			completed := 0
-			failures := 0
-
-			// Starta workers
-			for w := 1; w <= maxWorkers; w++ {
-				wg.Add(1)
-				go func(workerID int) {
-					defer wg.Done()
-					debug.Log("Worker %d started", workerID)
-
-					for job := range jobs {
-						debug.Log("Worker %d processing VM: %s", workerID, job.vmName)
-
-						// Skapa VM-objekt
-						client := a.GetClient()
-						vm := object.NewVirtualMachine(client.GetVim(), job.vmInfo.Ref)
-
-						// Skapa snapshot-namn med timestamp och VM-namn
-						snapshotName := fmt.Sprintf("%s-pre-%s-%s", a.config.Defaults.SnapshotNamePrefix, job.vmName, time.Now().Format("20060102-150405"))
-
-						// Uppgraderingsalternativ
-						opts := upgrade.UpgradeOptions{
-							VMInfo:         job.vmInfo,
-							GuestUsername:  guestUser,
-							GuestPassword:  guestPass,
-							ISOPath:        isoPath,
-							CreateSnapshot: createSnapshotCheck.Checked,
-							SnapshotName:   snapshotName,
-							Config:         a.config,
-						}
-
-						// Thread-safe log update - startar
-						mu.Lock()
-						logText.SetText(logText.Text + fmt.Sprintf("\n[%s] === %s === (Worker %d)\n", time.Now().Format("15:04:05"), job.vmName, workerID))
-						mu.Unlock()
+			// Debugloggen, journalen, VM-tabellen och körloggen prenumererar
+			// på samma händelseström från uppgraderingen
+			observers := []upgrade.Observer{upgrade.DebugLog, upgrade.ObserverFunc(func(e upgrade.Event) {
+				vmRows.observe(e, a.eventLogLine(e))
+			}), a.runLogObserver(runLog)}
+			if runID != "" {
+				observers = append(observers, a.journal.Observer(runID))
+			}
 
-						// Kör uppgradering
-						err := upgrade.UpgradeSingleVM(vm, opts)
+			screen := &rolloutScreen{
+				a:           a,
+				stages:      runStages,
+				total:       len(runNames),
+				runLog:      runLog,
+				vmRows:      vmRows,
+				statusLabel: statusLabel,
+				progressBar: progressBar,
+				last:        &last,
+			}
+			ro := newRollout(a.config.Upgrade, window, runStages, jobs, sched, screen)
+			ro.journal, ro.runID = a.journal, runID
+			screen.batches, screen.cancelled = len(ro.batches), ro.allCancelled
+			ro.upgradeVM = func(job rolloutJob, workerID int) (*upgrade.UpgradeResult, error) {
+				vm, err := a.upgradeVM(job.ctx, job.vmInfo, *profile)
+				if err != nil {
+					return nil, err
+				}
 
-						// Skicka resultat
-						results <- upgradeResult{
-							vmName: job.vmName,
-							err:    err,
-						}
+				// Skapa snapshot-namn med timestamp och VM-namn
+				snapshotName := fmt.Sprintf("%s-pre-%s-%s", a.config.Defaults.SnapshotNamePrefix, job.vmName, time.Now().Format("20060102-150405"))
+				var resumeResult *upgrade.UpgradeResult
+				if job.previous != nil {
+					resumeResult = job.previous
+				} else if job.resume != nil {
+					resumeResult = job.resume.Result()
+					if job.resume.SnapshotName != "" {
+						snapshotName = job.resume.SnapshotName
 					}
+				}
+
+				// Uppgraderingsalternativ
+				opts := upgrade.UpgradeOptions{
+					VMInfo:         job.vmInfo,
+					GuestUsername:  input.guestUser,
+					GuestPassword:  input.guestPass,
+					ISOPath:        input.isoPath,
+					CreateSnapshot: input.createSnapshot,
+					SnapshotName:   snapshotName,
+					Config:         a.config,
+					Profile:        *profile,
+					Resume:         resumeResult,
+					Observers:      observers,
+					Context:        job.ctx,
+					RunID:          runID,
+				}
 
-					debug.Log("Worker %d finished", workerID)
-				}(w)
+				runLog.add("")
+				runLog.logf("=== %s === (Worker %d)", job.vmName, workerID)
+				return upgrade.UpgradeSingleVM(vm, opts)
 			}
 
-			// Skicka alla jobb till workers
-			for _, vmName := range selectedNames {
-				// Hitta VMInfo
-				var vmInfo vcenter.VMInfo
-				for _, vm := range a.GetVMs() {
-					if vm.Name == vmName {
-						vmInfo = vm
-						break
-					}
+			// Schemalagd körning: vänta på fönstret med nedräkning. Körningen
+			// finns redan i journalen så schemat överlever en omstart.
+			// Avbryt alla avbryter också väntan.
+			if window.Scheduled(time.Now()) {
+				start := window.Start.Format(upgrade.WindowTimeFormat)
+				scheduleLabel.SetText(fmt.Sprintf(a.tr.ScheduledRun, start, a.windowEndText(window), len(runNames)))
+				runLog.logf(a.tr.ScheduledRun, start, a.windowEndText(window), len(runNames))
+				for _, vmName := range runNames {
+					vmRows.setStatus(vmName, fmt.Sprintf(a.tr.StatusScheduled, start))
 				}
-
-				jobs <- upgradeJob{
-					vmName: vmName,
-					vmInfo: vmInfo,
+				window.WaitStart(batchCtx, func(left time.Duration) {
+					statusLabel.SetText(fmt.Sprintf(a.tr.ScheduleCountdown, start, left.Round(time.Second)))
+				}, ro.allCancelled)
+				for _, job := range jobs {
+					if job.ctx.Err() == nil {
+						vmRows.setStatus(job.vmName, a.tr.StatusQueued)
+					}
 				}
 			}
-			close(jobs)
-
-			// Samla resultat i en goroutine
-			go func() {
-				wg.Wait()
-				close(results)
-			}()
-
-			// Hantera resultat och uppdatera UI
-			for result := range results {
-				mu.Lock()
-
-				completed++
-				if result.err != nil {
-					failures++
-					logText.SetText(logText.Text + fmt.Sprintf("[%s] "+a.tr.UpgradeFailed+"\n", time.Now().Format("15:04:05"), result.vmName, result.err))
-					statusLabel.SetText(fmt.Sprintf(a.tr.VMCompleteStatus,
-						completed, len(selectedNames), result.vmName, completed-failures, failures))
-				} else {
-					logText.SetText(logText.Text + fmt.Sprintf("[%s] "+a.tr.UpgradeCompleted+"\n", time.Now().Format("15:04:05"), result.vmName))
-					statusLabel.SetText(fmt.Sprintf(a.tr.VMSuccessStatus,
-						completed, len(selectedNames), result.vmName, completed-failures, failures))
+			if len(ro.batches) > 1 {
+				plan := make([]string, len(ro.batches))
+				for i, b := range ro.batches {
+					label := a.batchLabel(runStages, b)
+					plan[i] = fmt.Sprintf("%s (%d)", label, len(b.VMs))
+					if i == 0 {
+						continue
+					}
+					for _, vmName := range b.VMs {
+						if ro.jobs[vmName].ctx.Err() == nil {
+							vmRows.setStatus(vmName, fmt.Sprintf(a.tr.StatusWaveQueued, label))
+						}
+					}
 				}
+				runLog.logf(a.tr.RolloutPlan, strings.Join(plan, ", "))
+			}
+			if !window.End.IsZero() {
+				scheduleLabel.SetText(fmt.Sprintf(a.tr.ScheduleWindowOpen, window.End.Format(upgrade.WindowTimeFormat)))
+			}
 
-				progressBar.SetValue(float64(completed))
+			stoppedAfter, haltErr := ro.run(maxWorkers)
+			counts := ro.counts
+			if haltErr != nil {
+				progressBar.SetValue(float64(counts.completed))
+				label := a.batchLabel(runStages, stoppedAfter)
+				debug.Log("Rollout halted after %s: %v", label, haltErr)
+				runLog.logf(a.tr.RolloutHalted, label, haltErr, counts.halted)
+			}
 
-				mu.Unlock()
+			cancelAllBtn.Disable()
+			scheduleLabel.SetText("")
+
+			if counts.notStarted > 0 {
+				// Körningen lämnas ofärdig så resten kan schemaläggas igen
+				runLog.logf(a.tr.WindowClosed, window.End.Format(upgrade.WindowTimeFormat), counts.notStarted)
+			}
+			if runID != "" && counts.notStarted == 0 && counts.halted == 0 {
+				// En stoppad utrullning lämnas också ofärdig i journalen
+				if err := a.journal.FinishRun(runID); err != nil {
+					debug.LogError("JournalFinishRun", err)
+				}
 			}
 
 			// Klart - ingen popup, bara status och logg
-			statusLabel.SetText(fmt.Sprintf(a.tr.AllCompleteStatus, completed-failures, len(selectedNames), failures))
-			logText.SetText(logText.Text + fmt.Sprintf("\n[%s] %s\n", time.Now().Format("15:04:05"), a.tr.SummaryHeader))
-			logText.SetText(logText.Text + fmt.Sprintf("[%s] "+a.tr.SummaryTotal+"\n", time.Now().Format("15:04:05"), len(selectedNames)))
-			logText.SetText(logText.Text + fmt.Sprintf("[%s] "+a.tr.SummarySucceeded+"\n", time.Now().Format("15:04:05"), completed-failures))
-			logText.SetText(logText.Text + fmt.Sprintf("[%s] "+a.tr.SummaryFailed+"\n", time.Now().Format("15:04:05"), failures))
-			if failures == 0 {
-				logText.SetText(logText.Text + fmt.Sprintf("[%s] %s\n", time.Now().Format("15:04:05"), a.tr.AllSuccessful))
+			succeeded := counts.succeeded()
+			statusLabel.SetText(fmt.Sprintf(a.tr.AllCompleteStatus, succeeded, len(runNames), counts.failed))
+			runLog.add("")
+			runLog.logf("%s", a.tr.SummaryHeader)
+			runLog.logf(a.tr.SummaryTotal, len(runNames))
+			runLog.logf(a.tr.SummarySucceeded, succeeded)
+			runLog.logf(a.tr.SummaryFailed, counts.failed)
+			runLog.logf(a.tr.SummaryCancelled, counts.cancelled)
+			if counts.notStarted > 0 {
+				runLog.logf(a.tr.SummaryNotStarted, counts.notStarted)
+			}
+			if counts.halted > 0 {
+				runLog.logf(a.tr.SummaryHalted, counts.halted)
+			}
+			if counts.failed == 0 && counts.cancelled == 0 {
+				runLog.logf("%s", a.tr.AllSuccessful)
 			} else {
-				logText.SetText(logText.Text + fmt.Sprintf("[%s] %s\n", time.Now().Format("15:04:05"), a.tr.SomeFailed))
+				runLog.logf("%s", a.tr.SomeFailed)
 			}
-			logText.SetText(logText.Text + fmt.Sprintf("[%s] =======================\n\n", time.Now().Format("15:04:05")))
+			runLog.logf("=======================\n")
 
 			startBtn.Enable()
 			backBtn.Enable()
+			preflightBtn.Enable()
+			last.runID, last.input, last.failed = runID, input, counts.failedNames
+			if len(counts.failedNames) > 0 {
+				retryBtn.Enable()
+			}
+			scheduleCheck.Enable()
+			scheduleCheck.OnChanged(scheduleCheck.Checked)
+		}()
+	})
+
+	// Preflight: kör alla prechecks utan att ändra något på VMs
+	preflightBtn = widget.NewButton(a.tr.PreflightButton, func() {
+		guestUser := guestUserEntry.Text
+		guestPass := guestPassEntry.Text
+		isoPath := isoPathEntry.Text
+		if guestUser == "" || guestPass == "" || isoPath == "" {
+			dialog.ShowError(fmt.Errorf("%s", a.tr.FillAllFields), a.window)
+			return
+		}
+		profile := a.config.Profile(profileSelect.Selected)
+		if profile == nil {
+			dialog.ShowError(fmt.Errorf("%s", a.tr.SelectProfile), a.window)
+			return
+		}
+
+		preflightBtn.Disable()
+		startBtn.Disable()
+		statusLabel.SetText(fmt.Sprintf(a.tr.PreflightRunning, len(selectedNames)))
+		runLog.add("")
+		runLog.logf(a.tr.PreflightRunning, len(selectedNames))
+
+		go func() {
+			var infos []vcenter.VMInfo
+			for _, vmName := range selectedNames {
+				infos = append(infos, a.lookupVMInfo(vmName, resumeRecords[vmName]))
+			}
+			reports := a.runPreflight(infos, upgrade.UpgradeOptions{
+				GuestUsername:  guestUser,
+				GuestPassword:  guestPass,
+				ISOPath:        isoPath,
+				CreateSnapshot: createSnapshotCheck.Checked,
+				Config:         a.config,
+				Profile:        *profile,
+			})
+
+			// Skriv rapporten till loggen så den går att kopiera
+			ready := 0
+			for _, r := range reports {
+				if r.Ready() {
+					ready++
+					runLog.logf("✓ %s", r.VMName)
+					continue
+				}
+				runLog.logf("✗ %s", r.VMName)
+				for _, c := range r.Checks {
+					if c.Result == upgrade.CheckFailed {
+						runLog.add(fmt.Sprintf("           %s: %s", a.preflightHeader(c.Name), c.Detail))
+					}
+				}
+			}
+			statusLabel.SetText(fmt.Sprintf(a.tr.PreflightSummary, ready, len(reports)))
+			preflightBtn.Enable()
+			startBtn.Enable()
+
+			a.showPreflightDialog(reports, deselected, func(result map[string]bool) {
+				deselected = result
+				for _, vmName := range selectedNames {
+					if deselected[vmName] {
+						vmRows.setStatus(vmName, a.tr.StatusDeselected)
+					} else {
+						vmRows.setStatus(vmName, a.tr.StatusQueued)
+					}
+				}
+				count := len(selectedNames) - len(deselected)
+				title.SetText(fmt.Sprintf(a.tr.UpgradeVMs, count))
+				statusLabel.SetText(fmt.Sprintf(a.tr.ReadyToStart, count))
+				runLog.logf(a.tr.PreflightSelection, count, len(selectedNames))
+			})
 		}()
 	})
 
+	// Visa vad ISO:n innehåller utan att montera den
+	isoInfoBtn := widget.NewButton(a.tr.ISOInfoButton, func() {
+		a.showISOInfo(isoPathEntry.Text)
+	})
+
+	// Försök igen med de VMs som misslyckades, med samma inloggning, ISO
+	// och profil. Avklarade steg körs inte om.
+	retryBtn = widget.NewButton(a.tr.RetryFailed, func() {
+		debug.Log("Retry requested for %d failed VMs: %v", len(last.failed), last.failed)
+		guestUserEntry.SetText(last.input.guestUser)
+		guestPassEntry.SetText(last.input.guestPass)
+		profileSelect.SetSelected(last.input.profile)
+		isoPathEntry.SetText(last.input.isoPath)
+		createSnapshotCheck.SetChecked(last.input.createSnapshot)
+		retryNames = last.failed
+		startBtn.OnTapped()
+	})
+	retryBtn.Importance = widget.HighImportance
+	retryBtn.Disable()
+
+	// Avbryt alla köade och pågående VMs
+	cancelAllBtn = widget.NewButton(a.tr.CancelAll, func() {
+		debug.Log("Cancel all requested")
+		vmRows.cancelAll()
+		statusLabel.SetText(a.tr.StatusCancelling)
+	})
+	cancelAllBtn.Importance = widget.DangerImportance
+	cancelAllBtn.Disable()
+
 	// Tillbaka-knapp
 	backBtn = widget.NewButton(a.tr.Back, func() {
 		a.showVMSelectionScreen()
@@ -278,9 +625,14 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 	infoText := widget.NewLabel(a.tr.TipSaveCredentials)
 	infoText.Wrapping = fyne.TextWrapWord
 
-	// Log scroll
-	logScroll := container.NewVScroll(logText)
-	logScroll.SetMinSize(fyne.NewSize(900, 300))
+	// Körloggen öppnas i en dialog, VM-tabellen har varje VM:s egna händelser
+	runLogBtn := widget.NewButton(a.tr.RunLogButton, func() {
+		a.showRunLog(runLog)
+	})
+
+	dashboardHint := widget.NewLabel(a.tr.DashboardHint)
+	dashboardHint.Importance = widget.LowImportance
+	dashboard := container.NewBorder(dashboardHint, nil, nil, nil, vmRows.table)
 
 	// Layout
 	form := container.NewVBox(
@@ -288,9 +640,15 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 		widget.NewForm(
 			widget.NewFormItem(a.tr.GuestAdminUser+":", guestUserEntry),
 			widget.NewFormItem(a.tr.GuestAdminPassword+":", guestPassEntry),
-			widget.NewFormItem(a.tr.ISODatastorePath+":", isoPathEntry),
+			widget.NewFormItem(a.tr.TargetProfile+":", profileSelect),
+			widget.NewFormItem(a.tr.ISODatastorePath+":", container.NewBorder(nil, nil, nil, isoInfoBtn, isoPathEntry)),
 		),
 		createSnapshotCheck,
+		scheduleCheck,
+		container.NewGridWithColumns(2,
+			widget.NewForm(widget.NewFormItem(a.tr.ScheduleStart+":", scheduleStartEntry)),
+			widget.NewForm(widget.NewFormItem(a.tr.ScheduleEnd+":", scheduleEndEntry)),
+		),
 	)
 
 	content := container.NewBorder(
@@ -300,13 +658,373 @@ func (a *App) showUpgradeScreen(selectedVMs map[string]bool) {
 		),
 		container.NewVBox(
 			progressBar,
+			scheduleLabel,
 			statusLabel,
-			container.NewHBox(backBtn, settingsBtn, startBtn),
+			container.NewHBox(backBtn, settingsBtn, runLogBtn, preflightBtn, startBtn, retryBtn, cancelAllBtn),
 		),
 		nil,
 		nil,
-		logScroll,
+		dashboard,
 	)
 
 	a.window.SetContent(content)
 }
+
+// limitText visar en gräns för samtidiga uppgraderingar, "-" om den saknas
+func limitText(limit int) string {
+	if limit <= 0 {
+		return "-"
+	}
+	return strconv.Itoa(limit)
+}
+
+// checkRunConfig kontrollerar hooks, vågor och etapper innan en körning
+// startar
+func (a *App) checkRunConfig(stages []upgrade.Stage) error {
+	if err := upgrade.ValidateHooks(a.config.Hooks); err != nil {
+		return fmt.Errorf("%s: %v", a.tr.HooksInvalid, err)
+	}
+	if err := upgrade.ValidateWaves(a.config.Upgrade.Waves); err != nil {
+		return fmt.Errorf("%s: %v", a.tr.WavesInvalid, err)
+	}
+	if err := upgrade.ValidateStages(stages, a.config.Upgrade.Health); err != nil {
+		return fmt.Errorf("%s: %v", a.tr.StagesInvalid, err)
+	}
+	return nil
+}
+
+// parseWindow läser underhållsfönstret från skärmens fält. Utan start börjar
+// körningen direkt, utan slut stänger fönstret aldrig.
+func parseWindow(start, end string, now time.Time) (upgrade.MaintenanceWindow, error) {
+	var w upgrade.MaintenanceWindow
+	var err error
+	if strings.TrimSpace(start) != "" {
+		if w.Start, err = upgrade.ParseWindowTime(start, now); err != nil {
+			return w, err
+		}
+	}
+	if strings.TrimSpace(end) != "" {
+		from := w.Start
+		if from.IsZero() {
+			from = now
+		}
+		if w.End, err = upgrade.ParseWindowTime(end, from); err != nil {
+			return w, err
+		}
+	}
+	return w, w.Validate(now)
+}
+
+// checkISO kontrollerar att ISO:n finns och att dess build är profilens.
+// ISO:ns metadata skrivs till körloggen; går den inte att läsa fortsätter
+// körningen, edition och språk kontrolleras ändå per VM i precheck.
+func (a *App) checkISO(isoPath string, profile config.TargetProfile, runLog *runLogBuffer) error {
+	ctx := context.Background()
+	if err := upgrade.ValidateISOPath(ctx, isoPath); err != nil {
+		return err
+	}
+
+	meta, err := upgrade.ReadISOMetadata(ctx, isoPath)
+	switch {
+	case errors.Is(err, upgrade.ErrISONotFound):
+		return err
+	case err != nil:
+		runLog.logf(a.tr.ISOMetadataFailed, err)
+	case profile.TargetBuild != 0 && meta.Build != profile.TargetBuild:
+		return fmt.Errorf(a.tr.ISOBuildMismatch, meta.Build, profile.Name, profile.TargetBuild)
+	default:
+		runLog.logf(a.tr.ISOMedia, meta.Summary())
+		runLog.logf(a.tr.ISOImages, meta.Editions())
+	}
+	return nil
+}
+
+// journalRun journalför en körning så den kan återanslutas efter en krasch
+// och returnerar dess id, "" om den inte journalförs. Ett nytt försök köar
+// de misslyckade VMs igen i förra körningen, en återupptagen körning får
+// sitt nya fönster.
+func (a *App) journalRun(in runInput, window upgrade.MaintenanceWindow, stages []upgrade.Stage, jobs []rolloutJob, resume *journal.Run, retry *lastRun) string {
+	names := make([]string, 0, len(jobs))
+	vms := make([]vcenter.VMInfo, 0, len(jobs))
+	for _, job := range jobs {
+		names = append(names, job.vmName)
+		vms = append(vms, job.vmInfo)
+	}
+
+	var runID string
+	switch {
+	case retry != nil:
+		// Etapperna är redan journalförda
+		if retry.runID == "" {
+			return ""
+		}
+		if err := a.journal.Reopen(retry.runID, names); err != nil {
+			debug.LogError("JournalReopen", err, "Run", retry.runID)
+			return ""
+		}
+		return retry.runID
+	case resume != nil:
+		runID = resume.ID
+		if err := a.journal.SetWindow(runID, window); err != nil {
+			debug.LogError("JournalSetWindow", err, "Run", runID)
+		}
+	default:
+		run, err := a.journal.StartRun(in.isoPath, in.profile, in.guestUser, in.createSnapshot, window, vms)
+		if err != nil {
+			debug.LogError("JournalStartRun", err)
+			return ""
+		}
+		runID = run.ID
+	}
+	if err := a.journal.SetStages(runID, stages); err != nil {
+		debug.LogError("JournalSetStages", err, "Run", runID)
+	}
+	return runID
+}
+
+// rolloutScreen visar en körning på uppgraderingsskärmen: VM-tabellen,
+// statusraden, förloppet och körloggen
+type rolloutScreen struct {
+	a           *App
+	stages      []upgrade.Stage
+	batches     int
+	total       int
+	runLog      *runLogBuffer
+	vmRows      *vmStatusList
+	statusLabel *widget.Label
+	progressBar *widget.ProgressBar
+	last        *lastRun    // får varje resultat till ett nytt försök
+	cancelled   func() bool // alla VMs är avbrutna
+}
+
+func (s *rolloutScreen) batchStarting(index int, b upgrade.Batch) {
+	if s.batches > 1 {
+		s.runLog.add("")
+		s.runLog.logf(s.a.tr.BatchStarting, s.a.batchLabel(s.stages, b), index+1, s.batches, len(b.VMs))
+	}
+}
+
+func (s *rolloutScreen) vmFinished(res rolloutResult, outcome vmOutcome, counts rolloutCounts) {
+	a, vmName, result := s.a, res.vmName, res.result
+	s.last.record(res)
+	s.vmRows.setCancel(vmName, nil)
+	if result != nil && result.Inventory != nil && result.Inventory.After != nil {
+		// Jämförelsen finns även när hälsokontrollerna fällt VM:en
+		changes := result.Inventory.Changes
+		s.vmRows.setChanges(vmName, changes)
+		s.runLog.logf(a.tr.InventoryResult, vmName, len(changes))
+	}
+
+	status := a.tr.VMCompleteStatus
+	switch outcome {
+	case outcomeNotStarted:
+		s.vmRows.setStatus(vmName, a.tr.StatusWindowClosed)
+		s.vmRows.setOutcome(vmName, widget.WarningImportance)
+	case outcomeCancelled:
+		s.vmRows.setStatus(vmName, a.tr.StatusCancelled)
+		s.vmRows.setOutcome(vmName, widget.WarningImportance)
+		s.runLog.logf(a.tr.UpgradeCancelled, vmName, res.err)
+	case outcomeFailed:
+		s.vmRows.setStatus(vmName, a.tr.StatusFailed+res.err.Error())
+		s.vmRows.setOutcome(vmName, widget.DangerImportance)
+		s.runLog.logf(a.tr.UpgradeFailed, vmName, res.err)
+		if result != nil {
+			s.failureDetails(vmName, result)
+		}
+	case outcomeSucceeded:
+		s.vmRows.setStatus(vmName, a.tr.StatusDone)
+		s.vmRows.setOutcome(vmName, widget.SuccessImportance)
+		if result != nil && result.Compat != nil {
+			s.runLog.logf(a.tr.CompatScanResult, vmName, result.Compat.Summary())
+		}
+		if result != nil && result.Health != nil {
+			s.runLog.add(a.healthText(vmName, result.Health))
+		}
+		s.runLog.logf(a.tr.UpgradeCompleted, vmName)
+		status = a.tr.VMSuccessStatus
+	}
+	s.statusLabel.SetText(fmt.Sprintf(status, counts.completed, s.total, vmName, counts.succeeded(), counts.failed))
+	s.progressBar.SetValue(float64(counts.completed))
+}
+
+// failureDetails visar varför en VM misslyckades: gästens loggar, avkodat
+// setup-resultat, kompatibilitetsblock, hälsokontroller och rollback
+func (s *rolloutScreen) failureDetails(vmName string, result *upgrade.UpgradeResult) {
+	a := s.a
+	if result.GuestLogs != "" {
+		s.vmRows.setLogs(vmName, result.GuestLogs)
+		s.runLog.logf(a.tr.GuestLogsCollected, vmName, result.GuestLogs)
+	}
+	if se := result.SetupError; se != nil {
+		// Avkodat setup-resultat med förklaring och åtgärd
+		s.vmRows.setStatus(vmName, fmt.Sprintf(a.tr.StatusSetupError, se.CodeString(), a.setupCategory(se.Category)))
+		s.runLog.add(a.setupErrorText(vmName, se))
+	}
+	if compat := result.Compat; compat != nil && compat.Blocked() {
+		// Visa varje hårt block från kompatibilitetsskanningen
+		s.vmRows.setStatus(vmName, fmt.Sprintf(a.tr.StatusCompatBlocked, len(compat.HardBlocks)))
+		s.runLog.logf(a.tr.CompatScanResult, vmName, compat.Summary())
+		for _, block := range compat.HardBlocks {
+			s.runLog.add(fmt.Sprintf("    "+a.tr.CompatHardBlock, block.String()))
+		}
+		if compat.Decoded != nil {
+			s.runLog.add(a.setupErrorText(vmName, compat.Decoded))
+		}
+	}
+	if result.Health != nil && len(result.Health.Failed()) > 0 {
+		// Uppgraderad men trasig: visa varje kontroll
+		s.vmRows.setStatus(vmName, fmt.Sprintf(a.tr.StatusHealthFailed, len(result.Health.Failed())))
+		s.runLog.add(a.healthText(vmName, result.Health))
+	}
+	if rb := result.Rollback; rb != nil {
+		if rb.Verified {
+			s.vmRows.setStatus(vmName, fmt.Sprintf(a.tr.StatusRolledBack, rb.SnapshotName))
+			s.runLog.logf(a.tr.RolledBack, vmName, rb.SnapshotName)
+		} else {
+			s.runLog.logf(a.tr.RollbackFailed, vmName, rb.Error)
+		}
+	}
+}
+
+func (s *rolloutScreen) batchFinished(b upgrade.Batch, succeeded, failed, skipped int) {
+	s.runLog.logf(s.a.tr.BatchFinished, s.a.batchLabel(s.stages, b), succeeded, failed, skipped)
+}
+
+func (s *rolloutScreen) confirmNext(done, next upgrade.Batch, succeeded, failed, skipped int) bool {
+	a := s.a
+	label, nextLabel := a.batchLabel(s.stages, done), a.batchLabel(s.stages, next)
+	s.statusLabel.SetText(fmt.Sprintf(a.tr.WaitingForWave, nextLabel))
+	return a.confirmWave(fmt.Sprintf(a.tr.NextWaveMessage, label, succeeded, failed, nextLabel, len(next.VMs)), s.cancelled)
+}
+
+func (s *rolloutScreen) vmHalted(vmName string, cancelled bool) {
+	s.vmRows.setCancel(vmName, nil)
+	if cancelled {
+		s.vmRows.setStatus(vmName, s.a.tr.StatusCancelled)
+	} else {
+		s.vmRows.setStatus(vmName, s.a.tr.StatusHalted)
+	}
+	s.vmRows.setOutcome(vmName, widget.WarningImportance)
+}
+
+// batchLabel beskriver en omgång i loggen, t.ex. "etapp db, våg 2". Utan
+// namngivna etapper räcker vågen.
+func (a *App) batchLabel(stages []upgrade.Stage, b upgrade.Batch) string {
+	wave := fmt.Sprintf(a.tr.BatchWave, b.Wave)
+	name := stages[b.Stage].Name
+	if name == "" && len(stages) == 1 {
+		return wave
+	}
+	label := a.tr.StageNone
+	if name != "" {
+		label = fmt.Sprintf(a.tr.BatchStage, name)
+	}
+	if b.Waves > 1 {
+		label += ", " + wave
+	}
+	return label
+}
+
+// confirmWave frågar operatören om nästa våg ska startas och väntar på
+// svaret. Frågan stängs om alla VMs avbryts medan den visas.
+func (a *App) confirmWave(message string, cancelled func() bool) bool {
+	answer := make(chan bool, 1)
+	d := dialog.NewConfirm(a.tr.NextWaveTitle, message, func(ok bool) {
+		answer <- ok
+	}, a.window)
+	d.Show()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case ok := <-answer:
+			return ok
+		case <-ticker.C:
+			if cancelled() {
+				d.Hide()
+				return true
+			}
+		}
+	}
+}
+
+// windowEndText formaterar slutet på ett underhållsfönster, "inget" om det
+// saknas
+func (a *App) windowEndText(w upgrade.MaintenanceWindow) string {
+	if w.End.IsZero() {
+		return a.tr.ScheduleNoEnd
+	}
+	return w.End.Format(upgrade.WindowTimeFormat)
+}
+
+// lookupVMInfo hittar VMInfo för en VM i den laddade listan. För återupptagna
+// körningar används journalen om VM:en inte finns i listan.
+func (a *App) lookupVMInfo(vmName string, rec *journal.VMRecord) vcenter.VMInfo {
+	for _, vm := range a.GetVMs() {
+		if vm.Name == vmName {
+			return vm
+		}
+	}
+	if rec != nil {
+		return rec.VMInfo()
+	}
+	return vcenter.VMInfo{Name: vmName}
+}
+
+// setupCategory översätter en kategori från setup-felkatalogen
+func (a *App) setupCategory(category string) string {
+	switch category {
+	case upgrade.CategoryCompatibility:
+		return a.tr.SetupCategoryCompat
+	case upgrade.CategoryDriverRollback:
+		return a.tr.SetupCategoryDriver
+	case upgrade.CategoryDiskSpace:
+		return a.tr.SetupCategoryDiskSpace
+	case upgrade.CategoryMedia:
+		return a.tr.SetupCategoryMedia
+	}
+	return a.tr.SetupCategoryOther
+}
+
+// healthText formaterar hälsokontrollerna för loggen, en rad per kontroll
+func (a *App) healthText(vmName string, report *upgrade.HealthReport) string {
+	text := fmt.Sprintf("[%s] "+a.tr.HealthResult+"\n", time.Now().Format("15:04:05"), vmName, report.Summary())
+	for i := range report.Checks {
+		text += fmt.Sprintf("    %s: %s\n", report.Checks[i].Name, preflightCell(&report.Checks[i]))
+	}
+	return text
+}
+
+// setupErrorText formaterar ett avkodat setup-resultat för loggen, med
+// förklaring och åtgärd på gränssnittets språk
+func (a *App) setupErrorText(vmName string, se *upgrade.SetupError) string {
+	lang := a.config.UI.Language
+	return fmt.Sprintf("[%s] "+a.tr.SetupErrorDecoded+"\n    %s\n    "+a.tr.SetupRemediation+"\n",
+		time.Now().Format("15:04:05"), vmName, se.CodeString(), a.setupCategory(se.Category),
+		se.Explanation.In(lang), se.Remediation.In(lang))
+}
+
+// eventLogLine formaterar en händelse från uppgraderingen för VM:ens
+// detaljvy och körloggen. Mätvärden och förloppskopior hamnar bara i
+// debugloggen.
+func (a *App) eventLogLine(e upgrade.Event) string {
+	ts := e.Time.Format("15:04:05")
+	switch e.Kind {
+	case upgrade.EventStepStarted:
+		return fmt.Sprintf("[%s] "+a.tr.EventStepStarted+"\n", ts, e.VM, e.Step)
+	case upgrade.EventStepFinished:
+		if e.Status == upgrade.StatusFailed {
+			return fmt.Sprintf("[%s] "+a.tr.EventStepFailed+"\n", ts, e.VM, e.Step, e.Err)
+		}
+	case upgrade.EventWarning:
+		return fmt.Sprintf("[%s] "+a.tr.EventWarning+"\n", ts, e.VM, e.Message)
+	case upgrade.EventGuestOutput:
+		return fmt.Sprintf("[%s] "+a.tr.EventGuestOutput+"\n", ts, e.VM, e.Message)
+	case upgrade.EventHookOutput:
+		return fmt.Sprintf("[%s] "+a.tr.EventHookOutput+"\n", ts, e.VM, e.Message)
+	case upgrade.EventMessage:
+		return fmt.Sprintf("[%s] "+a.tr.EventMessage+"\n", ts, e.VM, e.Message)
+	}
+	return ""
+}
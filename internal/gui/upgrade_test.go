@@ -0,0 +1,44 @@
+package gui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name      string
+		start     string
+		end       string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantErr   bool
+	}{
+		{name: "start and end", start: "22:00", end: "04:00", wantStart: time.Date(2026, 3, 10, 22, 0, 0, 0, time.Local), wantEnd: time.Date(2026, 3, 11, 4, 0, 0, 0, time.Local)},
+		{name: "end only", end: "18:00", wantEnd: time.Date(2026, 3, 10, 18, 0, 0, 0, time.Local)},
+		{name: "start only", start: " 2026-03-12 01:00 ", wantStart: time.Date(2026, 3, 12, 1, 0, 0, 0, time.Local)},
+		{name: "neither"},
+		{name: "bad start", start: "25:00", wantErr: true},
+		{name: "bad end", start: "22:00", end: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := parseWindow(tt.start, tt.end, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("window %+v, want an error", w)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !w.Start.Equal(tt.wantStart) || !w.End.Equal(tt.wantEnd) {
+				t.Errorf("window %v - %v, want %v - %v", w.Start, w.End, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
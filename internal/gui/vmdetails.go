@@ -0,0 +1,170 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+)
+
+// showVMDetails visar en VM:s stegtidslinje, försök, händelser och
+// gästloggar från den senaste körningen
+func (a *App) showVMDetails(d vmDetails) {
+	var steps []upgrade.UpgradeStep
+	if d.result != nil {
+		steps = d.result.Steps
+	}
+
+	timeline := widget.NewTable(
+		func() (int, int) {
+			return len(steps), 5
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.TableCellID, item fyne.CanvasObject) {
+			label := item.(*widget.Label)
+			s := steps[id.Row]
+			label.Importance = widget.MediumImportance
+			switch id.Col {
+			case 0:
+				label.SetText(fmt.Sprintf("%d. %s", id.Row+1, s.Name))
+			case 1:
+				label.Importance = stepTone(s.Status)
+				label.SetText(a.stepStatusText(s.Status))
+			case 2:
+				if s.StartTime.IsZero() {
+					label.SetText("")
+				} else {
+					label.SetText(s.StartTime.Format("15:04:05"))
+				}
+			case 3:
+				if s.Status == upgrade.StatusInProgress {
+					label.SetText(elapsedText(s.StartTime, time.Time{}))
+				} else if s.EndTime.IsZero() {
+					label.SetText("")
+				} else {
+					label.SetText(s.EndTime.Sub(s.StartTime).Round(time.Second).String())
+				}
+			case 4:
+				switch {
+				case s.Error != nil:
+					label.Importance = widget.DangerImportance
+					label.SetText(s.Error.Error())
+				case s.Warning != "":
+					label.Importance = widget.WarningImportance
+					label.SetText(s.Warning)
+				default:
+					label.SetText("")
+				}
+			}
+		},
+	)
+	timeline.ShowHeaderRow = true
+	timeline.CreateHeader = func() fyne.CanvasObject {
+		return widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	}
+	timeline.UpdateHeader = func(id widget.TableCellID, item fyne.CanvasObject) {
+		headers := []string{a.tr.DetailsColumnStep, a.tr.DetailsColumnStatus, a.tr.DetailsColumnStart,
+			a.tr.DetailsColumnDuration, a.tr.DetailsColumnNote}
+		item.(*widget.Label).SetText(headers[id.Col])
+	}
+	for col, width := range []float32{180, 120, 90, 90, 420} {
+		timeline.SetColumnWidth(col, width)
+	}
+
+	// Händelser och gästens utdata går att markera och kopiera
+	events := widget.NewMultiLineEntry()
+	events.Wrapping = fyne.TextWrapWord
+	events.SetText(strings.Join(d.events, ""))
+
+	guestOutput := widget.NewMultiLineEntry()
+	guestOutput.Wrapping = fyne.TextWrapWord
+	if len(d.guestOutput) > 0 {
+		guestOutput.SetText(strings.Join(d.guestOutput, "\n"))
+	} else {
+		guestOutput.SetPlaceHolder(a.tr.DetailsNoGuestOutput)
+	}
+	guestTab := container.NewBorder(nil, nil, nil, nil, guestOutput)
+	if d.logs != "" {
+		link := widget.NewHyperlink(d.logs, folderURL(d.logs))
+		guestTab = container.NewBorder(container.NewHBox(widget.NewLabel(a.tr.DetailsLogsFolder), link), nil, nil, nil, guestOutput)
+	}
+
+	// Varje försök, det senaste sist
+	attempts := container.NewVBox()
+	if d.result != nil {
+		for i, at := range d.result.Attempts {
+			outcome := a.stepStatusText(at.Status)
+			switch {
+			case at.Status == upgrade.StatusInProgress && i < len(d.result.Attempts)-1:
+				outcome = a.tr.AttemptInterrupted
+			case at.Status == upgrade.StatusInProgress:
+				outcome = a.tr.AttemptRunning
+			case at.Error != nil:
+				outcome += " - " + at.Error.Error()
+			}
+			label := widget.NewLabel(fmt.Sprintf(a.tr.AttemptEntry, i+1, at.FromStep, at.StartTime.Format("2006-01-02 15:04:05"), outcome))
+			label.Wrapping = fyne.TextWrapWord
+			label.Importance = stepTone(at.Status)
+			attempts.Add(label)
+		}
+	}
+
+	tabs := container.NewAppTabs(
+		container.NewTabItem(a.tr.DetailsTimeline, timeline),
+		container.NewTabItem(a.tr.DetailsAttempts, container.NewVScroll(attempts)),
+		container.NewTabItem(a.tr.DetailsEvents, events),
+		container.NewTabItem(a.tr.DetailsGuestLogs, guestTab),
+	)
+	status := widget.NewLabel(d.status)
+	status.Wrapping = fyne.TextWrapWord
+	content := container.NewBorder(status, nil, nil, nil, tabs)
+
+	dlg := dialog.NewCustom(fmt.Sprintf(a.tr.DetailsTitle, d.name), a.tr.CloseButton, content, a.window)
+	dlg.Resize(fyne.NewSize(950, 550))
+	dlg.Show()
+}
+
+// stepStatusText översätter ett stegs status
+func (a *App) stepStatusText(status string) string {
+	switch status {
+	case upgrade.StatusPending:
+		return a.tr.StepPending
+	case upgrade.StatusInProgress:
+		return a.tr.StepInProgress
+	case upgrade.StatusCompleted:
+		return a.tr.StepCompleted
+	case upgrade.StatusFailed:
+		return a.tr.StepFailed
+	case upgrade.StatusSkipped:
+		return a.tr.StepSkipped
+	case upgrade.StatusCancelled:
+		return a.tr.StepCancelled
+	}
+	return status
+}
+
+// stepTone är färgen för ett stegs status
+func stepTone(status string) widget.Importance {
+	switch status {
+	case upgrade.StatusInProgress:
+		return widget.HighImportance
+	case upgrade.StatusCompleted:
+		return widget.SuccessImportance
+	case upgrade.StatusFailed:
+		return widget.DangerImportance
+	case upgrade.StatusCancelled:
+		return widget.WarningImportance
+	case upgrade.StatusPending, upgrade.StatusSkipped:
+		return widget.LowImportance
+	}
+	return widget.MediumImportance
+}
@@ -3,16 +3,104 @@ package gui
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
 	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
 )
 
+// stagePlan är etapperna operatören delat in VMs i, i körordning
+type stagePlan struct {
+	order       []string          // etappernas namn i ordning
+	vmStage     map[string]string // VM -> etapp
+	waitHealthy map[string]bool   // etapper med hälsogrind
+}
+
+func newStagePlan() *stagePlan {
+	return &stagePlan{
+		vmStage:     make(map[string]string),
+		waitHealthy: make(map[string]bool),
+	}
+}
+
+// assign sätter etappen för VMs. Ett tomt namn tar bort dem ur sin etapp.
+// Nya etapper läggs sist och etapper utan VMs försvinner.
+func (p *stagePlan) assign(vms []string, stage string) {
+	stage = strings.TrimSpace(stage)
+	for _, name := range vms {
+		if stage == "" {
+			delete(p.vmStage, name)
+		} else {
+			p.vmStage[name] = stage
+		}
+	}
+	if stage != "" && !containsString(p.order, stage) {
+		p.order = append(p.order, stage)
+	}
+
+	var order []string
+	for _, st := range p.order {
+		if p.count(st) > 0 {
+			order = append(order, st)
+		} else {
+			delete(p.waitHealthy, st)
+		}
+	}
+	p.order = order
+}
+
+// count räknar VMs i en etapp
+func (p *stagePlan) count(stage string) int {
+	n := 0
+	for _, st := range p.vmStage {
+		if st == stage {
+			n++
+		}
+	}
+	return n
+}
+
+// move flyttar etappen på plats i ett steg upp (-1) eller ner (+1)
+func (p *stagePlan) move(i, step int) {
+	j := i + step
+	if j < 0 || j >= len(p.order) {
+		return
+	}
+	p.order[i], p.order[j] = p.order[j], p.order[i]
+}
+
+// stages returnerar etapperna för de valda VMs, VMs i namnordning
+func (p *stagePlan) stages(selected map[string]bool) []upgrade.Stage {
+	var out []upgrade.Stage
+	for _, st := range p.order {
+		var vms []string
+		for name, stage := range p.vmStage {
+			if stage == st && selected[name] {
+				vms = append(vms, name)
+			}
+		}
+		sort.Strings(vms)
+		out = append(out, upgrade.Stage{Name: st, VMs: vms, WaitHealthy: p.waitHealthy[st]})
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *App) showVMSelectionScreen() {
 	vms := a.GetVMs()
 
@@ -30,11 +118,14 @@ func (a *App) showVMSelectionScreen() {
 	// Selection state
 	selectedVMs := make(map[string]bool)
 
+	// Etapper som körs i ordning
+	plan := newStagePlan()
+
 	// Filtrerad lista av VMs
 	var filteredVMs []vcenter.VMInfo
 
 	// Beräkna dynamiska kolumnbredder baserat på innehåll
-	calculateColumnWidths := func() (float32, float32, float32, float32, float32) {
+	calculateColumnWidths := func() (float32, float32, float32, float32, float32, float32) {
 		const charWidth = 8.0 // Ungefärlig bredd per tecken
 		const minWidth = 80.0
 		const padding = 20.0
@@ -44,6 +135,7 @@ func (a *App) showVMSelectionScreen() {
 		maxFolderLen := len("Folder")
 		maxDomainLen := len("Domain")
 		maxOSLen := len("OS")
+		maxStageLen := len(a.tr.StagePlaceholder)
 
 		// Hitta maxlängd för varje kolumn
 		for _, vm := range vms {
@@ -67,6 +159,7 @@ func (a *App) showVMSelectionScreen() {
 		folderWidth := float32(maxFolderLen)*charWidth + padding
 		domainWidth := float32(maxDomainLen)*charWidth + padding
 		osWidth := float32(maxOSLen)*charWidth + padding
+		stageWidth := float32(maxStageLen)*charWidth + padding
 
 		// Sätt minimum bredder
 		if nameWidth < minWidth {
@@ -82,14 +175,14 @@ func (a *App) showVMSelectionScreen() {
 			osWidth = minWidth
 		}
 
-		return checkboxWidth, nameWidth, folderWidth, domainWidth, osWidth
+		return checkboxWidth, nameWidth, folderWidth, domainWidth, osWidth, stageWidth
 	}
 
 	// Skapa tabell
 	table := widget.NewTable(
 		func() (int, int) {
 			// Antal rader (VMs + 1 header rad) och antal kolumner
-			return len(filteredVMs) + 1, 5
+			return len(filteredVMs) + 1, 6
 		},
 		func() fyne.CanvasObject {
 			// Skapa cell templates med mindre font
@@ -127,6 +220,9 @@ func (a *App) showVMSelectionScreen() {
 				case 4:
 					label.SetText(a.tr.ColumnOS)
 					label.Show()
+				case 5:
+					label.SetText(a.tr.ColumnStage)
+					label.Show()
 				}
 				return
 			}
@@ -179,22 +275,29 @@ func (a *App) showVMSelectionScreen() {
 				check.Hide()
 				label.TextStyle = fyne.TextStyle{}
 				label.SetText(vm.OS)
+			case 5:
+				// Etapp kolumn
+				label.Show()
+				check.Hide()
+				label.TextStyle = fyne.TextStyle{}
+				label.SetText(plan.vmStage[vm.Name])
 			}
 		},
 	)
 
 	// Sätt dynamiska kolumnbredder
-	checkboxW, nameW, folderW, domainW, osW := calculateColumnWidths()
+	checkboxW, nameW, folderW, domainW, osW, stageW := calculateColumnWidths()
 	table.SetColumnWidth(0, checkboxW) // Checkbox
 	table.SetColumnWidth(1, nameW)     // Name
 	table.SetColumnWidth(2, folderW)   // Folder
 	table.SetColumnWidth(3, domainW)   // Domain
 	table.SetColumnWidth(4, osW)       // OS
+	table.SetColumnWidth(5, stageW)    // Etapp
 
 	// Logga kolumnbredder för debugging
-	totalWidth := checkboxW + nameW + folderW + domainW + osW
-	debug.Log("Dynamic column widths: Checkbox=%.0f, Name=%.0f, Folder=%.0f, Domain=%.0f, OS=%.0f, Total=%.0f",
-		checkboxW, nameW, folderW, domainW, osW, totalWidth)
+	totalWidth := checkboxW + nameW + folderW + domainW + osW + stageW
+	debug.Log("Dynamic column widths: Checkbox=%.0f, Name=%.0f, Folder=%.0f, Domain=%.0f, OS=%.0f, Stage=%.0f, Total=%.0f",
+		checkboxW, nameW, folderW, domainW, osW, stageW, totalWidth)
 
 	// Justera fönsterstorlek om nödvändigt (lägg till padding för UI-element)
 	minWindowWidth := totalWidth + 100 // Extra för scrollbar och padding
@@ -232,14 +335,16 @@ func (a *App) showVMSelectionScreen() {
 				matched = re.MatchString(vm.Name) ||
 					re.MatchString(vm.Folder) ||
 					re.MatchString(vm.Domain) ||
-					re.MatchString(vm.OS)
+					re.MatchString(vm.OS) ||
+					re.MatchString(plan.vmStage[vm.Name])
 			} else {
 				// Fallback till case-insensitive substring
 				filterLower := strings.ToLower(filter)
 				matched = strings.Contains(strings.ToLower(vm.Name), filterLower) ||
 					strings.Contains(strings.ToLower(vm.Folder), filterLower) ||
 					strings.Contains(strings.ToLower(vm.Domain), filterLower) ||
-					strings.Contains(strings.ToLower(vm.OS), filterLower)
+					strings.Contains(strings.ToLower(vm.OS), filterLower) ||
+					strings.Contains(strings.ToLower(plan.vmStage[vm.Name]), filterLower)
 			}
 
 			if matched {
@@ -274,6 +379,28 @@ func (a *App) showVMSelectionScreen() {
 	})
 	deselectAllBtn.Importance = widget.HighImportance
 
+	// Etapp för valda VMs, ett tomt namn tar bort etappen
+	stageEntry := widget.NewEntry()
+	stageEntry.SetPlaceHolder(a.tr.StagePlaceholder)
+	assignStageBtn := widget.NewButton(a.tr.AssignStage, func() {
+		var names []string
+		for name, checked := range selectedVMs {
+			if checked {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			dialog.ShowInformation(a.tr.NoVMsSelected, a.tr.SelectVMsFirst, a.window)
+			return
+		}
+		plan.assign(names, stageEntry.Text)
+		debug.Log("Stage %q set for %d VMs, stages: %v", strings.TrimSpace(stageEntry.Text), len(names), plan.order)
+		table.Refresh()
+	})
+	stagesBtn := widget.NewButton(a.tr.StagesButton, func() {
+		a.showStageOrder(plan)
+	})
+
 	// Fortsätt-knapp
 	continueBtn := widget.NewButton(a.tr.ContinueToUpgrade, func() {
 		// Räkna valda VMs
@@ -290,7 +417,7 @@ func (a *App) showVMSelectionScreen() {
 		}
 
 		// Gå till upgrade-skärm
-		a.showUpgradeScreen(selectedVMs)
+		a.showUpgradeScreen(selectedVMs, plan.stages(selectedVMs), nil)
 	})
 
 	// Tillbaka-knapp
@@ -326,6 +453,7 @@ func (a *App) showVMSelectionScreen() {
 			title,
 			searchEntry,
 			container.NewHBox(selectAllBtn, deselectAllBtn, refreshBtn, snapshotBtn),
+			container.NewBorder(nil, nil, nil, container.NewHBox(assignStageBtn, stagesBtn), stageEntry),
 		),
 		container.NewHBox(backBtn, continueBtn),
 		nil,
@@ -335,3 +463,52 @@ func (a *App) showVMSelectionScreen() {
 
 	a.window.SetContent(content)
 }
+
+// showStageOrder visar etapperna i körordning. Ordningen och vilka etapper
+// som väntar tills alla VMs är uppgraderade och friska ändras direkt i plan.
+func (a *App) showStageOrder(plan *stagePlan) {
+	list := container.NewVBox()
+	var render func()
+	render = func() {
+		list.RemoveAll()
+		if len(plan.order) == 0 {
+			list.Add(widget.NewLabel(a.tr.NoStages))
+			return
+		}
+		for i, st := range plan.order {
+			up := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() {
+				plan.move(i, -1)
+				render()
+			})
+			down := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() {
+				plan.move(i, 1)
+				render()
+			})
+			if i == 0 {
+				up.Disable()
+			}
+			if i == len(plan.order)-1 {
+				down.Disable()
+			}
+			gate := widget.NewCheck(a.tr.StageWaitHealthy, func(checked bool) {
+				plan.waitHealthy[st] = checked
+			})
+			gate.SetChecked(plan.waitHealthy[st])
+			if !a.config.Upgrade.Health.Enabled && !gate.Checked {
+				// Utan hälsokontroller finns inget att vänta på
+				gate.Disable()
+			}
+			name := widget.NewLabel(fmt.Sprintf(a.tr.StageEntry, i+1, st, plan.count(st)))
+			list.Add(container.NewBorder(nil, nil, container.NewHBox(up, down), gate, name))
+		}
+	}
+	render()
+
+	info := widget.NewLabel(a.tr.StagesInfo)
+	info.Wrapping = fyne.TextWrapWord
+	content := container.NewBorder(info, nil, nil, nil, container.NewVScroll(list))
+
+	d := dialog.NewCustom(a.tr.StagesTitle, a.tr.CloseButton, content, a.window)
+	d.Resize(fyne.NewSize(700, 400))
+	d.Show()
+}
@@ -0,0 +1,473 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+)
+
+// Högsta antal händelser och gästrader som sparas per VM
+const (
+	maxRowEvents      = 2000
+	maxRowGuestOutput = 1000
+)
+
+// Kolumner i VM-tabellen
+const (
+	colVM = iota
+	colStatus
+	colStep
+	colElapsed
+	colMessage
+	colWarnings
+	colActions
+	numColumns
+)
+
+// vmStatusRow är en rad i VM-tabellen på uppgraderingsskärmen
+type vmStatusRow struct {
+	name   string
+	status string
+	tone   widget.Importance  // färg på statusen
+	cancel context.CancelFunc // satt medan VM:en är köad eller körs
+	logs   string             // lokal mapp med gästens loggar efter ett fel
+
+	// changes är skillnaderna i gästinventeringen, hasChanges är satt när
+	// inventeringen efter uppgraderingen finns även om inget ändrats
+	changes    []upgrade.InventoryChange
+	hasChanges bool
+
+	// Uppgraderingens senaste tillstånd från händelseströmmen
+	result      *upgrade.UpgradeResult
+	started     time.Time // första steget i denna körning
+	ended       time.Time // när VM:en fick sitt slutliga utfall
+	message     string    // senaste meddelandet
+	warnings    []string
+	events      []string // händelser för detaljvyn
+	guestOutput []string // rader från skript i gästen och hooks
+}
+
+// vmDetails är en kopia av en rad för detaljvyn
+type vmDetails struct {
+	name        string
+	status      string
+	result      *upgrade.UpgradeResult
+	events      []string
+	guestOutput []string
+	logs        string
+}
+
+// vmStatusList håller VM-raderna och tabellen som visar dem, med en
+// avbryt-knapp per VM
+type vmStatusList struct {
+	mu     sync.Mutex
+	rows   []*vmStatusRow
+	byName map[string]*vmStatusRow
+	table  *vmTable
+	tr     Translations
+
+	// onChanges anropas när ändringsknappen för en VM klickas
+	onChanges func(name string, changes []upgrade.InventoryChange)
+	// onDetails anropas när en rad dubbelklickas
+	onDetails func(details vmDetails)
+}
+
+// vmTable är en tabell där en dubbelklickad rad öppnar VM:ens detaljer
+type vmTable struct {
+	widget.Table
+	selected       int
+	onDoubleTapped func(row int)
+}
+
+// DoubleTapped tar reda på raden genom att markera cellen, som sedan
+// avmarkeras igen
+func (t *vmTable) DoubleTapped(e *fyne.PointEvent) {
+	t.UnselectAll()
+	t.selected = -1
+	t.Tapped(e)
+	row := t.selected
+	t.UnselectAll()
+	if row >= 0 && t.onDoubleTapped != nil {
+		t.onDoubleTapped(row)
+	}
+}
+
+func newVMStatusList(names []string, tr Translations) *vmStatusList {
+	l := &vmStatusList{
+		byName: make(map[string]*vmStatusRow),
+		tr:     tr,
+	}
+	for _, name := range names {
+		row := &vmStatusRow{name: name, status: tr.StatusQueued}
+		l.rows = append(l.rows, row)
+		l.byName[name] = row
+	}
+
+	t := &vmTable{selected: -1}
+	t.Length = func() (int, int) {
+		return len(l.rows), numColumns
+	}
+	t.CreateCell = func() fyne.CanvasObject {
+		label := widget.NewLabel("")
+		label.Truncation = fyne.TextTruncateEllipsis
+		cancelBtn := widget.NewButton(tr.CancelVM, nil)
+		logsLink := widget.NewHyperlink(tr.GuestLogsLink, nil)
+		changesBtn := widget.NewButton("", nil)
+		return container.NewStack(label, container.NewHBox(logsLink, changesBtn, cancelBtn))
+	}
+	t.UpdateCell = l.updateCell
+	t.ShowHeaderRow = true
+	t.CreateHeader = func() fyne.CanvasObject {
+		return widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	}
+	t.UpdateHeader = func(id widget.TableCellID, item fyne.CanvasObject) {
+		headers := []string{tr.DashColumnVM, tr.DashColumnStatus, tr.DashColumnStep, tr.DashColumnElapsed,
+			tr.DashColumnMessage, tr.DashColumnWarnings, ""}
+		item.(*widget.Label).SetText(headers[id.Col])
+	}
+	t.OnSelected = func(id widget.TableCellID) {
+		t.selected = id.Row
+	}
+	t.onDoubleTapped = func(row int) {
+		if l.onDetails != nil {
+			l.onDetails(l.details(row))
+		}
+	}
+	t.ExtendBaseWidget(t)
+
+	for col, width := range []float32{180, 300, 170, 80, 380, 90, 320} {
+		t.SetColumnWidth(col, width)
+	}
+	l.table = t
+	return l
+}
+
+// updateCell fyller en cell i tabellen från raden
+func (l *vmStatusList) updateCell(id widget.TableCellID, item fyne.CanvasObject) {
+	l.mu.Lock()
+	row := *l.rows[id.Row]
+	l.mu.Unlock()
+
+	c := item.(*fyne.Container)
+	label := c.Objects[0].(*widget.Label)
+	buttons := c.Objects[1].(*fyne.Container)
+	if id.Col != colActions {
+		buttons.Hide()
+		label.Show()
+		label.TextStyle = fyne.TextStyle{Bold: id.Col == colVM}
+		label.Importance = widget.MediumImportance
+	}
+
+	switch id.Col {
+	case colVM:
+		if row.result != nil && len(row.result.Attempts) > 1 {
+			label.SetText(fmt.Sprintf(l.tr.VMAttempt, row.name, len(row.result.Attempts)))
+		} else {
+			label.SetText(row.name)
+		}
+	case colStatus:
+		label.Importance = row.tone
+		label.SetText(row.status)
+	case colStep:
+		label.SetText(l.stepText(row.result))
+	case colElapsed:
+		label.SetText(elapsedText(row.started, row.ended))
+	case colMessage:
+		label.SetText(row.message)
+	case colWarnings:
+		if len(row.warnings) > 0 {
+			label.Importance = widget.WarningImportance
+			label.SetText(fmt.Sprintf("⚠ %d", len(row.warnings)))
+		} else {
+			label.SetText("")
+		}
+	case colActions:
+		label.Hide()
+		buttons.Show()
+		link := buttons.Objects[0].(*widget.Hyperlink)
+		if row.logs != "" {
+			link.SetURL(folderURL(row.logs))
+			link.Show()
+		} else {
+			link.Hide()
+		}
+		changesBtn := buttons.Objects[1].(*widget.Button)
+		if row.hasChanges {
+			changesBtn.SetText(fmt.Sprintf(l.tr.InventoryChangesButton, len(row.changes)))
+			changesBtn.OnTapped = func() {
+				if l.onChanges != nil {
+					l.onChanges(row.name, row.changes)
+				}
+			}
+			changesBtn.Show()
+		} else {
+			changesBtn.Hide()
+		}
+		btn := buttons.Objects[2].(*widget.Button)
+		btn.OnTapped = func() { l.cancelVM(row.name) }
+		if row.cancel != nil {
+			btn.Enable()
+		} else {
+			btn.Disable()
+		}
+	}
+}
+
+// stepText visar steget som körs eller senast kördes, t.ex. "9/17 setup"
+func (l *vmStatusList) stepText(res *upgrade.UpgradeResult) string {
+	if res == nil {
+		return ""
+	}
+	i := res.CurrentStep()
+	if i < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d %s", i+1, len(res.Steps), res.Steps[i].Name)
+}
+
+// elapsedText visar tiden sedan start, fram till slutet om det finns
+func elapsedText(start, end time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(start).Round(time.Second).String()
+}
+
+// details kopierar en rad för detaljvyn
+func (l *vmStatusList) details(index int) vmDetails {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	row := l.rows[index]
+	d := vmDetails{
+		name:        row.name,
+		status:      row.status,
+		events:      append([]string(nil), row.events...),
+		guestOutput: append([]string(nil), row.guestOutput...),
+		logs:        row.logs,
+	}
+	if row.result != nil {
+		d.result = row.result.Clone()
+	}
+	return d
+}
+
+// observe uppdaterar en VM:s rad från uppgraderingens händelser. line är
+// händelsen formaterad för detaljvyn, "" om den inte ska visas där.
+func (l *vmStatusList) observe(e upgrade.Event, line string) {
+	l.mu.Lock()
+	row, ok := l.byName[e.VM]
+	if !ok {
+		l.mu.Unlock()
+		return
+	}
+	if e.Result != nil {
+		row.result = e.Result
+	}
+	switch e.Kind {
+	case upgrade.EventProgress:
+		row.status = l.progressStatus(*e.Result)
+		row.tone = widget.HighImportance
+	case upgrade.EventStepStarted:
+		if row.started.IsZero() {
+			row.started = e.Time
+		}
+	case upgrade.EventStepFinished:
+		if e.Err != nil {
+			row.message = e.Err.Error()
+		}
+	case upgrade.EventWarning:
+		row.warnings = append(row.warnings, e.Message)
+		row.message = e.Message
+	case upgrade.EventGuestOutput, upgrade.EventHookOutput:
+		row.guestOutput = appendCapped(row.guestOutput, e.Time.Format("15:04:05")+" "+e.Message, maxRowGuestOutput)
+		row.message = e.Message
+	case upgrade.EventMessage:
+		row.message = e.Message
+	}
+	if line != "" {
+		row.events = appendCapped(row.events, line, maxRowEvents)
+	}
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// appendCapped lägger till en rad och släpper de äldsta över max
+func appendCapped(lines []string, line string, limit int) []string {
+	lines = append(lines, line)
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	return lines
+}
+
+// reset tömmer en VM:s rad inför en ny körning
+func (l *vmStatusList) reset(name string) {
+	l.mu.Lock()
+	if row, ok := l.byName[name]; ok {
+		*row = vmStatusRow{name: name, status: l.tr.StatusQueued, cancel: row.cancel}
+	}
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// setOutcome färgar en VM:s slutliga status och stoppar dess klocka
+func (l *vmStatusList) setOutcome(name string, tone widget.Importance) {
+	l.mu.Lock()
+	if row, ok := l.byName[name]; ok {
+		row.tone = tone
+		if !row.started.IsZero() && row.ended.IsZero() {
+			row.ended = time.Now()
+		}
+	}
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// tick uppdaterar tabellen varje sekund så tiderna räknar, tills stop stängs
+func (l *vmStatusList) tick(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			l.table.Refresh()
+		}
+	}
+}
+
+// setStatus uppdaterar statustexten för en VM
+func (l *vmStatusList) setStatus(name, status string) {
+	l.mu.Lock()
+	if row, ok := l.byName[name]; ok {
+		row.status = status
+	}
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// setCancel kopplar avbryt-knappen för en VM till en cancel-funktion.
+// Nil inaktiverar knappen.
+func (l *vmStatusList) setCancel(name string, cancel context.CancelFunc) {
+	l.mu.Lock()
+	if row, ok := l.byName[name]; ok {
+		row.cancel = cancel
+	}
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// setLogs visar en länk till mappen med gästens loggar för en VM
+func (l *vmStatusList) setLogs(name, dir string) {
+	l.mu.Lock()
+	if row, ok := l.byName[name]; ok {
+		row.logs = dir
+	}
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// setChanges visar en knapp med skillnaderna i gästinventeringen för en VM
+func (l *vmStatusList) setChanges(name string, changes []upgrade.InventoryChange) {
+	l.mu.Lock()
+	if row, ok := l.byName[name]; ok {
+		row.changes = changes
+		row.hasChanges = true
+	}
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// folderURL gör en file-URL av en lokal mapp, som öppnas i filhanteraren
+func folderURL(dir string) *url.URL {
+	p := filepath.ToSlash(dir)
+	if !strings.HasPrefix(p, "/") {
+		// Windows: C:/Users/... blir file:///C:/Users/...
+		p = "/" + p
+	}
+	return &url.URL{Scheme: "file", Path: p}
+}
+
+// cancelVM avbryter en köad eller pågående VM
+func (l *vmStatusList) cancelVM(name string) {
+	l.mu.Lock()
+	row, ok := l.byName[name]
+	if !ok || row.cancel == nil {
+		l.mu.Unlock()
+		return
+	}
+	row.cancel()
+	row.cancel = nil
+	row.status = l.tr.StatusCancelling
+	l.mu.Unlock()
+	l.table.Refresh()
+}
+
+// cancelAll avbryter alla VMs som fortfarande är köade eller körs
+func (l *vmStatusList) cancelAll() {
+	l.mu.Lock()
+	var names []string
+	for _, row := range l.rows {
+		if row.cancel != nil {
+			names = append(names, row.name)
+		}
+	}
+	l.mu.Unlock()
+	for _, name := range names {
+		l.cancelVM(name)
+	}
+}
+
+// progressStatus beskriver var en pågående uppgradering befinner sig
+func (l *vmStatusList) progressStatus(res upgrade.UpgradeResult) string {
+	if rb := res.Rollback; rb != nil && rb.EndTime.IsZero() {
+		return l.tr.StatusRollingBack
+	}
+	for _, s := range res.Steps {
+		if s.Status == upgrade.StatusInProgress {
+			// Steget står i en egen kolumn
+			if phase := l.phaseText(res.Progress); phase != "" {
+				return l.tr.StatusRunningStep + phase
+			}
+			return l.tr.StatusRunning
+		}
+	}
+	return l.tr.StatusQueued
+}
+
+// phaseText beskriver Windows Setups fas, t.ex. "Downlevel 35% (Install)"
+func (l *vmStatusList) phaseText(p upgrade.SetupProgress) string {
+	var text string
+	switch p.Phase {
+	case upgrade.PhaseDownlevel:
+		text = l.tr.PhaseDownlevel
+	case upgrade.PhaseSafeOS:
+		text = l.tr.PhaseSafeOS
+	case upgrade.PhaseFirstBoot:
+		text = l.tr.PhaseFirstBoot
+	case upgrade.PhaseSecondBoot:
+		text = l.tr.PhaseSecondBoot
+	default:
+		return ""
+	}
+	if p.Percent >= 0 {
+		text += fmt.Sprintf(" %d%%", p.Percent)
+	}
+	if p.Detail != "" {
+		text += " (" + p.Detail + ")"
+	}
+	return text
+}
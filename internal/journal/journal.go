@@ -0,0 +1,754 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const journalFileName = "journal.json"
+
+// maxFinishedRuns is how many finished runs are kept in the journal
+const maxFinishedRuns = 20
+
+// VM statuses in the journal
+const (
+	VMQueued    = "queued"
+	VMRunning   = "running"
+	VMSucceeded = "succeeded"
+	VMFailed    = "failed"
+	VMCancelled = "cancelled"
+)
+
+// Journal is the crash-safe record of upgrade runs. Every change is written
+// to disk immediately so an interrupted run can be picked up again.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	Runs []*Run `json:"runs"`
+}
+
+// Run is one press of the start button: a set of VMs upgraded with the same
+// ISO and options
+type Run struct {
+	ID             string      `json:"id"`
+	Started        time.Time   `json:"started"`
+	Finished       *time.Time  `json:"finished,omitempty"`
+	ISOPath        string      `json:"iso_path"`
+	Profile        string      `json:"profile,omitempty"`
+	GuestUsername  string      `json:"guest_username"`
+	CreateSnapshot bool        `json:"create_snapshot"`
+	VMs            []*VMRecord `json:"vms"`
+
+	// ScheduledStart and WindowEnd are the maintenance window of a
+	// scheduled run, nil when it started at once or has no end
+	ScheduledStart *time.Time `json:"scheduled_start,omitempty"`
+	WindowEnd      *time.Time `json:"window_end,omitempty"`
+
+	// Stages are the dependency stages of the run in order, each VM
+	// records which one it is in
+	Stages []StageRecord `json:"stages,omitempty"`
+}
+
+// StageRecord is the journal form of upgrade.Stage, without its VMs
+type StageRecord struct {
+	Name        string `json:"name"`
+	WaitHealthy bool   `json:"wait_healthy,omitempty"`
+}
+
+// VMRecord is the state of one VM within a run
+type VMRecord struct {
+	Name         string                       `json:"name"`
+	Ref          types.ManagedObjectReference `json:"ref"`
+	Folder       string                       `json:"folder,omitempty"`
+	Domain       string                       `json:"domain,omitempty"`
+	IP           string                       `json:"ip,omitempty"`
+	OS           string                       `json:"os,omitempty"`
+	Host         string                       `json:"host,omitempty"`
+	Cluster      string                       `json:"cluster,omitempty"`
+	Datastores   []string                     `json:"datastores,omitempty"`
+	Stage        string                       `json:"stage,omitempty"`
+	Status       string                       `json:"status"`
+	Error        string                       `json:"error,omitempty"`
+	SnapshotName string                       `json:"snapshot_name,omitempty"`
+	GuestPID     int64                        `json:"guest_pid,omitempty"`
+	OriginalOS   string                       `json:"original_os,omitempty"`
+	Steps        []StepRecord                 `json:"steps,omitempty"`
+	Rollback     *RollbackRecord              `json:"rollback,omitempty"`
+	Compat       *CompatRecord                `json:"compat,omitempty"`
+	Inventory    *InventoryRecord             `json:"inventory,omitempty"`
+	Health       []CheckRecord                `json:"health,omitempty"`
+	GuestLogs    string                       `json:"guest_logs,omitempty"`
+	SetupCode    uint32                       `json:"setup_code,omitempty"`
+	SetupExtCode uint32                       `json:"setup_extended_code,omitempty"`
+	Attempts     []AttemptRecord              `json:"attempts,omitempty"`
+	Updated      time.Time                    `json:"updated"`
+}
+
+// AttemptRecord is the journal form of upgrade.Attempt
+type AttemptRecord struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end,omitempty"`
+	FromStep string    `json:"from_step,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// StepRecord is the journal form of upgrade.UpgradeStep
+type StepRecord struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Warning string    `json:"warning,omitempty"`
+	Start   time.Time `json:"start,omitempty"`
+	End     time.Time `json:"end,omitempty"`
+}
+
+// RollbackRecord is the journal form of upgrade.RollbackResult
+type RollbackRecord struct {
+	FailureClass string    `json:"failure_class"`
+	SnapshotName string    `json:"snapshot_name"`
+	Reverted     bool      `json:"reverted"`
+	Verified     bool      `json:"verified"`
+	Error        string    `json:"error,omitempty"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end,omitempty"`
+}
+
+// CompatRecord is the journal form of upgrade.CompatResult
+type CompatRecord struct {
+	ExitCode   uint32              `json:"exit_code"`
+	Outcome    string              `json:"outcome"`
+	HardBlocks []CompatIssueRecord `json:"hard_blocks,omitempty"`
+	SoftBlocks int                 `json:"soft_blocks,omitempty"`
+	Reports    []string            `json:"reports,omitempty"`
+}
+
+// CompatIssueRecord is the journal form of upgrade.CompatIssue
+type CompatIssueRecord struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// InventoryRecord is the journal form of upgrade.InventoryResult. The
+// inventories themselves are in files in Dir.
+type InventoryRecord struct {
+	Dir     string                  `json:"dir,omitempty"`
+	Changes []InventoryChangeRecord `json:"changes,omitempty"`
+}
+
+// InventoryChangeRecord is the journal form of upgrade.InventoryChange
+type InventoryChangeRecord struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+}
+
+// CheckRecord is the journal form of a post-upgrade health check
+type CheckRecord struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// GetJournalPath returns the path of the journal file, next to conf.json
+func GetJournalPath() (string, error) {
+	dir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, journalFileName), nil
+}
+
+// Open loads the journal from disk. A missing file gives an empty journal.
+func Open() (*Journal, error) {
+	path, err := GetJournalPath()
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, fmt.Errorf("could not read journal: %w", err)
+	}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("could not parse journal: %w", err)
+	}
+	return j, nil
+}
+
+// save writes the journal atomically (must be called with mu held)
+func (j *Journal) save() error {
+	if j.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize journal: %w", err)
+	}
+
+	// Write to a temp file and rename so a crash never leaves a torn file
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write journal: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not sync journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("could not replace journal: %w", err)
+	}
+	return nil
+}
+
+// StartRun records a new run with all VMs queued. A run with a maintenance
+// window is recorded when it is scheduled, so the schedule survives a
+// restart.
+func (j *Journal) StartRun(isoPath, profile, guestUsername string, createSnapshot bool, window upgrade.MaintenanceWindow, vms []vcenter.VMInfo) (*Run, error) {
+	if j == nil {
+		return nil, errors.New("no journal")
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	run := &Run{
+		ID:             now.Format("20060102-150405.000"),
+		Started:        now,
+		ISOPath:        isoPath,
+		Profile:        profile,
+		GuestUsername:  guestUsername,
+		CreateSnapshot: createSnapshot,
+	}
+	if !window.Start.IsZero() {
+		run.ScheduledStart = &window.Start
+	}
+	if !window.End.IsZero() {
+		run.WindowEnd = &window.End
+	}
+	for _, vm := range vms {
+		run.VMs = append(run.VMs, &VMRecord{
+			Name:       vm.Name,
+			Ref:        vm.Ref,
+			Folder:     vm.Folder,
+			Domain:     vm.Domain,
+			IP:         vm.IP,
+			OS:         vm.OS,
+			Host:       vm.Host,
+			Cluster:    vm.Cluster,
+			Datastores: vm.Datastores,
+			Status:     VMQueued,
+			Updated:    now,
+		})
+	}
+
+	j.Runs = append(j.Runs, run)
+	j.prune()
+	return run, j.save()
+}
+
+// UpdateVM stores the latest result for a VM in a run. The journal is only
+// written when the record changed: setup progress is not kept, so the many
+// progress updates during setup cost no disk writes.
+func (j *Journal) UpdateVM(runID string, res upgrade.UpgradeResult) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rec := j.findVM(runID, res.VMName)
+	if rec == nil {
+		return fmt.Errorf("VM %s not found in run %s", res.VMName, runID)
+	}
+	prev := *rec
+
+	rec.SnapshotName = res.SnapshotName
+	rec.GuestPID = res.GuestPID
+	rec.OriginalOS = res.OriginalOS
+	rec.Rollback = nil
+	if rb := res.Rollback; rb != nil {
+		rec.Rollback = &RollbackRecord{
+			FailureClass: rb.FailureClass,
+			SnapshotName: rb.SnapshotName,
+			Reverted:     rb.Reverted,
+			Verified:     rb.Verified,
+			Start:        rb.StartTime,
+			End:          rb.EndTime,
+		}
+		if rb.Error != nil {
+			rec.Rollback.Error = rb.Error.Error()
+		}
+	}
+	rec.GuestLogs = res.GuestLogs
+	rec.SetupCode, rec.SetupExtCode = 0, 0
+	if se := res.SetupError; se != nil {
+		rec.SetupCode, rec.SetupExtCode = se.Code, se.Extended
+	}
+	rec.Compat = nil
+	if c := res.Compat; c != nil {
+		rec.Compat = &CompatRecord{
+			ExitCode:   c.ExitCode,
+			Outcome:    c.Outcome,
+			SoftBlocks: c.SoftBlocks,
+			Reports:    append([]string(nil), c.Reports...),
+		}
+		for _, b := range c.HardBlocks {
+			rec.Compat.HardBlocks = append(rec.Compat.HardBlocks, CompatIssueRecord(b))
+		}
+	}
+	rec.Inventory = nil
+	if inv := res.Inventory; inv != nil {
+		rec.Inventory = &InventoryRecord{Dir: inv.Dir}
+		for _, c := range inv.Changes {
+			rec.Inventory.Changes = append(rec.Inventory.Changes, InventoryChangeRecord(c))
+		}
+	}
+	rec.Health = nil
+	if h := res.Health; h != nil {
+		for _, c := range h.Checks {
+			rec.Health = append(rec.Health, CheckRecord(c))
+		}
+	}
+	rec.Steps = nil
+	for _, s := range res.Steps {
+		sr := StepRecord{
+			Name:    s.Name,
+			Status:  s.Status,
+			Warning: s.Warning,
+			Start:   s.StartTime,
+			End:     s.EndTime,
+		}
+		if s.Error != nil {
+			sr.Error = s.Error.Error()
+		}
+		rec.Steps = append(rec.Steps, sr)
+	}
+	rec.Attempts = nil
+	for _, a := range res.Attempts {
+		ar := AttemptRecord{
+			Start:    a.StartTime,
+			End:      a.EndTime,
+			FromStep: a.FromStep,
+			Status:   a.Status,
+		}
+		if a.Error != nil {
+			ar.Error = a.Error.Error()
+		}
+		rec.Attempts = append(rec.Attempts, ar)
+	}
+
+	switch {
+	case res.Success:
+		rec.Status = VMSucceeded
+		rec.Error = ""
+	case res.Cancelled:
+		rec.Status = VMCancelled
+		rec.Error = res.Error.Error()
+	case res.Error != nil:
+		rec.Status = VMFailed
+		rec.Error = res.Error.Error()
+	default:
+		rec.Status = VMRunning
+		rec.Error = ""
+	}
+	rec.Updated = prev.Updated
+	if reflect.DeepEqual(*rec, prev) {
+		return nil
+	}
+	rec.Updated = time.Now()
+
+	return j.save()
+}
+
+// Observer returns an upgrade observer that records the result of every
+// progress event in the given run. UpdateVM skips the write for events
+// that change nothing the journal keeps.
+func (j *Journal) Observer(runID string) upgrade.Observer {
+	return upgrade.ObserverFunc(func(e upgrade.Event) {
+		if e.Kind != upgrade.EventProgress || e.Result == nil {
+			return
+		}
+		if err := j.UpdateVM(runID, *e.Result); err != nil {
+			debug.LogError("JournalUpdateVM", err, "VM", e.VM)
+		}
+	})
+}
+
+// SetVMStatus sets the status of a VM without a step result, e.g. when it was
+// cancelled before it started
+func (j *Journal) SetVMStatus(runID, vmName, status string, cause error) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rec := j.findVM(runID, vmName)
+	if rec == nil {
+		return fmt.Errorf("VM %s not found in run %s", vmName, runID)
+	}
+	rec.Status = status
+	rec.Error = ""
+	if cause != nil {
+		rec.Error = cause.Error()
+	}
+	rec.Updated = time.Now()
+	return j.save()
+}
+
+// SetWindow replaces the maintenance window of a run, e.g. when a reattached
+// run is scheduled again
+func (j *Journal) SetWindow(runID string, window upgrade.MaintenanceWindow) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, run := range j.Runs {
+		if run.ID == runID {
+			run.ScheduledStart, run.WindowEnd = nil, nil
+			if !window.Start.IsZero() {
+				run.ScheduledStart = &window.Start
+			}
+			if !window.End.IsZero() {
+				run.WindowEnd = &window.End
+			}
+			return j.save()
+		}
+	}
+	return fmt.Errorf("run %s not found", runID)
+}
+
+// SetStages records the dependency stages of a run and the stage of each
+// VM in them. VMs in no stage get an empty stage, VMs not in stages at all,
+// e.g. ones already done when a run is reattached, keep theirs.
+func (j *Journal) SetStages(runID string, stages []upgrade.Stage) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, run := range j.Runs {
+		if run.ID != runID {
+			continue
+		}
+		run.Stages = nil
+		stageOf := make(map[string]string)
+		for _, st := range stages {
+			for _, name := range st.VMs {
+				stageOf[name] = st.Name
+			}
+			if st.Name != "" {
+				run.Stages = append(run.Stages, StageRecord{Name: st.Name, WaitHealthy: st.WaitHealthy})
+			}
+		}
+		for _, rec := range run.VMs {
+			if stage, ok := stageOf[rec.Name]; ok {
+				rec.Stage = stage
+			}
+		}
+		return j.save()
+	}
+	return fmt.Errorf("run %s not found", runID)
+}
+
+// Reopen queues VMs of a run again and marks the run as unfinished, e.g. to
+// retry the VMs that failed. Their steps are kept, so a retry that is
+// interrupted can still be reattached from the failed step.
+func (j *Journal) Reopen(runID string, vmNames []string) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, run := range j.Runs {
+		if run.ID != runID {
+			continue
+		}
+		run.Finished = nil
+		for _, name := range vmNames {
+			rec := j.findVM(runID, name)
+			if rec == nil {
+				return fmt.Errorf("VM %s not found in run %s", name, runID)
+			}
+			rec.Status = VMQueued
+			rec.Error = ""
+			rec.Updated = time.Now()
+		}
+		return j.save()
+	}
+	return fmt.Errorf("run %s not found", runID)
+}
+
+// FinishRun marks a run as finished. Finished runs are not offered for reattach.
+func (j *Journal) FinishRun(runID string) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, run := range j.Runs {
+		if run.ID == runID {
+			now := time.Now()
+			run.Finished = &now
+			return j.save()
+		}
+	}
+	return fmt.Errorf("run %s not found", runID)
+}
+
+// Unfinished returns the runs that were interrupted before they finished.
+// The runs are copies, so the caller can read them while a resumed run
+// updates the journal.
+func (j *Journal) Unfinished() []*Run {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var out []*Run
+	for _, run := range j.Runs {
+		if run.Finished == nil && len(run.Pending()) > 0 {
+			c, err := run.clone()
+			if err != nil {
+				debug.LogError("JournalCopyRun", err, "Run", run.ID)
+				continue
+			}
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// clone returns a deep copy of the run. It goes through the same JSON form
+// as the journal file so new record fields are copied too.
+func (run *Run) clone() (*Run, error) {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	var c Run
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Pending returns the VMs of the run that were queued or still running
+func (run *Run) Pending() []*VMRecord {
+	var out []*VMRecord
+	for _, vm := range run.VMs {
+		if vm.Status == VMQueued || vm.Status == VMRunning {
+			out = append(out, vm)
+		}
+	}
+	return out
+}
+
+// Window returns the run's maintenance window, zero if it has none
+func (run *Run) Window() upgrade.MaintenanceWindow {
+	var w upgrade.MaintenanceWindow
+	if run.ScheduledStart != nil {
+		w.Start = *run.ScheduledStart
+	}
+	if run.WindowEnd != nil {
+		w.End = *run.WindowEnd
+	}
+	return w
+}
+
+// StageList rebuilds the run's dependency stages with all of their VMs
+func (run *Run) StageList() []upgrade.Stage {
+	var stages []upgrade.Stage
+	for _, st := range run.Stages {
+		stage := upgrade.Stage{Name: st.Name, WaitHealthy: st.WaitHealthy}
+		for _, rec := range run.VMs {
+			if rec.Stage == st.Name {
+				stage.VMs = append(stage.VMs, rec.Name)
+			}
+		}
+		stages = append(stages, stage)
+	}
+	return stages
+}
+
+// VMInfo returns the inventory information recorded for the VM
+func (rec *VMRecord) VMInfo() vcenter.VMInfo {
+	return vcenter.VMInfo{
+		Name:       rec.Name,
+		Folder:     rec.Folder,
+		OS:         rec.OS,
+		Domain:     rec.Domain,
+		IP:         rec.IP,
+		Ref:        rec.Ref,
+		Host:       rec.Host,
+		Cluster:    rec.Cluster,
+		Datastores: rec.Datastores,
+	}
+}
+
+// Result rebuilds the upgrade result recorded for the VM, suitable for
+// upgrade.UpgradeOptions.Resume. It returns nil if no step has started.
+func (rec *VMRecord) Result() *upgrade.UpgradeResult {
+	if len(rec.Steps) == 0 {
+		return nil
+	}
+
+	res := &upgrade.UpgradeResult{
+		VMName:       rec.Name,
+		Success:      rec.Status == VMSucceeded,
+		Cancelled:    rec.Status == VMCancelled,
+		SnapshotName: rec.SnapshotName,
+		GuestPID:     rec.GuestPID,
+		OriginalOS:   rec.OriginalOS,
+		GuestLogs:    rec.GuestLogs,
+	}
+	if rec.SetupCode != 0 {
+		res.SetupError = upgrade.DecodeSetupError(rec.SetupCode, rec.SetupExtCode)
+	}
+	if rec.Error != "" {
+		res.Error = errors.New(rec.Error)
+	}
+	if rb := rec.Rollback; rb != nil {
+		res.Rollback = &upgrade.RollbackResult{
+			FailureClass: rb.FailureClass,
+			SnapshotName: rb.SnapshotName,
+			Reverted:     rb.Reverted,
+			Verified:     rb.Verified,
+			StartTime:    rb.Start,
+			EndTime:      rb.End,
+		}
+		if rb.Error != "" {
+			res.Rollback.Error = errors.New(rb.Error)
+		}
+	}
+	if c := rec.Compat; c != nil {
+		res.Compat = &upgrade.CompatResult{
+			ExitCode:   c.ExitCode,
+			Outcome:    c.Outcome,
+			Decoded:    upgrade.DecodeSetupError(c.ExitCode, 0),
+			SoftBlocks: c.SoftBlocks,
+			Reports:    append([]string(nil), c.Reports...),
+		}
+		for _, b := range c.HardBlocks {
+			res.Compat.HardBlocks = append(res.Compat.HardBlocks, upgrade.CompatIssue(b))
+		}
+	}
+	if inv := rec.Inventory; inv != nil {
+		res.Inventory = &upgrade.InventoryResult{Dir: inv.Dir}
+		for _, c := range inv.Changes {
+			res.Inventory.Changes = append(res.Inventory.Changes, upgrade.InventoryChange(c))
+		}
+	}
+	if rec.Health != nil {
+		res.Health = &upgrade.HealthReport{}
+		for _, c := range rec.Health {
+			res.Health.Checks = append(res.Health.Checks, upgrade.PreflightCheck(c))
+		}
+	}
+	for _, sr := range rec.Steps {
+		step := upgrade.UpgradeStep{
+			Name:      sr.Name,
+			Status:    sr.Status,
+			Warning:   sr.Warning,
+			StartTime: sr.Start,
+			EndTime:   sr.End,
+		}
+		if sr.Error != "" {
+			step.Error = errors.New(sr.Error)
+		}
+		res.Steps = append(res.Steps, step)
+	}
+	for _, ar := range rec.Attempts {
+		a := upgrade.Attempt{
+			StartTime: ar.Start,
+			EndTime:   ar.End,
+			FromStep:  ar.FromStep,
+			Status:    ar.Status,
+		}
+		if ar.Error != "" {
+			a.Error = errors.New(ar.Error)
+		}
+		res.Attempts = append(res.Attempts, a)
+	}
+	return res
+}
+
+// findVM looks up a VM record (must be called with mu held)
+func (j *Journal) findVM(runID, vmName string) *VMRecord {
+	for _, run := range j.Runs {
+		if run.ID != runID {
+			continue
+		}
+		for _, vm := range run.VMs {
+			if vm.Name == vmName {
+				return vm
+			}
+		}
+	}
+	return nil
+}
+
+// prune drops the oldest finished runs beyond maxFinishedRuns (must be
+// called with mu held)
+func (j *Journal) prune() {
+	finished := 0
+	for _, run := range j.Runs {
+		if run.Finished != nil {
+			finished++
+		}
+	}
+
+	kept := j.Runs[:0]
+	for _, run := range j.Runs {
+		if run.Finished != nil && finished > maxFinishedRuns {
+			finished--
+			continue
+		}
+		kept = append(kept, run)
+	}
+	j.Runs = kept
+}
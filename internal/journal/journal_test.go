@@ -0,0 +1,111 @@
+package journal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/upgrade"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+)
+
+// TestUpdateVMWrites checks that the journal is written when a step changes
+// and not for setup progress, which it does not keep
+func TestUpdateVMWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), journalFileName)
+	j := &Journal{path: path}
+	run, err := j.StartRun(`D:\iso\2022.iso`, "2016-to-2022", "admin", true, upgrade.MaintenanceWindow{}, []vcenter.VMInfo{{Name: "srv01"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := upgrade.UpgradeResult{VMName: "srv01", GuestPID: 4711}
+	res.Steps = []upgrade.UpgradeStep{{Name: upgrade.StepSetup, Status: upgrade.StatusCompleted}, {Name: upgrade.StepWaitExit, Status: upgrade.StatusInProgress, StartTime: time.Now()}}
+	written := func() bool {
+		t.Helper()
+		_, err := os.Stat(path)
+		if err == nil {
+			return true
+		}
+		if !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return false
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.UpdateVM(run.ID, res); err != nil {
+		t.Fatal(err)
+	}
+	if !written() {
+		t.Fatalf("journal not written when wait-exit started")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	for percent := 1; percent <= 10; percent++ {
+		res.Progress = upgrade.SetupProgress{Phase: upgrade.StepWaitExit, Percent: percent, Updated: time.Now()}
+		if err := j.UpdateVM(run.ID, res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if written() {
+		t.Errorf("journal written for setup progress")
+	}
+
+	res.Steps[1].Status, res.Steps[1].EndTime = upgrade.StatusCompleted, time.Now()
+	if err := j.UpdateVM(run.ID, res); err != nil {
+		t.Fatal(err)
+	}
+	if !written() {
+		t.Fatalf("journal not written when wait-exit finished")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Journal
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	rec := loaded.Runs[0].VMs[0]
+	if rec.GuestPID != 4711 || len(rec.Steps) != 2 || rec.Steps[1].Status != upgrade.StatusCompleted {
+		t.Errorf("record %+v, want PID 4711 and wait-exit completed", rec)
+	}
+}
+
+// TestUnfinishedCopies checks that the runs from Unfinished do not change
+// when the journal is updated afterwards
+func TestUnfinishedCopies(t *testing.T) {
+	j := &Journal{path: filepath.Join(t.TempDir(), journalFileName)}
+	run, err := j.StartRun(`D:\iso\2022.iso`, "2016-to-2022", "admin", true, upgrade.MaintenanceWindow{}, []vcenter.VMInfo{{Name: "srv01"}, {Name: "srv02"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := j.Unfinished()
+	if len(runs) != 1 || len(runs[0].Pending()) != 2 {
+		t.Fatalf("Unfinished() = %+v, want one run with two pending VMs", runs)
+	}
+
+	res := upgrade.UpgradeResult{VMName: "srv01", Success: true}
+	res.Steps = []upgrade.UpgradeStep{{Name: upgrade.StepSetup, Status: upgrade.StatusCompleted}}
+	if err := j.UpdateVM(run.ID, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.SetVMStatus(run.ID, "srv02", VMCancelled, upgrade.ErrCancelled); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := runs[0].Pending(); len(got) != 2 {
+		t.Errorf("copy has %d pending VMs after the journal changed, want 2", len(got))
+	}
+	if rec := runs[0].VMs[0]; len(rec.Steps) != 0 {
+		t.Errorf("copy of srv01 has steps %+v, want none", rec.Steps)
+	}
+}
@@ -0,0 +1,31 @@
+# Runs Windows Setup in compatibility scan mode. Nothing is installed, setup
+# only writes its findings to CompatData*.xml under $WINDOWS.~BT.
+param(
+    [int]$ImageIndex,
+    [string]$ProductKey
+)
+
+$ErrorActionPreference = 'Stop'
+
+$cdDrive = Get-CimInstance Win32_LogicalDisk | Where-Object { $_.DriveType -eq 5 } | Select-Object -First 1
+if (-not $cdDrive) { throw 'Ingen CD-ROM-enhet hittades' }
+$media = $cdDrive.DeviceID
+$setupPath = "$media\setup.exe"
+if (-not (Test-Path $setupPath)) { throw "setup.exe saknas på $media" }
+
+$panther = 'C:\$WINDOWS.~BT\Sources\Panther'
+$started = Get-Date
+
+$setupArgs = @('/auto','upgrade','/quiet','/noreboot','/dynamicupdate','disable','/telemetry','disable','/Compat','ScanOnly','/eula','accept')
+if ($ImageIndex) { $setupArgs += @('/imageindex',$ImageIndex) }
+if ($ProductKey) { $setupArgs += @('/pkey',$ProductKey) }
+
+$proc = Start-Process -FilePath $setupPath -ArgumentList $setupArgs -PassThru -WindowStyle Hidden -Wait
+
+# Setup exit codes are HRESULTs, e.g. C1900210 = no compatibility issues
+'exitcode=' + ('{0:X8}' -f $proc.ExitCode)
+if (Test-Path -LiteralPath $panther) {
+    Get-ChildItem -LiteralPath $panther -Filter 'CompatData*.xml' |
+        Where-Object { $_.LastWriteTime -ge $started } |
+        ForEach-Object { 'report=' + $_.FullName }
+}
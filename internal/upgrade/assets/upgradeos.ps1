@@ -1,3 +1,13 @@
+# Product keys and image indexes come from the catalog in osupgrader
+param(
+    [string]$StandardKey,
+    [int]$StandardCoreIndex,
+    [int]$StandardDesktopIndex,
+    [string]$DatacenterKey,
+    [int]$DatacenterCoreIndex,
+    [int]$DatacenterDesktopIndex
+)
+
 function Write-Log {
     param([string]$Message)
     $ts = Get-Date -Format 'yyyy-MM-dd HH:mm:ss'
@@ -11,22 +21,25 @@ function Set-ServerEditionAndImageIndex {
     $edition = Get-ServerEdition
     switch ($Edition) {
         'Datacenter' {
-            $glvk = 'WX4NM-KYWYW-QJJR4-XV3QB-6VM33'
+            $glvk = $DatacenterKey
             if($installationType -eq 'Server') {
-                $imageIndex = 4
+                $imageIndex = $DatacenterDesktopIndex
             } else {
-                $imageIndex = 3
+                $imageIndex = $DatacenterCoreIndex
             }
         }
         'Standard' {
-            $glvk = 'VDYBN-27WPP-V4HQT-9VMD4-VMK7H'
+            $glvk = $StandardKey
             if($installationType -eq 'Server') {
-                $imageIndex = 2
+                $imageIndex = $StandardDesktopIndex
             } else {
-                $imageIndex = 1
+                $imageIndex = $StandardCoreIndex
             }
         }
     }
+    if (-not $glvk -or -not $imageIndex) {
+        throw "Ingen produktnyckel/image index för edition '$edition'"
+    }
     return $glvk,$imageIndex,$edition
 }
 
@@ -56,7 +69,7 @@ try {
     $edition =  (Set-ServerEditionAndImageIndex)[2]
     Write-Log '=== Upgrade Start ==='
     Write-Log "Edition: $edition"
-    Write-Log "GLVK: $GLVK"
+    Write-Log ("Product key: *****-*****-*****-*****-" + $glvk.Substring($glvk.Length - 5))
     Write-Log "Image Index: $imageIndex"
 
     
@@ -88,7 +101,9 @@ try {
             $stderr = Get-Content 'C:\Windows\Temp\setup_stderr.log' -Raw
             Write-Log "STDERR: $stderr"
         }
-        throw "Setup misslyckades med exit code: $($proc.ExitCode)"
+        # Setup's own result code is decoded by the caller, e.g. C1900208
+        Write-Log ("Setup result: 0x{0:X8}" -f $proc.ExitCode)
+        exit $proc.ExitCode
     }
     
     Write-Log 'Setup completed successfully'
@@ -0,0 +1,308 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+	"github.com/vmware/govmomi/guest"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// VM is the vCenter side of a virtual machine as the upgrade flow uses it.
+// NewVM wraps a govmomi VM, NewFakeVM is an in-memory guest for tests.
+type VM interface {
+	// Properties reads the given property paths into dst
+	Properties(ctx context.Context, ps []string, dst *mo.VirtualMachine) error
+
+	// Datastores reads the given properties of the datastores refs
+	Datastores(ctx context.Context, refs []types.ManagedObjectReference, ps []string) ([]mo.Datastore, error)
+
+	// PowerOn and PowerOff return when the task has completed
+	PowerOn(ctx context.Context) error
+	PowerOff(ctx context.Context) error
+
+	CreateSnapshot(ctx context.Context, name, description string, memory, quiesce bool) error
+	RevertToSnapshot(ctx context.Context, name string) error
+
+	// Reconfigure applies spec, e.g. a CD-ROM backing change
+	Reconfigure(ctx context.Context, spec types.VirtualMachineConfigSpec) error
+
+	// Guest returns the guest operations of the VM, authenticated as gc
+	Guest(gc vcenter.GuestCreds) GuestOps
+}
+
+// GuestOps is what the upgrade flow does inside the guest through VMware
+// Tools. Every call authenticates with the credentials the GuestOps was
+// created with.
+type GuestOps interface {
+	ValidateCredentials(ctx context.Context) error
+
+	StartProgram(ctx context.Context, spec *types.GuestProgramSpec) (int64, error)
+	// ListProcesses lists the given PIDs, all processes if pids is empty
+	ListProcesses(ctx context.Context, pids []int64) ([]types.GuestProcessInfo, error)
+	TerminateProcess(ctx context.Context, pid int64) error
+
+	// CreateTemporaryFile creates an empty file in the guest's temp
+	// directory and returns its path
+	CreateTemporaryFile(ctx context.Context, prefix, suffix string) (string, error)
+	DeleteFile(ctx context.Context, path string) error
+	// ListFiles lists the entries of dir whose names match the regular
+	// expression pattern
+	ListFiles(ctx context.Context, dir, pattern string) ([]types.GuestFileInfo, error)
+	Upload(ctx context.Context, path string, data []byte) error
+	Download(ctx context.Context, path string) ([]byte, error)
+}
+
+// vcenterVM is a VM in vCenter, reached through govmomi
+type vcenterVM struct {
+	vm *object.VirtualMachine
+}
+
+// NewVM wraps a govmomi virtual machine
+func NewVM(vm *object.VirtualMachine) VM {
+	return &vcenterVM{vm: vm}
+}
+
+func (v *vcenterVM) Properties(ctx context.Context, ps []string, dst *mo.VirtualMachine) error {
+	return v.vm.Properties(ctx, v.vm.Reference(), ps, dst)
+}
+
+func (v *vcenterVM) Datastores(ctx context.Context, refs []types.ManagedObjectReference, ps []string) ([]mo.Datastore, error) {
+	var dss []mo.Datastore
+	pc := property.DefaultCollector(v.vm.Client())
+	if err := pc.Retrieve(ctx, refs, ps, &dss); err != nil {
+		return nil, err
+	}
+	return dss, nil
+}
+
+func (v *vcenterVM) PowerOn(ctx context.Context) error {
+	task, err := v.vm.PowerOn(ctx)
+	if err != nil {
+		return err
+	}
+	return task.Wait(ctx)
+}
+
+func (v *vcenterVM) PowerOff(ctx context.Context) error {
+	task, err := v.vm.PowerOff(ctx)
+	if err != nil {
+		return err
+	}
+	return task.Wait(ctx)
+}
+
+func (v *vcenterVM) CreateSnapshot(ctx context.Context, name, description string, memory, quiesce bool) error {
+	return vcenter.CreateSnapshot(ctx, v.vm, name, description, memory, quiesce)
+}
+
+func (v *vcenterVM) RevertToSnapshot(ctx context.Context, name string) error {
+	ref, err := v.vm.FindSnapshot(ctx, name)
+	if err != nil {
+		return fmt.Errorf("find snapshot %s: %w", name, err)
+	}
+	return vcenter.RevertToSnapshot(ctx, v.vm.Client(), *ref)
+}
+
+func (v *vcenterVM) Reconfigure(ctx context.Context, spec types.VirtualMachineConfigSpec) error {
+	task, err := v.vm.Reconfigure(ctx, spec)
+	if err != nil {
+		return err
+	}
+	return task.Wait(ctx)
+}
+
+func (v *vcenterVM) Guest(gc vcenter.GuestCreds) GuestOps {
+	return &vcenterGuest{
+		vm:   v.vm,
+		auth: &types.NamePasswordAuthentication{Username: gc.User, Password: gc.Pass},
+	}
+}
+
+// vcenterGuest runs guest operations through vCenter's guest operations
+// manager. The process and file managers are looked up once.
+type vcenterGuest struct {
+	vm   *object.VirtualMachine
+	auth types.BaseGuestAuthentication
+
+	mu sync.Mutex
+	pm *guest.ProcessManager
+	fm *guest.FileManager
+}
+
+func (g *vcenterGuest) opsManager() *guest.OperationsManager {
+	return guest.NewOperationsManager(g.vm.Client(), g.vm.Reference())
+}
+
+func (g *vcenterGuest) processManager(ctx context.Context) (*guest.ProcessManager, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pm == nil {
+		pm, err := g.opsManager().ProcessManager(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not get ProcessManager: %w", err)
+		}
+		g.pm = pm
+	}
+	return g.pm, nil
+}
+
+func (g *vcenterGuest) fileManager(ctx context.Context) (*guest.FileManager, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.fm == nil {
+		fm, err := g.opsManager().FileManager(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not get FileManager: %w", err)
+		}
+		g.fm = fm
+	}
+	return g.fm, nil
+}
+
+// ValidateCredentials calls the auth manager directly since govmomi's
+// AuthManager lookup panics when the server has none (vcsim)
+func (g *vcenterGuest) ValidateCredentials(ctx context.Context) error {
+	c := g.vm.Client()
+	if c.ServiceContent.GuestOperationsManager == nil {
+		return errors.New("guest operations not supported by server")
+	}
+	var gom mo.GuestOperationsManager
+	if err := property.DefaultCollector(c).RetrieveOne(ctx, *c.ServiceContent.GuestOperationsManager, []string{"authManager"}, &gom); err != nil {
+		return fmt.Errorf("could not get AuthManager: %w", err)
+	}
+	if gom.AuthManager == nil {
+		return errors.New("could not get AuthManager: guest authentication not supported by server")
+	}
+	_, err := methods.ValidateCredentialsInGuest(ctx, c, &types.ValidateCredentialsInGuest{This: *gom.AuthManager, Vm: g.vm.Reference(), Auth: g.auth})
+	return err
+}
+
+func (g *vcenterGuest) StartProgram(ctx context.Context, spec *types.GuestProgramSpec) (int64, error) {
+	pm, err := g.processManager(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return pm.StartProgram(ctx, g.auth, spec)
+}
+
+func (g *vcenterGuest) ListProcesses(ctx context.Context, pids []int64) ([]types.GuestProcessInfo, error) {
+	pm, err := g.processManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pm.ListProcesses(ctx, g.auth, pids)
+}
+
+func (g *vcenterGuest) TerminateProcess(ctx context.Context, pid int64) error {
+	pm, err := g.processManager(ctx)
+	if err != nil {
+		return err
+	}
+	return pm.TerminateProcess(ctx, g.auth, pid)
+}
+
+func (g *vcenterGuest) CreateTemporaryFile(ctx context.Context, prefix, suffix string) (string, error) {
+	fm, err := g.fileManager(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fm.CreateTemporaryFile(ctx, g.auth, prefix, suffix, "")
+}
+
+func (g *vcenterGuest) DeleteFile(ctx context.Context, path string) error {
+	fm, err := g.fileManager(ctx)
+	if err != nil {
+		return err
+	}
+	return fm.DeleteFile(ctx, g.auth, path)
+}
+
+func (g *vcenterGuest) ListFiles(ctx context.Context, dir, pattern string) ([]types.GuestFileInfo, error) {
+	fm, err := g.fileManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list, err := fm.ListFiles(ctx, g.auth, dir, 0, 100, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return list.Files, nil
+}
+
+// Upload writes data to path in the guest, replacing any existing file
+func (g *vcenterGuest) Upload(ctx context.Context, path string, data []byte) error {
+	fm, err := g.fileManager(ctx)
+	if err != nil {
+		return err
+	}
+	transferURL, err := fm.InitiateFileTransferToGuest(ctx, g.auth, path, &types.GuestFileAttributes{}, int64(len(data)), true)
+	if err != nil {
+		return fmt.Errorf("could not initiate file transfer: %w", err)
+	}
+
+	// Upload via HTTP PUT
+	req, err := http.NewRequestWithContext(ctx, "PUT", transferURL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("could not create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.ContentLength = int64(len(data))
+
+	var uploadErr error
+	err = g.vm.Client().Client.Do(ctx, req, func(resp *http.Response) error {
+		if resp.StatusCode != 200 && resp.StatusCode != 201 {
+			body, _ := io.ReadAll(resp.Body)
+			uploadErr = fmt.Errorf("file upload failed with status %d: %s", resp.StatusCode, string(body))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not upload file: %w", err)
+	}
+	return uploadErr
+}
+
+func (g *vcenterGuest) Download(ctx context.Context, path string) ([]byte, error) {
+	fm, err := g.fileManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fm.InitiateFileTransferFromGuest(ctx, g.auth, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not initiate file transfer of %s: %w", path, err)
+	}
+	u, err := fm.TransferURL(ctx, info.Url)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve transfer URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create download request: %w", err)
+	}
+
+	var data []byte
+	err = g.vm.Client().Client.Do(ctx, req, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("file download failed with status %d", resp.StatusCode)
+		}
+		var readErr error
+		data, readErr = io.ReadAll(resp.Body)
+		return readErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not download %s: %w", path, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,129 @@
+package upgrade
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+)
+
+// Editions detected by the guest upgrade script
+const (
+	EditionStandard   = "Standard"
+	EditionDatacenter = "Datacenter"
+)
+
+// catalogEntry is the product key and install.wim image indexes of one
+// edition of one release
+type catalogEntry struct {
+	gvlk         string
+	indexCore    int
+	indexDesktop int
+}
+
+// editionCatalog maps target build → edition → KMS client key (GVLK) and the
+// image indexes of a standard Microsoft ISO (1/2 Standard, 3/4 Datacenter)
+var editionCatalog = map[int]map[string]catalogEntry{
+	// Windows Server 2019
+	17763: {
+		EditionStandard:   {gvlk: "N69G4-B89J2-4G8F4-WWYCC-J464C", indexCore: 1, indexDesktop: 2},
+		EditionDatacenter: {gvlk: "WMDGN-G9PQG-XVVXX-R3X43-63DFG", indexCore: 3, indexDesktop: 4},
+	},
+	// Windows Server 2022
+	20348: {
+		EditionStandard:   {gvlk: "VDYBN-27WPP-V4HQT-9VMD4-VMK7H", indexCore: 1, indexDesktop: 2},
+		EditionDatacenter: {gvlk: "WX4NM-KYWYW-QJJR4-XV3QB-6VM33", indexCore: 3, indexDesktop: 4},
+	},
+	// Windows Server 2025
+	26100: {
+		EditionStandard:   {gvlk: "TVRH6-WHNXV-R9WG3-9XRFY-MY832", indexCore: 1, indexDesktop: 2},
+		EditionDatacenter: {gvlk: "D764K-2NDRG-47T6Q-P8T8W-YP6DF", indexCore: 3, indexDesktop: 4},
+	},
+}
+
+// ResolveEditions returns the product key and image indexes per edition for
+// a profile. The catalog entry for the profile's target build is the base,
+// the profile's own edition mappings replace it (custom media), and the
+// configured key overrides (MAK or organisation KMS keys) win last.
+func ResolveEditions(profile config.TargetProfile, overrides []config.ProductKeyOverride) map[string]config.EditionMapping {
+	out := make(map[string]config.EditionMapping)
+	for edition, e := range editionCatalog[profile.TargetBuild] {
+		out[edition] = config.EditionMapping{ProductKey: e.gvlk, ImageIndexCore: e.indexCore, ImageIndexDesktop: e.indexDesktop}
+	}
+
+	for edition, m := range profile.Editions {
+		cur := out[edition]
+		if m.ProductKey != "" {
+			cur.ProductKey = m.ProductKey
+		}
+		if m.ImageIndexCore != 0 {
+			cur.ImageIndexCore = m.ImageIndexCore
+		}
+		if m.ImageIndexDesktop != 0 {
+			cur.ImageIndexDesktop = m.ImageIndexDesktop
+		}
+		out[edition] = cur
+	}
+
+	// Overrides for all targets first so a build-specific one wins
+	for _, pass := range []bool{false, true} {
+		for _, o := range overrides {
+			if (o.TargetBuild != 0) != pass || o.ProductKey == "" {
+				continue
+			}
+			if o.TargetBuild != 0 && o.TargetBuild != profile.TargetBuild {
+				continue
+			}
+			for edition, cur := range out {
+				if strings.EqualFold(edition, o.Edition) {
+					cur.ProductKey = o.ProductKey
+					out[edition] = cur
+				}
+			}
+		}
+	}
+	return out
+}
+
+// scriptArguments renders the edition mappings as the parameters of
+// upgradeos.ps1. Editions without a mapping get no key, which the script
+// reports as an error once it has detected the guest's edition.
+func scriptArguments(editions map[string]config.EditionMapping) string {
+	var args []string
+	for _, edition := range []string{EditionStandard, EditionDatacenter} {
+		m, ok := editions[edition]
+		if !ok {
+			continue
+		}
+		args = append(args,
+			"-"+edition+"Key", psQuote(m.ProductKey),
+			"-"+edition+"CoreIndex", strconv.Itoa(m.ImageIndexCore),
+			"-"+edition+"DesktopIndex", strconv.Itoa(m.ImageIndexDesktop),
+		)
+	}
+	return strings.Join(args, " ")
+}
+
+// selectImage returns the product key and image index the upgrade script
+// uses for the guest's edition and installation type. ok is false when the
+// edition has no mapping.
+func selectImage(g GuestEdition, editions map[string]config.EditionMapping) (edition, key string, index int, ok bool) {
+	// "ServerDatacenter" and "ServerStandardEval" map to the catalog names
+	edition = strings.TrimSuffix(strings.TrimPrefix(g.EditionID, "Server"), "Eval")
+	m, ok := editions[edition]
+	if !ok {
+		return edition, "", 0, false
+	}
+	if strings.EqualFold(g.InstallationType, "Server Core") {
+		return edition, m.ProductKey, m.ImageIndexCore, true
+	}
+	return edition, m.ProductKey, m.ImageIndexDesktop, true
+}
+
+// maskKey hides all but the last group of a product key for logs
+func maskKey(key string) string {
+	if len(key) <= 5 {
+		return "*****"
+	}
+	return "*****-*****-*****-*****-" + key[len(key)-5:]
+}
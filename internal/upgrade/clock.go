@@ -0,0 +1,36 @@
+package upgrade
+
+import "time"
+
+// timeScale divides the waits, poll intervals and timeouts of the upgrade
+// flow. 0 and 1 are real time.
+type timeScale int
+
+// compressedBackend is implemented by backends whose time runs faster than
+// real time. Only the FakeVM and its guest do, so runs against real VMs
+// always wait in real time.
+type compressedBackend interface {
+	timeCompression() int
+}
+
+// timeScaleOf returns the time scale of a VM or GuestOps backend
+func timeScaleOf(backend any) timeScale {
+	if c, ok := backend.(compressedBackend); ok {
+		return timeScale(c.timeCompression())
+	}
+	return 1
+}
+
+// scaled returns d compressed by the time scale, at least a millisecond so
+// tickers stay valid
+func (s timeScale) scaled(d time.Duration) time.Duration {
+	if s <= 1 {
+		return d
+	}
+	return max(d/time.Duration(s), time.Millisecond)
+}
+
+// scaled returns d on the time scale of the run's VM
+func (r *upgradeRun) scaled(d time.Duration) time.Duration {
+	return r.clock.scaled(d)
+}
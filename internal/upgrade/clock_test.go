@@ -0,0 +1,39 @@
+package upgrade
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// TestTimeScale checks that only simulated guests run compressed
+func TestTimeScale(t *testing.T) {
+	f := NewFakeVM()
+	f.TimeCompression = 60
+
+	tests := []struct {
+		name    string
+		backend any
+		want    time.Duration
+	}{
+		{name: "vcenter VM", backend: NewVM(object.NewVirtualMachine(nil, types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"})), want: time.Hour},
+		{name: "fake VM", backend: f, want: time.Minute},
+		{name: "fake guest", backend: f.Guest(vcenter.GuestCreds{}), want: time.Minute},
+		{name: "real-time fake VM", backend: NewFakeVM(), want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := timeScaleOf(tt.backend).scaled(time.Hour); got != tt.want {
+				t.Errorf("scaled(1h) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := timeScale(1000).scaled(time.Microsecond); got != time.Millisecond {
+		t.Errorf("scaled(1µs) = %v, want the 1ms minimum", got)
+	}
+}
@@ -0,0 +1,273 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+)
+
+// scanNoIssues is the exit code of a /Compat ScanOnly run that found
+// nothing, any other code is decoded with the setup error catalog
+const scanNoIssues uint32 = 0xC1900210
+
+// CompatIssue is one finding of the compatibility scan
+type CompatIssue struct {
+	Kind    string // report section, e.g. "Program", "Device", "HardwareItem"
+	Name    string
+	Title   string
+	Message string
+}
+
+func (i CompatIssue) String() string {
+	text := i.Title
+	if text == "" {
+		text = i.Message
+	}
+	if i.Name != "" {
+		return fmt.Sprintf("%s %s: %s", i.Kind, i.Name, text)
+	}
+	return fmt.Sprintf("%s: %s", i.Kind, text)
+}
+
+// CompatResult is the outcome of Windows Setup /Compat ScanOnly
+type CompatResult struct {
+	ExitCode   uint32
+	Outcome    string
+	Decoded    *SetupError // catalog entry of the exit code, nil if unknown
+	HardBlocks []CompatIssue
+	SoftBlocks int      // warnings that do not stop the upgrade
+	Reports    []string // CompatData files read from the guest
+}
+
+// Blocked reports whether setup would refuse the upgrade
+func (c *CompatResult) Blocked() bool {
+	return len(c.HardBlocks) > 0 || (c.ExitCode != scanNoIssues && c.ExitCode != 0)
+}
+
+// Summary describes the scan result on one line
+func (c *CompatResult) Summary() string {
+	s := fmt.Sprintf("0x%08X %s", c.ExitCode, c.Outcome)
+	if len(c.HardBlocks) > 0 {
+		s += fmt.Sprintf(", %d hard blocks", len(c.HardBlocks))
+	}
+	if c.SoftBlocks > 0 {
+		s += fmt.Sprintf(", %d warnings", c.SoftBlocks)
+	}
+	return s
+}
+
+// compatScan runs Windows Setup in scan mode before anything is changed on
+// the VM. It mounts the ISO (the mount step remounts it later), collects the
+// CompatData reports from the guest and fails the run on hard blocks.
+func (r *upgradeRun) compatScan() error {
+	opts := r.opts
+	if !opts.Config.Upgrade.CompatScan {
+		return errStepSkipped
+	}
+	// A run resumed past this point has already changed the VM
+	if s := r.result.Step(StepSnapshot); s != nil && s.Status != StatusPending {
+		return errStepSkipped
+	}
+
+	edition, err := r.guestEdition()
+	if err != nil {
+		return fmt.Errorf("compat scan: %w", err)
+	}
+	name, key, index, ok := selectImage(edition, ResolveEditions(opts.Profile, opts.Config.Upgrade.ProductKeys))
+	if !ok || index == 0 {
+		return fmt.Errorf("compat scan: no image index for edition %s", name)
+	}
+
+	if err := MountISO(r.ctx, r.vm, opts.ISOPath); err != nil {
+		debug.LogError("MountISO", err, "VM", opts.VMInfo.Name, "ISOPath", opts.ISOPath)
+		return fmt.Errorf("compat scan: mount iso: %w", err)
+	}
+
+	res, err := r.runCompatScan(index, key)
+	if err != nil || res.Blocked() {
+		// Leave the VM as it was, nothing else has run yet
+		ctx, cancel := context.WithTimeout(context.Background(), r.scaled(2*time.Minute))
+		defer cancel()
+		if err := UnmountISO(ctx, r.vm); err != nil {
+			r.warnf("unmount ISO failed: %v", err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("compat scan: %w", err)
+	}
+
+	r.result.Compat = res
+	if res.Blocked() {
+		var titles []string
+		for _, b := range res.HardBlocks {
+			titles = append(titles, b.String())
+		}
+		debug.LogError("CompatScan", errors.New(res.Summary()), "VM", opts.VMInfo.Name, "Blocks", strings.Join(titles, "; "))
+		if len(titles) > 0 {
+			return fmt.Errorf("compat scan: %s: %s", res.Summary(), strings.Join(titles, "; "))
+		}
+		return fmt.Errorf("compat scan: %s", res.Summary())
+	}
+	if res.SoftBlocks > 0 {
+		r.warnf("compat scan: %d warnings, see %s", res.SoftBlocks, strings.Join(res.Reports, ", "))
+	}
+	debug.LogSuccess("CompatScan", "VM", opts.VMInfo.Name, "Result", res.Summary())
+	return nil
+}
+
+// runCompatScan starts setup.exe /Compat ScanOnly in the guest, waits for
+// it and reads the reports it wrote
+func (r *upgradeRun) runCompatScan(index int, key string) (*CompatResult, error) {
+	opts := r.opts
+	script, cleanup, err := extractAndReadCompatScript()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	args := fmt.Sprintf("-ImageIndex %d", index)
+	if key != "" {
+		args += " -ProductKey " + psQuote(key)
+	}
+	r.logf("Compat scan: image index %d, key %s", index, maskKey(key))
+
+	timeout := time.Duration(opts.Config.Timeouts.CompatScanMinutes) * time.Minute
+	out, err := runGuestPowerShell(r.ctx, r.guest, "& {\n"+script+"\n} "+args, opts.VMInfo.Name, timeout)
+	if err != nil {
+		// Do not leave setup scanning in the background
+		ctx, cancel := context.WithTimeout(context.Background(), r.scaled(2*time.Minute))
+		defer cancel()
+		if termErr := terminateGuestUpgrade(ctx, r.guest, 0, opts.VMInfo.Name); termErr != nil {
+			r.warnf("could not stop setup: %v", termErr)
+		}
+		return nil, err
+	}
+
+	res := &CompatResult{}
+	reported := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			r.guestOutput(line)
+		}
+		switch {
+		case strings.HasPrefix(line, "exitcode="):
+			code, err := strconv.ParseUint(strings.TrimPrefix(line, "exitcode="), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected setup exit code %q", line)
+			}
+			res.ExitCode = uint32(code)
+			reported = true
+		case strings.HasPrefix(line, "report="):
+			res.Reports = append(res.Reports, strings.TrimPrefix(line, "report="))
+		}
+	}
+	// Without a code nothing says the scan found no issues
+	if !reported {
+		return nil, errors.New("scan script did not report a setup exit code")
+	}
+	switch res.Decoded = DecodeSetupError(res.ExitCode, 0); {
+	case res.ExitCode == scanNoIssues:
+		res.Outcome = "no compatibility issues"
+	case res.Decoded != nil:
+		res.Outcome = res.Decoded.Explanation.EN
+	default:
+		res.Outcome = "unexpected setup result"
+	}
+
+	for _, report := range res.Reports {
+		data, err := downloadFileFromGuest(r.ctx, r.guest, report, opts.VMInfo.Name)
+		if err != nil {
+			r.warnf("could not read %s: %v", report, err)
+			continue
+		}
+		hard, soft, err := parseCompatData(data)
+		if err != nil {
+			r.warnf("could not parse %s: %v", report, err)
+			continue
+		}
+		res.HardBlocks = append(res.HardBlocks, hard...)
+		res.SoftBlocks += soft
+	}
+	r.logf("Compat scan: %s (%d reports)", res.Summary(), len(res.Reports))
+	return res, nil
+}
+
+// guestEdition returns the guest's edition, read once per run
+func (r *upgradeRun) guestEdition() (GuestEdition, error) {
+	if r.edition != nil {
+		return *r.edition, nil
+	}
+	g, err := ReadGuestEdition(r.ctx, r.guest, r.opts.VMInfo.Name)
+	if err != nil {
+		return GuestEdition{}, err
+	}
+	r.edition = &g
+	return g, nil
+}
+
+// parseCompatData decodes a CompatData XML report. Every element with a
+// CompatibilityInfo child is a finding; BlockingType "Hard" stops setup.
+func parseCompatData(data []byte) ([]CompatIssue, int, error) {
+	// Setup writes the reports as UTF-16LE with a byte order mark
+	dec := xml.NewDecoder(bytes.NewReader(decodeLogText(data)))
+	// The declaration still says UTF-16 after decoding
+	dec.CharsetReader = func(_ string, in io.Reader) (io.Reader, error) { return in, nil }
+
+	var hard []CompatIssue
+	soft := 0
+	var stack []xml.StartElement
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "CompatibilityInfo" && len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				issue := CompatIssue{
+					Kind:    parent.Name.Local,
+					Name:    xmlAttr(parent, "Name"),
+					Title:   xmlAttr(t, "Title"),
+					Message: xmlAttr(t, "Message"),
+				}
+				if issue.Name == "" {
+					issue.Name = xmlAttr(parent, "HardwareType")
+				}
+				switch strings.ToLower(xmlAttr(t, "BlockingType")) {
+				case "hard":
+					hard = append(hard, issue)
+				case "soft":
+					soft++
+				}
+			}
+			stack = append(stack, t)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return hard, soft, nil
+}
+
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package upgrade
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+)
+
+// EventKind tells what an Event reports
+type EventKind string
+
+// Event kinds emitted by UpgradeSingleVM
+const (
+	EventStepStarted  EventKind = "step-started"
+	EventStepFinished EventKind = "step-finished" // Status and Err say how
+	EventWarning      EventKind = "warning"       // non-fatal problem, also recorded on the step
+	EventGuestOutput  EventKind = "guest-output"  // a line written by a script in the guest
+	EventHookOutput   EventKind = "hook-output"   // a line written by a hook, Message is "hook: line"
+	EventMetric       EventKind = "metric"        // a measurement, see Metric, Value and Unit
+	EventMessage      EventKind = "message"       // what the run is doing, for logs
+	EventProgress     EventKind = "progress"      // the result changed, Result is a copy
+)
+
+// Event is one entry in the event stream of an upgrade run
+type Event struct {
+	Kind    EventKind
+	Time    time.Time
+	VM      string
+	Step    string // step the event belongs to, "" outside the steps
+	Message string
+
+	// EventStepFinished
+	Status string
+	Err    error
+
+	// EventMetric
+	Metric string
+	Value  float64
+	Unit   string
+
+	// EventStepStarted, EventStepFinished and EventProgress: a copy of the
+	// result at the time of the event
+	Result *UpgradeResult
+}
+
+// String formats the event as one log line
+func (e Event) String() string {
+	prefix := fmt.Sprintf("[%s]", e.VM)
+	if e.Step != "" {
+		prefix = fmt.Sprintf("[%s/%s]", e.VM, e.Step)
+	}
+	switch e.Kind {
+	case EventStepStarted:
+		return prefix + " step started"
+	case EventStepFinished:
+		if e.Err != nil {
+			return fmt.Sprintf("%s step %s: %v", prefix, e.Status, e.Err)
+		}
+		return fmt.Sprintf("%s step %s", prefix, e.Status)
+	case EventWarning:
+		return prefix + " WARNING: " + e.Message
+	case EventGuestOutput:
+		return prefix + " guest: " + e.Message
+	case EventHookOutput:
+		return prefix + " hook " + e.Message
+	case EventMetric:
+		return fmt.Sprintf("%s %s = %g %s", prefix, e.Metric, e.Value, e.Unit)
+	case EventProgress:
+		return prefix + " progress"
+	}
+	return prefix + " " + e.Message
+}
+
+// Observer receives the events of upgrade runs. Runs of several VMs call
+// the same observer from their own goroutines, and a run may call it from
+// a background poller, so OnEvent must be safe for concurrent use. It is
+// called synchronously and should return quickly.
+type Observer interface {
+	OnEvent(Event)
+}
+
+// ObserverFunc adapts a function to the Observer interface
+type ObserverFunc func(Event)
+
+// OnEvent calls f(e)
+func (f ObserverFunc) OnEvent(e Event) { f(e) }
+
+// DebugLog writes every event except progress snapshots to the debug log
+// file (-d). Add it to UpgradeOptions.Observers to keep the file log.
+var DebugLog Observer = ObserverFunc(func(e Event) {
+	if e.Kind != EventProgress {
+		debug.Log("%s", e)
+	}
+})
+
+// emit fills in the common fields and hands the event to every observer
+func (r *upgradeRun) emit(e Event) {
+	e.Time = time.Now()
+	e.VM = r.opts.VMInfo.Name
+	if e.Step == "" && r.step != nil {
+		e.Step = r.step.Name
+	}
+	for _, o := range r.opts.Observers {
+		o.OnEvent(e)
+	}
+}
+
+// logf emits a message event
+func (r *upgradeRun) logf(format string, args ...interface{}) {
+	r.emit(Event{Kind: EventMessage, Message: fmt.Sprintf(format, args...)})
+}
+
+// metric emits a measurement
+func (r *upgradeRun) metric(name string, value float64, unit string) {
+	r.emit(Event{Kind: EventMetric, Metric: name, Value: value, Unit: unit})
+}
+
+// hookOutput emits a line written by a hook, in the guest or locally
+func (r *upgradeRun) hookOutput(hook, line string) {
+	r.emit(Event{Kind: EventHookOutput, Message: hook + ": " + line})
+}
+
+// guestOutput emits a line written in the guest
+func (r *upgradeRun) guestOutput(line string) {
+	r.emit(Event{Kind: EventGuestOutput, Message: line})
+}
@@ -0,0 +1,899 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const gib = 1024 * 1024 * 1024
+
+// FakeVM is an in-memory VM with a simulated Windows guest, for running the
+// upgrade flow without vCenter or Windows. It starts powered on with
+// SourceOS. The upgrade script runs setup for SetupDuration; when setup
+// exits 0 the guest shuts down and the next power-on boots TargetOS and,
+// once the signal task has been created, writes the signal file. All
+// durations are compressed by TimeCompression.
+//
+// The exported fields are the scenario. Set them before the first call;
+// Errors makes the VM or GuestOps method of the same name fail, e.g.
+// "CreateSnapshot" or "Upload".
+type FakeVM struct {
+	SourceOS    string
+	SourceBuild int
+	TargetOS    string
+	TargetBuild int
+	Edition     GuestEdition
+	FreeGB      int64 // free space on C:
+	MemoryMB    int32
+
+	// DatastoreFreeGB is the free space of the VM's datastore
+	DatastoreFreeGB int64
+
+	// Creds are the only credentials the guest accepts, empty accepts any
+	Creds vcenter.GuestCreds
+	// RejectCredentials makes the guest refuse all credentials
+	RejectCredentials bool
+
+	SetupDuration time.Duration // setup.exe in the downlevel phase
+	ShutdownDelay time.Duration // from setup's exit to the guest powering off
+	BootDuration  time.Duration // from power-on to VMware Tools running
+	SignalDelay   time.Duration // from VMware Tools running to the signal file
+
+	// TimeCompression makes the guest, and the upgrade flow run against
+	// it, this many times faster than real time. 0 and 1 are real time.
+	TimeCompression int
+
+	SetupExitCode uint32 // setup's result code, e.g. 0xC1900208; 0 succeeds
+	NoShutdown    bool   // the guest stays on after setup
+	NeverBoots    bool   // VMware Tools never start after a power-on
+	NoSignal      bool   // the boot-time task never writes the signal file
+	NoCDROM       bool   // the VM has no CD/DVD device
+	Errors        map[string]error
+
+	// FailingHealth are the health checks (HealthServices, ...) that fail
+	// in the guest, the others pass
+	FailingHealth []string
+
+	// FailingHooks are the names of the guest hooks that exit 1, the
+	// others exit 0
+	FailingHooks []string
+
+	// HangingHooks are the names of the guest hooks that never exit by
+	// themselves, they run until they are terminated
+	HangingHooks []string
+
+	// Script answers the scripts of runGuestPowerShell before the
+	// built-in answers (edition, setup progress, compatibility scan,
+	// inventory, health checks, guest hooks). ok false falls through to
+	// them.
+	Script func(script string) (out string, exitCode int32, ok bool)
+
+	// Host is the vCenter VM the guest runs in, typically one in the
+	// vCenter simulator. When set, power, snapshot and CD-ROM operations
+	// are also applied to it, its devices and datastores are reported, and
+	// the guest OS is written back to it (vcsim's SET.guest.* keys) so the
+	// inventory shows the upgraded OS. See NewHostedFakeVM.
+	Host VM
+
+	mu         sync.Mutex
+	powered    bool
+	os         string
+	build      int
+	bootTime   time.Time
+	toolsAt    time.Time // VMware Tools running from, zero if they never start
+	offAt      time.Time // scheduled guest shutdown
+	signalAt   time.Time // signal file written, zero if never
+	upgraded   bool      // setup completed, the next boot is the target
+	signalTask bool
+	hostSync   bool // the host VM gets the new guest OS once VMware Tools run
+	iso        string
+	files      map[string]fakeFile // by lower-case path
+	procs      []*fakeProc
+	nextPID    int64
+	snapshots  map[string]*fakeSnapshot
+}
+
+type fakeFile struct {
+	path string
+	data []byte
+}
+
+type fakeProc struct {
+	info   types.GuestProcessInfo
+	exitAt time.Time
+	code   int32
+	onExit func(at time.Time) // runs under the lock when the process exits by itself
+}
+
+type fakeSnapshot struct {
+	powered    bool
+	os         string
+	build      int
+	upgraded   bool
+	signalTask bool
+	iso        string
+	files      map[string]fakeFile
+}
+
+// NewFakeVM returns a running Windows Server 2016 Standard guest that
+// upgrades to Windows Server 2022. Setup takes a few seconds, the shutdown
+// follows a minute later as in upgradeos.ps1.
+func NewFakeVM() *FakeVM {
+	f := &FakeVM{
+		SourceOS:        "Microsoft Windows Server 2016 (64-bit)",
+		SourceBuild:     14393,
+		TargetOS:        "Microsoft Windows Server 2022 (64-bit)",
+		TargetBuild:     20348,
+		Edition:         GuestEdition{EditionID: "ServerStandard", InstallationType: "Server", Language: "en-US"},
+		FreeGB:          60,
+		MemoryMB:        8192,
+		DatastoreFreeGB: 500,
+		SetupDuration:   3 * time.Second,
+		ShutdownDelay:   time.Minute,
+		BootDuration:    time.Second,
+		SignalDelay:     time.Second,
+		powered:         true,
+		files:           make(map[string]fakeFile),
+		snapshots:       make(map[string]*fakeSnapshot),
+		nextPID:         4000,
+	}
+	f.bootTime = time.Now().Add(-24 * time.Hour)
+	f.toolsAt = f.bootTime
+	return f
+}
+
+// NewHostedFakeVM returns a FakeVM that simulates the Windows guest of host,
+// starting from the OS, build, power state and free disk space the host
+// reports
+func NewHostedFakeVM(ctx context.Context, host VM) (*FakeVM, error) {
+	var o mo.VirtualMachine
+	if err := host.Properties(ctx, []string{"runtime.powerState", "guest"}, &o); err != nil {
+		return nil, err
+	}
+	build, err := guestBuild(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	f := NewFakeVM()
+	f.Host = host
+	f.SourceBuild = build
+	if o.Guest != nil {
+		f.SourceOS = o.Guest.GuestFullName
+		for _, d := range o.Guest.Disk {
+			if strings.EqualFold(d.DiskPath, `C:\`) {
+				f.FreeGB = d.FreeSpace / gib
+			}
+		}
+	}
+	if o.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+		f.powered = false
+		f.toolsAt = time.Time{}
+	}
+	return f, nil
+}
+
+// File returns a file in the guest
+func (f *FakeVM) File(path string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	file, ok := f.files[strings.ToLower(path)]
+	return file.data, ok
+}
+
+// fail returns the injected error of op
+func (f *FakeVM) fail(op string) error {
+	if err := f.Errors[op]; err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// advance applies what has happened in the guest since the last call
+func (f *FakeVM) advance() {
+	if f.os == "" {
+		f.os, f.build = f.SourceOS, f.SourceBuild
+	}
+	now := time.Now()
+	for _, p := range f.procs {
+		if p.info.EndTime == nil && !p.exitAt.IsZero() && !now.Before(p.exitAt) {
+			at := p.exitAt
+			p.info.EndTime = &at
+			p.info.ExitCode = p.code
+			if p.onExit != nil {
+				p.onExit(at)
+			}
+		}
+	}
+	if f.powered && !f.offAt.IsZero() && !now.Before(f.offAt) {
+		f.powerOff()
+		if err := f.setHostPower(context.Background(), false); err != nil {
+			debug.Log("FakeVM: host power off after guest shutdown failed: %v", err)
+		}
+	}
+	if f.powered && !f.signalAt.IsZero() && !now.Before(f.signalAt) {
+		f.writeFile(signalDir+`\`+signalFileName, []byte("ready"))
+		f.signalAt = time.Time{}
+	}
+	if f.hostSync && f.toolsRunning() {
+		f.hostSync = false
+		if err := f.syncHostGuest(context.Background()); err != nil {
+			debug.Log("FakeVM: could not write guest OS to host: %v", err)
+		}
+	}
+}
+
+func (f *FakeVM) toolsRunning() bool {
+	return f.powered && !f.toolsAt.IsZero() && !time.Now().Before(f.toolsAt)
+}
+
+func (f *FakeVM) writeFile(path string, data []byte) {
+	f.files[strings.ToLower(path)] = fakeFile{path: path, data: data}
+}
+
+func (f *FakeVM) appendFile(path, line string) {
+	file := f.files[strings.ToLower(path)]
+	f.writeFile(path, append(file.data, line+"\r\n"...))
+}
+
+// powerOff stops the guest and everything running in it
+func (f *FakeVM) powerOff() {
+	now := time.Now()
+	for _, p := range f.procs {
+		if p.info.EndTime == nil {
+			p.info.EndTime = &now
+			p.info.ExitCode = 1
+		}
+	}
+	f.powered = false
+	f.toolsAt, f.offAt, f.signalAt = time.Time{}, time.Time{}, time.Time{}
+}
+
+func (f *FakeVM) cdrom() *types.VirtualCdrom {
+	cd := &types.VirtualCdrom{VirtualDevice: types.VirtualDevice{
+		Key:        3000,
+		DeviceInfo: &types.Description{Label: "CD/DVD drive 1", Summary: "Remote device"},
+		Backing:    &types.VirtualCdromRemotePassthroughBackingInfo{},
+		Connectable: &types.VirtualDeviceConnectInfo{
+			AllowGuestControl: true,
+		},
+	}}
+	if f.iso != "" {
+		cd.DeviceInfo = &types.Description{Label: "CD/DVD drive 1", Summary: "ISO " + f.iso}
+		cd.Backing = &types.VirtualCdromIsoBackingInfo{VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: f.iso}}
+		cd.Connectable.StartConnected = true
+		cd.Connectable.Connected = true
+	}
+	return cd
+}
+
+// Properties fills in all the properties the upgrade flow reads, whatever
+// ps asks for
+func (f *FakeVM) Properties(ctx context.Context, ps []string, dst *mo.VirtualMachine) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if err := f.fail("Properties"); err != nil {
+		return err
+	}
+
+	var o mo.VirtualMachine
+	tools := string(types.VirtualMachineToolsRunningStatusGuestToolsNotRunning)
+	running := f.toolsRunning()
+	o.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOff
+	if f.powered {
+		o.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOn
+		boot := f.bootTime
+		o.Runtime.BootTime = &boot
+	}
+	if running {
+		tools = string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+	}
+	o.Guest = &types.GuestInfo{
+		GuestFullName:        f.os,
+		ToolsRunningStatus:   tools,
+		ToolsVersion:         "12352",
+		ToolsVersionStatus2:  string(types.VirtualMachineToolsVersionStatusGuestToolsCurrent),
+		GuestOperationsReady: types.NewBool(running),
+	}
+	if running {
+		o.Guest.Disk = []types.GuestDiskInfo{{DiskPath: `C:\`, Capacity: 127 * gib, FreeSpace: f.FreeGB * gib}}
+	}
+
+	var devices []types.BaseVirtualDevice
+	if !f.NoCDROM {
+		devices = append(devices, f.cdrom())
+	}
+	o.Config = &types.VirtualMachineConfigInfo{
+		GuestId:     "windows9Server64Guest",
+		Hardware:    types.VirtualHardware{MemoryMB: f.MemoryMB, Device: devices},
+		ExtraConfig: []types.BaseOptionValue{f.detailedData()},
+	}
+	o.Datastore = []types.ManagedObjectReference{{Type: "Datastore", Value: "datastore-1"}}
+
+	if f.Host != nil {
+		// The hardware and storage are the host VM's
+		var h mo.VirtualMachine
+		if err := f.Host.Properties(ctx, []string{"config.hardware", "datastore"}, &h); err != nil {
+			return err
+		}
+		if h.Config != nil {
+			o.Config.Hardware = h.Config.Hardware
+		}
+		o.Datastore = h.Datastore
+	}
+	*dst = o
+	return nil
+}
+
+// detailedData is the guestInfo.detailed.data VMware Tools reports
+func (f *FakeVM) detailedData() *types.OptionValue {
+	return &types.OptionValue{
+		Key:   detailedDataKey,
+		Value: fmt.Sprintf("architecture='X86' bitness='64' buildNumber='%d' distroName='Windows' familyName='Windows' prettyName='%s'", f.build, f.os),
+	}
+}
+
+// setHostPower powers the host VM on or off unless it already is
+func (f *FakeVM) setHostPower(ctx context.Context, on bool) error {
+	if f.Host == nil {
+		return nil
+	}
+	var h mo.VirtualMachine
+	if err := f.Host.Properties(ctx, []string{"runtime.powerState"}, &h); err != nil {
+		return err
+	}
+	if on == (h.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn) {
+		return nil
+	}
+	if on {
+		return f.Host.PowerOn(ctx)
+	}
+	return f.Host.PowerOff(ctx)
+}
+
+// syncHostGuest writes the guest OS to the host VM with the vCenter
+// simulator's SET.guest.* extra config keys
+func (f *FakeVM) syncHostGuest(ctx context.Context) error {
+	if f.Host == nil {
+		return nil
+	}
+	return f.Host.Reconfigure(ctx, types.VirtualMachineConfigSpec{ExtraConfig: []types.BaseOptionValue{
+		&types.OptionValue{Key: "SET.guest.guestFullName", Value: f.os},
+		f.detailedData(),
+	}})
+}
+
+func (f *FakeVM) Datastores(ctx context.Context, refs []types.ManagedObjectReference, ps []string) ([]mo.Datastore, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("Datastores"); err != nil {
+		return nil, err
+	}
+	if f.Host != nil {
+		return f.Host.Datastores(ctx, refs, ps)
+	}
+	var ds mo.Datastore
+	ds.Summary = types.DatastoreSummary{Name: "datastore1", Capacity: 2048 * gib, FreeSpace: f.DatastoreFreeGB * gib}
+	return []mo.Datastore{ds}, nil
+}
+
+func (f *FakeVM) PowerOn(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if err := f.fail("PowerOn"); err != nil {
+		return err
+	}
+	if f.powered {
+		return errors.New("the attempted operation cannot be performed in the current state (Powered on)")
+	}
+	if err := f.setHostPower(ctx, true); err != nil {
+		return err
+	}
+	now := time.Now()
+	f.powered = true
+	f.bootTime = now
+	if f.upgraded {
+		f.os, f.build = f.TargetOS, f.TargetBuild
+		f.upgraded = false
+		f.hostSync = true
+	}
+	f.toolsAt, f.signalAt = time.Time{}, time.Time{}
+	if !f.NeverBoots {
+		f.toolsAt = now.Add(f.scaled(f.BootDuration))
+		if f.signalTask && !f.NoSignal {
+			f.signalAt = f.toolsAt.Add(f.scaled(f.SignalDelay))
+		}
+	}
+	return nil
+}
+
+func (f *FakeVM) PowerOff(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if err := f.fail("PowerOff"); err != nil {
+		return err
+	}
+	if !f.powered {
+		return errors.New("the attempted operation cannot be performed in the current state (Powered off)")
+	}
+	if err := f.setHostPower(ctx, false); err != nil {
+		return err
+	}
+	f.powerOff()
+	return nil
+}
+
+func (f *FakeVM) CreateSnapshot(ctx context.Context, name, description string, memory, quiesce bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if err := f.fail("CreateSnapshot"); err != nil {
+		return err
+	}
+	if f.Host != nil {
+		if err := f.Host.CreateSnapshot(ctx, name, description, memory, quiesce); err != nil {
+			return err
+		}
+	}
+	files := make(map[string]fakeFile, len(f.files))
+	for k, v := range f.files {
+		files[k] = v
+	}
+	f.snapshots[name] = &fakeSnapshot{
+		powered:    f.powered && memory,
+		os:         f.os,
+		build:      f.build,
+		upgraded:   f.upgraded,
+		signalTask: f.signalTask,
+		iso:        f.iso,
+		files:      files,
+	}
+	return nil
+}
+
+func (f *FakeVM) RevertToSnapshot(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if err := f.fail("RevertToSnapshot"); err != nil {
+		return err
+	}
+	s, ok := f.snapshots[name]
+	if !ok {
+		return fmt.Errorf("find snapshot %s: snapshot not found", name)
+	}
+	if f.Host != nil {
+		if err := f.Host.RevertToSnapshot(ctx, name); err != nil {
+			return err
+		}
+	}
+	f.powerOff()
+	f.os, f.build, f.upgraded, f.signalTask, f.iso = s.os, s.build, s.upgraded, s.signalTask, s.iso
+	f.files = make(map[string]fakeFile, len(s.files))
+	for k, v := range s.files {
+		f.files[k] = v
+	}
+	if s.powered {
+		// A memory snapshot comes back running
+		f.powered = true
+		f.toolsAt = time.Now()
+	}
+	if err := f.setHostPower(ctx, f.powered); err != nil {
+		return err
+	}
+	if err := f.syncHostGuest(ctx); err != nil {
+		debug.Log("FakeVM: could not write guest OS to host: %v", err)
+	}
+	return nil
+}
+
+// Reconfigure only knows CD-ROM backing changes, a host VM gets the spec too
+func (f *FakeVM) Reconfigure(ctx context.Context, spec types.VirtualMachineConfigSpec) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	if err := f.fail("Reconfigure"); err != nil {
+		return err
+	}
+	if f.Host != nil {
+		if err := f.Host.Reconfigure(ctx, spec); err != nil {
+			return err
+		}
+	}
+	for _, change := range spec.DeviceChange {
+		cd, ok := change.GetVirtualDeviceConfigSpec().Device.(*types.VirtualCdrom)
+		if !ok || f.NoCDROM {
+			return errors.New("invalid configuration for device '0'")
+		}
+		f.iso = ""
+		if b, ok := cd.Backing.(*types.VirtualCdromIsoBackingInfo); ok && cd.Connectable != nil && cd.Connectable.Connected {
+			f.iso = b.FileName
+		}
+	}
+	return nil
+}
+
+func (f *FakeVM) timeCompression() int {
+	return f.TimeCompression
+}
+
+// scaled returns d compressed by the VM's time compression
+func (f *FakeVM) scaled(d time.Duration) time.Duration {
+	return timeScale(f.TimeCompression).scaled(d)
+}
+
+func (f *FakeVM) Guest(gc vcenter.GuestCreds) GuestOps {
+	return &fakeGuest{vm: f, gc: gc}
+}
+
+// fakeGuest is the guest operations of a FakeVM
+type fakeGuest struct {
+	vm *FakeVM
+	gc vcenter.GuestCreds
+}
+
+// begin locks the VM and checks that a guest operation can run. The caller
+// unlocks the VM, also when begin fails.
+func (g *fakeGuest) begin(op string) error {
+	f := g.vm
+	f.mu.Lock()
+	f.advance()
+	if err := f.fail(op); err != nil {
+		return err
+	}
+	if !f.toolsRunning() {
+		return errors.New("the guest operations agent could not be contacted")
+	}
+	if f.RejectCredentials || f.Creds != (vcenter.GuestCreds{}) && !(strings.EqualFold(g.gc.User, f.Creds.User) && g.gc.Pass == f.Creds.Pass) {
+		return errors.New("failed to authenticate with the guest operating system using the supplied credentials")
+	}
+	return nil
+}
+
+func (g *fakeGuest) timeCompression() int {
+	return g.vm.TimeCompression
+}
+
+func (g *fakeGuest) ValidateCredentials(ctx context.Context) error {
+	defer g.vm.mu.Unlock()
+	return g.begin("ValidateCredentials")
+}
+
+// outFilePattern finds the output file of a runGuestPowerShell wrapper
+var outFilePattern = regexp.MustCompile(`\| Out-File -Encoding UTF8 -FilePath '((?:[^']|'')*)'$`)
+
+func (g *fakeGuest) StartProgram(ctx context.Context, spec *types.GuestProgramSpec) (int64, error) {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("StartProgram"); err != nil {
+		return 0, err
+	}
+	f := g.vm
+	now := time.Now()
+	script := ""
+	if i := strings.Index(spec.Arguments, "-EncodedCommand "); i >= 0 {
+		script = decodePowerShell(strings.TrimSpace(spec.Arguments[i+len("-EncodedCommand "):]))
+	}
+	p := f.start(spec.ProgramPath, spec.Arguments, now)
+
+	switch {
+	case strings.Contains(spec.Arguments, "createsignaltasks.ps1"):
+		if _, ok := f.files[strings.ToLower(`C:\Temp\createsignaltasks.ps1`)]; !ok {
+			p.code = 1
+		} else {
+			f.signalTask = true
+		}
+		p.exitAt = now
+
+	case outFilePattern.MatchString(script):
+		if strings.Contains(script, hookExitMarker) && isNamedHook(script, f.HangingHooks) {
+			break
+		}
+		m := outFilePattern.FindStringSubmatch(script)
+		out, code := f.answer(script)
+		// Out-File UTF8 writes a byte order mark
+		f.writeFile(strings.ReplaceAll(m[1], "''", "'"), []byte("\ufeff"+out))
+		p.code, p.exitAt = code, now
+
+	case strings.Contains(script, "OSUpgraderSignal"):
+		// cleanup.ps1
+		f.signalTask = false
+		delete(f.files, strings.ToLower(`C:\Temp\createsignaltasks.ps1`))
+		p.exitAt = now
+
+	case strings.Contains(script, "'/auto','upgrade'"):
+		f.runSetup(p, now)
+
+	default:
+		p.exitAt = now
+	}
+	return p.info.Pid, nil
+}
+
+// start adds a running process
+func (f *FakeVM) start(path, args string, now time.Time) *fakeProc {
+	f.nextPID++
+	name := path
+	if i := strings.LastIndex(path, `\`); i >= 0 {
+		name = path[i+1:]
+	}
+	p := &fakeProc{info: types.GuestProcessInfo{
+		Name:      name,
+		Pid:       f.nextPID,
+		Owner:     "SYSTEM",
+		CmdLine:   path + " " + args,
+		StartTime: now,
+	}}
+	f.procs = append(f.procs, p)
+	return p
+}
+
+// runSetup simulates the upgrade script: setup.exe runs as its child for
+// SetupDuration, then the script exits with setup's result and, on
+// success, schedules the shutdown
+func (f *FakeVM) runSetup(p *fakeProc, now time.Time) {
+	const log = `C:\Temp\upgrade.log`
+	f.appendFile(log, "Upgrade script started")
+	if f.iso == "" {
+		f.appendFile(log, "FATAL ERROR: Ingen CD-ROM-enhet hittades")
+		p.code, p.exitAt = 1, now
+		return
+	}
+
+	done := now.Add(f.scaled(f.SetupDuration))
+	code := int32(f.SetupExitCode)
+	setup := f.start(`D:\setup.exe`, "/auto upgrade /noreboot", now)
+	setup.exitAt, setup.code = done, code
+	p.exitAt, p.code = done, code
+	p.onExit = func(at time.Time) {
+		f.appendFile(log, fmt.Sprintf("Setup process completed with exit code: %d", code))
+		if code != 0 {
+			f.appendFile(log, fmt.Sprintf("Setup result: 0x%08X", f.SetupExitCode))
+			f.appendFile(`C:\$WINDOWS.~BT\Sources\Panther\setuperr.log`, fmt.Sprintf("Error: Setup failed with 0x%08X", f.SetupExitCode))
+			return
+		}
+		f.appendFile(log, "Setup completed successfully")
+		f.upgraded = true
+		if !f.NoShutdown {
+			f.offAt = at.Add(f.scaled(f.ShutdownDelay))
+		}
+	}
+}
+
+// answer runs a script of runGuestPowerShell
+func (f *FakeVM) answer(script string) (string, int32) {
+	if f.Script != nil {
+		if out, code, ok := f.Script(script); ok {
+			return out, code
+		}
+	}
+	switch {
+	case strings.Contains(script, hookExitMarker):
+		return f.hook(script), 0
+	case strings.Contains(script, "InstallationType"):
+		return fmt.Sprintf("%s|%s|%s\r\n", f.Edition.EditionID, f.Edition.InstallationType, f.Edition.Language), 0
+	case strings.Contains(script, "ScanOnly"):
+		return fmt.Sprintf("exitcode=%08X\r\n", scanNoIssues), 0
+	case strings.Contains(script, "Get-WindowsFeature"):
+		return f.inventory(), 0
+	case strings.Contains(script, "function Report("):
+		var out strings.Builder
+		for _, name := range []string{HealthServices, HealthSecureChannel, HealthDNS, HealthGateway, HealthActivation, HealthPendingReboot} {
+			if !strings.Contains(script, "Report '"+name+"'") {
+				continue
+			}
+			result := CheckPassed
+			for _, failing := range f.FailingHealth {
+				if strings.EqualFold(failing, name) {
+					result = CheckFailed
+				}
+			}
+			fmt.Fprintf(&out, "%s|%s|simulated %s\r\n", name, result, name)
+		}
+		return out.String(), 0
+	case strings.Contains(script, "MoSetup"):
+		for _, p := range f.procs {
+			if p.info.Name == "setup.exe" && p.info.EndTime == nil && f.SetupDuration > 0 {
+				percent := int(time.Since(p.info.StartTime) * 100 / f.scaled(f.SetupDuration))
+				return fmt.Sprintf("percent=%d\r\nphase=Install\r\nlog=Setup running\r\n", min(percent, 99)), 0
+			}
+		}
+	}
+	return "", 0
+}
+
+// hook answers guestHookScript, the hook is named in its environment
+func (f *FakeVM) hook(script string) string {
+	for _, name := range f.FailingHooks {
+		if isNamedHook(script, []string{name}) {
+			return fmt.Sprintf("hook %s failed\r\n%s1\r\n", name, hookExitMarker)
+		}
+	}
+	return fmt.Sprintf("hook done\r\n%s0\r\n", hookExitMarker)
+}
+
+// isNamedHook reports whether guestHookScript script runs one of names
+func isNamedHook(script string, names []string) bool {
+	for _, name := range names {
+		if strings.Contains(script, "$env:OSUPGRADER_HOOK = "+psQuote(name)+"\n") {
+			return true
+		}
+	}
+	return false
+}
+
+// inventory answers inventoryScript. The target build drops XPS Viewer,
+// adds the Windows Update medic service and a newer Edge, as a real upgrade
+// would.
+func (f *FakeVM) inventory() string {
+	upgraded := f.TargetBuild != 0 && f.build >= f.TargetBuild
+	lines := []string{
+		"feature|FileAndStorage-Services|installed",
+		"feature|Storage-Services|installed",
+		"feature|NET-Framework-45-Core|installed",
+		"feature|PowerShellRoot|installed",
+		"feature|PowerShell|installed",
+		"feature|WoW64-Support|installed",
+		"service|LanmanServer|Running (Auto)",
+		"service|LanmanWorkstation|Running (Auto)",
+		"service|Dnscache|Running (Auto)",
+		"service|EventLog|Running (Auto)",
+		"service|W32Time|Running (Auto)",
+		"service|WinRM|Running (Auto)",
+		"service|VMTools|Running (Auto)",
+		"program|VMware Tools|12.3.5.22544099",
+		"port|TCP 0.0.0.0:135|svchost",
+		"port|TCP 0.0.0.0:445|System",
+		"port|TCP 0.0.0.0:3389|svchost",
+		"port|TCP 0.0.0.0:5985|System",
+		"network|Ethernet0|ip=10.20.30.40/24 gw=10.20.30.1 dns=10.20.30.10,10.20.30.11",
+	}
+	if upgraded {
+		lines = append(lines,
+			"service|WaaSMedicSvc|Stopped (Manual)",
+			"program|Microsoft Edge|118.0.2088.76",
+		)
+	} else {
+		lines = append(lines,
+			"feature|XPS-Viewer|installed",
+			"program|Microsoft Edge|109.0.1518.140",
+		)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func (g *fakeGuest) ListProcesses(ctx context.Context, pids []int64) ([]types.GuestProcessInfo, error) {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("ListProcesses"); err != nil {
+		return nil, err
+	}
+	var list []types.GuestProcessInfo
+	for _, p := range g.vm.procs {
+		if len(pids) == 0 || containsPID(pids, p.info.Pid) {
+			list = append(list, p.info)
+		}
+	}
+	return list, nil
+}
+
+func containsPID(pids []int64, pid int64) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *fakeGuest) TerminateProcess(ctx context.Context, pid int64) error {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("TerminateProcess"); err != nil {
+		return err
+	}
+	for _, p := range g.vm.procs {
+		if p.info.Pid == pid && p.info.EndTime == nil {
+			now := time.Now()
+			p.info.EndTime = &now
+			p.info.ExitCode = 1
+			p.onExit = nil
+			return nil
+		}
+	}
+	return fmt.Errorf("process %d not found", pid)
+}
+
+func (g *fakeGuest) CreateTemporaryFile(ctx context.Context, prefix, suffix string) (string, error) {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("CreateTemporaryFile"); err != nil {
+		return "", err
+	}
+	g.vm.nextPID++
+	path := fmt.Sprintf(`C:\Windows\Temp\%s%d%s`, prefix, g.vm.nextPID, suffix)
+	g.vm.writeFile(path, nil)
+	return path, nil
+}
+
+func (g *fakeGuest) DeleteFile(ctx context.Context, path string) error {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("DeleteFile"); err != nil {
+		return err
+	}
+	key := strings.ToLower(path)
+	if _, ok := g.vm.files[key]; !ok {
+		return fmt.Errorf("file %s was not found", path)
+	}
+	delete(g.vm.files, key)
+	return nil
+}
+
+func (g *fakeGuest) ListFiles(ctx context.Context, dir, pattern string) ([]types.GuestFileInfo, error) {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("ListFiles"); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	prefix := strings.ToLower(strings.TrimSuffix(dir, `\`)) + `\`
+	var list []types.GuestFileInfo
+	for key, file := range g.vm.files {
+		if !strings.HasPrefix(key, prefix) || strings.Contains(key[len(prefix):], `\`) {
+			continue
+		}
+		name := file.path[len(prefix):]
+		if re.MatchString(name) {
+			list = append(list, types.GuestFileInfo{Path: name, Type: string(types.GuestFileTypeFile), Size: int64(len(file.data))})
+		}
+	}
+	return list, nil
+}
+
+func (g *fakeGuest) Upload(ctx context.Context, path string, data []byte) error {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("Upload"); err != nil {
+		return err
+	}
+	g.vm.writeFile(path, append([]byte(nil), data...))
+	return nil
+}
+
+func (g *fakeGuest) Download(ctx context.Context, path string) ([]byte, error) {
+	defer g.vm.mu.Unlock()
+	if err := g.begin("Download"); err != nil {
+		return nil, err
+	}
+	file, ok := g.vm.files[strings.ToLower(path)]
+	if !ok {
+		return nil, fmt.Errorf("could not initiate file transfer of %s: file was not found", path)
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+// decodePowerShell reverses encodePowerShell
+func decodePowerShell(s string) string {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return ""
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return string(utf16.Decode(u))
+}
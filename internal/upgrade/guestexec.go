@@ -0,0 +1,94 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// runGuestPowerShell runs a PowerShell script in the guest and returns what
+// it wrote to the output stream. The output goes through a temporary file
+// since guest operations do not capture stdout. On timeout or cancellation
+// the guest process is terminated, it would otherwise keep running.
+func runGuestPowerShell(ctx context.Context, g GuestOps, script, serverName string, timeout time.Duration) (string, error) {
+	outFile, err := g.CreateTemporaryFile(ctx, "osupgrader_", ".txt")
+	if err != nil {
+		return "", fmt.Errorf("could not create guest temp file: %w", err)
+	}
+	defer func() {
+		// Best effort, the file is in the guest's temp directory
+		if err := g.DeleteFile(context.Background(), outFile); err != nil {
+			debug.Log("[%s] WARNING: could not delete %s: %v", serverName, outFile, err)
+		}
+	}()
+
+	wrapped := "$ErrorActionPreference = 'Stop'\n& {\n" + script + "\n} | Out-File -Encoding UTF8 -FilePath " + psQuote(outFile)
+	spec := &types.GuestProgramSpec{
+		ProgramPath: "C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\powershell.exe",
+		Arguments:   "-NoLogo -NonInteractive -NoProfile -ExecutionPolicy Bypass -EncodedCommand " + encodePowerShell(wrapped),
+	}
+	pid, err := g.StartProgram(ctx, spec)
+	if err != nil {
+		return "", fmt.Errorf("could not start guest script: %w", err)
+	}
+
+	clock := timeScaleOf(g)
+	ctx, cancel := context.WithTimeout(ctx, clock.scaled(timeout))
+	defer cancel()
+	ticker := time.NewTicker(clock.scaled(2 * time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopGuestProcess(g, pid, serverName)
+			return "", fmt.Errorf("guest script (PID %d): %w", pid, ctx.Err())
+		case <-ticker.C:
+		}
+
+		procs, err := g.ListProcesses(ctx, []int64{pid})
+		if err != nil {
+			debug.Log("[%s] WARNING: ListProcesses error: %v", serverName, err)
+			continue
+		}
+		if len(procs) > 0 && procs[0].EndTime == nil {
+			continue
+		}
+		if len(procs) > 0 && procs[0].ExitCode != 0 {
+			return "", fmt.Errorf("guest script exited with code %d", procs[0].ExitCode)
+		}
+		break
+	}
+
+	data, err := downloadFileFromGuest(ctx, g, outFile, serverName)
+	if err != nil {
+		return "", err
+	}
+	// Out-File UTF8 writes a byte order mark
+	return strings.TrimPrefix(string(data), "\ufeff"), nil
+}
+
+// stopGuestProcess terminates a guest process whose caller has given up on
+// it. It has a context of its own since the caller's is already done.
+func stopGuestProcess(g GuestOps, pid int64, serverName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeScaleOf(g).scaled(30*time.Second))
+	defer cancel()
+	debug.Log("[%s] Terminating guest script (PID: %d)", serverName, pid)
+	if err := g.TerminateProcess(ctx, pid); err != nil {
+		debug.Log("[%s] WARNING: could not terminate guest script (PID %d): %v", serverName, pid, err)
+	}
+}
+
+// downloadFileFromGuest reads a file from the guest via VMware FileManager
+func downloadFileFromGuest(ctx context.Context, g GuestOps, guestPath, serverName string) ([]byte, error) {
+	data, err := g.Download(ctx, guestPath)
+	if err != nil {
+		return nil, err
+	}
+	debug.LogSuccess("FileDownload", "Server", serverName, "Path", guestPath, "Size", len(data))
+	return data, nil
+}
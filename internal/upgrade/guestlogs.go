@@ -0,0 +1,142 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const guestLogsDirName = "osupgrader-logs"
+
+// guestLogFiles are the files copied from the guest when an upgrade fails
+var guestLogFiles = []string{
+	`C:\Temp\upgrade.log`,
+	`C:\Windows\Temp\setup_stdout.log`,
+	`C:\Windows\Temp\setup_stderr.log`,
+	`C:\$WINDOWS.~BT\Sources\Panther\setupact.log`,
+	`C:\$WINDOWS.~BT\Sources\Panther\setuperr.log`,
+	`C:\$WINDOWS.~BT\Sources\Rollback\setupact.log`,
+	`C:\$WINDOWS.~BT\Sources\Rollback\setuperr.log`,
+}
+
+// guestLogPatterns are directories and file patterns copied in addition to
+// guestLogFiles
+var guestLogPatterns = []struct{ dir, pattern string }{
+	{`C:\$WINDOWS.~BT\Sources\Panther`, `(?i)^CompatData.*\.xml$`},
+}
+
+// GuestLogDir returns the local folder for the guest logs of one VM in one
+// run, next to conf.json
+func GuestLogDir(runID, vmName string) (string, error) {
+	dir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, guestLogsDirName, safeFileName(runID), safeFileName(vmName)), nil
+}
+
+// collectLogs copies the setup logs of a failed run to the local guest log
+// folder. Anything that goes wrong is a warning, the upgrade error stays.
+func (r *upgradeRun) collectLogs(failedStep string) {
+	opts := r.opts
+	// Before setup has run there is nothing in the guest but CompatData
+	if s := r.result.Step(StepSetup); failedStep != StepCompatScan && (s == nil || s.Status == StatusPending) {
+		return
+	}
+
+	runID := opts.RunID
+	if runID == "" {
+		runID = time.Now().Format("20060102-150405")
+	}
+	dir, err := GuestLogDir(runID, opts.VMInfo.Name)
+	if err != nil {
+		r.warnf("guest logs not collected: %v", err)
+		return
+	}
+
+	// The run context may be what ran out
+	ctx, cancel := context.WithTimeout(context.Background(), r.scaled(5*time.Minute))
+	defer cancel()
+	files, err := collectGuestLogs(ctx, r.guest, dir, opts.VMInfo.Name)
+	if err != nil {
+		r.warnf("guest logs not collected: %v", err)
+	}
+	if len(files) > 0 {
+		r.result.GuestLogs = dir
+		debug.LogSuccess("CollectGuestLogs", "VM", opts.VMInfo.Name, "Dir", dir, "Files", len(files))
+	}
+}
+
+// collectGuestLogs downloads the setup logs that exist in the guest to dir
+// and returns the local paths. Files missing in the guest are skipped.
+func collectGuestLogs(ctx context.Context, g GuestOps, dir, serverName string) ([]string, error) {
+	paths := append([]string(nil), guestLogFiles...)
+	for _, p := range guestLogPatterns {
+		list, err := g.ListFiles(ctx, p.dir, p.pattern)
+		if err != nil {
+			debug.Log("[%s] No %s in %s: %v", serverName, p.pattern, p.dir, err)
+			continue
+		}
+		for _, f := range list {
+			if f.Type == string(types.GuestFileTypeFile) {
+				paths = append(paths, p.dir+`\`+f.Path)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, guestPath := range paths {
+		data, err := downloadFileFromGuest(ctx, g, guestPath, serverName)
+		if err != nil {
+			// Most of the files only exist after a given setup phase
+			debug.Log("[%s] Guest log %s not collected: %v", serverName, guestPath, err)
+			continue
+		}
+		local := filepath.Join(dir, guestLogName(guestPath))
+		if err := os.WriteFile(local, data, 0o644); err != nil {
+			return files, fmt.Errorf("could not write %s: %w", local, err)
+		}
+		files = append(files, local)
+	}
+	if len(files) == 0 {
+		os.Remove(dir)
+		return nil, fmt.Errorf("none of the setup logs could be read from the guest")
+	}
+	return files, nil
+}
+
+// guestLogName gives a guest path a unique local file name, e.g.
+// Panther\setupact.log becomes Panther_setupact.log
+func guestLogName(guestPath string) string {
+	parts := strings.Split(guestPath, `\`)
+	name := parts[len(parts)-1]
+	if len(parts) > 1 {
+		switch dir := parts[len(parts)-2]; dir {
+		case "Panther", "Rollback":
+			name = dir + "_" + name
+		}
+	}
+	return safeFileName(name)
+}
+
+// safeFileName replaces characters not allowed in Windows file names
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '<', '>', ':', '"', '/', '\\', '|', '?', '*':
+			return '_'
+		}
+		return r
+	}, s)
+}
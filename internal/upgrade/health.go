@@ -0,0 +1,254 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+)
+
+// Health check names, in report order
+const (
+	HealthServices      = "services"
+	HealthSecureChannel = "secure-channel"
+	HealthDNS           = "dns"
+	HealthGateway       = "gateway"
+	HealthActivation    = "activation"
+	HealthPendingReboot = "pending-reboot"
+)
+
+const healthTimeout = 5 * time.Minute
+
+// HealthReport is the outcome of the post-upgrade health checks. The checks
+// report pass, warn or fail like the preflight checks.
+type HealthReport struct {
+	Checks []PreflightCheck
+}
+
+// HealthCheckNames returns the checks cfg enables, in report order
+func HealthCheckNames(cfg config.HealthConfig) []string {
+	var names []string
+	if len(cfg.Services) > 0 {
+		names = append(names, HealthServices)
+	}
+	if cfg.SecureChannel {
+		names = append(names, HealthSecureChannel)
+	}
+	if cfg.DNS {
+		names = append(names, HealthDNS)
+	}
+	if cfg.Gateway {
+		names = append(names, HealthGateway)
+	}
+	if cfg.Activation {
+		names = append(names, HealthActivation)
+	}
+	if cfg.PendingReboot {
+		names = append(names, HealthPendingReboot)
+	}
+	return names
+}
+
+// Check returns the named check, or nil if it has not run
+func (h *HealthReport) Check(name string) *PreflightCheck {
+	for i := range h.Checks {
+		if h.Checks[i].Name == name {
+			return &h.Checks[i]
+		}
+	}
+	return nil
+}
+
+// Failed returns the checks that failed
+func (h *HealthReport) Failed() []PreflightCheck {
+	var failed []PreflightCheck
+	for _, c := range h.Checks {
+		if c.Result == CheckFailed {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// Summary counts the outcomes on one line
+func (h *HealthReport) Summary() string {
+	var passed, warned, failed int
+	for _, c := range h.Checks {
+		switch c.Result {
+		case CheckPassed:
+			passed++
+		case CheckWarning:
+			warned++
+		default:
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d passed, %d warnings, %d failed", passed, warned, failed)
+}
+
+// healthScriptHeader defines the report function and the computer's domain
+// membership used by several checks
+const healthScriptHeader = `function Report($name, $result, $detail) { "$name|$result|$detail" }
+$cs = Get-CimInstance -ClassName Win32_ComputerSystem`
+
+// Each check writes one "name|result|detail" line and turns its own errors
+// into a failure so the other checks still run
+const healthServicesScript = `try {
+    $bad = @()
+    foreach ($n in $services) {
+        $s = Get-Service -Name $n -ErrorAction SilentlyContinue
+        if (-not $s) { $bad += "$n (not installed)" } elseif ($s.Status -ne 'Running') { $bad += "$n ($($s.Status))" }
+    }
+    if ($bad) { Report 'services' 'fail' ('not running: ' + ($bad -join ', ')) } else { Report 'services' 'pass' ('running: ' + ($services -join ', ')) }
+} catch { Report 'services' 'fail' $_.Exception.Message }`
+
+const healthSecureChannelScript = `try {
+    if (-not $cs.PartOfDomain) { Report 'secure-channel' 'pass' 'not a domain member' }
+    elseif (Test-ComputerSecureChannel) { Report 'secure-channel' 'pass' "secure channel to $($cs.Domain) OK" }
+    else { Report 'secure-channel' 'fail' "secure channel to $($cs.Domain) is broken" }
+} catch { Report 'secure-channel' 'fail' $_.Exception.Message }`
+
+const healthDNSScript = `try {
+    if (-not $names -and $cs.PartOfDomain) { $names = @($cs.Domain) }
+    if (-not $names) { Report 'dns' 'warn' 'nothing to resolve (not a domain member, no dns_names)' }
+    else {
+        $bad = @()
+        foreach ($n in $names) {
+            try { Resolve-DnsName -Name $n -DnsOnly -QuickTimeout -ErrorAction Stop | Out-Null } catch { $bad += $n }
+        }
+        if ($bad) { Report 'dns' 'fail' ('could not resolve: ' + ($bad -join ', ')) } else { Report 'dns' 'pass' ('resolved: ' + ($names -join ', ')) }
+    }
+} catch { Report 'dns' 'fail' $_.Exception.Message }`
+
+const healthGatewayScript = `try {
+    $gw = Get-NetRoute -DestinationPrefix '0.0.0.0/0' -ErrorAction SilentlyContinue | Sort-Object -Property RouteMetric | Select-Object -First 1 -ExpandProperty NextHop
+    if (-not $gw) { Report 'gateway' 'warn' 'no default gateway' }
+    elseif (Test-Connection -ComputerName $gw -Count 2 -Quiet) { Report 'gateway' 'pass' "$gw reachable" }
+    else { Report 'gateway' 'fail' "$gw does not answer ping" }
+} catch { Report 'gateway' 'fail' $_.Exception.Message }`
+
+const healthActivationScript = `try {
+    $lp = Get-CimInstance -ClassName SoftwareLicensingProduct -Filter "ApplicationID='55c92734-d682-4d71-983e-d6ec3f16059f' AND PartialProductKey IS NOT NULL" | Select-Object -First 1
+    $states = 'unlicensed', 'licensed', 'out-of-box grace', 'out-of-tolerance grace', 'non-genuine grace', 'notification', 'extended grace'
+    if (-not $lp) { Report 'activation' 'fail' 'no product key installed' }
+    elseif ($lp.LicenseStatus -eq 1) { Report 'activation' 'pass' "licensed ($($lp.Name))" }
+    elseif ($lp.LicenseStatus -eq 0) { Report 'activation' 'fail' "unlicensed ($($lp.Name))" }
+    else { Report 'activation' 'warn' "$($states[$lp.LicenseStatus]) ($($lp.Name))" }
+} catch { Report 'activation' 'fail' $_.Exception.Message }`
+
+const healthPendingRebootScript = `try {
+    $why = @()
+    if (Test-Path 'HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\RebootPending') { $why += 'component servicing' }
+    if (Test-Path 'HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired') { $why += 'Windows Update' }
+    $sm = Get-ItemProperty -Path 'HKLM:\SYSTEM\CurrentControlSet\Control\Session Manager' -Name PendingFileRenameOperations -ErrorAction SilentlyContinue
+    if ($sm -and $sm.PendingFileRenameOperations) { $why += 'pending file renames' }
+    if ($why) { Report 'pending-reboot' 'warn' ('reboot pending: ' + ($why -join ', ')) } else { Report 'pending-reboot' 'pass' 'no reboot pending' }
+} catch { Report 'pending-reboot' 'fail' $_.Exception.Message }`
+
+// buildHealthScript returns the read-only script running the checks of cfg
+func buildHealthScript(cfg config.HealthConfig) string {
+	quoted := func(list []string) string {
+		q := make([]string, len(list))
+		for i, s := range list {
+			q[i] = psQuote(s)
+		}
+		return "@(" + strings.Join(q, ", ") + ")"
+	}
+
+	parts := []string{healthScriptHeader}
+	for _, name := range HealthCheckNames(cfg) {
+		switch name {
+		case HealthServices:
+			parts = append(parts, "$services = "+quoted(cfg.Services), healthServicesScript)
+		case HealthSecureChannel:
+			parts = append(parts, healthSecureChannelScript)
+		case HealthDNS:
+			parts = append(parts, "$names = "+quoted(cfg.DNSNames), healthDNSScript)
+		case HealthGateway:
+			parts = append(parts, healthGatewayScript)
+		case HealthActivation:
+			parts = append(parts, healthActivationScript)
+		case HealthPendingReboot:
+			parts = append(parts, healthPendingRebootScript)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// parseHealth turns the output of the health script into a report. A check
+// that wrote nothing has failed.
+func parseHealth(out string, names []string) *HealthReport {
+	found := make(map[string]PreflightCheck)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		c := PreflightCheck{Name: parts[0], Result: parts[1], Detail: parts[2]}
+		if c.Result != CheckPassed && c.Result != CheckWarning {
+			c.Result = CheckFailed
+		}
+		found[c.Name] = c
+	}
+
+	report := &HealthReport{}
+	for _, name := range names {
+		c, ok := found[name]
+		if !ok {
+			c = PreflightCheck{Name: name, Result: CheckFailed, Detail: "no result from the guest"}
+		}
+		report.Checks = append(report.Checks, c)
+	}
+	return report
+}
+
+// RunHealthChecks runs the checks of cfg in the guest through guest
+// operations. Nothing is changed in the guest.
+func RunHealthChecks(ctx context.Context, g GuestOps, cfg config.HealthConfig, serverName string) (*HealthReport, error) {
+	out, err := runGuestPowerShell(ctx, g, buildHealthScript(cfg), serverName, healthTimeout)
+	if err != nil {
+		return nil, err
+	}
+	report := parseHealth(out, HealthCheckNames(cfg))
+	debug.Log("[%s] Health checks: %s", serverName, report.Summary())
+	return report, nil
+}
+
+// health verifies that the upgraded server works. Warnings are recorded on
+// the step, failed checks fail the VM without a rollback: the upgrade itself
+// succeeded and is kept.
+func (r *upgradeRun) health() error {
+	cfg := r.opts.Config.Upgrade.Health
+	if !cfg.Enabled {
+		return errStepSkipped
+	}
+
+	r.logf("Running post-upgrade health checks...")
+	report, err := RunHealthChecks(r.ctx, r.guest, cfg, r.opts.VMInfo.Name)
+	if err != nil {
+		debug.LogError("HealthChecks", err, "VM", r.opts.VMInfo.Name)
+		return fmt.Errorf("health checks: %w", err)
+	}
+	r.result.Health = report
+
+	var failed []string
+	for _, c := range report.Checks {
+		switch c.Result {
+		case CheckPassed:
+			r.logf("Health %s: %s", c.Name, c.Detail)
+		case CheckWarning:
+			r.warnf("health %s: %s", c.Name, c.Detail)
+		default:
+			failed = append(failed, c.Name+": "+c.Detail)
+		}
+	}
+	if len(failed) > 0 {
+		debug.LogError("HealthChecks", fmt.Errorf("checks failed"), "VM", r.opts.VMInfo.Name, "Failed", len(failed))
+		return fmt.Errorf("health: %d of %d checks failed: %s", len(failed), len(report.Checks), strings.Join(failed, "; "))
+	}
+	debug.LogSuccess("HealthChecks", "VM", r.opts.VMInfo.Name, "Summary", report.Summary())
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package upgrade
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as it is. Without process groups only the
+// hook's own process is killed, cmd.WaitDelay stops waiting for the rest.
+func killProcessGroup(cmd *exec.Cmd) {}
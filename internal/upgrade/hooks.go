@@ -0,0 +1,246 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+)
+
+// Hook phases, config.HookConfig.When
+const (
+	HookPre  = "pre"  // before setup starts
+	HookPost = "post" // after the guest signalled that Windows is ready
+)
+
+// Hook failure policies, config.HookConfig.OnFailure
+const (
+	HookFail     = "fail"     // the VM fails at the hook step
+	HookContinue = "continue" // a warning, the upgrade goes on
+)
+
+const (
+	defaultHookTimeout = 10 * time.Minute
+
+	// localHookWaitDelay is how long a local hook's output is read after
+	// it was killed
+	localHookWaitDelay = 5 * time.Second
+
+	// hookExitMarker ends the output of a guest hook with its exit code
+	hookExitMarker = "osupgrader-hook-exit|"
+)
+
+// ValidateHooks checks hook definitions before a run starts, so a typo is
+// not found half way through the VMs
+func ValidateHooks(hooks []config.HookConfig) error {
+	for i, h := range hooks {
+		name := h.Name
+		if name == "" {
+			return fmt.Errorf("hook %d has no name", i+1)
+		}
+		if h.When != HookPre && h.When != HookPost {
+			return fmt.Errorf("hook %s: when must be %q or %q, not %q", name, HookPre, HookPost, h.When)
+		}
+		if (h.GuestScript == "") == (h.Command == "") {
+			return fmt.Errorf("hook %s: set exactly one of guest_script and command", name)
+		}
+		if h.OnFailure != "" && h.OnFailure != HookFail && h.OnFailure != HookContinue {
+			return fmt.Errorf("hook %s: on_failure must be %q or %q, not %q", name, HookFail, HookContinue, h.OnFailure)
+		}
+		if h.TimeoutMinutes < 0 {
+			return fmt.Errorf("hook %s: negative timeout_minutes", name)
+		}
+		if h.GuestScript != "" {
+			if _, err := os.Stat(h.GuestScript); err != nil {
+				return fmt.Errorf("hook %s: guest script: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// HooksFor returns the hooks run in phase, in configuration order
+func HooksFor(hooks []config.HookConfig, phase string) []config.HookConfig {
+	var out []config.HookConfig
+	for _, h := range hooks {
+		if h.When == phase {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func hookTimeout(h config.HookConfig) time.Duration {
+	if h.TimeoutMinutes <= 0 {
+		return defaultHookTimeout
+	}
+	return time.Duration(h.TimeoutMinutes) * time.Minute
+}
+
+// hookEnv is what a hook is told about the VM, as environment variables
+func (r *upgradeRun) hookEnv(h config.HookConfig) []string {
+	return []string{
+		"OSUPGRADER_HOOK=" + h.Name,
+		"OSUPGRADER_PHASE=" + h.When,
+		"OSUPGRADER_VM=" + r.opts.VMInfo.Name,
+		"OSUPGRADER_FQDN=" + r.opts.VMInfo.Domain,
+		"OSUPGRADER_IP=" + r.opts.VMInfo.IP,
+		"OSUPGRADER_RUN_ID=" + r.opts.RunID,
+		"OSUPGRADER_PROFILE=" + r.opts.Profile.Name,
+	}
+}
+
+// runHooks runs the hooks of phase one at a time. A failed hook fails the
+// step unless its policy is to continue. Either way nothing is rolled back:
+// a pre hook runs before setup has changed the guest and a post hook after
+// the upgrade is done.
+func (r *upgradeRun) runHooks(phase string) error {
+	hooks := HooksFor(r.opts.Config.Hooks, phase)
+	if len(hooks) == 0 {
+		return errStepSkipped
+	}
+
+	for _, h := range hooks {
+		r.logf("Running %s hook %s...", phase, h.Name)
+		start := time.Now()
+		var err error
+		if h.GuestScript != "" {
+			err = r.runGuestHook(h)
+		} else {
+			err = r.runLocalHook(h)
+		}
+		if err == nil {
+			r.logf("Hook %s done in %v", h.Name, time.Since(start).Round(time.Second))
+			debug.LogSuccess("Hook", "VM", r.opts.VMInfo.Name, "Hook", h.Name)
+			continue
+		}
+		debug.LogError("Hook", err, "VM", r.opts.VMInfo.Name, "Hook", h.Name)
+		if h.OnFailure == HookContinue {
+			r.warnf("hook %s failed, continuing: %v", h.Name, err)
+			continue
+		}
+		return fmt.Errorf("hook %s: %w", h.Name, err)
+	}
+	return nil
+}
+
+// preHooks runs the hooks that prepare the server before setup starts
+func (r *upgradeRun) preHooks() error {
+	return r.runHooks(HookPre)
+}
+
+// postHooks runs the hooks once the upgraded server is up again
+func (r *upgradeRun) postHooks() error {
+	return r.runHooks(HookPost)
+}
+
+// runLocalHook runs a hook command on the operator machine, through
+// PowerShell on Windows and sh elsewhere. Its output goes to the run log.
+func (r *upgradeRun) runLocalHook(h config.HookConfig) error {
+	timeout := hookTimeout(h)
+	ctx, cancel := context.WithTimeout(r.ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		script := "$global:LASTEXITCODE = 0\n" + h.Command + "\nexit $LASTEXITCODE"
+		cmd = exec.CommandContext(ctx, "powershell.exe", "-NoLogo", "-NonInteractive", "-NoProfile",
+			"-ExecutionPolicy", "Bypass", "-EncodedCommand", encodePowerShell(script))
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", h.Command)
+	}
+	cmd.Env = append(os.Environ(), r.hookEnv(h)...)
+	// A process the hook left running may hold its output open
+	cmd.WaitDelay = localHookWaitDelay
+	killProcessGroup(cmd)
+
+	out, err := cmd.CombinedOutput()
+	for _, line := range outputLines(string(out)) {
+		r.hookOutput(h.Name, line)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
+
+// guestHookScript runs an uploaded hook with the hook environment and
+// reports its exit code on the last line. Errors the hook throws fail it.
+const guestHookScript = `%s
+$global:LASTEXITCODE = 0
+try {
+    & %s *>&1 | ForEach-Object { "$_" }
+    "` + hookExitMarker + `$LASTEXITCODE"
+} catch {
+    "$($_.Exception.Message)"
+    "` + hookExitMarker + `1"
+}`
+
+// runGuestHook uploads a hook's PowerShell file to the guest's temp folder,
+// runs it and removes it again. Its output goes to the run log.
+func (r *upgradeRun) runGuestHook(h config.HookConfig) error {
+	data, err := os.ReadFile(h.GuestScript)
+	if err != nil {
+		return fmt.Errorf("could not read guest script: %w", err)
+	}
+
+	guestPath, err := r.guest.CreateTemporaryFile(r.ctx, "osupgrader_hook_", ".ps1")
+	if err != nil {
+		return fmt.Errorf("could not create guest temp file: %w", err)
+	}
+	defer func() {
+		if err := r.guest.DeleteFile(context.Background(), guestPath); err != nil {
+			debug.Log("[%s] WARNING: could not delete %s: %v", r.opts.VMInfo.Name, guestPath, err)
+		}
+	}()
+	if err := r.guest.Upload(r.ctx, guestPath, data); err != nil {
+		return fmt.Errorf("could not upload guest script: %w", err)
+	}
+
+	var env []string
+	for _, kv := range r.hookEnv(h) {
+		name, value, _ := strings.Cut(kv, "=")
+		env = append(env, "$env:"+name+" = "+psQuote(value))
+	}
+	script := fmt.Sprintf(guestHookScript, strings.Join(env, "\n"), psQuote(guestPath))
+
+	out, err := runGuestPowerShell(r.ctx, r.guest, script, r.opts.VMInfo.Name, hookTimeout(h))
+	if err != nil {
+		return err
+	}
+
+	exitCode, reported := 0, false
+	for _, line := range outputLines(out) {
+		if code, ok := strings.CutPrefix(line, hookExitMarker); ok {
+			exitCode, err = strconv.Atoi(strings.TrimSpace(code))
+			reported = err == nil
+			continue
+		}
+		r.hookOutput(h.Name, line)
+	}
+	if !reported {
+		return fmt.Errorf("guest script did not report an exit code")
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("guest script exited with code %d", exitCode)
+	}
+	return nil
+}
+
+// outputLines splits command output into non-empty lines
+func outputLines(out string) []string {
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimRight(line, "\r \t"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
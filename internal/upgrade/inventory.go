@@ -0,0 +1,295 @@
+package upgrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+)
+
+// Inventory categories, in diff order
+const (
+	InventoryFeature = "feature" // installed roles and features
+	InventoryService = "service" // state and start mode
+	InventoryProgram = "program" // installed programs and their version
+	InventoryPort    = "port"    // listening TCP ports and the owning process
+	InventoryNetwork = "network" // addresses, gateway and DNS servers per adapter
+)
+
+// Inventory change kinds
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+const (
+	inventoryTimeout    = 5 * time.Minute
+	inventoryBeforeFile = "inventory-before.json"
+	inventoryAfterFile  = "inventory-after.json"
+)
+
+// GuestInventory is what a guest has installed and running at one point in
+// time, by category and name
+type GuestInventory struct {
+	Captured time.Time                    `json:"captured"`
+	Items    map[string]map[string]string `json:"items"` // category → name → state
+}
+
+// InventoryChange is one difference between two inventories
+type InventoryChange struct {
+	Category string
+	Name     string
+	Kind     string // "added", "removed", "changed"
+	Before   string
+	After    string
+}
+
+func (c InventoryChange) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		if c.After == "" {
+			return fmt.Sprintf("%s %s added", c.Category, c.Name)
+		}
+		return fmt.Sprintf("%s %s added (%s)", c.Category, c.Name, c.After)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s %s removed", c.Category, c.Name)
+	}
+	return fmt.Sprintf("%s %s was %s, now %s", c.Category, c.Name, c.Before, c.After)
+}
+
+// InventoryResult is the guest inventory captured before setup and after
+// the upgrade, and the differences between the two
+type InventoryResult struct {
+	Dir     string // local folder with the inventory files
+	Before  *GuestInventory
+	After   *GuestInventory
+	Changes []InventoryChange
+}
+
+// inventoryScript lists the inventory as "category|name|state" lines. Each
+// category is read on its own so a missing cmdlet only loses that part.
+// Ports in the dynamic range are left out, they change with every boot.
+const inventoryScript = `try {
+    Get-WindowsFeature | Where-Object { $_.Installed } | ForEach-Object { "feature|$($_.Name)|installed" }
+} catch { }
+Get-CimInstance -ClassName Win32_Service | ForEach-Object { "service|$($_.Name)|$($_.State) ($($_.StartMode))" }
+$keys = 'HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall\*', 'HKLM:\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall\*'
+Get-ItemProperty -Path $keys -ErrorAction SilentlyContinue | Where-Object { $_.DisplayName -and -not $_.SystemComponent } | ForEach-Object { "program|$($_.DisplayName)|$($_.DisplayVersion)" }
+try {
+    Get-NetTCPConnection -State Listen | Where-Object { $_.LocalPort -lt 49152 } | ForEach-Object {
+        $p = Get-Process -Id $_.OwningProcess -ErrorAction SilentlyContinue
+        "port|TCP $($_.LocalAddress):$($_.LocalPort)|$($p.ProcessName)"
+    }
+} catch { }
+try {
+    Get-NetIPConfiguration | ForEach-Object {
+        $ip = ($_.IPv4Address | ForEach-Object { "$($_.IPAddress)/$($_.PrefixLength)" }) -join ','
+        $gw = ($_.IPv4DefaultGateway | ForEach-Object { $_.NextHop }) -join ','
+        $dns = ($_.DNSServer | Where-Object { $_.AddressFamily -eq 2 } | ForEach-Object { $_.ServerAddresses }) -join ','
+        "network|$($_.InterfaceAlias)|ip=$ip gw=$gw dns=$dns"
+    }
+} catch { }`
+
+// parseInventory turns the output of inventoryScript into an inventory
+func parseInventory(out string) *GuestInventory {
+	inv := &GuestInventory{Captured: time.Now(), Items: make(map[string]map[string]string)}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 3)
+		if len(parts) != 3 || parts[1] == "" {
+			continue
+		}
+		items := inv.Items[parts[0]]
+		if items == nil {
+			items = make(map[string]string)
+			inv.Items[parts[0]] = items
+		}
+		items[parts[1]] = parts[2]
+	}
+	return inv
+}
+
+// Count returns the number of items in the inventory
+func (inv *GuestInventory) Count() int {
+	n := 0
+	for _, items := range inv.Items {
+		n += len(items)
+	}
+	return n
+}
+
+// DiffInventory returns what changed from before to after, by category and
+// name. Names are compared without regard to case.
+func DiffInventory(before, after *GuestInventory) []InventoryChange {
+	categories := []string{InventoryFeature, InventoryService, InventoryProgram, InventoryPort, InventoryNetwork}
+	for _, inv := range []*GuestInventory{before, after} {
+		for c := range inv.Items {
+			if !containsString(categories, c) {
+				categories = append(categories, c)
+			}
+		}
+	}
+
+	var changes []InventoryChange
+	for _, category := range categories {
+		old, cur := foldKeys(before.Items[category]), foldKeys(after.Items[category])
+		var names []string
+		for key := range old {
+			names = append(names, key)
+		}
+		for key := range cur {
+			if _, ok := old[key]; !ok {
+				names = append(names, key)
+			}
+		}
+		sort.Strings(names)
+
+		for _, key := range names {
+			o, inOld := old[key]
+			n, inCur := cur[key]
+			switch {
+			case !inOld:
+				changes = append(changes, InventoryChange{Category: category, Name: n.name, Kind: ChangeAdded, After: n.state})
+			case !inCur:
+				changes = append(changes, InventoryChange{Category: category, Name: o.name, Kind: ChangeRemoved, Before: o.state})
+			case o.state != n.state:
+				changes = append(changes, InventoryChange{Category: category, Name: n.name, Kind: ChangeChanged, Before: o.state, After: n.state})
+			}
+		}
+	}
+	return changes
+}
+
+type inventoryItem struct{ name, state string }
+
+// foldKeys indexes items by lower-case name
+func foldKeys(items map[string]string) map[string]inventoryItem {
+	out := make(map[string]inventoryItem, len(items))
+	for name, state := range items {
+		out[strings.ToLower(name)] = inventoryItem{name, state}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveInventory writes an inventory as JSON
+func SaveInventory(path string, inv *GuestInventory) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create %s: %w", filepath.Dir(path), err)
+	}
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// LoadInventory reads an inventory written by SaveInventory
+func LoadInventory(path string) (*GuestInventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inv GuestInventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return &inv, nil
+}
+
+// captureInventory reads the guest inventory and writes it to file in the
+// run's folder for the VM
+func (r *upgradeRun) captureInventory(file string) (*GuestInventory, error) {
+	out, err := runGuestPowerShell(r.ctx, r.guest, inventoryScript, r.opts.VMInfo.Name, inventoryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	inv := parseInventory(out)
+	if inv.Count() == 0 {
+		return nil, fmt.Errorf("the inventory query returned nothing")
+	}
+
+	res := r.result.Inventory
+	if res.Dir == "" {
+		runID := r.opts.RunID
+		if runID == "" {
+			runID = time.Now().Format("20060102-150405")
+		}
+		if res.Dir, err = GuestLogDir(runID, r.opts.VMInfo.Name); err != nil {
+			return nil, err
+		}
+	}
+	if err := SaveInventory(filepath.Join(res.Dir, file), inv); err != nil {
+		// The inventory is still compared, only the file is missing
+		r.warnf("inventory not saved: %v", err)
+	}
+	return inv, nil
+}
+
+// inventory captures the guest inventory before setup changes anything. A
+// failure only produces a warning, the upgrade does not depend on it.
+func (r *upgradeRun) inventory() error {
+	if !r.opts.Config.Upgrade.Inventory {
+		return errStepSkipped
+	}
+	r.result.Inventory = &InventoryResult{}
+	inv, err := r.captureInventory(inventoryBeforeFile)
+	if err != nil {
+		debug.LogError("CaptureInventory", err, "VM", r.opts.VMInfo.Name)
+		r.warnf("guest inventory not captured, no before/after comparison: %v", err)
+		r.result.Inventory = nil
+		return nil
+	}
+	r.result.Inventory.Before = inv
+	r.logf("Guest inventory captured: %d items", inv.Count())
+	return nil
+}
+
+// inventoryDiff captures the inventory again after the upgrade and compares
+// it with the one taken before setup. A failure only produces a warning.
+func (r *upgradeRun) inventoryDiff() error {
+	res := r.result.Inventory
+	if res == nil {
+		return errStepSkipped
+	}
+	if res.Before == nil && res.Dir != "" {
+		// Resumed from the journal, which only keeps the folder
+		before, err := LoadInventory(filepath.Join(res.Dir, inventoryBeforeFile))
+		if err != nil {
+			r.warnf("inventory from before the upgrade not available: %v", err)
+			return nil
+		}
+		res.Before = before
+	}
+	if res.Before == nil {
+		return errStepSkipped
+	}
+
+	after, err := r.captureInventory(inventoryAfterFile)
+	if err != nil {
+		debug.LogError("CaptureInventory", err, "VM", r.opts.VMInfo.Name)
+		r.warnf("guest inventory after the upgrade not captured: %v", err)
+		return nil
+	}
+	res.After = after
+	res.Changes = DiffInventory(res.Before, after)
+	r.logf("Guest inventory compared: %d changes", len(res.Changes))
+	for _, c := range res.Changes {
+		r.logf("Inventory: %s", c)
+	}
+	debug.LogSuccess("InventoryDiff", "VM", r.opts.VMInfo.Name, "Changes", len(res.Changes))
+	return nil
+}
@@ -4,24 +4,17 @@ import (
 	"context"
 	"errors"
 
-	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
 // MountISO mounts an ISO to the VM's CD-ROM
-func MountISO(ctx context.Context, vm *object.VirtualMachine, isoPath string) error {
+func MountISO(ctx context.Context, vm VM, isoPath string) error {
 	var o mo.VirtualMachine
-	if err := vm.Properties(ctx, vm.Reference(), []string{"config.hardware.device"}, &o); err != nil {
+	if err := vm.Properties(ctx, []string{"config.hardware.device"}, &o); err != nil {
 		return err
 	}
-	var cd *types.VirtualCdrom
-	for _, dev := range o.Config.Hardware.Device {
-		if v, ok := dev.(*types.VirtualCdrom); ok {
-			cd = v
-			break
-		}
-	}
+	cd := findCdrom(o.Config.Hardware.Device)
 	if cd == nil {
 		return errors.New("no CD/DVD device")
 	}
@@ -33,8 +26,8 @@ func MountISO(ctx context.Context, vm *object.VirtualMachine, isoPath string) er
 		},
 	}
 	cd.Connectable = &types.VirtualDeviceConnectInfo{
-		StartConnected:   true,
-		Connected:        true,
+		StartConnected:    true,
+		Connected:         true,
 		AllowGuestControl: true,
 	}
 	spec := types.VirtualMachineConfigSpec{
@@ -45,34 +38,24 @@ func MountISO(ctx context.Context, vm *object.VirtualMachine, isoPath string) er
 			},
 		},
 	}
-	task, err := vm.Reconfigure(ctx, spec)
-	if err != nil {
-		return err
-	}
-	return task.Wait(ctx)
+	return vm.Reconfigure(ctx, spec)
 }
 
 // UnmountISO unmounts ISO from the VM's CD-ROM
-func UnmountISO(ctx context.Context, vm *object.VirtualMachine) error {
+func UnmountISO(ctx context.Context, vm VM) error {
 	var o mo.VirtualMachine
-	if err := vm.Properties(ctx, vm.Reference(), []string{"config.hardware.device"}, &o); err != nil {
+	if err := vm.Properties(ctx, []string{"config.hardware.device"}, &o); err != nil {
 		return err
 	}
-	var cd *types.VirtualCdrom
-	for _, dev := range o.Config.Hardware.Device {
-		if v, ok := dev.(*types.VirtualCdrom); ok {
-			cd = v
-			break
-		}
-	}
+	cd := findCdrom(o.Config.Hardware.Device)
 	if cd == nil {
 		return errors.New("no CD/DVD device")
 	}
 	cdCopy := *cd
 	cd = &cdCopy
 	cd.Connectable = &types.VirtualDeviceConnectInfo{
-		StartConnected:   false,
-		Connected:        false,
+		StartConnected:    false,
+		Connected:         false,
 		AllowGuestControl: true,
 	}
 	cd.Backing = &types.VirtualCdromRemotePassthroughBackingInfo{}
@@ -84,9 +67,27 @@ func UnmountISO(ctx context.Context, vm *object.VirtualMachine) error {
 			},
 		},
 	}
-	task, err := vm.Reconfigure(ctx, spec)
-	if err != nil {
+	return vm.Reconfigure(ctx, spec)
+}
+
+// VerifyCDROM verifies that the VM has a CD/DVD device to mount the ISO in
+func VerifyCDROM(ctx context.Context, vm VM) error {
+	var o mo.VirtualMachine
+	if err := vm.Properties(ctx, []string{"config.hardware.device"}, &o); err != nil {
 		return err
 	}
-	return task.Wait(ctx)
+	if findCdrom(o.Config.Hardware.Device) == nil {
+		return errors.New("no CD/DVD device")
+	}
+	return nil
+}
+
+// findCdrom returns the first CD/DVD device, or nil if there is none
+func findCdrom(devices []types.BaseVirtualDevice) *types.VirtualCdrom {
+	for _, dev := range devices {
+		if v, ok := dev.(*types.VirtualCdrom); ok {
+			return v
+		}
+	}
+	return nil
 }
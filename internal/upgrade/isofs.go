@@ -0,0 +1,447 @@
+package upgrade
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// sectorSize is the logical sector size of ISO9660 and UDF on optical media
+const sectorSize = 2048
+
+// Bounds on what the descriptors of an image may claim, so a corrupt image
+// gives an error rather than a huge allocation
+const (
+	udfMinBlockSize = 512
+	udfMaxBlockSize = 4096
+	udfMaxVDSectors = 64
+	isoMaxDirSize   = 16 << 20
+)
+
+// UDF descriptor tag identifiers (ECMA-167)
+const (
+	udfTagAnchor          = 2
+	udfTagPartition       = 5
+	udfTagLogicalVolume   = 6
+	udfTagTerminating     = 8
+	udfTagFileSet         = 256
+	udfTagFileIdentifier  = 257
+	udfTagFileEntry       = 261
+	udfTagExtendedFileEnt = 266
+)
+
+var (
+	errNoUDF      = errors.New("no UDF file system")
+	errNoISO9660  = errors.New("no ISO9660 file system")
+	errNotOnImage = errors.New("not found on the image")
+)
+
+var le = binary.LittleEndian
+
+// isoExtent is a contiguous run of file data on the image
+type isoExtent struct {
+	offset int64 // byte offset in the image
+	length int64
+}
+
+// isoFile is a file located on an ISO image. UDF can embed small files in
+// the file entry itself, those are kept in data.
+type isoFile struct {
+	size    int64
+	extents []isoExtent
+	data    []byte
+}
+
+// readAt reads file data at off from the image
+func (f *isoFile) readAt(r io.ReaderAt, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if f.data != nil {
+		if off >= int64(len(f.data)) {
+			return 0, io.EOF
+		}
+		n := copy(p, f.data[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	read := 0
+	pos := int64(0)
+	for _, ext := range f.extents {
+		if read == len(p) {
+			break
+		}
+		want := off + int64(read)
+		if want >= pos+ext.length {
+			pos += ext.length
+			continue
+		}
+		chunk := p[read:]
+		if rest := pos + ext.length - want; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		n, err := r.ReadAt(chunk, ext.offset+want-pos)
+		read += n
+		if err != nil && !(errors.Is(err, io.EOF) && n == len(chunk)) {
+			return read, err
+		}
+		pos += ext.length
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// findISOFile locates a file on an ISO image by its path components,
+// compared case-insensitively. Windows media keep install.wim in the UDF
+// file system only, ISO9660 is tried when the image has no UDF.
+func findISOFile(r io.ReaderAt, path ...string) (*isoFile, error) {
+	f, err := udfFind(r, path)
+	if errors.Is(err, errNoUDF) {
+		f, err = iso9660Find(r, path)
+		if errors.Is(err, errNoISO9660) {
+			return nil, errors.New("image has neither a UDF nor an ISO9660 file system")
+		}
+	}
+	return f, err
+}
+
+func readSector(r io.ReaderAt, sector int64) ([]byte, error) {
+	b := make([]byte, sectorSize)
+	if _, err := r.ReadAt(b, sector*sectorSize); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// udfVolume is the one partition of a UDF volume as written by oscdimg
+type udfVolume struct {
+	r         io.ReaderAt
+	partStart int64 // first sector of the partition
+	blockSize int64
+}
+
+// offset returns the image offset of a logical block in the partition
+func (v *udfVolume) offset(lbn uint32) int64 {
+	return v.partStart*sectorSize + int64(lbn)*v.blockSize
+}
+
+func udfFind(r io.ReaderAt, path []string) (*isoFile, error) {
+	anchor, err := readSector(r, 256)
+	if errors.Is(err, io.EOF) {
+		// Too small to have an anchor
+		return nil, errNoUDF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read UDF anchor: %w", err)
+	}
+	if le.Uint16(anchor) != udfTagAnchor {
+		return nil, errNoUDF
+	}
+	vdsLength := le.Uint32(anchor[16:])
+	vdsLocation := le.Uint32(anchor[20:])
+
+	// Main volume descriptor sequence: partition and logical volume
+	v := &udfVolume{r: r}
+	var fsdBlock uint32
+	havePartition, haveVolume := false, false
+sequence:
+	for i := uint32(0); i < vdsLength/sectorSize && i < udfMaxVDSectors; i++ {
+		d, err := readSector(r, int64(vdsLocation+i))
+		if err != nil {
+			return nil, fmt.Errorf("read UDF volume descriptor: %w", err)
+		}
+		switch le.Uint16(d) {
+		case udfTagPartition:
+			v.partStart = int64(le.Uint32(d[188:]))
+			havePartition = true
+		case udfTagLogicalVolume:
+			v.blockSize = int64(le.Uint32(d[212:]))
+			fsdBlock = le.Uint32(d[252:])
+			// Only type 1 (physical) partition maps, UDF 2.50 metadata
+			// partitions are not used by Windows media
+			if le.Uint32(d[268:]) > 0 && d[440] != 1 {
+				return nil, fmt.Errorf("unsupported UDF partition map type %d", d[440])
+			}
+			haveVolume = true
+		case udfTagTerminating:
+			break sequence
+		}
+	}
+	if !havePartition || !haveVolume {
+		return nil, errors.New("incomplete UDF volume descriptor sequence")
+	}
+	if v.blockSize < udfMinBlockSize || v.blockSize > udfMaxBlockSize {
+		return nil, fmt.Errorf("invalid UDF block size %d", v.blockSize)
+	}
+
+	fsd := make([]byte, v.blockSize)
+	if _, err := r.ReadAt(fsd, v.offset(fsdBlock)); err != nil {
+		return nil, fmt.Errorf("read UDF file set descriptor: %w", err)
+	}
+	if le.Uint16(fsd) != udfTagFileSet {
+		return nil, errors.New("UDF file set descriptor not found")
+	}
+
+	icb := le.Uint32(fsd[404:]) // root directory ICB
+	for i, name := range path {
+		dir, isDir, err := v.fileEntry(icb)
+		if err != nil {
+			return nil, err
+		}
+		if !isDir {
+			return nil, fmt.Errorf("%s is not a directory", strings.Join(path[:i], "/"))
+		}
+		next, found, err := v.lookup(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: %w", strings.Join(path[:i+1], "/"), errNotOnImage)
+		}
+		icb = next
+	}
+
+	f, _, err := v.fileEntry(icb)
+	return f, err
+}
+
+// fileEntry reads a (extended) file entry and the allocation of its data
+func (v *udfVolume) fileEntry(lbn uint32) (*isoFile, bool, error) {
+	b := make([]byte, v.blockSize)
+	if _, err := v.r.ReadAt(b, v.offset(lbn)); err != nil {
+		return nil, false, fmt.Errorf("read UDF file entry: %w", err)
+	}
+
+	var lenEA, lenAD uint32
+	var adStart int
+	switch le.Uint16(b) {
+	case udfTagFileEntry:
+		lenEA, lenAD, adStart = le.Uint32(b[168:]), le.Uint32(b[172:]), 176
+	case udfTagExtendedFileEnt:
+		lenEA, lenAD, adStart = le.Uint32(b[208:]), le.Uint32(b[212:]), 216
+	default:
+		return nil, false, fmt.Errorf("no UDF file entry at block %d", lbn)
+	}
+	isDir := b[16+11] == 4 // ICB tag file type
+	flags := le.Uint16(b[16+18:])
+	f := &isoFile{size: int64(le.Uint64(b[56:]))}
+
+	start := adStart + int(lenEA)
+	if start+int(lenAD) > len(b) {
+		return nil, false, errors.New("UDF allocation descriptors out of range")
+	}
+	ads := b[start : start+int(lenAD)]
+
+	switch flags & 7 {
+	case 0: // short_ad
+		for i := 0; i+8 <= len(ads); i += 8 {
+			length := le.Uint32(ads[i:])
+			if length&0x3FFFFFFF == 0 {
+				break
+			}
+			if length>>30 != 0 {
+				return nil, false, errors.New("unsupported UDF extent type")
+			}
+			f.extents = append(f.extents, isoExtent{offset: v.offset(le.Uint32(ads[i+4:])), length: int64(length & 0x3FFFFFFF)})
+		}
+	case 1: // long_ad
+		for i := 0; i+16 <= len(ads); i += 16 {
+			length := le.Uint32(ads[i:])
+			if length&0x3FFFFFFF == 0 {
+				break
+			}
+			if length>>30 != 0 {
+				return nil, false, errors.New("unsupported UDF extent type")
+			}
+			f.extents = append(f.extents, isoExtent{offset: v.offset(le.Uint32(ads[i+4:])), length: int64(length & 0x3FFFFFFF)})
+		}
+	case 3: // data embedded in the entry
+		f.data = append([]byte(nil), ads...)
+	default:
+		return nil, false, fmt.Errorf("unsupported UDF allocation type %d", flags&7)
+	}
+	return f, isDir, nil
+}
+
+// lookup finds a name in a directory and returns the ICB of the entry
+func (v *udfVolume) lookup(dir *isoFile, name string) (uint32, bool, error) {
+	if dir.size < 0 || dir.size > isoMaxDirSize {
+		return 0, false, fmt.Errorf("invalid UDF directory size %d", dir.size)
+	}
+	d := make([]byte, dir.size)
+	if _, err := dir.readAt(v.r, d, 0); err != nil && !errors.Is(err, io.EOF) {
+		return 0, false, fmt.Errorf("read UDF directory: %w", err)
+	}
+
+	for off := 0; off+38 <= len(d); {
+		if le.Uint16(d[off:]) != udfTagFileIdentifier {
+			return 0, false, errors.New("corrupt UDF directory")
+		}
+		characteristics := d[off+18]
+		lenFI := int(d[off+19])
+		icb := le.Uint32(d[off+24:])
+		lenIU := int(le.Uint16(d[off+36:]))
+		nameStart := off + 38 + lenIU
+		if nameStart+lenFI > len(d) {
+			return 0, false, errors.New("corrupt UDF directory")
+		}
+		// Skip the parent entry and deleted files
+		if characteristics&0x0C == 0 && strings.EqualFold(udfString(d[nameStart:nameStart+lenFI]), name) {
+			return icb, true, nil
+		}
+		off += (38 + lenIU + lenFI + 3) &^ 3
+	}
+	return 0, false, nil
+}
+
+// udfString decodes an OSTA compressed unicode file identifier
+func udfString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	switch b[0] {
+	case 8:
+		runes := make([]rune, 0, len(b)-1)
+		for _, c := range b[1:] {
+			runes = append(runes, rune(c))
+		}
+		return string(runes)
+	case 16:
+		return utf16BE(b[1:])
+	}
+	return ""
+}
+
+func utf16BE(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(u))
+}
+
+func iso9660Find(r io.ReaderAt, path []string) (*isoFile, error) {
+	// Volume descriptors start at sector 16. Joliet is preferred over the
+	// primary descriptor since it keeps the original names.
+	var root []byte
+	joliet := false
+descriptors:
+	for s := int64(16); s < 16+32; s++ {
+		d, err := readSector(r, s)
+		if errors.Is(err, io.EOF) && s == 16 {
+			return nil, errNoISO9660
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read ISO9660 volume descriptor: %w", err)
+		}
+		if string(d[1:6]) != "CD001" {
+			if s == 16 {
+				return nil, errNoISO9660
+			}
+			break
+		}
+		switch d[0] {
+		case 1:
+			if root == nil {
+				root = d[156:190]
+			}
+		case 2:
+			if d[88] == '%' && d[89] == '/' && (d[90] == '@' || d[90] == 'C' || d[90] == 'E') {
+				root = d[156:190]
+				joliet = true
+			}
+		case 255:
+			break descriptors
+		}
+	}
+	if root == nil {
+		return nil, errors.New("ISO9660 primary volume descriptor not found")
+	}
+
+	f := &isoFile{
+		size:    int64(le.Uint32(root[10:])),
+		extents: []isoExtent{{offset: int64(le.Uint32(root[2:])) * sectorSize, length: int64(le.Uint32(root[10:]))}},
+	}
+	for i, name := range path {
+		next, err := iso9660Lookup(r, f, name, joliet)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(path[:i+1], "/"), errNotOnImage)
+		}
+		f = next
+	}
+	return f, nil
+}
+
+// iso9660Lookup finds a name in a directory. Files of 4 GB and more are
+// recorded as several consecutive entries with the multi-extent flag.
+func iso9660Lookup(r io.ReaderAt, dir *isoFile, name string, joliet bool) (*isoFile, error) {
+	if dir.size > isoMaxDirSize {
+		return nil, fmt.Errorf("invalid ISO9660 directory size %d", dir.size)
+	}
+	d := make([]byte, dir.size)
+	if _, err := dir.readAt(r, d, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read ISO9660 directory: %w", err)
+	}
+
+	var found *isoFile
+	for off := 0; off < len(d); {
+		recLen := int(d[off])
+		if recLen == 0 {
+			// Records do not cross sectors, the rest is padding
+			off = (off/sectorSize + 1) * sectorSize
+			continue
+		}
+		if off+recLen > len(d) || recLen < 34 {
+			return nil, errors.New("corrupt ISO9660 directory")
+		}
+		rec := d[off : off+recLen]
+		off += recLen
+
+		nameLen := int(rec[32])
+		if 33+nameLen > len(rec) {
+			return nil, errors.New("corrupt ISO9660 directory")
+		}
+		raw := rec[33 : 33+nameLen]
+		if nameLen == 1 && raw[0] <= 1 {
+			continue // "." and ".."
+		}
+		var recName string
+		if joliet {
+			recName = utf16BE(raw)
+		} else {
+			recName = string(raw)
+		}
+		if i := strings.IndexByte(recName, ';'); i >= 0 {
+			recName = recName[:i]
+		}
+		recName = strings.TrimSuffix(recName, ".")
+		if !strings.EqualFold(recName, name) {
+			if found != nil {
+				break
+			}
+			continue
+		}
+
+		if found == nil {
+			found = &isoFile{}
+		}
+		length := int64(le.Uint32(rec[10:]))
+		found.size += length
+		found.extents = append(found.extents, isoExtent{offset: int64(le.Uint32(rec[2:])) * sectorSize, length: length})
+		if rec[25]&0x80 == 0 {
+			break
+		}
+	}
+	return found, nil
+}
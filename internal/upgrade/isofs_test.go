@@ -0,0 +1,316 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// UDF fixture layout: descriptors after the anchor, the partition after
+// them. Blocks are partition relative.
+const (
+	udfPartStart   = 260
+	udfFSDBlock    = 0
+	udfRootFE      = 1
+	udfRootDir     = 2
+	udfSourcesFE   = 3
+	udfWIMFE       = 4
+	udfWIMData     = 5
+	udfFixtureWIMs = "Windows Server 2022"
+)
+
+// buildUDFImage returns a UDF image as oscdimg writes Windows media, with
+// sources/install.wim holding wim. The root directory uses short_ad, the
+// sources directory is embedded in its file entry and install.wim is an
+// extended file entry with long_ad and a UTF-16 name.
+func buildUDFImage(wim []byte) []byte {
+	wimBlocks := (len(wim) + sectorSize - 1) / sectorSize
+	img := make([]byte, (udfPartStart+udfWIMData+wimBlocks)*sectorSize)
+	sector := func(n int) []byte { return img[n*sectorSize : (n+1)*sectorSize] }
+	block := func(n int) []byte { return sector(udfPartStart + n) }
+
+	anchor := sector(256)
+	le.PutUint16(anchor, udfTagAnchor)
+	le.PutUint32(anchor[16:], 3*sectorSize)
+	le.PutUint32(anchor[20:], 257)
+
+	pd := sector(257)
+	le.PutUint16(pd, udfTagPartition)
+	le.PutUint32(pd[188:], udfPartStart)
+
+	lvd := sector(258)
+	le.PutUint16(lvd, udfTagLogicalVolume)
+	le.PutUint32(lvd[212:], sectorSize)
+	le.PutUint32(lvd[252:], udfFSDBlock)
+	le.PutUint32(lvd[268:], 1)
+	lvd[440] = 1
+
+	le.PutUint16(sector(259), udfTagTerminating)
+
+	fsd := block(udfFSDBlock)
+	le.PutUint16(fsd, udfTagFileSet)
+	le.PutUint32(fsd[404:], udfRootFE)
+
+	root := udfFID(udfRootFE, "", 0x08)
+	root = append(root, udfFID(udfSourcesFE, "\x08sources", 0)...)
+	copy(block(udfRootDir), root)
+	ad := make([]byte, 8)
+	le.PutUint32(ad, uint32(len(root)))
+	le.PutUint32(ad[4:], udfRootDir)
+	udfFileEntry(block(udfRootFE), udfTagFileEntry, true, 0, int64(len(root)), ad)
+
+	name := []byte{16}
+	for _, u := range utf16.Encode([]rune("install.wim")) {
+		name = append(name, byte(u>>8), byte(u))
+	}
+	sources := udfFID(udfRootFE, "", 0x08)
+	sources = append(sources, udfFID(udfWIMFE, string(name), 0)...)
+	udfFileEntry(block(udfSourcesFE), udfTagFileEntry, true, 3, int64(len(sources)), sources)
+
+	ad = make([]byte, 16)
+	le.PutUint32(ad, uint32(len(wim)))
+	le.PutUint32(ad[4:], udfWIMData)
+	udfFileEntry(block(udfWIMFE), udfTagExtendedFileEnt, false, 1, int64(len(wim)), ad)
+	copy(img[(udfPartStart+udfWIMData)*sectorSize:], wim)
+	return img
+}
+
+// udfFileEntry writes a (extended) file entry with the allocation
+// descriptors or embedded data ads
+func udfFileEntry(b []byte, tag uint16, dir bool, allocType uint16, size int64, ads []byte) {
+	le.PutUint16(b, tag)
+	b[16+11] = 5
+	if dir {
+		b[16+11] = 4
+	}
+	le.PutUint16(b[16+18:], allocType)
+	le.PutUint64(b[56:], uint64(size))
+	adStart := 176
+	if tag == udfTagExtendedFileEnt {
+		adStart = 216
+	}
+	le.PutUint32(b[adStart-4:], uint32(len(ads)))
+	copy(b[adStart:], ads)
+}
+
+// udfFID returns a file identifier descriptor for the entry at icb. name is
+// in OSTA compressed unicode, empty for the parent entry.
+func udfFID(icb uint32, name string, characteristics byte) []byte {
+	fid := make([]byte, (38+len(name)+3)&^3)
+	le.PutUint16(fid, udfTagFileIdentifier)
+	fid[18] = characteristics
+	fid[19] = byte(len(name))
+	le.PutUint32(fid[20:], sectorSize)
+	le.PutUint32(fid[24:], icb)
+	copy(fid[38:], name)
+	return fid
+}
+
+func TestReadMediaMetadata(t *testing.T) {
+	wim := buildWIM(udfFixtureWIMs, 20348, []string{"en-US", "sv-SE"})
+	iso := BuildMediaISO(udfFixtureWIMs, 20348, "en-US", "sv-SE")
+
+	tests := []struct {
+		name    string
+		img     []byte
+		wantErr string
+	}{
+		{name: "ISO9660", img: iso},
+		{name: "ISO9660 without padding", img: iso[:(20+(len(wim)+sectorSize-1)/sectorSize)*sectorSize]},
+		{name: "UDF", img: buildUDFImage(wim)},
+		{name: "no file system", img: make([]byte, 300*sectorSize), wantErr: "neither a UDF nor an ISO9660"},
+		{name: "no install.wim", img: bytes.Replace(iso, []byte("INSTALL.WIM"), []byte("INSTALL.OLD"), 1), wantErr: "not found on the image"},
+		{name: "empty", img: nil, wantErr: "neither a UDF nor an ISO9660"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := readMediaMetadata(bytes.NewReader(tt.img), "[ds1] iso/2022.iso")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.File != "sources/install.wim" || meta.Build != 20348 || meta.Version != "10.0.20348.1" {
+				t.Errorf("media %s in %s, want build 20348 in sources/install.wim", meta.Summary(), meta.File)
+			}
+			if len(meta.Images) != len(mediaImages) || strings.Join(meta.Languages, ",") != "en-US,sv-SE" {
+				t.Errorf("media %s, want %d images in en-US and sv-SE", meta.Summary(), len(mediaImages))
+			}
+			img, err := meta.MatchImage(GuestEdition{EditionID: "ServerDatacenter", InstallationType: "Server", Language: "sv-SE"})
+			if err != nil || img.Index != 4 {
+				t.Errorf("Datacenter Desktop Experience image %+v (%v), want index 4", img, err)
+			}
+		})
+	}
+}
+
+func TestReadMediaMetadataNotOnImage(t *testing.T) {
+	img := buildUDFImage(buildWIM(udfFixtureWIMs, 20348, []string{"en-US"}))
+	if _, err := findISOFile(bytes.NewReader(img), "sources", "boot.wim"); !errors.Is(err, errNotOnImage) {
+		t.Errorf("error %v, want %v", err, errNotOnImage)
+	}
+	if _, err := findISOFile(bytes.NewReader(img), "sources", "install.wim", "x"); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("error %v, want not a directory", err)
+	}
+}
+
+// TestReadMediaMetadataCorrupt damages the descriptors of both fixtures. A
+// damaged image must give an error, never a panic or a huge allocation.
+func TestReadMediaMetadataCorrupt(t *testing.T) {
+	wim := buildWIM(udfFixtureWIMs, 20348, []string{"en-US"})
+	udfWIMSector := udfPartStart + udfWIMData
+
+	fixtures := []struct {
+		name    string
+		img     []byte
+		end     int   // bytes needed to read the metadata
+		sectors []int // sectors holding descriptors
+	}{
+		{
+			name:    "ISO9660",
+			img:     BuildMediaISO(udfFixtureWIMs, 20348),
+			end:     20*sectorSize + len(wim),
+			sectors: []int{16, 17, 18, 19, 20},
+		},
+		{
+			name: "UDF",
+			img:  buildUDFImage(wim),
+			end:  udfWIMSector*sectorSize + len(wim),
+			sectors: []int{256, 257, 258, 259, udfPartStart + udfFSDBlock, udfPartStart + udfRootFE,
+				udfPartStart + udfRootDir, udfPartStart + udfSourcesFE, udfPartStart + udfWIMFE, udfWIMSector},
+		},
+	}
+
+	for _, fx := range fixtures {
+		t.Run(fx.name+" truncated", func(t *testing.T) {
+			for n := 0; n < fx.end; n += 256 {
+				if _, err := readMediaMetadata(bytes.NewReader(fx.img[:n]), "x.iso"); err == nil {
+					t.Errorf("no error for an image truncated to %d bytes", n)
+				}
+			}
+		})
+
+		t.Run(fx.name+" damaged", func(t *testing.T) {
+			img := append([]byte(nil), fx.img...)
+			for _, s := range fx.sectors {
+				// The descriptors and the start of the WIM header
+				for off := s * sectorSize; off < (s+1)*sectorSize && off+4 <= len(img); off += 4 {
+					saved := le.Uint32(img[off:])
+					le.PutUint32(img[off:], 0xFFFFFFFF)
+					readMediaMetadata(bytes.NewReader(img), "x.iso")
+					le.PutUint32(img[off:], saved)
+				}
+			}
+		})
+	}
+
+	udf := buildUDFImage(wim)
+	iso := BuildMediaISO(udfFixtureWIMs, 20348)
+	tests := []struct {
+		name    string
+		img     []byte
+		off     int
+		value   uint32
+		wantErr string
+	}{
+		{name: "UDF block size", img: udf, off: 258*sectorSize + 212, value: 1 << 30, wantErr: "invalid UDF block size"},
+		{name: "UDF directory size", img: udf, off: (udfPartStart+udfRootFE)*sectorSize + 56, value: 0xFFFFFFFF, wantErr: "invalid UDF directory size"},
+		{name: "UDF allocation descriptors", img: udf, off: (udfPartStart+udfRootFE)*sectorSize + 172, value: 0xFFFFFFF0, wantErr: "out of range"},
+		{name: "UDF directory entry", img: udf, off: (udfPartStart + udfRootDir) * sectorSize, value: 0, wantErr: "corrupt UDF directory"},
+		{name: "UDF file entry", img: udf, off: (udfPartStart + udfWIMFE) * sectorSize, value: 0, wantErr: "no UDF file entry"},
+		{name: "UDF partition map", img: udf, off: 258*sectorSize + 440, value: 2, wantErr: "unsupported UDF partition map type"},
+		{name: "ISO9660 directory size", img: iso, off: 16*sectorSize + 156 + 10, value: 0xFFFFFFFF, wantErr: "invalid ISO9660 directory size"},
+		{name: "ISO9660 directory record", img: iso, off: 18*sectorSize + 68, value: 0x20, wantErr: "corrupt ISO9660 directory"},
+		{name: "WIM XML offset", img: udf, off: udfWIMSector*sectorSize + wimXMLResource + 8, value: 0xFFFFFFFF, wantErr: "outside the file"},
+		{name: "WIM XML size", img: iso, off: 20*sectorSize + wimXMLResource, value: 0, wantErr: "unexpected WIM XML size"},
+		{name: "WIM magic", img: iso, off: 20 * sectorSize, value: 0, wantErr: "not a WIM file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := append([]byte(nil), tt.img...)
+			le.PutUint32(img[tt.off:], tt.value)
+			if _, err := readMediaMetadata(bytes.NewReader(img), "x.iso"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseWIMXML(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		wantErr string
+	}{
+		{name: "images", xml: `<WIM><IMAGE INDEX="1"><WINDOWS><VERSION><BUILD>26100</BUILD></VERSION></WINDOWS></IMAGE></WIM>`},
+		{name: "no images", xml: `<WIM></WIM>`, wantErr: "lists no images"},
+		{name: "bad index", xml: `<WIM><IMAGE INDEX="one"></IMAGE></WIM>`, wantErr: "invalid WIM image index"},
+		{name: "not XML", xml: `<WIM><IMAGE`, wantErr: "parse WIM XML"},
+		{name: "odd length", xml: "<", wantErr: "parse WIM XML"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte{0xFF, 0xFE}
+			for _, u := range utf16.Encode([]rune(tt.xml)) {
+				data = append(data, byte(u), byte(u>>8))
+			}
+			if tt.name == "odd length" {
+				data = data[:len(data)-1]
+			}
+			images, err := parseWIMXML(data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(images) != 1 || images[0].Index != 1 || images[0].Build != 26100 {
+				t.Errorf("images %+v, want index 1 build 26100", images)
+			}
+		})
+	}
+}
+
+// TestReadISOMetadataCache checks that only successful reads are reused
+func TestReadISOMetadataCache(t *testing.T) {
+	const path = "[ds1] iso/cache-test.iso"
+
+	// No vCenter client in tests, the read fails
+	for i := 0; i < 2; i++ {
+		if _, err := ReadISOMetadata(context.Background(), path); err == nil {
+			t.Fatal("read without a vCenter client succeeded")
+		}
+	}
+	isoMetadataMu.Lock()
+	_, cached := isoMetadataCache[path]
+	isoMetadataMu.Unlock()
+	if cached {
+		t.Fatal("failed read cached")
+	}
+
+	meta := &ISOMetadata{Path: path, Build: 20348}
+	isoMetadataMu.Lock()
+	isoMetadataCache[path] = isoMetadataEntry{meta: meta, read: time.Now()}
+	isoMetadataMu.Unlock()
+	t.Cleanup(func() {
+		isoMetadataMu.Lock()
+		delete(isoMetadataCache, path)
+		isoMetadataMu.Unlock()
+	})
+	if got, err := ReadISOMetadata(context.Background(), " "+path); err != nil || got != meta {
+		t.Errorf("metadata %v (%v), want the cached result", got, err)
+	}
+}
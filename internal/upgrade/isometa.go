@@ -0,0 +1,375 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ErrISONotFound is returned when the ISO file does not exist on the datastore
+var ErrISONotFound = errors.New("ISO file not found on datastore")
+
+const (
+	// isoChunkSize is the size of each ranged read from the datastore
+	isoChunkSize = 64 * 1024
+	// isoMaxChunks bounds the read cache of one ISO (16 MB)
+	isoMaxChunks = 256
+	// isoMetadataTTL is how long metadata read from an ISO is reused
+	isoMetadataTTL = 10 * time.Minute
+)
+
+// ISOImage is one installable image in install.wim
+type ISOImage struct {
+	Index            int
+	Name             string // e.g. "Windows Server 2022 SERVERSTANDARDCORE"
+	DisplayName      string
+	EditionID        string // e.g. "ServerStandard"
+	InstallationType string // "Server Core" or "Server" (Desktop Experience)
+	Version          string // e.g. "10.0.20348.587"
+	Build            int
+	Languages        []string
+	DefaultLanguage  string
+}
+
+// ISOMetadata describes the Windows media on an upgrade ISO, read from the
+// XML metadata of sources/install.wim without mounting the ISO
+type ISOMetadata struct {
+	Path      string
+	File      string // path of the image file on the ISO
+	Version   string
+	Build     int
+	Languages []string
+	Images    []ISOImage
+}
+
+// GuestEdition is the Windows edition and install language of a guest. An
+// in-place upgrade needs an image with the same edition, installation type
+// and language.
+type GuestEdition struct {
+	EditionID        string // e.g. "ServerStandard"
+	InstallationType string // "Server Core" or "Server"
+	Language         string // install language, e.g. "en-US"
+}
+
+func (g GuestEdition) String() string {
+	return fmt.Sprintf("%s %s (%s)", g.EditionID, g.InstallationType, g.Language)
+}
+
+// Summary describes the media on one line
+func (m *ISOMetadata) Summary() string {
+	return fmt.Sprintf("%s (build %d), %s, %d images", m.Version, m.Build, strings.Join(m.Languages, "/"), len(m.Images))
+}
+
+// Editions lists the images as "index: edition type"
+func (m *ISOMetadata) Editions() string {
+	var parts []string
+	for _, img := range m.Images {
+		parts = append(parts, fmt.Sprintf("%d: %s %s", img.Index, img.EditionID, img.InstallationType))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Image returns the image with the given index, or nil
+func (m *ISOMetadata) Image(index int) *ISOImage {
+	for i := range m.Images {
+		if m.Images[i].Index == index {
+			return &m.Images[i]
+		}
+	}
+	return nil
+}
+
+// HasLanguage reports whether the image contains the language
+func (img *ISOImage) HasLanguage(lang string) bool {
+	for _, l := range img.Languages {
+		if strings.EqualFold(l, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchImage returns the image an in-place upgrade of the guest can use. The
+// error says whether the edition or the language is missing from the ISO.
+func (m *ISOMetadata) MatchImage(g GuestEdition) (*ISOImage, error) {
+	editionFound := false
+	for i := range m.Images {
+		img := &m.Images[i]
+		if !strings.EqualFold(img.EditionID, g.EditionID) || !strings.EqualFold(img.InstallationType, g.InstallationType) {
+			continue
+		}
+		editionFound = true
+		if g.Language == "" || img.HasLanguage(g.Language) {
+			return img, nil
+		}
+	}
+	if !editionFound {
+		return nil, fmt.Errorf("ISO has no %s %s image (available: %s)", g.EditionID, g.InstallationType, m.Editions())
+	}
+	return nil, fmt.Errorf("ISO language %s does not match guest language %s", strings.Join(m.Languages, "/"), g.Language)
+}
+
+// VerifyISOMedia checks that the ISO can upgrade the guest in place: the
+// build is the profile's target, an image matches the guest's edition and
+// language, and it is the image the upgrade script will pick
+func VerifyISOMedia(meta *ISOMetadata, profile config.TargetProfile, editions map[string]config.EditionMapping, g GuestEdition) (*ISOImage, error) {
+	if profile.TargetBuild != 0 && meta.Build != profile.TargetBuild {
+		return nil, fmt.Errorf("ISO is build %d but profile %s targets build %d", meta.Build, profile.Name, profile.TargetBuild)
+	}
+	img, err := meta.MatchImage(g)
+	if err != nil {
+		return nil, err
+	}
+
+	// The script picks the index from the catalog by edition and type
+	if edition, _, index, ok := selectImage(g, editions); ok && index != 0 && index != img.Index {
+		other := "missing"
+		if o := meta.Image(index); o != nil {
+			other = o.EditionID + " " + o.InstallationType
+		}
+		return nil, fmt.Errorf("image index %d for %s %s is %s on this ISO, %s is index %d (set editions in the profile)", index, edition, g.InstallationType, other, g.EditionID, img.Index)
+	}
+	return img, nil
+}
+
+type isoMetadataEntry struct {
+	meta *ISOMetadata
+	read time.Time
+}
+
+var (
+	isoMetadataMu    sync.Mutex
+	isoMetadataCache = make(map[string]isoMetadataEntry)
+)
+
+// ReadISOMetadata reads the version, languages and images of an ISO on a
+// datastore. Only the file system structures and the install.wim header and
+// XML are downloaded, using HTTP range requests on the datastore /folder
+// endpoint. Results are reused for a few minutes since every VM of a run
+// checks the same ISO. Failures are not, the ISO may be uploaded or the
+// datastore back a moment later.
+func ReadISOMetadata(ctx context.Context, isoPath string) (*ISOMetadata, error) {
+	isoPath = strings.TrimSpace(isoPath)
+
+	isoMetadataMu.Lock()
+	e, ok := isoMetadataCache[isoPath]
+	isoMetadataMu.Unlock()
+	if ok && time.Since(e.read) < isoMetadataTTL {
+		return e.meta, nil
+	}
+
+	meta, err := readISOMetadata(ctx, isoPath)
+	if err != nil {
+		return nil, err
+	}
+	isoMetadataMu.Lock()
+	isoMetadataCache[isoPath] = isoMetadataEntry{meta: meta, read: time.Now()}
+	isoMetadataMu.Unlock()
+	return meta, nil
+}
+
+func readISOMetadata(ctx context.Context, isoPath string) (*ISOMetadata, error) {
+	debug.LogFunction("ReadISOMetadata", "ISOPath", isoPath)
+
+	c := vcenter.GetCachedClient()
+	if c == nil {
+		return nil, errors.New("no active govmomi client")
+	}
+	dsName, filePath, err := parseISOPath(isoPath)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := findDatastore(ctx, c, dsName)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &datastoreReader{ctx: ctx, c: c, u: ds.NewURL(filePath), chunks: make(map[int64][]byte)}
+	meta, err := readMediaMetadata(r, isoPath)
+	if err != nil {
+		debug.LogError("ReadISOMetadata", err, "ISOPath", isoPath)
+		return nil, err
+	}
+
+	debug.LogSuccess("ReadISOMetadata", "ISOPath", isoPath, "Media", meta.Summary(), "Requests", r.requests)
+	return meta, nil
+}
+
+// readMediaMetadata reads the metadata of the Windows media in the image r
+func readMediaMetadata(r io.ReaderAt, isoPath string) (*ISOMetadata, error) {
+	var f *isoFile
+	var file string
+	var err error
+	for _, name := range []string{"install.wim", "install.esd"} {
+		file = "sources/" + name
+		f, err = findISOFile(r, "sources", name)
+		if !errors.Is(err, errNotOnImage) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readWIMXML(r, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	images, err := parseWIMXML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	meta := &ISOMetadata{Path: isoPath, File: file, Images: images, Version: images[0].Version, Build: images[0].Build}
+	seen := make(map[string]bool)
+	for _, img := range images {
+		for _, l := range img.Languages {
+			if !seen[strings.ToLower(l)] {
+				seen[strings.ToLower(l)] = true
+				meta.Languages = append(meta.Languages, l)
+			}
+		}
+	}
+	return meta, nil
+}
+
+// findDatastore looks up a datastore by name and resolves the inventory path
+// that its /folder URL needs
+func findDatastore(ctx context.Context, c *vim25.Client, name string) (*object.Datastore, error) {
+	m := view.NewManager(c)
+	v, err := m.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"Datastore"}, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not create container view: %w", err)
+	}
+	defer v.Destroy(ctx)
+
+	var dss []mo.Datastore
+	if err := v.Retrieve(ctx, []string{"Datastore"}, []string{"summary.name"}, &dss); err != nil {
+		return nil, fmt.Errorf("could not fetch datastores: %w", err)
+	}
+
+	var ref *types.ManagedObjectReference
+	for _, d := range dss {
+		if strings.EqualFold(d.Summary.Name, name) {
+			ref = &d.Self
+			break
+		}
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("datastore '%s' not found", name)
+	}
+
+	ds := object.NewDatastore(c, *ref)
+	if err := ds.FindInventoryPath(ctx); err != nil {
+		return nil, fmt.Errorf("could not resolve datastore path: %w", err)
+	}
+	return ds, nil
+}
+
+// datastoreReader reads a datastore file in chunks with HTTP range requests
+type datastoreReader struct {
+	ctx      context.Context
+	c        *vim25.Client
+	u        *url.URL
+	chunks   map[int64][]byte
+	order    []int64
+	requests int
+}
+
+// ReadAt implements io.ReaderAt
+func (d *datastoreReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		start := pos - pos%isoChunkSize
+		chunk, err := d.chunk(start)
+		if err != nil {
+			return n, err
+		}
+		if pos-start >= int64(len(chunk)) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], chunk[pos-start:])
+	}
+	return n, nil
+}
+
+func (d *datastoreReader) chunk(start int64) ([]byte, error) {
+	if chunk, ok := d.chunks[start]; ok {
+		return chunk, nil
+	}
+
+	param := soap.DefaultDownload
+	param.Headers = map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", start, start+isoChunkSize-1)}
+	res, err := d.c.DownloadRequest(d.ctx, d.u, &param)
+	if err != nil {
+		return nil, fmt.Errorf("datastore read: %w", err)
+	}
+	defer res.Body.Close()
+	d.requests++
+
+	var chunk []byte
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		chunk, err = io.ReadAll(io.LimitReader(res.Body, isoChunkSize))
+		if err != nil {
+			return nil, fmt.Errorf("datastore read: %w", err)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Past the end of the file
+	case http.StatusNotFound:
+		return nil, ErrISONotFound
+	case http.StatusOK:
+		return nil, errors.New("datastore read: server ignored the range request")
+	default:
+		return nil, fmt.Errorf("datastore read: %s", res.Status)
+	}
+
+	if len(d.order) >= isoMaxChunks {
+		delete(d.chunks, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.chunks[start] = chunk
+	d.order = append(d.order, start)
+	return chunk, nil
+}
+
+// ReadGuestEdition reads the edition, installation type and install language
+// of the guest. It runs a read-only query through guest operations.
+func ReadGuestEdition(ctx context.Context, g GuestOps, serverName string) (GuestEdition, error) {
+	script := `$cv = Get-ItemProperty 'HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion'
+$lang = [System.Globalization.CultureInfo]::InstalledUICulture.Name
+$nls = Get-ItemProperty 'HKLM:\SYSTEM\CurrentControlSet\Control\Nls\Language' -ErrorAction SilentlyContinue
+if ($nls -and $nls.InstallLanguage) {
+    $lang = [System.Globalization.CultureInfo]::GetCultureInfo([Convert]::ToInt32($nls.InstallLanguage, 16)).Name
+}
+"$($cv.EditionID)|$($cv.InstallationType)|$lang"`
+
+	out, err := runGuestPowerShell(ctx, g, script, serverName, 2*time.Minute)
+	if err != nil {
+		return GuestEdition{}, err
+	}
+	parts := strings.Split(strings.TrimSpace(out), "|")
+	if len(parts) != 3 || parts[0] == "" {
+		return GuestEdition{}, fmt.Errorf("unexpected edition query output %q", strings.TrimSpace(out))
+	}
+	ed := GuestEdition{EditionID: parts[0], InstallationType: parts[1], Language: parts[2]}
+	debug.Log("[%s] Guest edition: %s", serverName, ed)
+	return ed, nil
+}
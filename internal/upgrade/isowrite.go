@@ -0,0 +1,155 @@
+package upgrade
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf16"
+)
+
+var be = binary.BigEndian
+
+// mediaImages are the images of a standard Microsoft Windows Server ISO in
+// install.wim order, see editionCatalog
+var mediaImages = []struct {
+	editionID, installationType, flag, display string
+}{
+	{"ServerStandard", "Server Core", "SERVERSTANDARDCORE", "Standard"},
+	{"ServerStandard", "Server", "SERVERSTANDARD", "Standard (Desktop Experience)"},
+	{"ServerDatacenter", "Server Core", "SERVERDATACENTERCORE", "Datacenter"},
+	{"ServerDatacenter", "Server", "SERVERDATACENTER", "Datacenter (Desktop Experience)"},
+}
+
+// BuildMediaISO returns a small ISO9660 image that ReadISOMetadata reads as
+// Windows Server media: sources/install.wim holds the WIM header and XML
+// metadata of the four standard images and no image data. It stands in for
+// real media in the simulator. languages defaults to en-US.
+func BuildMediaISO(productName string, build int, languages ...string) []byte {
+	if len(languages) == 0 {
+		languages = []string{"en-US"}
+	}
+	wim := buildWIM(productName, build, languages)
+
+	const (
+		rootSector    = 18
+		sourcesSector = 19
+		wimSector     = 20
+	)
+	sectors := wimSector + (len(wim)+sectorSize-1)/sectorSize
+	// Readers look for the UDF anchor at sector 256 first
+	if sectors <= 256 {
+		sectors = 257
+	}
+	img := make([]byte, sectors*sectorSize)
+
+	// Primary volume descriptor and terminator
+	pvd := img[16*sectorSize:]
+	pvd[0] = 1
+	copy(pvd[1:], "CD001")
+	pvd[6] = 1
+	copy(pvd[8:40], padRight("", 32))
+	copy(pvd[40:72], padRight(strings.ToUpper(strings.ReplaceAll(productName, " ", "_")), 32))
+	putBoth32(pvd[80:], uint32(sectors))
+	putBoth16(pvd[120:], 1)
+	putBoth16(pvd[124:], 1)
+	putBoth16(pvd[128:], sectorSize)
+	copy(pvd[156:190], isoDirRecord(rootSector, sectorSize, true, "\x00"))
+	pvd[881] = 1
+
+	term := img[17*sectorSize:]
+	term[0] = 255
+	copy(term[1:], "CD001")
+	term[6] = 1
+
+	// Root → SOURCES → INSTALL.WIM
+	writeISODir(img[rootSector*sectorSize:], rootSector, rootSector,
+		isoDirRecord(sourcesSector, sectorSize, true, "SOURCES"))
+	writeISODir(img[sourcesSector*sectorSize:], sourcesSector, rootSector,
+		isoDirRecord(wimSector, uint32(len(wim)), false, "INSTALL.WIM;1"))
+	copy(img[wimSector*sectorSize:], wim)
+	return img
+}
+
+// buildWIM returns a WIM header followed by the uncompressed XML metadata
+func buildWIM(productName string, build int, languages []string) []byte {
+	var x strings.Builder
+	x.WriteString("<WIM>")
+	for i, m := range mediaImages {
+		fmt.Fprintf(&x, `<IMAGE INDEX="%d"><NAME>%s %s</NAME><DISPLAYNAME>%s %s</DISPLAYNAME><WINDOWS>`,
+			i+1, html.EscapeString(productName), m.flag, html.EscapeString(productName), m.display)
+		fmt.Fprintf(&x, "<EDITIONID>%s</EDITIONID><INSTALLATIONTYPE>%s</INSTALLATIONTYPE><LANGUAGES>", m.editionID, m.installationType)
+		for _, l := range languages {
+			fmt.Fprintf(&x, "<LANGUAGE>%s</LANGUAGE>", html.EscapeString(l))
+		}
+		fmt.Fprintf(&x, "<DEFAULT>%s</DEFAULT></LANGUAGES>", html.EscapeString(languages[0]))
+		fmt.Fprintf(&x, "<VERSION><MAJOR>10</MAJOR><MINOR>0</MINOR><BUILD>%d</BUILD><SPBUILD>1</SPBUILD></VERSION></WINDOWS></IMAGE>", build)
+	}
+	x.WriteString("</WIM>")
+
+	var xml bytes.Buffer
+	xml.Write([]byte{0xFF, 0xFE})
+	for _, u := range utf16.Encode([]rune(x.String())) {
+		xml.Write([]byte{byte(u), byte(u >> 8)})
+	}
+
+	h := make([]byte, wimHeaderSize)
+	copy(h, wimMagic)
+	le.PutUint32(h[8:], wimHeaderSize)
+	le.PutUint32(h[12:], 0x10d00) // version
+	le.PutUint16(h[40:], 1)       // part number
+	le.PutUint16(h[42:], 1)       // total parts
+	le.PutUint32(h[44:], uint32(len(mediaImages)))
+	le.PutUint64(h[wimXMLResource:], uint64(xml.Len())) // size, flags 0
+	le.PutUint64(h[wimXMLResource+8:], wimHeaderSize)
+	le.PutUint64(h[wimXMLResource+16:], uint64(xml.Len()))
+	return append(h, xml.Bytes()...)
+}
+
+// writeISODir writes a one-sector directory with ".", ".." and entries
+func writeISODir(dst []byte, self, parent uint32, entries ...[]byte) {
+	off := copy(dst, isoDirRecord(self, sectorSize, true, "\x00"))
+	off += copy(dst[off:], isoDirRecord(parent, sectorSize, true, "\x01"))
+	for _, e := range entries {
+		off += copy(dst[off:], e)
+	}
+}
+
+// isoDirRecord returns an ISO9660 directory record
+func isoDirRecord(extent, size uint32, dir bool, name string) []byte {
+	n := 33 + len(name)
+	if n%2 == 1 {
+		n++
+	}
+	rec := make([]byte, n)
+	rec[0] = byte(n)
+	putBoth32(rec[2:], extent)
+	putBoth32(rec[10:], size)
+	copy(rec[18:25], []byte{125, 1, 1, 0, 0, 0, 0}) // 2025-01-01 00:00 UTC
+	if dir {
+		rec[25] = 0x02
+	}
+	putBoth16(rec[28:], 1)
+	rec[32] = byte(len(name))
+	copy(rec[33:], name)
+	return rec
+}
+
+// putBoth32 writes v in ISO9660 both-byte order
+func putBoth32(b []byte, v uint32) {
+	le.PutUint32(b, v)
+	be.PutUint32(b[4:], v)
+}
+
+func putBoth16(b []byte, v uint16) {
+	le.PutUint16(b, v)
+	be.PutUint16(b[2:], v)
+}
+
+func padRight(s string, n int) string {
+	if len(s) >= n {
+		return s[:n]
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
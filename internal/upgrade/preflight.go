@@ -0,0 +1,283 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Preflight check names, in report order
+const (
+	CheckPower       = "power"
+	CheckTools       = "tools"
+	CheckSourceOS    = "source-os"
+	CheckCredentials = "credentials"
+	CheckCDROM       = "cdrom"
+	CheckDisk        = "disk"
+	CheckDatastore   = "datastore"
+	CheckMedia       = "media"
+	CheckISO         = "iso"
+)
+
+// Preflight check outcomes
+const (
+	CheckPassed  = "pass"
+	CheckWarning = "warn"
+	CheckFailed  = "fail"
+)
+
+// PreflightCheck is the outcome of one read-only readiness check
+type PreflightCheck struct {
+	Name   string
+	Result string // "pass", "warn", "fail"
+	Detail string
+}
+
+// PreflightReport is the readiness of one VM for an upgrade
+type PreflightReport struct {
+	VMName string
+	Checks []PreflightCheck
+}
+
+// PreflightCheckNames returns the names of the preflight checks in the order
+// they appear in a report
+func PreflightCheckNames() []string {
+	return []string{CheckPower, CheckTools, CheckSourceOS, CheckCredentials, CheckCDROM, CheckDisk, CheckDatastore, CheckMedia, CheckISO}
+}
+
+// Add records the outcome of a check
+func (p *PreflightReport) Add(check PreflightCheck) {
+	p.Checks = append(p.Checks, check)
+}
+
+// Check returns the named check, or nil if it has not run
+func (p *PreflightReport) Check(name string) *PreflightCheck {
+	for i := range p.Checks {
+		if p.Checks[i].Name == name {
+			return &p.Checks[i]
+		}
+	}
+	return nil
+}
+
+// Ready reports whether no check failed. Warnings do not block an upgrade.
+func (p *PreflightReport) Ready() bool {
+	for _, c := range p.Checks {
+		if c.Result == CheckFailed {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PreflightReport) add(name, result, format string, args ...interface{}) {
+	p.Add(PreflightCheck{Name: name, Result: result, Detail: fmt.Sprintf(format, args...)})
+}
+
+// PreflightISO validates the ISO path and reads the media metadata once for
+// a whole run. Metadata that cannot be read only warns, a missing file or a
+// build other than the profile's target fails.
+func PreflightISO(ctx context.Context, isoPath string, profile config.TargetProfile) PreflightCheck {
+	if err := ValidateISOPath(ctx, isoPath); err != nil {
+		return PreflightCheck{Name: CheckISO, Result: CheckFailed, Detail: err.Error()}
+	}
+	meta, err := ReadISOMetadata(ctx, isoPath)
+	switch {
+	case errors.Is(err, ErrISONotFound):
+		return PreflightCheck{Name: CheckISO, Result: CheckFailed, Detail: fmt.Sprintf("%s: %v", isoPath, err)}
+	case err != nil:
+		return PreflightCheck{Name: CheckISO, Result: CheckWarning, Detail: fmt.Sprintf("could not read ISO metadata: %v", err)}
+	case profile.TargetBuild != 0 && meta.Build != profile.TargetBuild:
+		return PreflightCheck{Name: CheckISO, Result: CheckFailed, Detail: fmt.Sprintf("ISO is build %d, profile %s targets %d", meta.Build, profile.Name, profile.TargetBuild)}
+	}
+	return PreflightCheck{Name: CheckISO, Result: CheckPassed, Detail: meta.Summary()}
+}
+
+// PreflightVM runs the prechecks of an upgrade against a VM without changing
+// anything on it: no snapshot, no mount, only a read-only query of the
+// edition in the guest. The ISO check is not included, see PreflightISO.
+func PreflightVM(ctx context.Context, vm VM, opts UpgradeOptions) *PreflightReport {
+	debug.LogFunction("PreflightVM", "VM", opts.VMInfo.Name)
+
+	report := &PreflightReport{VMName: opts.VMInfo.Name}
+
+	var o mo.VirtualMachine
+	if err := vm.Properties(ctx, []string{"runtime.powerState", "guest", "config.hardware", "datastore"}, &o); err != nil {
+		debug.LogError("PreflightProperties", err, "VM", opts.VMInfo.Name)
+		report.add(CheckPower, CheckFailed, "could not read VM properties: %v", err)
+		return report
+	}
+
+	// Power state
+	if o.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		report.add(CheckPower, CheckPassed, "%s", o.Runtime.PowerState)
+	} else {
+		report.add(CheckPower, CheckFailed, "VM is not powered on (state: %s)", o.Runtime.PowerState)
+	}
+
+	// VMware Tools
+	toolsRunning := o.Guest != nil && o.Guest.ToolsRunningStatus == string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+	switch {
+	case !toolsRunning:
+		status := "unknown"
+		if o.Guest != nil {
+			status = o.Guest.ToolsRunningStatus
+		}
+		report.add(CheckTools, CheckFailed, "VMware Tools not running (%s)", status)
+	case o.Guest.ToolsVersionStatus2 == string(types.VirtualMachineToolsVersionStatusGuestToolsNeedUpgrade):
+		report.add(CheckTools, CheckWarning, "running, upgrade available (version %s)", o.Guest.ToolsVersion)
+	default:
+		report.add(CheckTools, CheckPassed, "running (version %s)", o.Guest.ToolsVersion)
+	}
+
+	// Source OS allowed by the target profile
+	guestOS := ""
+	if o.Guest != nil {
+		guestOS = o.Guest.GuestFullName
+	}
+	if err := VerifySourceOS(opts.Profile, guestOS); err != nil {
+		report.add(CheckSourceOS, CheckFailed, "%v", err)
+	} else {
+		report.add(CheckSourceOS, CheckPassed, "%s → %s", guestOS, opts.Profile.Name)
+	}
+
+	// Guest credentials, only possible with tools running
+	gc := guestCredentials(opts)
+	if !toolsRunning {
+		report.add(CheckCredentials, CheckFailed, "not checked, VMware Tools not running")
+	} else if err := vm.Guest(gc).ValidateCredentials(ctx); err != nil {
+		debug.LogError("PreflightCredentials", err, "VM", opts.VMInfo.Name, "Username", gc.User)
+		report.add(CheckCredentials, CheckFailed, "authentication failed for '%s': %v", gc.User, err)
+	} else {
+		report.add(CheckCredentials, CheckPassed, "%s", gc.User)
+	}
+	credentialsOK := report.Check(CheckCredentials).Result == CheckPassed
+
+	// CD/DVD device for the ISO
+	var cd *types.VirtualCdrom
+	if o.Config != nil {
+		cd = findCdrom(o.Config.Hardware.Device)
+	}
+	if cd == nil {
+		report.add(CheckCDROM, CheckFailed, "no CD/DVD device")
+	} else {
+		label := "CD/DVD"
+		if cd.DeviceInfo != nil {
+			label = cd.DeviceInfo.GetDescription().Label
+		}
+		report.add(CheckCDROM, CheckPassed, "%s", label)
+	}
+
+	// Free space on the system drive
+	required := opts.Config.Upgrade.PrecheckDiskGB
+	if sysDrive, err := GetSystemDrive(ctx, vm); err != nil {
+		report.add(CheckDisk, CheckFailed, "could not find system drive: %v", err)
+	} else if free, err := GetDiskFreeGB(ctx, vm, sysDrive); err != nil {
+		report.add(CheckDisk, CheckFailed, "%v", err)
+	} else if required > 0 && int(free) < required {
+		report.add(CheckDisk, CheckFailed, "%s %d GB free < required %d GB", sysDrive, free, required)
+	} else {
+		report.add(CheckDisk, CheckPassed, "%s %d GB free", sysDrive, free)
+	}
+
+	// Datastore space for the snapshot
+	report.Add(preflightDatastore(ctx, vm, o, opts))
+
+	// ISO edition and language against the guest
+	report.Add(preflightMedia(ctx, vm, gc, credentialsOK, opts))
+
+	debug.Log("Preflight %s: ready=%v", opts.VMInfo.Name, report.Ready())
+	return report
+}
+
+// preflightMedia checks that the ISO has an image matching the guest's
+// edition, installation type and language
+func preflightMedia(ctx context.Context, vm VM, gc vcenter.GuestCreds, credentialsOK bool, opts UpgradeOptions) PreflightCheck {
+	check := PreflightCheck{Name: CheckMedia}
+	meta, err := ReadISOMetadata(ctx, opts.ISOPath)
+	if err != nil {
+		// Reported by the ISO check
+		check.Result = CheckWarning
+		check.Detail = "ISO metadata not available"
+		return check
+	}
+	if !credentialsOK {
+		check.Result = CheckFailed
+		check.Detail = "not checked, no guest access"
+		return check
+	}
+
+	edition, err := ReadGuestEdition(ctx, vm.Guest(gc), opts.VMInfo.Name)
+	if err != nil {
+		debug.LogError("PreflightGuestEdition", err, "VM", opts.VMInfo.Name)
+		check.Result = CheckWarning
+		check.Detail = fmt.Sprintf("could not read guest edition: %v", err)
+		return check
+	}
+	img, err := VerifyISOMedia(meta, opts.Profile, ResolveEditions(opts.Profile, opts.Config.Upgrade.ProductKeys), edition)
+	if err != nil {
+		check.Result = CheckFailed
+		check.Detail = fmt.Sprintf("%s: %v", edition, err)
+		return check
+	}
+	check.Result = CheckPassed
+	check.Detail = fmt.Sprintf("%s → index %d", edition, img.Index)
+	return check
+}
+
+// preflightDatastore checks that the VM's datastores have room for the
+// snapshot: the memory file plus the delta disks growing while setup rewrites
+// the system drive (estimated as the disk precheck requirement)
+func preflightDatastore(ctx context.Context, vm VM, o mo.VirtualMachine, opts UpgradeOptions) PreflightCheck {
+	check := PreflightCheck{Name: CheckDatastore}
+	if !opts.CreateSnapshot {
+		check.Result = CheckPassed
+		check.Detail = "no snapshot"
+		return check
+	}
+	if len(o.Datastore) == 0 {
+		check.Result = CheckWarning
+		check.Detail = "no datastore information"
+		return check
+	}
+
+	dss, err := vm.Datastores(ctx, o.Datastore, []string{"summary"})
+	if err != nil {
+		check.Result = CheckWarning
+		check.Detail = fmt.Sprintf("could not read datastores: %v", err)
+		return check
+	}
+
+	requiredGB := int64(opts.Config.Upgrade.PrecheckDiskGB)
+	if !opts.Config.Defaults.SkipMemoryInSnapshot && o.Config != nil {
+		requiredGB += int64(o.Config.Hardware.MemoryMB) / 1024
+	}
+
+	// The tightest datastore decides
+	var worst mo.Datastore
+	for i, ds := range dss {
+		if i == 0 || ds.Summary.FreeSpace < worst.Summary.FreeSpace {
+			worst = ds
+		}
+	}
+	freeGB := worst.Summary.FreeSpace / (1024 * 1024 * 1024)
+	capacityGB := worst.Summary.Capacity / (1024 * 1024 * 1024)
+
+	check.Detail = fmt.Sprintf("%s %d GB free (need ~%d GB)", worst.Summary.Name, freeGB, requiredGB)
+	switch {
+	case freeGB < requiredGB:
+		check.Result = CheckFailed
+	case capacityGB > 0 && (freeGB-requiredGB)*10 < capacityGB:
+		// Less than 10% left after the snapshot grows risks stunning the VM
+		check.Result = CheckWarning
+	default:
+		check.Result = CheckPassed
+	}
+	return check
+}
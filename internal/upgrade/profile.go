@@ -0,0 +1,91 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// detailedDataKey is the extraConfig key where VMware Tools 11.2+ publishes
+// detailed guest OS data, including the build number
+const detailedDataKey = "guestInfo.detailed.data"
+
+var buildNumberPattern = regexp.MustCompile(`buildNumber='(\d+)'`)
+
+// VerifySourceOS verifies that a guest reporting guestOS may be upgraded with
+// the profile: it is one of the allowed source versions and not already
+// running the target
+func VerifySourceOS(profile config.TargetProfile, guestOS string) error {
+	if profile.Name == "" {
+		return fmt.Errorf("no target profile selected")
+	}
+	if guestOS == "" {
+		return fmt.Errorf("guest OS unknown (VMware Tools?)")
+	}
+
+	g := strings.ToLower(guestOS)
+	if profile.TargetOS != "" && strings.Contains(g, strings.ToLower(profile.TargetOS)) {
+		return fmt.Errorf("%s already runs the target of profile %s", guestOS, profile.Name)
+	}
+	if len(profile.AllowedSources) == 0 {
+		return nil
+	}
+	for _, src := range profile.AllowedSources {
+		if strings.Contains(g, strings.ToLower(src)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not an allowed source for profile %s (allowed: %s)", guestOS, profile.Name, strings.Join(profile.AllowedSources, ", "))
+}
+
+// guestBuild reads the guest OS build number published by VMware Tools. It
+// returns 0 if the guest or the tools version does not report it.
+func guestBuild(ctx context.Context, vm VM) (int, error) {
+	var o mo.VirtualMachine
+	if err := vm.Properties(ctx, []string{"config.extraConfig"}, &o); err != nil {
+		return 0, err
+	}
+	return buildOf(o), nil
+}
+
+// buildOf returns the guest OS build number in the extraConfig of o, 0 if it
+// is not there
+func buildOf(o mo.VirtualMachine) int {
+	if o.Config == nil {
+		return 0
+	}
+	for _, opt := range o.Config.ExtraConfig {
+		v := opt.GetOptionValue()
+		if v == nil || !strings.EqualFold(v.Key, detailedDataKey) {
+			continue
+		}
+		data, _ := v.Value.(string)
+		if m := buildNumberPattern.FindStringSubmatch(data); m != nil {
+			build, _ := strconv.Atoi(m[1])
+			return build
+		}
+	}
+	return 0
+}
+
+// psQuote returns s as a single-quoted PowerShell string literal
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// guestOSName reads guestFullName, used when the inventory value is stale
+func guestOSName(ctx context.Context, vm VM) (string, error) {
+	var o mo.VirtualMachine
+	if err := vm.Properties(ctx, []string{"guest.guestFullName"}, &o); err != nil {
+		return "", err
+	}
+	if o.Guest == nil {
+		return "", nil
+	}
+	return o.Guest.GuestFullName, nil
+}
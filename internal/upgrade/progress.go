@@ -0,0 +1,157 @@
+package upgrade
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// Windows Setup phases. Downlevel runs in the old OS and is the only phase
+// the guest can be queried in, the later ones are told apart by reboots.
+const (
+	PhaseDownlevel  = "downlevel"
+	PhaseSafeOS     = "safe-os"
+	PhaseFirstBoot  = "first-boot"
+	PhaseSecondBoot = "second-boot"
+)
+
+const (
+	progressInterval     = time.Minute
+	progressProbeTimeout = 45 * time.Second
+	bootPollInterval     = 30 * time.Second
+)
+
+// SetupProgress is where Windows Setup is in a running upgrade
+type SetupProgress struct {
+	Phase   string
+	Percent int    // setup's own percentage, -1 if not known
+	Detail  string // setup sub-phase or the last line of upgrade.log
+	Updated time.Time
+}
+
+// progressScript reads setup's progress without touching it: the MoSetup
+// percentage, the last phase marker in setupact.log and the last line of
+// the upgrade script's log
+const progressScript = `$v = Get-ItemProperty -Path 'HKLM:\SYSTEM\Setup\MoSetup\Volatile' -ErrorAction SilentlyContinue
+if ($v -and $null -ne $v.SetupProgress) { 'percent=' + $v.SetupProgress }
+$act = 'C:\$WINDOWS.~BT\Sources\Panther\setupact.log'
+if (Test-Path -LiteralPath $act) {
+    $m = Get-Content -LiteralPath $act -Tail 2000 -ErrorAction SilentlyContinue | Select-String -Pattern 'phase' | Select-Object -Last 1
+    if ($m) { 'phase=' + $m.Line }
+}
+$log = 'C:\Temp\upgrade.log'
+if (Test-Path -LiteralPath $log) { 'log=' + (Get-Content -LiteralPath $log -Tail 1 -ErrorAction SilentlyContinue) }`
+
+// setupPhasePattern finds the downlevel sub-phase in a setupact.log line
+var setupPhasePattern = regexp.MustCompile(`(?i)\b(PreDownload|Download|PreInstall|Install|Finalize|Rollback)\b`)
+
+// parseProgress turns the output of progressScript into a progress report
+// and the last line of upgrade.log
+func parseProgress(out string) (SetupProgress, string) {
+	p := SetupProgress{Phase: PhaseDownlevel, Percent: -1, Updated: time.Now()}
+	var logLine string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "percent="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(line, "percent=")); err == nil && n >= 0 && n <= 100 {
+				p.Percent = n
+			}
+		case strings.HasPrefix(line, "phase="):
+			if m := setupPhasePattern.FindStringSubmatch(line); m != nil {
+				p.Detail = m[1]
+			}
+		case strings.HasPrefix(line, "log="):
+			logLine = strings.TrimPrefix(line, "log=")
+		}
+	}
+	if p.Detail == "" {
+		p.Detail = logLine
+	}
+	return p, logLine
+}
+
+// setProgress records the setup progress and reports it
+func (r *upgradeRun) setProgress(p SetupProgress) {
+	r.result.Progress = p
+	r.notify()
+}
+
+// watch calls poll every interval in the background until stop is called.
+// stop waits for a running poll to finish; the step must not touch the
+// result before that.
+func (r *upgradeRun) watch(interval time.Duration, poll func(ctx context.Context)) (stop func()) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(r.scaled(interval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				poll(ctx)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// watchDownlevel reports setup's progress while the upgrade script runs
+func (r *upgradeRun) watchDownlevel() (stop func()) {
+	r.setProgress(SetupProgress{Phase: PhaseDownlevel, Percent: -1, Updated: time.Now()})
+	var lastLine string
+	return r.watch(progressInterval, func(ctx context.Context) {
+		out, err := runGuestPowerShell(ctx, r.guest, progressScript, r.opts.VMInfo.Name, progressProbeTimeout)
+		if err != nil {
+			// Progress is informational, the wait goes on regardless
+			r.logf("Setup progress not available: %v", err)
+			return
+		}
+		p, line := parseProgress(out)
+		if line != "" && line != lastLine {
+			r.guestOutput(line)
+			lastLine = line
+		}
+		if p.Percent >= 0 {
+			r.metric("setup_percent", float64(p.Percent), "%")
+		}
+		r.setProgress(p)
+	})
+}
+
+// watchBootPhases follows setup through the reboots after the power-on:
+// the first boot is Safe OS, then first boot and second boot of the new OS.
+// Each reboot changes the VM's boot time.
+func (r *upgradeRun) watchBootPhases() (stop func()) {
+	phases := []string{PhaseSafeOS, PhaseFirstBoot, PhaseSecondBoot}
+	var lastBoot time.Time
+	reboots := 0
+	return r.watch(bootPollInterval, func(ctx context.Context) {
+		var o mo.VirtualMachine
+		if err := r.vm.Properties(ctx, []string{"runtime.bootTime"}, &o); err != nil || o.Runtime.BootTime == nil {
+			return
+		}
+		boot := *o.Runtime.BootTime
+		if !lastBoot.IsZero() && !boot.Equal(lastBoot) && reboots < len(phases)-1 {
+			reboots++
+			r.logf("Guest rebooted, setup phase %s", phases[reboots])
+		}
+		if boot.Equal(lastBoot) {
+			return
+		}
+		lastBoot = boot
+		r.setProgress(SetupProgress{Phase: phases[reboots], Percent: -1, Updated: time.Now()})
+	})
+}
@@ -0,0 +1,148 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Failure classes that can trigger an automatic rollback
+const (
+	FailureSetup   = "setup"    // the upgrade script or setup.exe failed
+	FailureOSCheck = "os-check" // the target OS was not reported in time
+	FailureBoot    = "boot"     // the VM did not come back after the reboot
+)
+
+// RollbackResult records an automatic revert to the pre-upgrade snapshot
+type RollbackResult struct {
+	FailureClass string
+	SnapshotName string
+	Reverted     bool // the snapshot was restored and the VM powered on
+	Verified     bool // the original OS was reported again
+	Error        error
+	StartTime    time.Time
+	EndTime      time.Time
+}
+
+// FailureClassOf returns the rollback failure class of a failed step, or ""
+// if a failure in that step never triggers a rollback
+func FailureClassOf(step string) string {
+	switch step {
+	case StepSetup, StepWaitExit:
+		return FailureSetup
+	case StepVerifyOS:
+		return FailureOSCheck
+	case StepPowerCycle, StepSignalWait:
+		return FailureBoot
+	}
+	return ""
+}
+
+// shouldRollback reports whether the rollback policy covers a failure in
+// the given step. Only a snapshot taken by this run is ever used.
+func (r *upgradeRun) shouldRollback(step string) bool {
+	policy := r.opts.Config.Upgrade.Rollback
+	if !policy.Enabled || r.parent.Err() != nil {
+		return false
+	}
+	if s := r.result.Step(StepSnapshot); s == nil || s.Status != StatusCompleted || r.result.SnapshotName == "" {
+		r.logf("Rollback not possible, no snapshot was taken by this run")
+		return false
+	}
+
+	class := FailureClassOf(step)
+	if class == "" {
+		return false
+	}
+	if len(policy.On) == 0 {
+		return true
+	}
+	for _, c := range policy.On {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// rollback reverts the VM to the snapshot taken by this run, powers it on
+// and waits for the original OS to be reported again. The outcome is
+// recorded in result.Rollback; the upgrade error itself is left unchanged.
+func (r *upgradeRun) rollback(failedStep string) {
+	opts := r.opts
+	rb := &RollbackResult{
+		FailureClass: FailureClassOf(failedStep),
+		SnapshotName: r.result.SnapshotName,
+		StartTime:    time.Now(),
+	}
+	r.result.Rollback = rb
+	r.notify()
+
+	r.logf("Rolling back to snapshot %s after %s failure in %s", rb.SnapshotName, rb.FailureClass, failedStep)
+
+	// The run timeout may be what failed, the rollback gets its own
+	verifyTimeout := time.Duration(opts.Config.Timeouts.TargetOSMinutes) * time.Minute
+	ctx, cancel := context.WithTimeout(r.parent, r.scaled(verifyTimeout+15*time.Minute))
+	defer cancel()
+
+	err := r.revertAndPowerOn(ctx)
+	if err == nil {
+		rb.Reverted = true
+		r.notify()
+		err = r.verifyOriginalOS(ctx, verifyTimeout)
+	}
+	if err == nil {
+		rb.Verified = true
+		debug.LogSuccess("Rollback", "VM", opts.VMInfo.Name, "Snapshot", rb.SnapshotName)
+	} else {
+		rb.Error = err
+		debug.LogError("Rollback", err, "VM", opts.VMInfo.Name, "Snapshot", rb.SnapshotName)
+	}
+	rb.EndTime = time.Now()
+	r.notify()
+}
+
+// revertAndPowerOn restores the pre-upgrade snapshot and makes sure the VM
+// is running afterwards
+func (r *upgradeRun) revertAndPowerOn(ctx context.Context) error {
+	if err := r.vm.RevertToSnapshot(ctx, r.result.SnapshotName); err != nil {
+		return fmt.Errorf("revert: %w", err)
+	}
+	debug.LogSuccess("RevertToSnapshot", "VM", r.opts.VMInfo.Name, "Snapshot", r.result.SnapshotName)
+
+	// A snapshot with memory comes back running, one without is powered off
+	var o mo.VirtualMachine
+	if err := r.vm.Properties(ctx, []string{"runtime.powerState"}, &o); err != nil {
+		return fmt.Errorf("power state: %w", err)
+	}
+	if o.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		return nil
+	}
+	if err := r.vm.PowerOn(ctx); err != nil {
+		return fmt.Errorf("power on: %w", err)
+	}
+	debug.LogSuccess("PowerOn", "VM", r.opts.VMInfo.Name)
+	return nil
+}
+
+// verifyOriginalOS waits for the guest to report the OS it had before the
+// upgrade
+func (r *upgradeRun) verifyOriginalOS(ctx context.Context, timeout time.Duration) error {
+	original := r.result.OriginalOS
+	if original == "" && r.opts.VMInfo.OS != "Unknown" {
+		// Result recorded before the OS was captured, fall back to inventory
+		original = r.opts.VMInfo.OS
+	}
+	if original == "" {
+		return errors.New("original OS unknown, could not verify rollback")
+	}
+	if err := waitForTargetOS(ctx, r.vm, []string{original}, 0, r.opts.VMInfo.Name, timeout); err != nil {
+		return fmt.Errorf("original OS %q not reported after revert: %w", original, err)
+	}
+	return nil
+}
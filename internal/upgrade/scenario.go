@@ -0,0 +1,185 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+)
+
+// defaultTimeCompression makes a simulated upgrade of about an hour take a
+// minute
+const defaultTimeCompression = 60
+
+// Scenario describes how the simulated guests of the vCenter simulator
+// behave during an upgrade: how long each phase takes and which VMs fail
+// where. It is read from a JSON file, e.g.
+//
+//	{
+//	  "time_compression": 60,
+//	  "defaults": {"setup_minutes": 40},
+//	  "vms": {
+//	    "srv003": {"bad_credentials": true},
+//	    "srv005": {"free_gb": 4},
+//	    "srv007": {"setup_exit_code": "0xC1900208"},
+//	    "srv009": {"never_boots": true},
+//	    "srv011": {"logonui_timeout": true},
+//	    "srv013": {"health_failures": ["services", "gateway"]},
+//	    "srv015": {"hook_failures": ["stop-app"]}
+//	  }
+//	}
+type Scenario struct {
+	// TimeCompression is how many times faster than real time the guests
+	// and the upgrade flow against them run, see FakeVM.TimeCompression
+	TimeCompression int `json:"time_compression,omitempty"`
+
+	// Defaults applies to every VM, VMs (keyed by VM name) override it
+	// field by field
+	Defaults GuestScenario            `json:"defaults"`
+	VMs      map[string]GuestScenario `json:"vms,omitempty"`
+}
+
+// GuestScenario is the behaviour of one simulated guest. Durations are in
+// guest time, before compression; zero keeps the default.
+type GuestScenario struct {
+	SetupMinutes  float64 `json:"setup_minutes,omitempty"`  // setup.exe in the downlevel phase
+	BootMinutes   float64 `json:"boot_minutes,omitempty"`   // power-on until VMware Tools run
+	SignalMinutes float64 `json:"signal_minutes,omitempty"` // VMware Tools until the signal file
+
+	FreeGB         int64  `json:"free_gb,omitempty"`         // free space on C:, low values fail the disk precheck
+	BadCredentials bool   `json:"bad_credentials,omitempty"` // the guest rejects all credentials
+	SetupExitCode  string `json:"setup_exit_code,omitempty"` // setup's result, e.g. "0xC1900208"
+	NoShutdown     bool   `json:"no_shutdown,omitempty"`     // the guest stays on after setup, forcing a power off
+	NeverBoots     bool   `json:"never_boots,omitempty"`     // VMware Tools never start after the power cycle
+	LogonUITimeout bool   `json:"logonui_timeout,omitempty"` // the signal file is never written
+
+	// HealthFailures are the post-upgrade health checks that fail, e.g.
+	// ["services", "gateway"]
+	HealthFailures []string `json:"health_failures,omitempty"`
+
+	// HookFailures are the guest hooks (by name) that exit 1
+	HookFailures []string `json:"hook_failures,omitempty"`
+}
+
+// DefaultScenario is used when no scenario file is given: every guest
+// upgrades successfully
+func DefaultScenario() *Scenario {
+	return &Scenario{
+		TimeCompression: defaultTimeCompression,
+		Defaults:        GuestScenario{SetupMinutes: 40, BootMinutes: 4, SignalMinutes: 2},
+	}
+}
+
+// LoadScenario reads a scenario file. Fields it leaves out keep the values
+// of DefaultScenario.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read scenario: %w", err)
+	}
+	var file Scenario
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse scenario %s: %w", path, err)
+	}
+
+	s := DefaultScenario()
+	if file.TimeCompression != 0 {
+		s.TimeCompression = file.TimeCompression
+	}
+	s.Defaults = s.Defaults.merge(file.Defaults)
+	s.VMs = make(map[string]GuestScenario, len(file.VMs))
+	for name, g := range file.VMs {
+		if _, err := g.exitCode(); err != nil {
+			return nil, fmt.Errorf("scenario for %s: %w", name, err)
+		}
+		s.VMs[strings.ToLower(name)] = g
+	}
+	if _, err := s.Defaults.exitCode(); err != nil {
+		return nil, fmt.Errorf("scenario defaults: %w", err)
+	}
+	return s, nil
+}
+
+// Guest returns the scenario of a VM
+func (s *Scenario) Guest(vmName string) GuestScenario {
+	return s.Defaults.merge(s.VMs[strings.ToLower(vmName)])
+}
+
+// NewVM returns the simulated guest of host for an upgrade to profile, with
+// the VM's scenario applied
+func (s *Scenario) NewVM(ctx context.Context, host VM, vmName string, profile config.TargetProfile) (*FakeVM, error) {
+	f, err := NewHostedFakeVM(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	f.TargetOS = "Microsoft " + profile.TargetOS + " (64-bit)"
+	f.TargetBuild = profile.TargetBuild
+	f.TimeCompression = s.TimeCompression
+
+	g := s.Guest(vmName)
+	f.SetupDuration = minutes(g.SetupMinutes)
+	f.BootDuration = minutes(g.BootMinutes)
+	f.SignalDelay = minutes(g.SignalMinutes)
+	if g.FreeGB != 0 {
+		f.FreeGB = g.FreeGB
+	}
+	f.RejectCredentials = g.BadCredentials
+	f.SetupExitCode, _ = g.exitCode()
+	f.NoShutdown = g.NoShutdown
+	f.NeverBoots = g.NeverBoots
+	f.NoSignal = g.LogonUITimeout
+	f.FailingHealth = g.HealthFailures
+	f.FailingHooks = g.HookFailures
+	return f, nil
+}
+
+// merge returns g with the non-zero fields of o
+func (g GuestScenario) merge(o GuestScenario) GuestScenario {
+	if o.SetupMinutes != 0 {
+		g.SetupMinutes = o.SetupMinutes
+	}
+	if o.BootMinutes != 0 {
+		g.BootMinutes = o.BootMinutes
+	}
+	if o.SignalMinutes != 0 {
+		g.SignalMinutes = o.SignalMinutes
+	}
+	if o.FreeGB != 0 {
+		g.FreeGB = o.FreeGB
+	}
+	if o.SetupExitCode != "" {
+		g.SetupExitCode = o.SetupExitCode
+	}
+	g.BadCredentials = g.BadCredentials || o.BadCredentials
+	g.NoShutdown = g.NoShutdown || o.NoShutdown
+	g.NeverBoots = g.NeverBoots || o.NeverBoots
+	g.LogonUITimeout = g.LogonUITimeout || o.LogonUITimeout
+	if o.HealthFailures != nil {
+		g.HealthFailures = o.HealthFailures
+	}
+	if o.HookFailures != nil {
+		g.HookFailures = o.HookFailures
+	}
+	return g
+}
+
+// exitCode parses SetupExitCode, hex with 0x or decimal
+func (g GuestScenario) exitCode() (uint32, error) {
+	if g.SetupExitCode == "" {
+		return 0, nil
+	}
+	code, err := strconv.ParseUint(strings.TrimSpace(g.SetupExitCode), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid setup_exit_code %q", g.SetupExitCode)
+	}
+	return uint32(code), nil
+}
+
+func minutes(m float64) time.Duration {
+	return time.Duration(m * float64(time.Minute))
+}
@@ -0,0 +1,256 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+)
+
+func TestLoadScenario(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+		check   func(t *testing.T, s *Scenario)
+	}{
+		{
+			name: "defaults",
+			file: `{}`,
+			check: func(t *testing.T, s *Scenario) {
+				if s.TimeCompression != defaultTimeCompression {
+					t.Errorf("time compression %d, want %d", s.TimeCompression, defaultTimeCompression)
+				}
+				if g := s.Guest("srv01"); !reflect.DeepEqual(g, DefaultScenario().Defaults) {
+					t.Errorf("guest %+v, want the defaults", g)
+				}
+			},
+		},
+		{
+			name: "per VM overrides",
+			file: `{"time_compression": 10, "defaults": {"setup_minutes": 5},
+				"vms": {"SRV07": {"setup_exit_code": "0xC1900208", "boot_minutes": 1}}}`,
+			check: func(t *testing.T, s *Scenario) {
+				if s.TimeCompression != 10 {
+					t.Errorf("time compression %d, want 10", s.TimeCompression)
+				}
+				g := s.Guest("srv07")
+				if g.SetupMinutes != 5 || g.BootMinutes != 1 || g.SignalMinutes != DefaultScenario().Defaults.SignalMinutes {
+					t.Errorf("durations %+v, want setup 5, boot 1 and the default signal", g)
+				}
+				if code, _ := g.exitCode(); code != 0xC1900208 {
+					t.Errorf("exit code %#x, want 0xC1900208", code)
+				}
+				if g := s.Guest("srv08"); g.SetupExitCode != "" || g.SetupMinutes != 5 {
+					t.Errorf("other VM %+v, want only the defaults", g)
+				}
+			},
+		},
+		{
+			name:    "invalid exit code",
+			file:    `{"vms": {"srv01": {"setup_exit_code": "oops"}}}`,
+			wantErr: "invalid setup_exit_code",
+		},
+		{
+			name:    "invalid default exit code",
+			file:    `{"defaults": {"setup_exit_code": "0x1FFFFFFFF"}}`,
+			wantErr: "invalid setup_exit_code",
+		},
+		{
+			name:    "not JSON",
+			file:    `vms: srv01`,
+			wantErr: "could not parse scenario",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenario.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s, err := LoadScenario(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, s)
+		})
+	}
+}
+
+// TestScenarioUpgrade runs an upgrade of a simulated guest for every kind of
+// failure a scenario can inject
+func TestScenarioUpgrade(t *testing.T) {
+	hookScript := filepath.Join(t.TempDir(), "stop-app.ps1")
+	if err := os.WriteFile(hookScript, []byte("Stop-Service App\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		guest   GuestScenario
+		prepare func(f *FakeVM, opts *UpgradeOptions)
+		failed  string // step expected to fail, "" for success
+		wantErr string // in the error of the failed step
+		check   func(t *testing.T, f *FakeVM, res *UpgradeResult)
+	}{
+		{
+			name: "default guest",
+		},
+		{
+			name:    "bad credentials",
+			guest:   GuestScenario{BadCredentials: true},
+			failed:  StepUpload,
+			wantErr: "failed to authenticate",
+		},
+		{
+			name:    "low disk space",
+			guest:   GuestScenario{FreeGB: 4},
+			failed:  StepPrecheck,
+			wantErr: "disk: 4 GB",
+		},
+		{
+			name:    "setup compatibility block",
+			guest:   GuestScenario{SetupExitCode: "0xC1900208"},
+			failed:  StepWaitExit,
+			wantErr: "0xC1900208",
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if se := res.SetupError; se == nil || se.Category != CategoryCompatibility {
+					t.Errorf("setup error %+v, want category %s", se, CategoryCompatibility)
+				}
+			},
+		},
+		{
+			name:    "setup out of disk space",
+			guest:   GuestScenario{SetupExitCode: "0x80070070"},
+			failed:  StepWaitExit,
+			wantErr: "0x80070070",
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if se := res.SetupError; se == nil || se.Category != CategoryDiskSpace {
+					t.Errorf("setup error %+v, want category %s", se, CategoryDiskSpace)
+				}
+				if res.GuestLogs == "" {
+					t.Errorf("setup logs not collected")
+				}
+			},
+		},
+		{
+			name:  "hung setup",
+			guest: GuestScenario{SetupMinutes: 600},
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				opts.Config.Upgrade.TimeoutMinutes = 30
+			},
+			failed:  StepWaitExit,
+			wantErr: "deadline exceeded",
+		},
+		{
+			name:  "no shutdown",
+			guest: GuestScenario{NoShutdown: true},
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if w := res.Step(StepPowerCycle).Warning; !strings.Contains(w, "forced power off") {
+					t.Errorf("power-cycle warning %q, want a forced power off", w)
+				}
+			},
+		},
+		{
+			name:  "failed power off",
+			guest: GuestScenario{NoShutdown: true},
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				f.Errors = map[string]error{"PowerOff": errors.New("host not responding")}
+			},
+			failed:  StepPowerCycle,
+			wantErr: "power off: PowerOff: host not responding",
+		},
+		{
+			name:    "never boots",
+			guest:   GuestScenario{NeverBoots: true},
+			failed:  StepVerifyOS,
+			wantErr: "timeout while waiting for OS version",
+		},
+		{
+			name:  "logonui timeout",
+			guest: GuestScenario{LogonUITimeout: true},
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if w := res.Step(StepSignalWait).Warning; !strings.Contains(w, "checked manually") {
+					t.Errorf("signal-wait warning %q, want a manual check", w)
+				}
+			},
+		},
+		{
+			name:  "health failures",
+			guest: GuestScenario{HealthFailures: []string{HealthServices, HealthGateway}},
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				opts.Config.Upgrade.Health = config.HealthConfig{Enabled: true, Services: []string{"Netlogon"}, Gateway: true, DNS: true}
+			},
+			failed:  StepHealth,
+			wantErr: "2 of 3 checks failed",
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if got := guestOS(f); got != f.TargetOS {
+					t.Errorf("guest OS %q, the upgrade is kept after failed health checks", got)
+				}
+			},
+		},
+		{
+			name:  "hook failure",
+			guest: GuestScenario{HookFailures: []string{"stop-app"}},
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				opts.Config.Hooks = []config.HookConfig{{Name: "stop-app", When: HookPre, GuestScript: hookScript}}
+			},
+			failed:  StepPreHooks,
+			wantErr: "exited with code 1",
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if n := setupRuns(f); n != 0 {
+					t.Errorf("setup.exe started after a failed pre-hook")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &Scenario{
+				TimeCompression: testTimeCompression,
+				Defaults:        GuestScenario{SetupMinutes: 1, BootMinutes: 0.5, SignalMinutes: 0.5},
+				VMs:             map[string]GuestScenario{"srv01": tt.guest},
+			}
+			opts := testOptions("srv01")
+			f, err := s.NewVM(context.Background(), NewFakeVM(), "srv01", opts.Profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(f, &opts)
+			}
+
+			res, err := UpgradeSingleVM(f, opts)
+			if got := res.FailedStep(); got != tt.failed {
+				t.Fatalf("failed step %q, want %q (error: %v)", got, tt.failed, err)
+			}
+			if tt.failed == "" {
+				if err != nil || !res.Success {
+					t.Fatalf("upgrade failed: %v", err)
+				}
+				if got := guestOS(f); got != f.TargetOS {
+					t.Errorf("guest OS %q, want %q", got, f.TargetOS)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, f, res)
+			}
+		})
+	}
+}
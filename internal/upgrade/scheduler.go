@@ -0,0 +1,190 @@
+package upgrade
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+)
+
+// Scheduler hands out the VMs of a run to the upgrade workers in order, but
+// only VMs that fit within the limits per ESXi host, datastore and cluster.
+// A VM that does not fit waits for an upgrade on the same host, datastore or
+// cluster to finish, and queued VMs behind it that fit go first. VMs without
+// placement information are only limited by what is known about them.
+type Scheduler struct {
+	cfg config.UpgradeConfig
+
+	mu       sync.Mutex
+	queue    []vcenter.VMInfo
+	running  map[resource]int // upgrades running on each resource
+	reported map[string]bool  // VMs already passed to Waiting
+	closed   bool
+	changed  chan struct{} // closed and replaced when a slot frees or VMs are added
+
+	// Waiting is called the first time a queued VM has to wait, with the
+	// resource that is full, e.g. "host esx01"
+	Waiting func(vm vcenter.VMInfo, resource string)
+}
+
+// NewScheduler returns a scheduler with the limits of cfg
+func NewScheduler(cfg config.UpgradeConfig) *Scheduler {
+	return &Scheduler{
+		cfg:      cfg,
+		running:  make(map[resource]int),
+		reported: make(map[string]bool),
+		changed:  make(chan struct{}),
+	}
+}
+
+// Limited reports whether any limit per host, datastore or cluster is set
+func (s *Scheduler) Limited() bool {
+	return s.cfg.MaxPerHost > 0 || s.cfg.MaxPerDatastore > 0 || s.cfg.MaxPerCluster > 0
+}
+
+// Add queues VMs after the ones already queued
+func (s *Scheduler) Add(vms ...vcenter.VMInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, vms...)
+	s.signal()
+}
+
+// Close tells the workers that no more VMs will be added. Next returns false
+// once the queue is empty.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.signal()
+}
+
+// Next waits for a queued VM that fits within every limit and returns it
+// with the function that frees its slots when the upgrade is done. VMs
+// for which skip returns true, e.g. cancelled VMs that will not be touched,
+// are returned at once without taking a slot. ok is false when the
+// scheduler is closed and empty.
+func (s *Scheduler) Next(skip func(vcenter.VMInfo) bool) (vm vcenter.VMInfo, release func(), ok bool) {
+	for {
+		type wait struct {
+			vm       vcenter.VMInfo
+			resource string
+		}
+		var waits []wait
+		// report passes the VMs that have to wait to Waiting, also when a
+		// VM behind them goes first. The caller does not hold s.mu.
+		report := func() {
+			if s.Waiting != nil {
+				for _, w := range waits {
+					s.Waiting(w.vm, w.resource)
+				}
+			}
+		}
+
+		s.mu.Lock()
+		for i, queued := range s.queue {
+			if skip != nil && skip(queued) {
+				s.queue = append(s.queue[:i:i], s.queue[i+1:]...)
+				s.mu.Unlock()
+				report()
+				return queued, func() {}, true
+			}
+			if full := s.full(queued); full != "" {
+				if !s.reported[queued.Name] {
+					s.reported[queued.Name] = true
+					waits = append(waits, wait{queued, full})
+				}
+				continue
+			}
+			s.queue = append(s.queue[:i:i], s.queue[i+1:]...)
+			resources := s.resources(queued)
+			for _, r := range resources {
+				s.running[r]++
+			}
+			s.mu.Unlock()
+			report()
+			return queued, s.releaseFunc(resources), true
+		}
+		if s.closed && len(s.queue) == 0 {
+			s.mu.Unlock()
+			return vcenter.VMInfo{}, nil, false
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		report()
+		// skip can change without a signal, e.g. when a VM is cancelled
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// releaseFunc returns the function that frees the slots of one upgrade
+func (s *Scheduler) releaseFunc(resources []resource) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, r := range resources {
+				s.running[r]--
+			}
+			s.signal()
+		})
+	}
+}
+
+// resource is a host, cluster or datastore with a limit on upgrades
+type resource struct{ kind, name string }
+
+func (r resource) String() string {
+	return r.kind + " " + r.name
+}
+
+// resources returns the limited resources an upgrade of vm takes a slot on
+func (s *Scheduler) resources(vm vcenter.VMInfo) []resource {
+	var out []resource
+	if s.cfg.MaxPerHost > 0 && vm.Host != "" {
+		out = append(out, resource{"host", vm.Host})
+	}
+	if s.cfg.MaxPerCluster > 0 && vm.Cluster != "" {
+		out = append(out, resource{"cluster", vm.Cluster})
+	}
+	if s.cfg.MaxPerDatastore > 0 {
+		for _, ds := range vm.Datastores {
+			out = append(out, resource{"datastore", ds})
+		}
+	}
+	return out
+}
+
+// full returns the first resource of vm that is at its limit, or ""
+func (s *Scheduler) full(vm vcenter.VMInfo) string {
+	for _, r := range s.resources(vm) {
+		if s.running[r] >= s.limit(r) {
+			return fmt.Sprintf("%s (%d running)", r, s.running[r])
+		}
+	}
+	return ""
+}
+
+// limit returns the limit for a kind of resource
+func (s *Scheduler) limit(r resource) int {
+	switch r.kind {
+	case "host":
+		return s.cfg.MaxPerHost
+	case "cluster":
+		return s.cfg.MaxPerCluster
+	}
+	return s.cfg.MaxPerDatastore
+}
+
+// signal wakes the workers waiting in Next. The caller holds s.mu.
+func (s *Scheduler) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,197 @@
+package upgrade
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+)
+
+// scheduled is what a call of Scheduler.Next returned
+type scheduled struct {
+	name    string
+	release func()
+	ok      bool
+}
+
+// nextAsync calls s.Next in the background and returns what it returns
+func nextAsync(s *Scheduler, skip func(vcenter.VMInfo) bool) <-chan scheduled {
+	ch := make(chan scheduled, 1)
+	go func() {
+		vm, release, ok := s.Next(skip)
+		ch <- scheduled{vm.Name, release, ok}
+	}()
+	return ch
+}
+
+// nextNow returns the VM s.Next hands out without waiting for a slot
+func nextNow(t *testing.T, s *Scheduler, skip func(vcenter.VMInfo) bool) scheduled {
+	t.Helper()
+	select {
+	case got := <-nextAsync(s, skip):
+		return got
+	case <-time.After(time.Second):
+		t.Fatal("Next is waiting, want a VM at once")
+		return scheduled{}
+	}
+}
+
+// stillWaiting checks that a background Next has not returned yet
+func stillWaiting(t *testing.T, ch <-chan scheduled) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		t.Fatalf("Next returned %q, want it to wait", got.name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	vm := func(name, host, cluster string, datastores ...string) vcenter.VMInfo {
+		return vcenter.VMInfo{Name: name, Host: host, Cluster: cluster, Datastores: datastores}
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.UpgradeConfig
+		vms      []vcenter.VMInfo
+		skip     string   // VM that is skipped
+		want     []string // handed out at once, in order
+		waiting  string   // VM that waits until the others release their slots
+		resource string   // the full resource reported for it
+	}{
+		{
+			name:     "per host",
+			cfg:      config.UpgradeConfig{MaxPerHost: 1},
+			vms:      []vcenter.VMInfo{vm("a", "esx01", ""), vm("b", "esx01", ""), vm("c", "esx02", "")},
+			want:     []string{"a", "c"},
+			waiting:  "b",
+			resource: "host esx01",
+		},
+		{
+			name:     "per datastore",
+			cfg:      config.UpgradeConfig{MaxPerDatastore: 1},
+			vms:      []vcenter.VMInfo{vm("a", "", "", "ds1"), vm("b", "", "", "ds2", "ds1"), vm("c", "", "", "ds3")},
+			want:     []string{"a", "c"},
+			waiting:  "b",
+			resource: "datastore ds1",
+		},
+		{
+			name:     "per cluster",
+			cfg:      config.UpgradeConfig{MaxPerCluster: 2},
+			vms:      []vcenter.VMInfo{vm("a", "esx01", "prod"), vm("b", "esx02", "prod"), vm("c", "esx03", "prod"), vm("d", "esx04", "test")},
+			want:     []string{"a", "b", "d"},
+			waiting:  "c",
+			resource: "cluster prod",
+		},
+		{
+			name: "no placement information",
+			cfg:  config.UpgradeConfig{MaxPerHost: 1, MaxPerCluster: 1, MaxPerDatastore: 1},
+			vms:  []vcenter.VMInfo{vm("a", "", ""), vm("b", "", "")},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "no limits",
+			vms:  []vcenter.VMInfo{vm("a", "esx01", "prod", "ds1"), vm("b", "esx01", "prod", "ds1")},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "skipped VM takes no slot",
+			cfg:  config.UpgradeConfig{MaxPerHost: 1},
+			vms:  []vcenter.VMInfo{vm("a", "esx01", ""), vm("b", "esx01", ""), vm("c", "esx01", "")},
+			skip: "a",
+			want: []string{"a", "b"},
+			// c waits for b, not for the skipped a
+			waiting:  "c",
+			resource: "host esx01",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(tt.cfg)
+			waits := make(chan string, len(tt.vms))
+			s.Waiting = func(vm vcenter.VMInfo, resource string) {
+				waits <- vm.Name + ": " + resource
+			}
+			s.Add(tt.vms...)
+			s.Close()
+			skip := func(vm vcenter.VMInfo) bool { return vm.Name == tt.skip }
+
+			var releases []func()
+			for _, want := range tt.want {
+				got := nextNow(t, s, skip)
+				if !got.ok || got.name != want {
+					t.Fatalf("Next returned %q (ok %v), want %q", got.name, got.ok, want)
+				}
+				releases = append(releases, got.release)
+			}
+
+			if tt.waiting != "" {
+				ch := nextAsync(s, skip)
+				stillWaiting(t, ch)
+				select {
+				case w := <-waits:
+					if want := tt.waiting + ": " + tt.resource + " "; !strings.HasPrefix(w, want) {
+						t.Errorf("waiting %q, want %q...", w, want)
+					}
+				default:
+					t.Errorf("no wait reported for %s", tt.waiting)
+				}
+				for _, release := range releases {
+					release()
+				}
+				select {
+				case got := <-ch:
+					if !got.ok || got.name != tt.waiting {
+						t.Fatalf("Next returned %q (ok %v), want %q", got.name, got.ok, tt.waiting)
+					}
+					got.release()
+				case <-time.After(time.Second):
+					t.Fatalf("%s still waits after the slots were released", tt.waiting)
+				}
+			}
+
+			if got := nextNow(t, s, skip); got.ok {
+				t.Errorf("Next returned %q from an empty closed scheduler", got.name)
+			}
+		})
+	}
+}
+
+// TestSchedulerReleaseTwice releases a slot twice. Only the first release
+// may free it, or the host would take one upgrade too many.
+func TestSchedulerReleaseTwice(t *testing.T) {
+	s := NewScheduler(config.UpgradeConfig{MaxPerHost: 1})
+	for _, name := range []string{"a", "b", "c"} {
+		s.Add(vcenter.VMInfo{Name: name, Host: "esx01"})
+	}
+
+	a := nextNow(t, s, nil)
+	a.release()
+	a.release()
+	if b := nextNow(t, s, nil); b.name != "b" {
+		t.Fatalf("Next returned %q, want b", b.name)
+	}
+	stillWaiting(t, nextAsync(s, nil))
+}
+
+// TestSchedulerClose ends a worker waiting for VMs when the scheduler is
+// closed
+func TestSchedulerClose(t *testing.T) {
+	s := NewScheduler(config.UpgradeConfig{})
+	ch := nextAsync(s, nil)
+	stillWaiting(t, ch)
+
+	s.Close()
+	select {
+	case got := <-ch:
+		if got.ok {
+			t.Errorf("Next returned %q after Close, want none", got.name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next still waits after Close")
+	}
+}
@@ -0,0 +1,239 @@
+package upgrade
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Setup error categories
+const (
+	CategoryCompatibility  = "compatibility"   // a program, driver or device blocks the upgrade
+	CategoryDriverRollback = "driver-rollback" // setup failed after reboot and rolled back
+	CategoryDiskSpace      = "disk-space"      // not enough space on the system or reserved partition
+	CategoryMedia          = "media-mismatch"  // edition, language or architecture of the ISO does not fit, or it is damaged
+	CategoryOther          = "other"
+)
+
+// Text is a message in both UI languages
+type Text struct {
+	EN string
+	SV string
+}
+
+// In returns the text in the given UI language ("en" or "sv")
+func (t Text) In(lang string) string {
+	if lang == "sv" && t.SV != "" {
+		return t.SV
+	}
+	return t.EN
+}
+
+// SetupError is a decoded setup.exe result code
+type SetupError struct {
+	Code        uint32
+	Extended    uint32 // extended code from the setup logs, 0 if not known
+	Category    string
+	Explanation Text
+	Remediation Text
+}
+
+// CodeString formats the code the way setup logs it, e.g. 0xC1900101 - 0x20017
+func (e *SetupError) CodeString() string {
+	if e.Extended != 0 {
+		return fmt.Sprintf("0x%08X - 0x%X", e.Code, e.Extended)
+	}
+	return fmt.Sprintf("0x%08X", e.Code)
+}
+
+type setupCodeKey struct {
+	code, extended uint32
+}
+
+// setupCodes is the catalog of known setup.exe and MoSetup results. An entry
+// with extended code 0 covers every extended code without its own entry.
+var setupCodes = map[setupCodeKey]SetupError{
+	{0xC1900101, 0}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"A driver caused the upgrade to fail and setup rolled back to the previous version.", "En drivrutin fick uppgraderingen att misslyckas och setup återställde den tidigare versionen."},
+		Remediation: Text{"Update or remove third-party drivers (storage, network, antivirus filters) and check setuperr.log for the failing driver.", "Uppdatera eller ta bort tredjepartsdrivrutiner (lagring, nätverk, antivirusfilter) och leta upp den felande drivrutinen i setuperr.log."},
+	},
+	{0xC1900101, 0x20004}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"Setup failed in the SAFE_OS phase, usually because of a storage or SCSI driver.", "Setup misslyckades i SAFE_OS-fasen, oftast på grund av en lagrings- eller SCSI-drivrutin."},
+		Remediation: Text{"Update the VMware Tools storage drivers (pvscsi) and detach unused disks or controllers.", "Uppdatera VMware Tools lagringsdrivrutiner (pvscsi) och koppla bort oanvända diskar och kontroller."},
+	},
+	{0xC1900101, 0x2000C}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"Setup failed in the SAFE_OS phase while applying drivers.", "Setup misslyckades i SAFE_OS-fasen när drivrutiner installerades."},
+		Remediation: Text{"Update VMware Tools and remove devices that are not needed before retrying.", "Uppdatera VMware Tools och ta bort enheter som inte behövs innan nytt försök."},
+	},
+	{0xC1900101, 0x20017}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"A driver failed during the SAFE_OS boot and setup rolled back.", "En drivrutin fallerade under SAFE_OS-starten och setup backade."},
+		Remediation: Text{"Find the driver in the Rollback setupact.log, update or uninstall it and retry.", "Leta upp drivrutinen i Rollback-mappens setupact.log, uppdatera eller avinstallera den och försök igen."},
+	},
+	{0xC1900101, 0x30017}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"A driver failed during the first boot of the new OS.", "En drivrutin fallerade under första starten av det nya OS:et."},
+		Remediation: Text{"Update or remove third-party drivers and filter drivers (backup, antivirus), then retry.", "Uppdatera eller ta bort tredjepartsdrivrutiner och filterdrivrutiner (backup, antivirus) och försök igen."},
+	},
+	{0xC1900101, 0x30018}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"A device driver stopped responding during the first boot.", "En drivrutin slutade svara under första starten."},
+		Remediation: Text{"Detach unneeded virtual hardware and update VMware Tools before retrying.", "Koppla bort virtuell hårdvara som inte behövs och uppdatera VMware Tools innan nytt försök."},
+	},
+	{0xC1900101, 0x3000D}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"The display driver failed during the first boot.", "Skärmdrivrutinen fallerade under första starten."},
+		Remediation: Text{"Update the VMware SVGA driver or uninstall third-party display drivers.", "Uppdatera VMware SVGA-drivrutinen eller avinstallera tredjeparts skärmdrivrutiner."},
+	},
+	{0xC1900101, 0x4000D}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"Setup failed in the SECOND_BOOT phase while migrating settings.", "Setup misslyckades i SECOND_BOOT-fasen vid migrering av inställningar."},
+		Remediation: Text{"Check setupact.log in the Rollback folder for the failing component; remove agents that hook into the boot process.", "Se setupact.log i Rollback-mappen efter den felande komponenten; ta bort agenter som kopplar in sig i startprocessen."},
+	},
+	{0xC1900101, 0x40017}: {
+		Category:    CategoryDriverRollback,
+		Explanation: Text{"A driver or filter driver failed in the SECOND_BOOT phase.", "En drivrutin eller filterdrivrutin fallerade i SECOND_BOOT-fasen."},
+		Remediation: Text{"Uninstall antivirus, backup and monitoring agents with filter drivers, then retry.", "Avinstallera antivirus-, backup- och övervakningsagenter med filterdrivrutiner och försök igen."},
+	},
+	{0xC1900107, 0}: {
+		Category:    CategoryOther,
+		Explanation: Text{"A cleanup from an earlier upgrade attempt is still pending.", "En upprensning efter ett tidigare uppgraderingsförsök väntar fortfarande."},
+		Remediation: Text{"Restart the VM and retry; remove C:\\$WINDOWS.~BT if the error remains.", "Starta om VM:en och försök igen; ta bort C:\\$WINDOWS.~BT om felet kvarstår."},
+	},
+	{0xC1900200, 0}: {
+		Category:    CategoryCompatibility,
+		Explanation: Text{"The VM does not meet the minimum requirements for the new version.", "VM:en uppfyller inte minimikraven för den nya versionen."},
+		Remediation: Text{"Check CPU, memory, firmware and disk requirements of the target OS.", "Kontrollera målversionens krav på CPU, minne, firmware och disk."},
+	},
+	{0xC1900202, 0}: {
+		Category:    CategoryCompatibility,
+		Explanation: Text{"The VM does not meet the minimum requirements for the new version.", "VM:en uppfyller inte minimikraven för den nya versionen."},
+		Remediation: Text{"Check CPU, memory, firmware and disk requirements of the target OS.", "Kontrollera målversionens krav på CPU, minne, firmware och disk."},
+	},
+	{0xC1900204, 0}: {
+		Category:    CategoryMedia,
+		Explanation: Text{"The ISO does not allow this upgrade path (edition, language or architecture differs).", "ISO:n tillåter inte den här uppgraderingsvägen (edition, språk eller arkitektur skiljer sig)."},
+		Remediation: Text{"Use media with the VM's edition and install language; check the image index and product key.", "Använd media med VM:ens edition och installationsspråk; kontrollera image-index och produktnyckel."},
+	},
+	{0xC1900208, 0}: {
+		Category:    CategoryCompatibility,
+		Explanation: Text{"An installed program or driver blocks the upgrade.", "Ett installerat program eller en drivrutin blockerar uppgraderingen."},
+		Remediation: Text{"Run the compatibility scan and uninstall or update the blocking programs listed in CompatData.", "Kör kompatibilitetsskanningen och avinstallera eller uppdatera programmen som listas i CompatData."},
+	},
+	{0xC1900209, 0}: {
+		Category:    CategoryCompatibility,
+		Explanation: Text{"Incompatible software blocks the upgrade.", "Inkompatibel programvara blockerar uppgraderingen."},
+		Remediation: Text{"Uninstall the software reported in CompatData and retry.", "Avinstallera programvaran som rapporteras i CompatData och försök igen."},
+	},
+	{0xC190020E, 0}: {
+		Category:    CategoryDiskSpace,
+		Explanation: Text{"There is not enough free disk space for the upgrade.", "Det finns inte tillräckligt med ledigt diskutrymme för uppgraderingen."},
+		Remediation: Text{"Free at least 20 GB on C: or extend the disk, then retry.", "Frigör minst 20 GB på C: eller utöka disken och försök igen."},
+	},
+	{0x80070070, 0}: {
+		Category:    CategoryDiskSpace,
+		Explanation: Text{"The disk ran full during the upgrade.", "Disken blev full under uppgraderingen."},
+		Remediation: Text{"Free space on C: (or extend the disk) and check the size of the system reserved partition.", "Frigör utrymme på C: (eller utöka disken) och kontrollera storleken på systemreserverade partitionen."},
+	},
+	{0x800F0922, 0}: {
+		Category:    CategoryDiskSpace,
+		Explanation: Text{"The system reserved partition is too small or could not be updated.", "Den systemreserverade partitionen är för liten eller kunde inte uppdateras."},
+		Remediation: Text{"Extend the system reserved or EFI partition, or free space on it.", "Utöka den systemreserverade eller EFI-partitionen, eller frigör utrymme på den."},
+	},
+	{0x800F0923, 0}: {
+		Category:    CategoryCompatibility,
+		Explanation: Text{"A driver or other software is not compatible with the new version.", "En drivrutin eller annan programvara är inte kompatibel med den nya versionen."},
+		Remediation: Text{"Update or remove the incompatible driver or program named in setuperr.log.", "Uppdatera eller ta bort den inkompatibla drivrutinen eller programmet som nämns i setuperr.log."},
+	},
+	{0x8007025D, 0}: {
+		Category:    CategoryMedia,
+		Explanation: Text{"Setup could not read the installation files, the ISO may be damaged.", "Setup kunde inte läsa installationsfilerna, ISO:n kan vara skadad."},
+		Remediation: Text{"Verify the checksum of the ISO and upload it to the datastore again.", "Verifiera ISO:ns kontrollsumma och ladda upp den till datastoren igen."},
+	},
+	{0x80070570, 0}: {
+		Category:    CategoryMedia,
+		Explanation: Text{"A file on the installation media is corrupt.", "En fil på installationsmediet är skadad."},
+		Remediation: Text{"Verify the checksum of the ISO and upload it to the datastore again.", "Verifiera ISO:ns kontrollsumma och ladda upp den till datastoren igen."},
+	},
+}
+
+// DecodeSetupError looks up a setup result code and, if known from the logs,
+// its extended code. It returns nil for codes that are not in the catalog.
+func DecodeSetupError(code, extended uint32) *SetupError {
+	e, ok := setupCodes[setupCodeKey{code, extended}]
+	if !ok {
+		e, ok = setupCodes[setupCodeKey{code, 0}]
+	}
+	if !ok {
+		return nil
+	}
+	e.Code = code
+	e.Extended = extended
+	return &e
+}
+
+// refineSetupError adds the extended code found in the collected setup logs.
+// A failure after the reboot has no exit code, but a rollback by setup
+// leaves 0xC1900101 and its extended code in the logs.
+func (r *upgradeRun) refineSetupError(failedStep string) {
+	dir := r.result.GuestLogs
+	if dir == "" {
+		return
+	}
+	code := uint32(0xC1900101)
+	if se := r.result.SetupError; se != nil {
+		code = se.Code
+	} else if FailureClassOf(failedStep) != FailureOSCheck && FailureClassOf(failedStep) != FailureBoot {
+		return
+	}
+
+	ext := findExtendedCode(dir, code)
+	if ext == 0 {
+		return
+	}
+	r.result.SetupError = DecodeSetupError(code, ext)
+	r.logf("Setup result from logs: %s", r.result.SetupError.CodeString())
+}
+
+// extendedCodePattern matches setup's "0xC1900101 - 0x20017" notation
+var extendedCodePattern = regexp.MustCompile(`(?i)0x([0-9A-F]{8})\s*-\s*0x([0-9A-F]{1,8})\b`)
+
+// findExtendedCode searches the setup logs collected to dir for the extended
+// code logged with code. The last occurrence wins, it is the final result.
+func findExtendedCode(dir string, code uint32) uint32 {
+	var extended uint32
+	for _, name := range []string{"Panther_setuperr.log", "Panther_setupact.log", "Rollback_setuperr.log", "Rollback_setupact.log"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, m := range extendedCodePattern.FindAllSubmatch(decodeLogText(data), -1) {
+			c, err1 := strconv.ParseUint(string(m[1]), 16, 32)
+			ext, err2 := strconv.ParseUint(string(m[2]), 16, 32)
+			if err1 == nil && err2 == nil && uint32(c) == code && ext != 0 {
+				extended = uint32(ext)
+			}
+		}
+	}
+	return extended
+}
+
+// decodeLogText converts a UTF-16LE log with byte order mark to UTF-8
+func decodeLogText(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xFE}) {
+		return data
+	}
+	data = data[2:]
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		u = append(u, le.Uint16(data[i:]))
+	}
+	return []byte(string(utf16.Decode(u)))
+}
@@ -0,0 +1,104 @@
+package upgrade
+
+import (
+	"fmt"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+)
+
+// Stage is a named group of VMs in a run, e.g. the database servers of an
+// application. Stages run one after another in order. With WaitHealthy the
+// next stage only starts when every VM of the stage upgraded and passed its
+// health checks.
+type Stage struct {
+	Name        string // "" for the VMs not given a stage
+	VMs         []string
+	WaitHealthy bool
+}
+
+// Batch is a group of VMs that finishes before the next one starts: one
+// rollout wave of one stage
+type Batch struct {
+	Stage int // index in the stages the batch was planned from
+	Wave  int // wave within the stage, from 1
+	Waves int // waves in the stage
+	VMs   []string
+	Gate  bool // the last wave of a stage that waits until healthy
+}
+
+// ValidateStages checks the stages of a run before it starts. A stage that
+// waits until healthy needs the health checks, without them its gate would
+// only see that the VMs upgraded.
+func ValidateStages(stages []Stage, health config.HealthConfig) error {
+	for _, st := range stages {
+		if st.WaitHealthy && !health.Enabled {
+			return fmt.Errorf("stage %s waits until its VMs are healthy, but health checks are disabled", st.Name)
+		}
+	}
+	return nil
+}
+
+// OrderStages returns the stages of a run with only the run's VMs: the
+// given stages in order, stages without VMs left out, and the VMs in no
+// stage last in a stage without a name
+func OrderStages(stages []Stage, vms []string) []Stage {
+	inRun := make(map[string]bool, len(vms))
+	for _, name := range vms {
+		inRun[name] = true
+	}
+
+	var out []Stage
+	staged := make(map[string]bool)
+	for _, st := range stages {
+		var members []string
+		for _, name := range st.VMs {
+			if inRun[name] && !staged[name] {
+				members = append(members, name)
+				staged[name] = true
+			}
+		}
+		if len(members) > 0 {
+			out = append(out, Stage{Name: st.Name, VMs: members, WaitHealthy: st.WaitHealthy})
+		}
+	}
+
+	var rest []string
+	for _, name := range vms {
+		if !staged[name] {
+			rest = append(rest, name)
+		}
+	}
+	if len(rest) > 0 {
+		out = append(out, Stage{VMs: rest})
+	}
+	return out
+}
+
+// PlanBatches splits stages into the batches of a run: stage by stage in
+// order, each in rollout waves when waves are enabled
+func PlanBatches(stages []Stage, cfg config.WaveConfig) []Batch {
+	var batches []Batch
+	for i, st := range stages {
+		waves := PlanWaves(st.VMs, cfg)
+		for w, vms := range waves {
+			batches = append(batches, Batch{
+				Stage: i,
+				Wave:  w + 1,
+				Waves: len(waves),
+				VMs:   vms,
+				Gate:  st.WaitHealthy && w == len(waves)-1,
+			})
+		}
+	}
+	return batches
+}
+
+// CheckStageGate is the gate after a stage that waits until healthy. It
+// returns an error wrapping ErrWaveHalted unless all of the stage's VMs
+// upgraded and passed their health checks.
+func CheckStageGate(stage Stage, healthy int) error {
+	if healthy < len(stage.VMs) {
+		return fmt.Errorf("%w: stage %s has %d of %d VMs upgraded and healthy", ErrWaveHalted, stage.Name, healthy, len(stage.VMs))
+	}
+	return nil
+}
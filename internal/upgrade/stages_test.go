@@ -0,0 +1,48 @@
+package upgrade
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+)
+
+func TestValidateStages(t *testing.T) {
+	tests := []struct {
+		name    string
+		stages  []Stage
+		health  bool
+		wantErr string
+	}{
+		{name: "no gates", stages: []Stage{{Name: "db", VMs: []string{"db1"}}}},
+		{name: "gate with health checks", stages: []Stage{{Name: "db", VMs: []string{"db1"}, WaitHealthy: true}}, health: true},
+		{
+			name:    "gate without health checks",
+			stages:  []Stage{{Name: "web", VMs: []string{"web1"}}, {Name: "db", VMs: []string{"db1"}, WaitHealthy: true}},
+			wantErr: "stage db waits until its VMs are healthy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateStages(tt.stages, config.HealthConfig{Enabled: tt.health})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("error %v, want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckStageGate(t *testing.T) {
+	stage := Stage{Name: "db", VMs: []string{"db1", "db2"}, WaitHealthy: true}
+	if err := CheckStageGate(stage, 2); err != nil {
+		t.Errorf("all healthy: error %v, want none", err)
+	}
+	if err := CheckStageGate(stage, 1); !errors.Is(err, ErrWaveHalted) {
+		t.Errorf("one healthy: error %v, want %v", err, ErrWaveHalted)
+	}
+}
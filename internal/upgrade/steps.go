@@ -0,0 +1,734 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/debug"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Step names in execution order
+const (
+	StepPrecheck      = "precheck"
+	StepCompatScan    = "compat-scan"
+	StepSnapshot      = "snapshot"
+	StepMount         = "mount"
+	StepUpload        = "upload"
+	StepSignal        = "signal"
+	StepInventory     = "inventory"
+	StepPreHooks      = "pre-hooks"
+	StepSetup         = "setup"
+	StepWaitExit      = "wait-exit"
+	StepPowerCycle    = "power-cycle"
+	StepVerifyOS      = "verify-os"
+	StepSignalWait    = "signal-wait"
+	StepPostHooks     = "post-hooks"
+	StepUnmount       = "unmount"
+	StepInventoryDiff = "inventory-diff"
+	StepHealth        = "health"
+)
+
+// Step statuses
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusSkipped    = "skipped"
+	StatusCancelled  = "cancelled"
+)
+
+// errStepSkipped is returned by a step that had nothing to do
+var errStepSkipped = errors.New("step skipped")
+
+// ErrCancelled is returned when an upgrade is cancelled through
+// UpgradeOptions.Context
+var ErrCancelled = errors.New("upgrade cancelled")
+
+// upgradeRun carries the state shared by the steps of one upgrade run
+type upgradeRun struct {
+	ctx    context.Context
+	parent context.Context // caller's context, without the run timeout
+	vm     VM
+	opts   UpgradeOptions
+	result *UpgradeResult
+	gc     vcenter.GuestCreds
+	guest  GuestOps     // guest operations as gc
+	step   *UpgradeStep // step currently executing
+	clock  timeScale    // time compression of vm
+
+	edition *GuestEdition // guest edition, once read
+}
+
+type upgradeStepDef struct {
+	name string
+	run  func(r *upgradeRun) error
+}
+
+// upgradeSteps is the upgrade flow. The order is significant, a resumed run
+// continues at the first step that is not completed or skipped.
+var upgradeSteps = []upgradeStepDef{
+	{StepPrecheck, (*upgradeRun).precheck},
+	{StepCompatScan, (*upgradeRun).compatScan},
+	{StepSnapshot, (*upgradeRun).snapshot},
+	{StepMount, (*upgradeRun).mount},
+	{StepUpload, (*upgradeRun).upload},
+	{StepSignal, (*upgradeRun).signal},
+	{StepInventory, (*upgradeRun).inventory},
+	{StepPreHooks, (*upgradeRun).preHooks},
+	{StepSetup, (*upgradeRun).setup},
+	{StepWaitExit, (*upgradeRun).waitExit},
+	{StepPowerCycle, (*upgradeRun).powerCycle},
+	{StepVerifyOS, (*upgradeRun).verifyOS},
+	{StepSignalWait, (*upgradeRun).signalWait},
+	{StepPostHooks, (*upgradeRun).postHooks},
+	{StepUnmount, (*upgradeRun).unmount},
+	{StepInventoryDiff, (*upgradeRun).inventoryDiff},
+	{StepHealth, (*upgradeRun).health},
+}
+
+// StepNames returns the names of all upgrade steps in execution order
+func StepNames() []string {
+	names := make([]string, len(upgradeSteps))
+	for i, def := range upgradeSteps {
+		names[i] = def.name
+	}
+	return names
+}
+
+// NewUpgradeResult returns a result with every step pending
+func NewUpgradeResult(vmName string) *UpgradeResult {
+	res := &UpgradeResult{VMName: vmName}
+	for _, def := range upgradeSteps {
+		res.Steps = append(res.Steps, UpgradeStep{Name: def.name, Status: StatusPending})
+	}
+	return res
+}
+
+// Step returns the named step, or nil if the result has no such step
+func (res *UpgradeResult) Step(name string) *UpgradeStep {
+	for i := range res.Steps {
+		if res.Steps[i].Name == name {
+			return &res.Steps[i]
+		}
+	}
+	return nil
+}
+
+// FailedStep returns the name of the step that failed, or "" if none did
+func (res *UpgradeResult) FailedStep() string {
+	for _, s := range res.Steps {
+		if s.Status == StatusFailed {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// NextStep returns the name of the first step that still has to run, or ""
+// if every step is done
+func (res *UpgradeResult) NextStep() string {
+	for _, s := range res.Steps {
+		if s.Status != StatusCompleted && s.Status != StatusSkipped {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// CurrentStep returns the index of the step that is running, or of the last
+// step that ran when none is, or -1 if no step has started
+func (res *UpgradeResult) CurrentStep() int {
+	current := -1
+	for i, s := range res.Steps {
+		if s.Status == StatusInProgress {
+			return i
+		}
+		if !s.StartTime.IsZero() {
+			current = i
+		}
+	}
+	return current
+}
+
+// resumeCopy returns a copy of the result prepared for a new run. Steps are
+// matched by name so results recorded by an older step list still resume.
+func (res *UpgradeResult) resumeCopy() *UpgradeResult {
+	out := NewUpgradeResult(res.VMName)
+	out.SnapshotName = res.SnapshotName
+	out.GuestPID = res.GuestPID
+	out.OriginalOS = res.OriginalOS
+	out.Compat = res.Compat
+	out.Inventory = res.Inventory
+	out.Attempts = append([]Attempt(nil), res.Attempts...)
+	for i := range out.Steps {
+		prev := res.Step(out.Steps[i].Name)
+		if prev != nil && (prev.Status == StatusCompleted || prev.Status == StatusSkipped) {
+			out.Steps[i] = *prev
+		}
+	}
+
+	// Setup that exited with an error has no process left to wait for. It
+	// runs again, after the signal task it relies on. Results recorded
+	// before waitExit cleared the PID still have the setup result code.
+	waitExit := res.Step(StepWaitExit)
+	exited := out.GuestPID == 0 || waitExit != nil && waitExit.Status == StatusFailed && res.SetupError != nil
+	if out.Step(StepSetup).Status == StatusCompleted && out.Step(StepWaitExit).Status != StatusCompleted && exited {
+		out.GuestPID = 0
+		for _, name := range []string{StepSignal, StepSetup, StepWaitExit} {
+			s := out.Step(name)
+			*s = UpgradeStep{Name: name, Status: StatusPending}
+		}
+	}
+
+	// A VM reverted to its snapshot is back where it started, only the
+	// snapshot itself is kept
+	if res.Rollback != nil && res.Rollback.Reverted {
+		out.GuestPID = 0
+		for i := range out.Steps {
+			if out.Steps[i].Name != StepSnapshot {
+				out.Steps[i] = UpgradeStep{Name: out.Steps[i].Name, Status: StatusPending}
+			}
+		}
+	}
+	return out
+}
+
+// Clone returns a deep copy of the result
+func (res *UpgradeResult) Clone() *UpgradeResult {
+	out := *res
+	out.Steps = append([]UpgradeStep(nil), res.Steps...)
+	out.Attempts = append([]Attempt(nil), res.Attempts...)
+	if res.Rollback != nil {
+		rb := *res.Rollback
+		out.Rollback = &rb
+	}
+	if res.SetupError != nil {
+		se := *res.SetupError
+		out.SetupError = &se
+	}
+	if res.Compat != nil {
+		c := *res.Compat
+		c.HardBlocks = append([]CompatIssue(nil), res.Compat.HardBlocks...)
+		c.Reports = append([]string(nil), res.Compat.Reports...)
+		out.Compat = &c
+	}
+	if res.Inventory != nil {
+		inv := *res.Inventory
+		inv.Changes = append([]InventoryChange(nil), res.Inventory.Changes...)
+		out.Inventory = &inv
+	}
+	if res.Health != nil {
+		out.Health = &HealthReport{Checks: append([]PreflightCheck(nil), res.Health.Checks...)}
+	}
+	return &out
+}
+
+// notify reports the current state of the run to the observers
+func (r *upgradeRun) notify() {
+	r.emit(Event{Kind: EventProgress, Result: r.result.Clone()})
+}
+
+// stepFinished reports the outcome and duration of the current step
+func (r *upgradeRun) stepFinished() {
+	s := r.step
+	r.emit(Event{Kind: EventStepFinished, Status: s.Status, Err: s.Error, Result: r.result.Clone()})
+	if s.Status != StatusSkipped {
+		r.metric("step_duration", s.EndTime.Sub(s.StartTime).Seconds(), "s")
+	}
+}
+
+// warnf records a non-fatal problem on the current step
+func (r *upgradeRun) warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	r.emit(Event{Kind: EventWarning, Message: msg})
+	if r.step != nil {
+		if r.step.Warning != "" {
+			r.step.Warning += "; "
+		}
+		r.step.Warning += msg
+		r.notify()
+	}
+}
+
+// cancelCleanup leaves a cancelled VM in a known state. While setup has not
+// finished the guest upgrade script and Windows Setup are terminated, which
+// abandons the upgrade. The ISO is unmounted and the signal task removed in
+// every case. If setup had already finished the upgrade is staged and will
+// continue the next time the guest boots.
+func (r *upgradeRun) cancelCleanup() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.scaled(5*time.Minute))
+	defer cancel()
+
+	res := r.result
+	started := func(name string) bool {
+		s := res.Step(name)
+		return s != nil && s.Status != StatusPending
+	}
+	// Steps undone by the cleanup have to run again if the VM is resumed
+	reset := func(names ...string) {
+		for _, name := range names {
+			if s := res.Step(name); s != nil && s.Status == StatusCompleted {
+				s.Status = StatusPending
+			}
+		}
+	}
+
+	if res.GuestPID != 0 && res.Step(StepWaitExit).Status != StatusCompleted {
+		if err := terminateGuestUpgrade(ctx, r.guest, res.GuestPID, r.opts.VMInfo.Name); err != nil {
+			r.warnf("could not terminate guest upgrade: %v", err)
+		} else {
+			res.GuestPID = 0
+			reset(StepSetup)
+		}
+	}
+
+	// A compatibility scan has no PID of its own, only setup is running
+	if s := res.Step(StepCompatScan); s != nil && s.Status == StatusInProgress {
+		if err := terminateGuestUpgrade(ctx, r.guest, 0, r.opts.VMInfo.Name); err != nil {
+			r.warnf("could not stop compatibility scan: %v", err)
+		}
+	}
+
+	if started(StepUpload) {
+		if err := runCleanupScript(ctx, r.guest, r.opts.VMInfo.Name); err != nil {
+			r.warnf("could not remove signal task: %v", err)
+		} else {
+			reset(StepUpload, StepSignal)
+		}
+	}
+
+	// The compatibility scan mounts the ISO before the mount step does
+	if started(StepMount) || started(StepCompatScan) && res.Step(StepCompatScan).Status != StatusSkipped {
+		if err := UnmountISO(ctx, r.vm); err != nil {
+			r.warnf("unmount ISO failed: %v", err)
+		} else {
+			debug.LogSuccess("UnmountISO", "VM", r.opts.VMInfo.Name)
+			reset(StepMount)
+		}
+	}
+}
+
+// precheck verifies that no upgrade is running and that there is enough disk
+func (r *upgradeRun) precheck() error {
+	opts := r.opts
+
+	r.logf("Checking if upgrade already in progress...")
+	inProgress, err := CheckUpgradeInProgress(r.ctx, r.vm)
+	if err != nil {
+		debug.LogError("CheckUpgradeInProgress", err, "VM", opts.VMInfo.Name)
+		return fmt.Errorf("status check: %w", err)
+	}
+	if inProgress {
+		debug.LogError("UpgradeInProgress", fmt.Errorf("upgrade already in progress"), "VM", opts.VMInfo.Name)
+		return fmt.Errorf("upgrade verkar redan pågå på denna VM")
+	}
+	debug.LogSuccess("CheckUpgradeInProgress", "VM", opts.VMInfo.Name)
+
+	// Remember the OS so a rollback can verify that it is back
+	if r.result.OriginalOS == "" {
+		name, err := guestOSName(r.ctx, r.vm)
+		if err != nil {
+			debug.LogError("GuestOSName", err, "VM", opts.VMInfo.Name)
+		}
+		r.result.OriginalOS = name
+		r.logf("Original OS: %s", r.result.OriginalOS)
+	}
+
+	// The target profile decides which source versions may be upgraded
+	if err := VerifySourceOS(opts.Profile, r.result.OriginalOS); err != nil {
+		debug.LogError("VerifySourceOS", err, "VM", opts.VMInfo.Name, "Profile", opts.Profile.Name)
+		return fmt.Errorf("source os: %w", err)
+	}
+
+	// Without a CD/DVD device the mount step would fail after the snapshot
+	if err := VerifyCDROM(r.ctx, r.vm); err != nil {
+		debug.LogError("VerifyCDROM", err, "VM", opts.VMInfo.Name)
+		return fmt.Errorf("cd-rom: %w", err)
+	}
+
+	// The ISO must have an image for the guest's edition and language
+	if err := r.verifyMedia(); err != nil {
+		return err
+	}
+
+	if opts.Config.Upgrade.PrecheckDiskGB <= 0 {
+		return nil
+	}
+
+	r.logf("Disk space precheck...")
+	sysDrive, err := GetSystemDrive(r.ctx, r.vm)
+	if err != nil {
+		debug.LogError("GetSystemDrive", err, "VM", opts.VMInfo.Name)
+		return fmt.Errorf("kunde inte hitta system drive: %w", err)
+	}
+	r.logf("System drive detected: %s", sysDrive)
+
+	free, err := GetDiskFreeGB(r.ctx, r.vm, sysDrive)
+	if err != nil {
+		debug.LogError("GetDiskFreeGB", err, "VM", opts.VMInfo.Name, "Drive", sysDrive)
+		return fmt.Errorf("diskcheck: %w", err)
+	}
+	r.logf("Free space: %d GB (required: %d GB)", free, opts.Config.Upgrade.PrecheckDiskGB)
+	r.metric("disk_free", float64(free), "GB")
+
+	if int(free) < opts.Config.Upgrade.PrecheckDiskGB {
+		debug.LogError("InsufficientDiskSpace", fmt.Errorf("not enough disk space"),
+			"VM", opts.VMInfo.Name, "Free", free, "Required", opts.Config.Upgrade.PrecheckDiskGB)
+		return fmt.Errorf("disk: %d GB ledigt < krav %d GB", free, opts.Config.Upgrade.PrecheckDiskGB)
+	}
+	debug.LogSuccess("DiskPrecheck", "VM", opts.VMInfo.Name, "Free", free)
+	return nil
+}
+
+// verifyMedia compares the ISO metadata with the guest's edition and
+// language. Only a missing ISO or a mismatch fails, metadata that cannot be
+// read is a warning and the upgrade goes on as before.
+func (r *upgradeRun) verifyMedia() error {
+	opts := r.opts
+	meta, err := ReadISOMetadata(r.ctx, opts.ISOPath)
+	if errors.Is(err, ErrISONotFound) {
+		return fmt.Errorf("iso: %s: %w", opts.ISOPath, err)
+	}
+	if err != nil {
+		debug.LogError("ReadISOMetadata", err, "VM", opts.VMInfo.Name)
+		r.warnf("could not read ISO metadata, edition and language not verified: %v", err)
+		return nil
+	}
+
+	edition, err := r.guestEdition()
+	if err != nil {
+		debug.LogError("ReadGuestEdition", err, "VM", opts.VMInfo.Name)
+		r.warnf("could not read guest edition, ISO not verified: %v", err)
+		return nil
+	}
+
+	img, err := VerifyISOMedia(meta, opts.Profile, ResolveEditions(opts.Profile, opts.Config.Upgrade.ProductKeys), edition)
+	if err != nil {
+		debug.LogError("VerifyISOMedia", err, "VM", opts.VMInfo.Name, "Edition", edition.String())
+		return fmt.Errorf("media: %s: %w", edition, err)
+	}
+	debug.LogSuccess("VerifyISOMedia", "VM", opts.VMInfo.Name, "Edition", edition.String(), "Image", img.Index)
+	return nil
+}
+
+// snapshot takes the pre-upgrade snapshot
+func (r *upgradeRun) snapshot() error {
+	opts := r.opts
+	if !opts.CreateSnapshot {
+		return errStepSkipped
+	}
+
+	name := r.result.SnapshotName
+	r.logf("Snapshot name: %s, Include memory: %v", name, !opts.Config.Defaults.SkipMemoryInSnapshot)
+
+	if err := r.vm.CreateSnapshot(r.ctx, name, "Pre upgrade", !opts.Config.Defaults.SkipMemoryInSnapshot, false); err != nil {
+		debug.LogError("CreateSnapshot", err, "VM", opts.VMInfo.Name, "SnapshotName", name)
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	debug.LogSuccess("CreateSnapshot", "VM", opts.VMInfo.Name, "Name", name)
+	return nil
+}
+
+// mount connects the upgrade ISO to the VM's CD-ROM
+func (r *upgradeRun) mount() error {
+	r.logf("ISO path: %s", r.opts.ISOPath)
+	if err := MountISO(r.ctx, r.vm, r.opts.ISOPath); err != nil {
+		debug.LogError("MountISO", err, "VM", r.opts.VMInfo.Name, "ISOPath", r.opts.ISOPath)
+		return fmt.Errorf("mount iso: %w", err)
+	}
+	debug.LogSuccess("MountISO", "VM", r.opts.VMInfo.Name, "ISOPath", r.opts.ISOPath)
+	return nil
+}
+
+// upload copies the helper PowerShell scripts to the guest
+func (r *upgradeRun) upload() error {
+	if err := uploadScriptsToGuest(r.ctx, r.guest, r.opts.VMInfo.Name); err != nil {
+		debug.LogError("UploadScripts", err, "VM", r.opts.VMInfo.Name)
+		return fmt.Errorf("kunde inte ladda upp scripts: %w", err)
+	}
+	return nil
+}
+
+// signal registers the boot-time task that reports when Windows is ready.
+// A failure is not fatal, the upgrade continues but signal-wait may time out.
+func (r *upgradeRun) signal() error {
+	if err := executeSignalTaskScript(r.ctx, r.guest, r.opts.VMInfo.Name, r.opts.Config.Timeouts); err != nil {
+		debug.LogError("ExecuteSignalTaskScript", err, "VM", r.opts.VMInfo.Name)
+		r.warnf("failed to set up signal task script, signal detection may fail: %v", err)
+		return nil
+	}
+	debug.LogSuccess("SignalTaskSetup", "VM", r.opts.VMInfo.Name)
+	return nil
+}
+
+// setup starts the guest upgrade script and records its PID
+func (r *upgradeRun) setup() error {
+	editions := ResolveEditions(r.opts.Profile, r.opts.Config.Upgrade.ProductKeys)
+	if len(editions) == 0 {
+		return fmt.Errorf("no product keys for profile %s (build %d is not in the catalog, add editions to the profile)", r.opts.Profile.Name, r.opts.Profile.TargetBuild)
+	}
+
+	// A reattached run may find the upgrade of the interrupted run still in
+	// the guest, a second setup would run on top of it
+	if pid, err := r.runningUpgrade(); err != nil {
+		r.warnf("could not check for a running upgrade: %v", err)
+	} else if pid != 0 {
+		r.logf("Upgrade already running in the guest (PID: %d), not started again", pid)
+		r.result.GuestPID = pid
+		return nil
+	}
+
+	pid, err := startGuestUpgrade(r.ctx, r.guest, r.gc, editions)
+	if err != nil {
+		debug.LogError("StartGuestUpgrade", err, "VM", r.opts.VMInfo.Name, "GuestUser", r.opts.GuestUsername)
+		return fmt.Errorf("guest script: %w", err)
+	}
+	r.result.GuestPID = pid
+	debug.LogSuccess("StartGuestUpgrade", "VM", r.opts.VMInfo.Name, "PID", pid)
+	return nil
+}
+
+// runningUpgrade returns the PID to wait for when the upgrade script of an
+// earlier run, or a setup.exe it started, is still in the guest, and 0 when
+// neither is. The recorded script is used also when it has exited, so its
+// exit code is not lost.
+func (r *upgradeRun) runningUpgrade() (int64, error) {
+	procs, err := r.guest.ListProcesses(r.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	var setupPID int64
+	for _, proc := range procs {
+		if r.result.GuestPID != 0 && proc.Pid == r.result.GuestPID && strings.EqualFold(proc.Name, "powershell.exe") {
+			return proc.Pid, nil
+		}
+		name := strings.ToLower(proc.Name)
+		if proc.EndTime == nil && (name == "setup.exe" || name == "setuphost.exe") && setupPID == 0 {
+			setupPID = proc.Pid
+		}
+	}
+	return setupPID, nil
+}
+
+// waitExit waits for the guest upgrade script to exit successfully
+func (r *upgradeRun) waitExit() error {
+	pid := r.result.GuestPID
+	r.logf("Waiting for upgrade script to complete (PID: %d)...", pid)
+	stop := r.watchDownlevel()
+	exitCode, err := waitForProcessExit(r.ctx, r.guest, pid, r.opts.VMInfo.Name)
+	stop()
+	if err != nil {
+		debug.LogError("WaitForProcessExit", err, "VM", r.opts.VMInfo.Name, "PID", pid)
+		return fmt.Errorf("script wait: %w", err)
+	}
+	if exitCode != 0 {
+		debug.LogError("ScriptExitCode", fmt.Errorf("non-zero exit code"), "VM", r.opts.VMInfo.Name, "ExitCode", exitCode)
+		// The script is gone, a retry has to start setup again
+		r.result.GuestPID = 0
+		// The script exits with setup's own result code when setup fails
+		if se := DecodeSetupError(uint32(exitCode), 0); se != nil {
+			r.result.SetupError = se
+			return fmt.Errorf("upgrade script failed with exit code %s: %s", se.CodeString(), se.Explanation.EN)
+		}
+		return fmt.Errorf("upgrade script failed with exit code %d", exitCode)
+	}
+	debug.LogSuccess("ScriptCompleted", "VM", r.opts.VMInfo.Name, "ExitCode", exitCode)
+	r.result.Progress = SetupProgress{Phase: PhaseDownlevel, Percent: 100, Updated: time.Now()}
+	return nil
+}
+
+// powerCycle waits for the guest to shut down after setup (forcing it off if
+// it does not) and powers the VM back on. A reattached run repeats the step,
+// so the VM's state is checked first: a guest that already rebooted is in
+// setup's offline phases or done and must not be powered off.
+func (r *upgradeRun) powerCycle() error {
+	ctx := r.ctx
+	vm := r.vm
+	opts := r.opts
+
+	var o mo.VirtualMachine
+	if err := vm.Properties(ctx, []string{"runtime.powerState", "runtime.bootTime", "guest.guestFullName", "config.extraConfig"}, &o); err != nil {
+		return fmt.Errorf("power state: %w", err)
+	}
+	poweredOff := o.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff
+	if !poweredOff && r.rebootedAfterSetup(o) {
+		r.logf("Guest has already rebooted after setup, power cycle not needed")
+		return nil
+	}
+
+	if poweredOff {
+		r.logf("VM is already powered off")
+	} else if err := r.waitForShutdown(); err != nil {
+		return err
+	}
+
+	r.logf("Waiting 60 seconds before powering on via vCenter...")
+	powerOnDelay := time.NewTimer(r.scaled(60 * time.Second))
+	defer powerOnDelay.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("cancelled before power on could run: %w", ctx.Err())
+	case <-powerOnDelay.C:
+	}
+
+	powerOnCtx, powerOnCancel := context.WithTimeout(ctx, r.scaled(10*time.Minute))
+	defer powerOnCancel()
+
+	if err := vm.PowerOn(powerOnCtx); err != nil {
+		debug.LogError("PowerOn", err, "VM", opts.VMInfo.Name)
+		return fmt.Errorf("power on: %w", err)
+	}
+	debug.LogSuccess("PowerOn", "VM", opts.VMInfo.Name)
+
+	// Short wait before continuing so VMware Tools can initialize
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(r.scaled(20 * time.Second)):
+	}
+	return nil
+}
+
+// rebootedAfterSetup reports whether a running guest has booted since the
+// upgrade script exited, or already reports the target OS or build
+func (r *upgradeRun) rebootedAfterSetup(o mo.VirtualMachine) bool {
+	exited := r.result.Step(StepWaitExit).EndTime
+	if o.Runtime.BootTime != nil && !exited.IsZero() && o.Runtime.BootTime.After(exited) {
+		return true
+	}
+	profile := r.opts.Profile
+	if o.Guest != nil && profile.TargetOS != "" && strings.Contains(strings.ToLower(o.Guest.GuestFullName), strings.ToLower(profile.TargetOS)) {
+		return true
+	}
+	return profile.TargetBuild != 0 && buildOf(o) == profile.TargetBuild
+}
+
+// waitForShutdown gives Windows time to shut down by itself after setup and
+// forces the VM off if it does not
+func (r *upgradeRun) waitForShutdown() error {
+	ctx := r.ctx
+	vm := r.vm
+	opts := r.opts
+
+	r.logf("Giving Windows 60 seconds before checking power state...")
+	select {
+	case <-time.After(r.scaled(60 * time.Second)):
+	case <-ctx.Done():
+		return fmt.Errorf("cancelled before shutdown check could run: %w", ctx.Err())
+	}
+
+	shutdownTimeout := time.Duration(opts.Config.Timeouts.PowerOffMinutes) * time.Minute
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = 5 * time.Minute
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, r.scaled(shutdownTimeout))
+	defer shutdownCancel()
+
+	pollTicker := time.NewTicker(r.scaled(15 * time.Second))
+	defer pollTicker.Stop()
+
+	for {
+		select {
+		case <-shutdownCtx.Done():
+			if ctx.Err() != nil {
+				return fmt.Errorf("cancelled while waiting for shutdown: %w", ctx.Err())
+			}
+			r.logf("Guest shutdown timeout reached, attempting forced power off...")
+			r.warnf("guest did not shut down within %v, forced power off", shutdownTimeout)
+			powerOffCtx, powerOffCancel := context.WithTimeout(ctx, r.scaled(10*time.Minute))
+			defer powerOffCancel()
+			if err := vm.PowerOff(powerOffCtx); err != nil {
+				debug.LogError("PowerOff", err, "VM", opts.VMInfo.Name)
+				return fmt.Errorf("power off: %w", err)
+			}
+			debug.LogSuccess("PowerOff", "VM", opts.VMInfo.Name)
+			return nil
+		case <-pollTicker.C:
+			var o mo.VirtualMachine
+			if err := vm.Properties(shutdownCtx, []string{"runtime.powerState"}, &o); err != nil {
+				r.logf("Could not query runtime.powerState while waiting for shutdown: %v", err)
+				continue
+			}
+			if o.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff {
+				r.logf("VM is powered off")
+				return nil
+			}
+		}
+	}
+}
+
+// verifyOS waits until the guest reports the target OS version
+func (r *upgradeRun) verifyOS() error {
+	profile := r.opts.Profile
+	if profile.TargetOS == "" && profile.TargetBuild == 0 {
+		return fmt.Errorf("target profile %q has neither target OS nor build", profile.Name)
+	}
+
+	r.logf("Validating guest OS against profile %s (OS %q, build %d)...", profile.Name, profile.TargetOS, profile.TargetBuild)
+	stop := r.watchBootPhases()
+	// A profile with only a build waits for the build, one with a name
+	// waits for the name and has its build checked below
+	build := 0
+	if profile.TargetOS == "" {
+		build = profile.TargetBuild
+	}
+	err := waitForTargetOS(r.ctx, r.vm, []string{profile.TargetOS}, build, r.opts.VMInfo.Name, time.Duration(r.opts.Config.Timeouts.TargetOSMinutes)*time.Minute)
+	stop()
+	r.result.Progress = SetupProgress{}
+	if err != nil {
+		debug.LogError("WaitForTargetOS", err, "VM", r.opts.VMInfo.Name)
+		return fmt.Errorf("os version: %w", err)
+	}
+
+	// The name alone does not tell releases apart on older hosts, the
+	// build number does
+	if profile.TargetBuild != 0 {
+		build, err := guestBuild(r.ctx, r.vm)
+		switch {
+		case err != nil:
+			r.warnf("could not read guest build number: %v", err)
+		case build == 0:
+			r.warnf("guest does not report a build number, only the OS name was verified")
+		case build != profile.TargetBuild:
+			debug.LogError("TargetBuild", fmt.Errorf("build mismatch"), "VM", r.opts.VMInfo.Name, "Build", build, "Expected", profile.TargetBuild)
+			return fmt.Errorf("os build: guest reports build %d, profile %s expects %d", build, profile.Name, profile.TargetBuild)
+		default:
+			r.logf("Guest build %d matches profile %s", build, profile.Name)
+		}
+	}
+	debug.LogSuccess("TargetOSDetected", "VM", r.opts.VMInfo.Name, "Profile", profile.Name)
+	return nil
+}
+
+// signalWait waits for the signal file written by the boot-time task. A
+// timeout only produces a warning since the server may still be fine.
+func (r *upgradeRun) signalWait() error {
+	if err := waitForPostRebootSignals(r.ctx, r.guest, r.opts.VMInfo.Name, r.opts.Config.Timeouts); err != nil {
+		if strings.Contains(err.Error(), "LOGONUI_TIMEOUT") {
+			r.warnf("task signal file not created within timeout - server %s should be checked manually (%v)", r.opts.VMInfo.Name, err)
+			return nil
+		}
+		debug.LogError("WaitForSignalFiles", err, "VM", r.opts.VMInfo.Name)
+		return fmt.Errorf("signal file check: %w", err)
+	}
+	debug.LogSuccess("WaitForSignalFiles", "VM", r.opts.VMInfo.Name)
+	return nil
+}
+
+// unmount disconnects the ISO. A failure only produces a warning.
+func (r *upgradeRun) unmount() error {
+	if err := UnmountISO(context.Background(), r.vm); err != nil {
+		r.warnf("unmount ISO failed: %v", err)
+		return nil
+	}
+	debug.LogSuccess("UnmountISO", "VM", r.opts.VMInfo.Name)
+	return nil
+}
@@ -1,14 +1,13 @@
 package upgrade
 
 import (
-	"bytes"
 	"context"
 	"embed"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 	"unicode/utf16"
@@ -17,8 +16,6 @@ import (
 	"github.com/skabbio1976/osupgrader-gui/internal/config"
 	"github.com/skabbio1976/osupgrader-gui/internal/debug"
 	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
-	"github.com/vmware/govmomi/guest"
-	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
@@ -35,102 +32,217 @@ type UpgradeOptions struct {
 	CreateSnapshot bool
 	SnapshotName   string
 	Config         *config.AppConfig
+
+	// Profile is the upgrade target. Only its source versions are upgraded
+	// and success means the guest reports exactly its target.
+	Profile config.TargetProfile
+
+	// Resume is the result of an earlier run of the same VM. Steps that
+	// completed (or were skipped) in that run are not executed again.
+	Resume *UpgradeResult
+
+	// Observers receive the run's events: steps starting and finishing,
+	// warnings, guest output, metrics and a copy of the result whenever it
+	// changes. Add DebugLog to write them to the debug log file.
+	Observers []Observer
+
+	// Context cancels the upgrade when done. Nil means context.Background().
+	Context context.Context
+
+	// RunID names the folder guest logs are collected to when the upgrade
+	// fails (see GuestLogDir). Empty uses the time of the failure.
+	RunID string
 }
 
 // UpgradeResult contains the result of an upgrade
 type UpgradeResult struct {
-	VMName  string
-	Success bool
-	Error   error
-	Steps   []UpgradeStep
+	VMName    string
+	Success   bool
+	Cancelled bool
+	Error     error
+	Steps     []UpgradeStep
+
+	// State handed between steps, kept so a failed run can be resumed
+	SnapshotName string
+	GuestPID     int64
+	OriginalOS   string // guest OS reported before the upgrade
+
+	// Compat is the result of the compatibility scan, if one ran
+	Compat *CompatResult
+
+	// GuestLogs is the local folder the guest's setup logs were copied to
+	// after a failure, "" if none were collected
+	GuestLogs string
+
+	// Progress is Windows Setup's phase and percentage while it runs
+	Progress SetupProgress
+
+	// SetupError is the decoded setup result of a failed upgrade, nil if
+	// setup did not report a known code
+	SetupError *SetupError
+
+	// Rollback is set when the VM was reverted to its pre-upgrade snapshot
+	// after a failure
+	Rollback *RollbackResult
+
+	// Inventory is the guest inventory from before setup and after the
+	// upgrade with the differences, nil if it was not captured
+	Inventory *InventoryResult
+
+	// Health is the outcome of the post-upgrade health checks, nil if they
+	// did not run
+	Health *HealthReport
+
+	// Attempts are the runs of the upgrade on the VM, oldest first. A retry
+	// or a reattached run adds one that continues from the failed step.
+	Attempts []Attempt
+}
+
+// Attempt is one run of UpgradeSingleVM on a VM
+type Attempt struct {
+	StartTime time.Time
+	EndTime   time.Time // zero while it runs, or if it was interrupted
+	FromStep  string    // first step the attempt had to run
+	Status    string    // StatusInProgress, StatusCompleted, StatusFailed or StatusCancelled
+	Error     error
 }
 
 // UpgradeStep represents a step in the upgrade process
 type UpgradeStep struct {
 	Name      string
-	Status    string // "pending", "in_progress", "completed", "failed"
+	Status    string // "pending", "in_progress", "completed", "failed", "skipped", "cancelled"
 	Error     error
+	Warning   string
 	StartTime time.Time
 	EndTime   time.Time
 }
 
-// UpgradeSingleVM upgrades a single VM
-func UpgradeSingleVM(vm *object.VirtualMachine, opts UpgradeOptions) error {
+// UpgradeSingleVM upgrades a single VM by running the upgrade steps in order.
+// When opts.Resume is set the run continues from the first step that did not
+// complete in the earlier run. vm is usually NewVM of a vCenter VM.
+func UpgradeSingleVM(vm VM, opts UpgradeOptions) (*UpgradeResult, error) {
 	debug.LogFunction("UpgradeSingleVM",
 		"VM", opts.VMInfo.Name,
 		"ISOPath", opts.ISOPath,
+		"Profile", opts.Profile.Name,
 		"CreateSnapshot", opts.CreateSnapshot,
 		"SnapshotName", opts.SnapshotName,
 		"TimeoutMinutes", opts.Config.Upgrade.TimeoutMinutes,
 		"PrecheckDiskGB", opts.Config.Upgrade.PrecheckDiskGB,
+		"Resume", opts.Resume != nil,
 	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(opts.Config.Upgrade.TimeoutMinutes)*time.Minute)
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	clock := timeScaleOf(vm)
+	ctx, cancel := context.WithTimeout(parent, clock.scaled(runTimeout(opts)))
 	defer cancel()
 
-	// 0. Check if upgrade is already in progress
-	debug.Log("Step 0: Checking if upgrade already in progress...")
-	inProgress, err := CheckUpgradeInProgress(ctx, vm)
-	if err != nil {
-		debug.LogError("CheckUpgradeInProgress", err, "VM", opts.VMInfo.Name)
-		return fmt.Errorf("status check: %w", err)
-	}
-	if inProgress {
-		debug.LogError("UpgradeInProgress", fmt.Errorf("upgrade already in progress"), "VM", opts.VMInfo.Name)
-		return fmt.Errorf("upgrade verkar redan pågå på denna VM")
-	}
-	debug.LogSuccess("CheckUpgradeInProgress", "VM", opts.VMInfo.Name)
-
-	// 1. Disk precheck
-	if opts.Config.Upgrade.PrecheckDiskGB > 0 {
-		debug.Log("Step 1: Disk space precheck...")
-		sysDrive, err := GetSystemDrive(ctx, vm)
-		if err != nil {
-			debug.LogError("GetSystemDrive", err, "VM", opts.VMInfo.Name)
-			return fmt.Errorf("kunde inte hitta system drive: %w", err)
-		}
-		debug.Log("System drive detected: %s", sysDrive)
+	var result *UpgradeResult
+	if opts.Resume != nil {
+		result = opts.Resume.resumeCopy()
+		debug.Log("Resuming upgrade of %s from step %s", opts.VMInfo.Name, result.NextStep())
+	} else {
+		result = NewUpgradeResult(opts.VMInfo.Name)
+		result.SnapshotName = opts.SnapshotName
+	}
 
-		free, err := GetDiskFreeGB(ctx, vm, sysDrive)
-		if err != nil {
-			debug.LogError("GetDiskFreeGB", err, "VM", opts.VMInfo.Name, "Drive", sysDrive)
-			return fmt.Errorf("diskcheck: %w", err)
-		}
-		debug.Log("Free space: %d GB (required: %d GB)", free, opts.Config.Upgrade.PrecheckDiskGB)
+	result.Attempts = append(result.Attempts, Attempt{
+		StartTime: time.Now(),
+		FromStep:  result.NextStep(),
+		Status:    StatusInProgress,
+	})
 
-		if int(free) < opts.Config.Upgrade.PrecheckDiskGB {
-			debug.LogError("InsufficientDiskSpace", fmt.Errorf("not enough disk space"),
-				"VM", opts.VMInfo.Name, "Free", free, "Required", opts.Config.Upgrade.PrecheckDiskGB)
-			return fmt.Errorf("disk: %d GB ledigt < krav %d GB", free, opts.Config.Upgrade.PrecheckDiskGB)
+	gc := guestCredentials(opts)
+	r := &upgradeRun{
+		ctx:    ctx,
+		parent: parent,
+		vm:     vm,
+		opts:   opts,
+		result: result,
+		gc:     gc,
+		guest:  vm.Guest(gc),
+		clock:  clock,
+	}
+
+	for i, def := range upgradeSteps {
+		step := &result.Steps[i]
+		if step.Status == StatusCompleted || step.Status == StatusSkipped {
+			r.logf("Step %d/%d (%s): already %s, skipping", i+1, len(upgradeSteps), def.name, step.Status)
+			continue
 		}
-		debug.LogSuccess("DiskPrecheck", "VM", opts.VMInfo.Name, "Free", free)
-	}
-
-	// 2. Snapshot
-	if opts.CreateSnapshot {
-		debug.Log("Step 2: Creating snapshot...")
-		debug.Log("Snapshot name: %s, Include memory: %v", opts.SnapshotName, !opts.Config.Defaults.SkipMemoryInSnapshot)
 
-		if err := vcenter.CreateSnapshot(ctx, vm, opts.SnapshotName, "Pre upgrade", !opts.Config.Defaults.SkipMemoryInSnapshot, false); err != nil {
-			debug.LogError("CreateSnapshot", err, "VM", opts.VMInfo.Name, "SnapshotName", opts.SnapshotName)
-			return fmt.Errorf("snapshot: %w", err)
+		r.step = step
+		step.Status = StatusInProgress
+		step.Error = nil
+		step.Warning = ""
+		step.StartTime = time.Now()
+		step.EndTime = time.Time{}
+		r.emit(Event{Kind: EventStepStarted, Message: fmt.Sprintf("step %d/%d", i+1, len(upgradeSteps)), Result: result.Clone()})
+		r.notify()
+
+		var err error
+		if parent.Err() == nil {
+			err = def.run(r)
 		}
-		debug.LogSuccess("CreateSnapshot", "VM", opts.VMInfo.Name, "Name", opts.SnapshotName)
+		step.EndTime = time.Now()
+		switch {
+		case parent.Err() != nil:
+			// Cancelled by the caller, before or while the step ran
+			step.Status = StatusCancelled
+			result.Cancelled = true
+			result.Error = fmt.Errorf("%w during %s", ErrCancelled, def.name)
+			step.Error = result.Error
+			r.stepFinished()
+			r.cancelCleanup()
+			r.endAttempt(StatusCancelled, result.Error)
+			r.notify()
+			return result, result.Error
+		case err == errStepSkipped:
+			step.Status = StatusSkipped
+		case err != nil:
+			step.Status = StatusFailed
+			step.Error = err
+			result.Error = err
+			r.stepFinished()
+			r.notify()
+			// Before any rollback, which would discard the logs
+			r.collectLogs(def.name)
+			r.refineSetupError(def.name)
+			r.notify()
+			if r.shouldRollback(def.name) {
+				r.rollback(def.name)
+			}
+			r.endAttempt(StatusFailed, err)
+			r.notify()
+			return result, err
+		default:
+			step.Status = StatusCompleted
+		}
+		r.stepFinished()
+		r.notify()
 	}
 
-	// 3. Mount ISO
-	debug.Log("Step 3: Mounting ISO...")
-	debug.Log("ISO path: %s", opts.ISOPath)
-	if err := MountISO(ctx, vm, opts.ISOPath); err != nil {
-		debug.LogError("MountISO", err, "VM", opts.VMInfo.Name, "ISOPath", opts.ISOPath)
-		return fmt.Errorf("mount iso: %w", err)
-	}
-	debug.LogSuccess("MountISO", "VM", opts.VMInfo.Name, "ISOPath", opts.ISOPath)
+	result.Success = true
+	r.endAttempt(StatusCompleted, nil)
+	r.notify()
+	debug.LogSuccess("UpgradeSingleVM", "VM", opts.VMInfo.Name)
+	return result, nil
+}
 
-	// 4. Prepare guest credentials and setup post-reboot signaling BEFORE upgrade
-	debug.Log("Step 4: Preparing guest credentials and post-reboot signaling...")
+// endAttempt records how the current attempt ended
+func (r *upgradeRun) endAttempt(status string, err error) {
+	a := &r.result.Attempts[len(r.result.Attempts)-1]
+	a.EndTime = time.Now()
+	a.Status = status
+	a.Error = err
+}
 
-	// Build username - add domain if not already present
+// guestCredentials builds the guest credentials for a VM. A username
+// without domain gets the VM's domain appended.
+func guestCredentials(opts UpgradeOptions) vcenter.GuestCreds {
 	username := opts.GuestUsername
 	if !strings.Contains(username, "\\") && !strings.Contains(username, "@") {
 		// No domain format found, add @domain if we have it
@@ -148,193 +260,15 @@ func UpgradeSingleVM(vm *object.VirtualMachine, opts UpgradeOptions) error {
 		}
 	}
 
-	gc := vcenter.GuestCreds{
+	return vcenter.GuestCreds{
 		User: username,
 		Pass: opts.GuestPassword,
 	}
-
-	// 4.5. Upload all PowerShell scripts to guest
-	debug.Log("Step 4.5: Uploading all PowerShell scripts to guest (BEFORE upgrade)...")
-	if err := uploadScriptsToGuest(ctx, vm, gc, opts.VMInfo.Name); err != nil {
-		debug.LogError("UploadScripts", err, "VM", opts.VMInfo.Name)
-		return fmt.Errorf("kunde inte ladda upp scripts: %w", err)
-	}
-
-	// 4.6. Run createsignaltasks.ps1 to set up post-reboot signaling
-	debug.Log("Step 4.6: Setting up post-reboot signal mechanisms (BEFORE upgrade)...")
-	if err := executeSignalTaskScript(ctx, vm, gc, opts.VMInfo.Name, opts.Config.Timeouts); err != nil {
-		debug.LogError("ExecuteSignalTaskScript", err, "VM", opts.VMInfo.Name)
-		// Not critical - continue anyway, but log warning
-		debug.Log("WARNING: Failed to set up signal task script, upgrade will continue but signal detection may fail")
-	} else {
-		debug.LogSuccess("SignalTaskSetup", "VM", opts.VMInfo.Name)
-	}
-
-	// 5. Guest upgrade script
-	debug.Log("Step 5: Starting guest upgrade script...")
-
-	pid, err := startGuestUpgrade(ctx, vm, gc)
-	if err != nil {
-		debug.LogError("StartGuestUpgrade", err, "VM", opts.VMInfo.Name, "GuestUser", opts.GuestUsername)
-		return fmt.Errorf("guest script: %w", err)
-	}
-	debug.LogSuccess("StartGuestUpgrade", "VM", opts.VMInfo.Name, "PID", pid)
-
-	// 6. Wait for script to complete
-	debug.Log("Step 6: Waiting for upgrade script to complete (PID: %d)...", pid)
-	exitCode, err := waitForProcessExit(ctx, vm, gc, pid, opts.VMInfo.Name)
-	if err != nil {
-		debug.LogError("WaitForProcessExit", err, "VM", opts.VMInfo.Name, "PID", pid)
-		return fmt.Errorf("script wait: %w", err)
-	}
-	if exitCode != 0 {
-		debug.LogError("ScriptExitCode", fmt.Errorf("non-zero exit code"), "VM", opts.VMInfo.Name, "ExitCode", exitCode)
-		return fmt.Errorf("upgrade script failed with exit code %d", exitCode)
-	}
-	debug.LogSuccess("ScriptCompleted", "VM", opts.VMInfo.Name, "ExitCode", exitCode)
-
-	// 7. Wait for guest to shut down (script is done but Windows may take time to stop) and handle shutdown/power cycle
-	debug.Log("Step 7: Giving Windows 60 seconds before checking power state...")
-	select {
-	case <-time.After(60 * time.Second):
-	case <-ctx.Done():
-		return fmt.Errorf("cancelled before shutdown check could run: %w", ctx.Err())
-	}
-
-	shutdownTimeout := time.Duration(opts.Config.Timeouts.PowerOffMinutes) * time.Minute
-	if shutdownTimeout <= 0 {
-		shutdownTimeout = 5 * time.Minute
-	}
-	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, shutdownTimeout)
-	defer shutdownCancel()
-
-	abortGuestCheck := false
-
-	pollTicker := time.NewTicker(15 * time.Second)
-	defer pollTicker.Stop()
-
-waitForPowerOff:
-	for {
-		select {
-		case <-shutdownCtx.Done():
-			debug.Log("WARNING: Guest shutdown timeout reached, attempting forced power off...")
-			abortGuestCheck = true
-			break waitForPowerOff
-		case <-pollTicker.C:
-			var o mo.VirtualMachine
-			if err := vm.Properties(shutdownCtx, vm.Reference(), []string{"runtime.powerState"}, &o); err != nil {
-				debug.Log("[%s] WARNING: Failed to query runtime.powerState while waiting for shutdown: %v", opts.VMInfo.Name, err)
-				continue
-			}
-			if o.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOff {
-				debug.Log("Step 7: VM is powered off")
-				break waitForPowerOff
-			}
-		}
-	}
-
-	if abortGuestCheck {
-		debug.Log("Step 7: Forcing power off via vCenter...")
-		powerOffCtx, powerOffCancel := context.WithTimeout(ctx, 10*time.Minute)
-		defer powerOffCancel()
-		powerOffTask, err := vm.PowerOff(powerOffCtx)
-		if err != nil {
-			debug.LogError("PowerOff", err, "VM", opts.VMInfo.Name)
-			return fmt.Errorf("power off: %w", err)
-		}
-		if err := powerOffTask.Wait(powerOffCtx); err != nil {
-			debug.LogError("PowerOffWait", err, "VM", opts.VMInfo.Name)
-			return fmt.Errorf("power off wait: %w", err)
-		}
-		debug.LogSuccess("PowerOff", "VM", opts.VMInfo.Name)
-	}
-
-	debug.Log("Step 7: Waiting 60 seconds before powering on via vCenter...")
-	powerOnDelay := time.NewTimer(60 * time.Second)
-	defer powerOnDelay.Stop()
-
-	select {
-	case <-ctx.Done():
-		return fmt.Errorf("cancelled before power on could run: %w", ctx.Err())
-	case <-powerOnDelay.C:
-	}
-
-	powerOnCtx, powerOnCancel := context.WithTimeout(ctx, 10*time.Minute)
-	defer powerOnCancel()
-
-	powerOnTask, err := vm.PowerOn(powerOnCtx)
-	if err != nil {
-		debug.LogError("PowerOn", err, "VM", opts.VMInfo.Name)
-		return fmt.Errorf("power on: %w", err)
-	}
-	if err := powerOnTask.Wait(powerOnCtx); err != nil {
-		debug.LogError("PowerOnWait", err, "VM", opts.VMInfo.Name)
-		return fmt.Errorf("power on wait: %w", err)
-	}
-	debug.LogSuccess("PowerOn", "VM", opts.VMInfo.Name)
-
-	// Short wait before continuing so VMware Tools can initialize
-	time.Sleep(20 * time.Second)
-
-	// 8.5. Verify that OS version matches target version
-	targetOS := []string{"windows server 2022", "windows server 2025"}
-	debug.Log("Step 8.5: Validating guest OS version against targets: %v...", targetOS)
-	if err := waitForTargetOS(ctx, vm, targetOS, opts.VMInfo.Name, time.Duration(opts.Config.Timeouts.TargetOSMinutes)*time.Minute); err != nil {
-		debug.LogError("WaitForTargetOS", err, "VM", opts.VMInfo.Name)
-		return fmt.Errorf("os version: %w", err)
-	}
-	debug.LogSuccess("TargetOSDetected", "VM", opts.VMInfo.Name)
-
-	// 9. Verify Windows is ready by waiting for signal file from scheduled task
-	debug.Log("Step 9: Waiting for post-reboot task signal file...")
-	if err := waitForPostRebootSignals(ctx, vm, gc, opts.VMInfo.Name, opts.Config.Timeouts); err != nil {
-		// Check if it's a timeout error
-		if strings.Contains(err.Error(), "LOGONUI_TIMEOUT") {
-			// Log warning but continue anyway
-			debug.Log("WARNING: Task signal file not created within timeout - server %s should be checked manually", opts.VMInfo.Name)
-			debug.Log("WARNING: %v", err)
-		} else {
-			// Other error, abort upgrade
-			debug.LogError("WaitForSignalFiles", err, "VM", opts.VMInfo.Name)
-			return fmt.Errorf("signal file check: %w", err)
-		}
-	} else {
-		debug.LogSuccess("WaitForSignalFiles", "VM", opts.VMInfo.Name)
-	}
-
-	// 10. Unmount ISO
-	debug.Log("Step 10: Unmounting ISO...")
-	if err := UnmountISO(context.Background(), vm); err != nil {
-		debug.Log("WARNING: unmount ISO failed: %v", err)
-	} else {
-		debug.LogSuccess("UnmountISO", "VM", opts.VMInfo.Name)
-	}
-
-	debug.LogSuccess("UpgradeSingleVM", "VM", opts.VMInfo.Name)
-	return nil
 }
 
-func startGuestUpgrade(ctx context.Context, vm *object.VirtualMachine, gc vcenter.GuestCreds) (int64, error) {
-	c := vm.Client()
-
-	debug.Log("Creating guest OperationsManager...")
-
-	// Create OperationsManager for guest operations
-	opsMgr := guest.NewOperationsManager(c, vm.Reference())
-
-	// Get ProcessManager
-	pm, err := opsMgr.ProcessManager(ctx)
-	if err != nil {
-		debug.LogError("GetProcessManager", err)
-		return 0, fmt.Errorf("could not get ProcessManager: %w", err)
-	}
-
-	debug.Log("ProcessManager created successfully")
-
+func startGuestUpgrade(ctx context.Context, g GuestOps, gc vcenter.GuestCreds, editions map[string]config.EditionMapping) (int64, error) {
 	// Validate credentials FIRST before trying to run anything
 	// This prevents account lockout from repeated failed attempts
-	auth := &types.NamePasswordAuthentication{Username: gc.User, Password: gc.Pass}
-
 	debug.Log("=== AUTHENTICATION DEBUG ===")
 	debug.Log("Username (raw): %s", gc.User)
 	//debug.Log("Password (raw): %s", gc.Pass) // Kommenterad av säkerhetsskäl
@@ -344,16 +278,8 @@ func startGuestUpgrade(ctx context.Context, vm *object.VirtualMachine, gc vcente
 	debug.Log("Username contains @: %v", strings.Contains(gc.User, "@"))
 	debug.Log("===========================")
 
-	// Get AuthManager to validate credentials
-	am, err := opsMgr.AuthManager(ctx)
-	if err != nil {
-		debug.LogError("GetAuthManager", err)
-		return 0, fmt.Errorf("could not get AuthManager: %w", err)
-	}
-
 	// Validate credentials before trying to start anything
-	err = am.ValidateCredentials(ctx, auth)
-	if err != nil {
+	if err := g.ValidateCredentials(ctx); err != nil {
 		debug.LogError("ValidateCredentials", err,
 			"Username", gc.User,
 			"PasswordLength", len(gc.Pass),
@@ -372,7 +298,14 @@ func startGuestUpgrade(ctx context.Context, vm *object.VirtualMachine, gc vcente
 	}
 	defer cleanup()
 
+	// Run the script as a script block so the product keys and image
+	// indexes can be passed as its parameters
+	script = "& {\n" + script + "\n} " + scriptArguments(editions)
+
 	debug.Log("PowerShell script prepared")
+	for edition, m := range editions {
+		debug.Log("Edition %s: key %s, image index core %d / desktop %d", edition, maskKey(m.ProductKey), m.ImageIndexCore, m.ImageIndexDesktop)
+	}
 
 	encoded := encodePowerShell(script)
 
@@ -389,7 +322,7 @@ func startGuestUpgrade(ctx context.Context, vm *object.VirtualMachine, gc vcente
 	debug.Log("Working dir: %s", spec.WorkingDirectory)
 
 	// Use the validated auth (credentials already validated above)
-	pid, err := pm.StartProgram(ctx, auth, spec)
+	pid, err := g.StartProgram(ctx, spec)
 	if err != nil {
 		debug.LogError("ProcessManager.StartProgram", err,
 			"ProgramPath", spec.ProgramPath,
@@ -404,16 +337,8 @@ func startGuestUpgrade(ctx context.Context, vm *object.VirtualMachine, gc vcente
 	return pid, nil
 }
 
-func waitForProcessExit(ctx context.Context, vm *object.VirtualMachine, gc vcenter.GuestCreds, pid int64, serverName string) (int32, error) {
-	c := vm.Client()
-	opsMgr := guest.NewOperationsManager(c, vm.Reference())
-	pm, err := opsMgr.ProcessManager(ctx)
-	if err != nil {
-		return -1, fmt.Errorf("could not get ProcessManager: %w", err)
-	}
-
-	auth := &types.NamePasswordAuthentication{Username: gc.User, Password: gc.Pass}
-	ticker := time.NewTicker(15 * time.Second)
+func waitForProcessExit(ctx context.Context, g GuestOps, pid int64, serverName string) (int32, error) {
+	ticker := time.NewTicker(timeScaleOf(g).scaled(15 * time.Second))
 	defer ticker.Stop()
 
 	debug.Log("[%s] Polling for process exit (PID: %d)...", serverName, pid)
@@ -424,7 +349,7 @@ func waitForProcessExit(ctx context.Context, vm *object.VirtualMachine, gc vcent
 			return -1, ctx.Err()
 		case <-ticker.C:
 			// ListProcesses returns list with process info
-			procs, err := pm.ListProcesses(ctx, auth, []int64{pid})
+			procs, err := g.ListProcesses(ctx, []int64{pid})
 			if err != nil {
 				debug.Log("[%s] WARNING: ListProcesses error: %v", serverName, err)
 				continue
@@ -447,12 +372,41 @@ func waitForProcessExit(ctx context.Context, vm *object.VirtualMachine, gc vcent
 	}
 }
 
-func waitForTargetOS(ctx context.Context, vm *object.VirtualMachine, targets []string, serverName string, timeout time.Duration) error {
-	ticker := time.NewTicker(45 * time.Second)
+// runTimeout is the time a run of the VM may take. A compatibility scan
+// still to run has a timeout of its own, which is added so the scan does not
+// use up the time of the upgrade.
+func runTimeout(opts UpgradeOptions) time.Duration {
+	timeout := time.Duration(opts.Config.Upgrade.TimeoutMinutes) * time.Minute
+	if !opts.Config.Upgrade.CompatScan {
+		return timeout
+	}
+	if res := opts.Resume; res != nil {
+		if s := res.Step(StepCompatScan); s != nil && (s.Status == StatusCompleted || s.Status == StatusSkipped) {
+			return timeout
+		}
+	}
+	return timeout + time.Duration(opts.Config.Timeouts.CompatScanMinutes)*time.Minute
+}
+
+// waitForTargetOS waits until the running guest reports an OS name that
+// contains one of targets, or the build number build. Empty targets and a
+// zero build never match.
+func waitForTargetOS(ctx context.Context, vm VM, targets []string, build int, serverName string, timeout time.Duration) error {
+	clock := timeScaleOf(vm)
+	ticker := time.NewTicker(clock.scaled(45 * time.Second))
 	defer ticker.Stop()
-	lowerTargets := make([]string, len(targets))
-	for i, t := range targets {
-		lowerTargets[i] = strings.ToLower(t)
+	var lowerTargets []string
+	for _, t := range targets {
+		if t != "" {
+			lowerTargets = append(lowerTargets, strings.ToLower(t))
+		}
+	}
+	if len(lowerTargets) == 0 && build == 0 {
+		return errors.New("no target OS or build to wait for")
+	}
+	props := []string{"guest.guestFullName", "guest.toolsRunningStatus"}
+	if build != 0 {
+		props = append(props, "config.extraConfig")
 	}
 
 	consecutiveErrors := 0
@@ -460,10 +414,10 @@ func waitForTargetOS(ctx context.Context, vm *object.VirtualMachine, targets []s
 
 	var timeoutCh <-chan time.Time
 	if timeout > 0 {
-		timeoutCh = time.After(timeout)
+		timeoutCh = time.After(clock.scaled(timeout))
 	}
 
-	debug.Log("[%s] Polling for OS version change (target: %v, timeout: %v)...", serverName, targets, timeout)
+	debug.Log("[%s] Polling for OS version change (target: %v, build: %d, timeout: %v)...", serverName, lowerTargets, build, timeout)
 
 	for {
 		select {
@@ -473,7 +427,7 @@ func waitForTargetOS(ctx context.Context, vm *object.VirtualMachine, targets []s
 			return fmt.Errorf("timeout while waiting for OS version to match %v (waited %v)", targets, timeout)
 		case <-ticker.C:
 			var o mo.VirtualMachine
-			if err := vm.Properties(ctx, vm.Reference(), []string{"guest.guestFullName", "guest.toolsRunningStatus"}, &o); err != nil {
+			if err := vm.Properties(ctx, props, &o); err != nil {
 				consecutiveErrors++
 				debug.Log("[%s] WARNING: Properties error (%d/%d): %v", serverName, consecutiveErrors, maxConsecutiveErrors, err)
 				if consecutiveErrors >= maxConsecutiveErrors {
@@ -502,27 +456,30 @@ func waitForTargetOS(ctx context.Context, vm *object.VirtualMachine, targets []s
 						}
 					}
 				}
+				if b := buildOf(o); build != 0 && b == build {
+					debug.Log("[%s] Target build detected: %d", serverName, b)
+					return nil
+				}
 			}
 		}
 	}
 }
 
+// The boot-time task created by createsignaltasks.ps1 writes the signal file
+// once Windows is up after the upgrade
+const (
+	signalDir      = `C:\Temp`
+	signalFileName = "osupgrader_ready.txt"
+)
+
 // waitForPostRebootSignals verifies Windows is ready by looking for signal files
 // - Scheduled task signal: created by a scheduled task at startup
 // This is the most reliable method to know system is completely ready after reboot
-func waitForPostRebootSignals(ctx context.Context, vm *object.VirtualMachine, gc vcenter.GuestCreds, serverName string, timeouts config.TimeoutConfig) error {
-	c := vm.Client()
-	opsMgr := guest.NewOperationsManager(c, vm.Reference())
+func waitForPostRebootSignals(ctx context.Context, g GuestOps, serverName string, timeouts config.TimeoutConfig) error {
+	taskSignalFile := signalDir + "\\" + signalFileName
 
-	fm, err := opsMgr.FileManager(ctx)
-	if err != nil {
-		return fmt.Errorf("could not get FileManager: %w", err)
-	}
-
-	auth := &types.NamePasswordAuthentication{Username: gc.User, Password: gc.Pass}
-	taskSignalFile := "C:\\Temp\\osupgrader_ready.txt"
-
-	ticker := time.NewTicker(30 * time.Second)
+	clock := timeScaleOf(g)
+	ticker := time.NewTicker(clock.scaled(30 * time.Second))
 	defer ticker.Stop()
 
 	// Timeout for undersized servers
@@ -530,7 +487,7 @@ func waitForPostRebootSignals(ctx context.Context, vm *object.VirtualMachine, gc
 	if signalTimeout <= 0 {
 		signalTimeout = 30 * time.Minute
 	}
-	timeout := time.After(signalTimeout)
+	timeout := time.After(clock.scaled(signalTimeout))
 
 	debug.Log("[%s] Polling for post-reboot task signal file (every 30s, timeout %v)...", serverName, signalTimeout)
 	debug.Log("[%s] Task signal file: %s", serverName, taskSignalFile)
@@ -547,8 +504,8 @@ func waitForPostRebootSignals(ctx context.Context, vm *object.VirtualMachine, gc
 		case <-ticker.C:
 			// Check task signal file
 			if !taskFileFound {
-				_, err := fm.InitiateFileTransferFromGuest(ctx, auth, taskSignalFile)
-				if err == nil {
+				files, err := g.ListFiles(ctx, signalDir, "^"+regexp.QuoteMeta(signalFileName)+"$")
+				if err == nil && len(files) > 0 {
 					taskFileFound = true
 					debug.Log("[%s] ✓ Task signal file detected at %s", serverName, time.Now().Format("2006-01-02 15:04:05"))
 				}
@@ -560,26 +517,8 @@ func waitForPostRebootSignals(ctx context.Context, vm *object.VirtualMachine, gc
 				debug.Log("[%s] Running cleanup script to remove signal file...", serverName)
 
 				// Run cleanup.ps1 to remove signal files
-				cleanupScript, cleanupCleanup, err := extractAndReadCleanupScript()
-				if err != nil {
-					debug.Log("WARNING: Could not extract cleanup script: %v", err)
-				} else {
-					defer cleanupCleanup()
-
-					pm, pmErr := opsMgr.ProcessManager(ctx)
-					if pmErr == nil {
-						encoded := encodePowerShell(cleanupScript)
-						spec := &types.GuestProgramSpec{
-							ProgramPath: "C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\powershell.exe",
-							Arguments:   "-NoLogo -NonInteractive -ExecutionPolicy Bypass -EncodedCommand " + encoded,
-						}
-						_, err := pm.StartProgram(ctx, auth, spec)
-						if err != nil {
-							debug.Log("WARNING: Cleanup script failed: %v", err)
-						} else {
-							debug.LogSuccess("CleanupScript", "Server", serverName)
-						}
-					}
+				if err := runCleanupScript(ctx, g, serverName); err != nil {
+					debug.Log("WARNING: Cleanup script failed: %v", err)
 				}
 
 				return nil
@@ -593,12 +532,53 @@ func waitForPostRebootSignals(ctx context.Context, vm *object.VirtualMachine, gc
 	}
 }
 
-// func truncateGVLK(key string) string {
-// 	if len(key) <= 10 {
-// 		return "***MASKED***"
-// 	}
-// 	return key[:5] + "-*****-*****-" + key[len(key)-5:]
-// }
+// runCleanupScript starts cleanup.ps1 in the guest, which removes the signal
+// task and the uploaded helper scripts
+func runCleanupScript(ctx context.Context, g GuestOps, serverName string) error {
+	cleanupScript, cleanup, err := extractAndReadCleanupScript()
+	if err != nil {
+		return fmt.Errorf("could not extract cleanup script: %w", err)
+	}
+	defer cleanup()
+
+	spec := &types.GuestProgramSpec{
+		ProgramPath: "C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\powershell.exe",
+		Arguments:   "-NoLogo -NonInteractive -ExecutionPolicy Bypass -EncodedCommand " + encodePowerShell(cleanupScript),
+	}
+	if _, err := g.StartProgram(ctx, spec); err != nil {
+		return fmt.Errorf("could not start cleanup script: %w", err)
+	}
+
+	debug.LogSuccess("CleanupScript", "Server", serverName)
+	return nil
+}
+
+// terminateGuestUpgrade stops the guest upgrade script and any Windows Setup
+// processes it started. This is only safe while setup is still in its
+// downlevel phase, i.e. before the script has exited.
+func terminateGuestUpgrade(ctx context.Context, g GuestOps, pid int64, serverName string) error {
+	// Setup runs as child processes that outlive the PowerShell wrapper
+	procs, err := g.ListProcesses(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not list guest processes: %w", err)
+	}
+
+	var firstErr error
+	for _, proc := range procs {
+		if proc.EndTime != nil {
+			continue
+		}
+		name := strings.ToLower(proc.Name)
+		if proc.Pid != pid && name != "setup.exe" && name != "setuphost.exe" {
+			continue
+		}
+		debug.Log("[%s] Terminating guest process %s (PID: %d)", serverName, proc.Name, proc.Pid)
+		if err := g.TerminateProcess(ctx, proc.Pid); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("could not terminate %s (PID %d): %w", proc.Name, proc.Pid, err)
+		}
+	}
+	return firstErr
+}
 
 // encodePowerShell UTF-16LE + base64
 func encodePowerShell(s string) string {
@@ -615,76 +595,53 @@ func encodePowerShell(s string) string {
 // extractAndReadPowerShellScript extracts PowerShell script from embedded FS
 // to user's home directory and reads it as a string
 func extractAndReadPowerShellScript() (string, func(), error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", nil, fmt.Errorf("could not find home directory: %w", err)
-	}
-
-	debug.Log("Extrating PowerShell-script to %s...", homeDir)
-
-	// Extract file to home directory
-	extractedPath, cleanup, err := efs.ExtractFile(assetsFS, "assets/upgradeos.ps1", "osupgrader_", homeDir)
-	if err != nil {
-		return "", nil, fmt.Errorf("could not extract PowerShell script: %w", err)
-	}
-
-	debug.Log("PowerShell-script extraherat till: %s", extractedPath)
-
-	// Read file as string
-	content, err := os.ReadFile(extractedPath)
-	if err != nil {
-		cleanup()
-		return "", nil, fmt.Errorf("could not read PowerShell script: %w", err)
-	}
-
-	debug.LogSuccess("ExtractPowerShellScript", "Path", extractedPath, "Size", len(content))
-
-	return string(content), cleanup, nil
+	return extractAndReadScript("assets/upgradeos.ps1", "osupgrader_")
 }
 
 // extractAndReadCleanupScript extracts cleanup PowerShell script from embedded FS
 // to user's home directory and reads it as a string
 func extractAndReadCleanupScript() (string, func(), error) {
+	return extractAndReadScript("assets/cleanup.ps1", "cleanup_")
+}
+
+// extractAndReadCompatScript extracts the compatibility scan script from
+// embedded FS to user's home directory and reads it as a string
+func extractAndReadCompatScript() (string, func(), error) {
+	return extractAndReadScript("assets/compatscan.ps1", "compatscan_")
+}
+
+// extractAndReadScript extracts an embedded script to the user's home
+// directory and reads it as a string
+func extractAndReadScript(embeddedPath, prefix string) (string, func(), error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", nil, fmt.Errorf("could not find home directory: %w", err)
 	}
 
-	debug.Log("Extracting cleanup-script to %s...", homeDir)
+	debug.Log("Extracting %s to %s...", embeddedPath, homeDir)
 
 	// Extract file to home directory
-	extractedPath, cleanup, err := efs.ExtractFile(assetsFS, "assets/cleanup.ps1", "cleanup_", homeDir)
+	extractedPath, cleanup, err := efs.ExtractFile(assetsFS, embeddedPath, prefix, homeDir)
 	if err != nil {
-		return "", nil, fmt.Errorf("could not extract cleanup script: %w", err)
+		return "", nil, fmt.Errorf("could not extract %s: %w", embeddedPath, err)
 	}
 
-	debug.Log("Cleanup-script extraced to: %s", extractedPath)
+	debug.Log("%s extracted to: %s", embeddedPath, extractedPath)
 
 	// Read file as string
 	content, err := os.ReadFile(extractedPath)
 	if err != nil {
 		cleanup()
-		return "", nil, fmt.Errorf("could not read cleanup script: %w", err)
+		return "", nil, fmt.Errorf("could not read %s: %w", embeddedPath, err)
 	}
 
-	debug.LogSuccess("ExtractCleanupScript", "Path", extractedPath, "Size", len(content))
+	debug.LogSuccess("ExtractScript", "Path", extractedPath, "Size", len(content))
 
 	return string(content), cleanup, nil
 }
 
 // uploadFileToGuest uploads a file from embedded FS to guest via VMware FileManager
-func uploadFileToGuest(ctx context.Context, vm *object.VirtualMachine, gc vcenter.GuestCreds, embeddedPath, guestPath, serverName string) error {
-	c := vm.Client()
-	opsMgr := guest.NewOperationsManager(c, vm.Reference())
-
-	// Get FileManager
-	fm, err := opsMgr.FileManager(ctx)
-	if err != nil {
-		return fmt.Errorf("could not get FileManager: %w", err)
-	}
-
-	auth := &types.NamePasswordAuthentication{Username: gc.User, Password: gc.Pass}
-
+func uploadFileToGuest(ctx context.Context, g GuestOps, embeddedPath, guestPath, serverName string) error {
 	debug.Log("[%s] Extracting %s locally...", serverName, embeddedPath)
 
 	// Extract script locally
@@ -707,34 +664,8 @@ func uploadFileToGuest(ctx context.Context, vm *object.VirtualMachine, gc vcente
 
 	debug.Log("[%s] Uploading to %s (%d bytes)...", serverName, guestPath, len(fileContent))
 
-	// Initiate file transfer
-	fileTransferInfo, err := fm.InitiateFileTransferToGuest(ctx, auth, guestPath, &types.GuestFileAttributes{}, int64(len(fileContent)), true)
-	if err != nil {
-		return fmt.Errorf("could not initiate file transfer: %w", err)
-	}
-
-	// Upload via HTTP PUT
-	req, err := http.NewRequestWithContext(ctx, "PUT", fileTransferInfo, bytes.NewReader(fileContent))
-	if err != nil {
-		return fmt.Errorf("could not create upload request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/octet-stream")
-	req.ContentLength = int64(len(fileContent))
-
-	var uploadErr error
-	err = c.Client.Do(ctx, req, func(resp *http.Response) error {
-		if resp.StatusCode != 200 && resp.StatusCode != 201 {
-			body, _ := io.ReadAll(resp.Body)
-			uploadErr = fmt.Errorf("file upload failed with status %d: %s", resp.StatusCode, string(body))
-		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("could not upload file: %w", err)
-	}
-	if uploadErr != nil {
-		return uploadErr
+	if err := g.Upload(ctx, guestPath, fileContent); err != nil {
+		return err
 	}
 
 	debug.LogSuccess("FileUpload", "Server", serverName, "Path", guestPath)
@@ -742,7 +673,7 @@ func uploadFileToGuest(ctx context.Context, vm *object.VirtualMachine, gc vcente
 }
 
 // uploadScriptsToGuest uploads all required PowerShell scripts to guest
-func uploadScriptsToGuest(ctx context.Context, vm *object.VirtualMachine, gc vcenter.GuestCreds, serverName string) error {
+func uploadScriptsToGuest(ctx context.Context, g GuestOps, serverName string) error {
 	debug.Log("[%s] Uploading all required PowerShell scripts to guest...", serverName)
 
 	scripts := []struct {
@@ -755,7 +686,7 @@ func uploadScriptsToGuest(ctx context.Context, vm *object.VirtualMachine, gc vce
 	}
 
 	for _, script := range scripts {
-		if err := uploadFileToGuest(ctx, vm, gc, script.embedded, script.guest, serverName); err != nil {
+		if err := uploadFileToGuest(ctx, g, script.embedded, script.guest, serverName); err != nil {
 			return fmt.Errorf("failed to upload %s: %w", script.embedded, err)
 		}
 	}
@@ -765,25 +696,15 @@ func uploadScriptsToGuest(ctx context.Context, vm *object.VirtualMachine, gc vce
 }
 
 // executeSignalTaskScript runs createsignaltasks.ps1 which already exists on guest
-func executeSignalTaskScript(ctx context.Context, vm *object.VirtualMachine, gc vcenter.GuestCreds, serverName string, timeouts config.TimeoutConfig) error {
-	c := vm.Client()
-	opsMgr := guest.NewOperationsManager(c, vm.Reference())
-
-	auth := &types.NamePasswordAuthentication{Username: gc.User, Password: gc.Pass}
-
+func executeSignalTaskScript(ctx context.Context, g GuestOps, serverName string, timeouts config.TimeoutConfig) error {
 	debug.Log("[%s] Executing createsignaltasks.ps1...", serverName)
 
-	pm, err := opsMgr.ProcessManager(ctx)
-	if err != nil {
-		return fmt.Errorf("could not get ProcessManager: %w", err)
-	}
-
 	spec := &types.GuestProgramSpec{
 		ProgramPath: "C:\\Windows\\System32\\WindowsPowerShell\\v1.0\\powershell.exe",
 		Arguments:   "-NoProfile -ExecutionPolicy Bypass -File C:\\Temp\\createsignaltasks.ps1",
 	}
 
-	pid, err := pm.StartProgram(ctx, auth, spec)
+	pid, err := g.StartProgram(ctx, spec)
 	if err != nil {
 		return fmt.Errorf("could not start script: %w", err)
 	}
@@ -791,14 +712,15 @@ func executeSignalTaskScript(ctx context.Context, vm *object.VirtualMachine, gc
 	debug.Log("[%s] Script startat, PID: %d", serverName, pid)
 
 	// Wait for script to complete
-	ticker := time.NewTicker(5 * time.Second)
+	clock := timeScaleOf(g)
+	ticker := time.NewTicker(clock.scaled(5 * time.Second))
 	defer ticker.Stop()
 
 	timeoutSeconds := timeouts.SignalScriptSeconds
 	if timeoutSeconds <= 0 {
 		timeoutSeconds = 30
 	}
-	timeout := time.After(time.Duration(timeoutSeconds) * time.Second)
+	timeout := time.After(clock.scaled(time.Duration(timeoutSeconds) * time.Second))
 
 	for {
 		select {
@@ -808,7 +730,7 @@ func executeSignalTaskScript(ctx context.Context, vm *object.VirtualMachine, gc
 			debug.Log("[%s] WARNING: Script timeout (%ds), continuing anyway...", serverName, timeoutSeconds)
 			return nil
 		case <-ticker.C:
-			procs, err := pm.ListProcesses(ctx, auth, []int64{pid})
+			procs, err := g.ListProcesses(ctx, []int64{pid})
 			if err != nil {
 				debug.Log("[%s] WARNING: ListProcesses error: %v", serverName, err)
 				continue
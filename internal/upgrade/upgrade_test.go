@@ -0,0 +1,720 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
+)
+
+// testTimeCompression makes guest phases of minutes take milliseconds
+const testTimeCompression = 1000
+
+// newTestVM returns a FakeVM that runs testTimeCompression times faster
+// than real time
+func newTestVM() *FakeVM {
+	f := NewFakeVM()
+	f.TimeCompression = testTimeCompression
+	return f
+}
+
+func TestMain(m *testing.M) {
+	// Scripts are extracted to the home directory and guest logs and
+	// inventories are written next to the configuration
+	home, err := os.MkdirTemp("", "osupgrader-test-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("USERPROFILE", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// testOptions upgrades a Windows Server 2016 FakeVM to 2022 with a snapshot
+// and the inventory comparison
+func testOptions(vmName string) UpgradeOptions {
+	return UpgradeOptions{
+		VMInfo:         vcenter.VMInfo{Name: vmName, OS: "Microsoft Windows Server 2016 (64-bit)"},
+		GuestUsername:  "Administrator",
+		GuestPassword:  "secret",
+		ISOPath:        "[datastore1] iso/windows-server-2022.iso",
+		CreateSnapshot: true,
+		SnapshotName:   "pre-upgrade-" + vmName,
+		Config: &config.AppConfig{
+			Defaults: config.DefaultsConfig{SnapshotNamePrefix: "pre-upgrade", SkipMemoryInSnapshot: true},
+			Upgrade:  config.UpgradeConfig{TimeoutMinutes: 150, PrecheckDiskGB: 10, Inventory: true},
+			Timeouts: config.TimeoutConfig{
+				SignalScriptSeconds: 30,
+				SignalFilesMinutes:  30,
+				TargetOSMinutes:     20,
+				PowerOffMinutes:     5,
+				CompatScanMinutes:   45,
+			},
+		},
+		Profile: config.TargetProfile{
+			Name:           "Windows Server 2022",
+			TargetOS:       "Windows Server 2022",
+			TargetBuild:    20348,
+			AllowedSources: []string{"Windows Server 2016"},
+		},
+	}
+}
+
+// setupRuns counts the times setup.exe was started in the guest
+func setupRuns(f *FakeVM) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, p := range f.procs {
+		if p.info.Name == "setup.exe" {
+			n++
+		}
+	}
+	return n
+}
+
+// guestOS returns the OS the guest reports
+func guestOS(f *FakeVM) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+	return f.os
+}
+
+// mountedISO returns the ISO connected to the VM's CD-ROM, "" if none
+func mountedISO(f *FakeVM) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.iso
+}
+
+// stepStatus returns the status of a step in res
+func stepStatus(t *testing.T, res *UpgradeResult, name string) string {
+	t.Helper()
+	s := res.Step(name)
+	if s == nil {
+		t.Fatalf("result has no step %s", name)
+	}
+	return s.Status
+}
+
+// interruptAt starts an upgrade of f and freezes it when step starts, as if
+// the application had crashed there, and returns the result recorded up to
+// that point. The frozen run is cancelled when the test ends.
+func interruptAt(t *testing.T, f *FakeVM, opts UpgradeOptions, step string) *UpgradeResult {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	recorded := make(chan *UpgradeResult, 1)
+	done := make(chan struct{})
+	opts.Context = ctx
+	opts.Observers = []Observer{ObserverFunc(func(e Event) {
+		if e.Kind == EventStepStarted && e.Step == step {
+			recorded <- e.Result
+			<-ctx.Done()
+		}
+	})}
+	go func() {
+		defer close(done)
+		UpgradeSingleVM(f, opts)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	select {
+	case res := <-recorded:
+		return res
+	case <-done:
+		t.Fatalf("run ended before step %s started", step)
+		return nil
+	}
+}
+
+func TestUpgradeSingleVM(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(f *FakeVM, opts *UpgradeOptions)
+		failed  string // step expected to fail, "" for success
+		check   func(t *testing.T, f *FakeVM, res *UpgradeResult)
+	}{
+		{
+			name: "upgrade",
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				for _, name := range []string{StepPrecheck, StepSnapshot, StepMount, StepUpload, StepSignal, StepInventory,
+					StepSetup, StepWaitExit, StepPowerCycle, StepVerifyOS, StepSignalWait, StepUnmount, StepInventoryDiff} {
+					if got := stepStatus(t, res, name); got != StatusCompleted {
+						t.Errorf("step %s is %s, want %s", name, got, StatusCompleted)
+					}
+				}
+				for _, name := range []string{StepCompatScan, StepPreHooks, StepPostHooks, StepHealth} {
+					if got := stepStatus(t, res, name); got != StatusSkipped {
+						t.Errorf("step %s is %s, want %s", name, got, StatusSkipped)
+					}
+				}
+				if _, ok := f.snapshots[res.SnapshotName]; !ok {
+					t.Errorf("snapshot %s not taken", res.SnapshotName)
+				}
+				if res.Inventory == nil || len(res.Inventory.Changes) == 0 {
+					t.Errorf("inventory changes not recorded: %+v", res.Inventory)
+				}
+			},
+		},
+		{
+			name: "without snapshot",
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				opts.CreateSnapshot = false
+			},
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if got := stepStatus(t, res, StepSnapshot); got != StatusSkipped {
+					t.Errorf("snapshot step is %s, want %s", got, StatusSkipped)
+				}
+				if len(f.snapshots) != 0 {
+					t.Errorf("%d snapshots taken, want none", len(f.snapshots))
+				}
+			},
+		},
+		{
+			name: "profile with only a build",
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				opts.Profile.TargetOS = ""
+			},
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if got := guestOS(f); got != f.TargetOS {
+					t.Errorf("guest OS %q, want %q", got, f.TargetOS)
+				}
+				if w := res.Step(StepVerifyOS).Warning; w != "" {
+					t.Errorf("verify-os warning %q", w)
+				}
+			},
+		},
+		{
+			name: "setup failure",
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				f.SetupExitCode = 0xC1900208
+			},
+			failed: StepWaitExit,
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if res.SetupError == nil || res.SetupError.Code != 0xC1900208 {
+					t.Errorf("setup error %+v, want code 0xC1900208", res.SetupError)
+				}
+				if res.Rollback != nil {
+					t.Errorf("rolled back without a rollback policy: %+v", res.Rollback)
+				}
+				if got := guestOS(f); got != f.SourceOS {
+					t.Errorf("guest OS %q, want %q", got, f.SourceOS)
+				}
+			},
+		},
+		{
+			name: "setup failure with rollback",
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				f.SetupExitCode = 0xC1900101
+				opts.Config.Upgrade.Rollback = config.RollbackConfig{Enabled: true, On: []string{FailureSetup}}
+			},
+			failed: StepWaitExit,
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				rb := res.Rollback
+				if rb == nil {
+					t.Fatal("no rollback")
+				}
+				if !rb.Reverted || !rb.Verified || rb.Error != nil {
+					t.Errorf("rollback %+v, want reverted and verified", rb)
+				}
+				if rb.FailureClass != FailureSetup {
+					t.Errorf("failure class %q, want %q", rb.FailureClass, FailureSetup)
+				}
+				if got := guestOS(f); got != f.SourceOS {
+					t.Errorf("guest OS after rollback %q, want %q", got, f.SourceOS)
+				}
+				if mountedISO(f) != "" {
+					t.Errorf("ISO still mounted after revert")
+				}
+			},
+		},
+		{
+			name: "rollback policy for other failures only",
+			prepare: func(f *FakeVM, opts *UpgradeOptions) {
+				f.SetupExitCode = 0xC1900208
+				opts.Config.Upgrade.Rollback = config.RollbackConfig{Enabled: true, On: []string{FailureBoot}}
+			},
+			failed: StepWaitExit,
+			check: func(t *testing.T, f *FakeVM, res *UpgradeResult) {
+				if res.Rollback != nil {
+					t.Errorf("setup failure rolled back with policy %v", []string{FailureBoot})
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := newTestVM()
+			opts := testOptions("srv01")
+			if tt.prepare != nil {
+				tt.prepare(f, &opts)
+			}
+
+			res, err := UpgradeSingleVM(f, opts)
+			if got := res.FailedStep(); got != tt.failed {
+				t.Fatalf("failed step %q, want %q (error: %v)", got, tt.failed, err)
+			}
+			if tt.failed == "" {
+				if err != nil || !res.Success {
+					t.Fatalf("upgrade failed: %v", err)
+				}
+				if got := guestOS(f); got != f.TargetOS {
+					t.Errorf("guest OS %q, want %q", got, f.TargetOS)
+				}
+				if mountedISO(f) != "" {
+					t.Errorf("ISO still mounted")
+				}
+			} else if err == nil || res.Success {
+				t.Fatalf("upgrade succeeded, want failure in %s", tt.failed)
+			}
+			if n := setupRuns(f); n != 1 {
+				t.Errorf("setup.exe started %d times, want 1", n)
+			}
+			if len(res.Attempts) != 1 {
+				t.Errorf("%d attempts, want 1", len(res.Attempts))
+			}
+			if tt.check != nil {
+				tt.check(t, f, res)
+			}
+		})
+	}
+}
+
+func TestWaitForTargetOS(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		build   int
+		wantErr string
+	}{
+		{name: "name", targets: []string{"Windows Server 2016"}},
+		{name: "build", targets: []string{""}, build: 14393},
+		{name: "other name", targets: []string{"Windows Server 2022"}, wantErr: "timeout"},
+		{name: "empty name", targets: []string{""}, build: 20348, wantErr: "timeout"},
+		{name: "nothing to wait for", targets: []string{""}, wantErr: "no target OS or build"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := waitForTargetOS(context.Background(), newTestVM(), tt.targets, tt.build, "srv01", 5*time.Minute)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompatScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string // of the scan script
+		wantErr string // "" for an upgrade without findings
+	}{
+		{name: "no issues", output: "exitcode=C1900210\r\n"},
+		{name: "blocked", output: "exitcode=C1900208\r\n", wantErr: "0xC1900208"},
+		{name: "no exit code", output: "setup.exe started\r\n", wantErr: "did not report a setup exit code"},
+		{name: "no output", wantErr: "did not report a setup exit code"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := newTestVM()
+			f.Script = func(script string) (string, int32, bool) {
+				return tt.output, 0, strings.Contains(script, "ScanOnly")
+			}
+			opts := testOptions("srv01")
+			opts.Config.Upgrade.CompatScan = true
+
+			res, err := UpgradeSingleVM(f, opts)
+			if tt.wantErr == "" {
+				if err != nil || !res.Success {
+					t.Fatalf("upgrade failed in %s: %v", res.FailedStep(), err)
+				}
+				if c := res.Compat; c == nil || c.ExitCode != scanNoIssues {
+					t.Errorf("scan result %+v, want 0x%08X", c, scanNoIssues)
+				}
+				return
+			}
+			if got := res.FailedStep(); got != StepCompatScan {
+				t.Fatalf("failed step %q, want %q (error: %v)", got, StepCompatScan, err)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+			if n := setupRuns(f); n != 0 {
+				t.Errorf("setup.exe started %d times after a failed scan", n)
+			}
+			if mountedISO(f) != "" {
+				t.Errorf("ISO still mounted after a failed scan")
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	scanned := NewUpgradeResult("srv01")
+	scanned.Step(StepCompatScan).Status = StatusCompleted
+
+	tests := []struct {
+		name   string
+		scan   bool
+		resume *UpgradeResult
+		want   time.Duration
+	}{
+		{name: "without scan", want: 150 * time.Minute},
+		{name: "with scan", scan: true, want: 195 * time.Minute},
+		{name: "resumed before the scan", scan: true, resume: NewUpgradeResult("srv01"), want: 195 * time.Minute},
+		{name: "resumed after the scan", scan: true, resume: scanned, want: 150 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions("srv01")
+			opts.Config.Upgrade.CompatScan = tt.scan
+			opts.Resume = tt.resume
+			if got := runTimeout(opts); got != tt.want {
+				t.Errorf("timeout %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUpgradeResume interrupts an upgrade at the start of every step and
+// resumes it from the recorded result, as a reattach does after a crash
+func TestUpgradeResume(t *testing.T) {
+	for _, step := range StepNames() {
+		t.Run(step, func(t *testing.T) {
+			t.Parallel()
+			f := newTestVM()
+			recorded := interruptAt(t, f, testOptions("srv01"), step)
+			if got := recorded.NextStep(); got != step {
+				t.Fatalf("recorded result continues at %s, want %s", got, step)
+			}
+
+			opts := testOptions("srv01")
+			opts.Resume = recorded
+			res, err := UpgradeSingleVM(f, opts)
+			if err != nil || !res.Success {
+				t.Fatalf("resumed upgrade failed in %s: %v", res.FailedStep(), err)
+			}
+			if got := guestOS(f); got != f.TargetOS {
+				t.Errorf("guest OS %q, want %q", got, f.TargetOS)
+			}
+			if n := setupRuns(f); n != 1 {
+				t.Errorf("setup.exe started %d times, want 1", n)
+			}
+
+			// Steps done before the interruption are not run again
+			for _, prev := range recorded.Steps {
+				if prev.Status != StatusCompleted && prev.Status != StatusSkipped {
+					continue
+				}
+				if s := res.Step(prev.Name); !s.StartTime.Equal(prev.StartTime) {
+					t.Errorf("step %s ran again", prev.Name)
+				}
+			}
+			if len(res.Attempts) != 2 || res.Attempts[1].FromStep != step || res.Attempts[1].Status != StatusCompleted {
+				t.Errorf("attempts %+v, want the second from %s completed", res.Attempts, step)
+			}
+		})
+	}
+}
+
+// TestUpgradeReattach resumes runs that stopped in the middle of a step, as
+// after a crash: the journal has the step in progress and the guest has
+// moved on without the tool
+func TestUpgradeReattach(t *testing.T) {
+	tests := []struct {
+		name      string
+		prepare   func(f *FakeVM)
+		interrupt string // step the run stops at
+		reopen    string // step the journal still has in progress
+		recorded  func(res *UpgradeResult)
+	}{
+		{
+			name:      "setup running",
+			prepare:   func(f *FakeVM) { f.SetupDuration = 10 * time.Minute },
+			interrupt: StepWaitExit,
+			reopen:    StepSetup,
+		},
+		{
+			name:      "setup running, PID not recorded",
+			prepare:   func(f *FakeVM) { f.SetupDuration = 10 * time.Minute },
+			interrupt: StepWaitExit,
+			reopen:    StepSetup,
+			recorded:  func(res *UpgradeResult) { res.GuestPID = 0 },
+		},
+		{
+			name:      "setup exited",
+			interrupt: StepWaitExit,
+			reopen:    StepSetup,
+			recorded:  func(res *UpgradeResult) { time.Sleep(timeScale(testTimeCompression).scaled(10 * time.Second)) },
+		},
+		{
+			name:      "guest rebooted",
+			interrupt: StepVerifyOS,
+			reopen:    StepPowerCycle,
+		},
+		{
+			name:      "guest powered off",
+			interrupt: StepPowerCycle,
+			reopen:    StepPowerCycle,
+			recorded:  func(res *UpgradeResult) { time.Sleep(timeScale(testTimeCompression).scaled(2 * time.Minute)) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := newTestVM()
+			if tt.prepare != nil {
+				tt.prepare(f)
+			}
+			recorded := interruptAt(t, f, testOptions("srv01"), tt.interrupt)
+			reopened := false
+			for i := range recorded.Steps {
+				s := &recorded.Steps[i]
+				if s.Name == tt.reopen {
+					reopened = true
+					s.Status, s.EndTime = StatusInProgress, time.Time{}
+				} else if reopened {
+					*s = UpgradeStep{Name: s.Name, Status: StatusPending}
+				}
+			}
+			if tt.recorded != nil {
+				tt.recorded(recorded)
+			}
+
+			// A guest that is not shut down by the tool must not be forced off
+			f.mu.Lock()
+			f.Errors = map[string]error{"PowerOff": errors.New("powered off again")}
+			booted := f.bootTime
+			f.mu.Unlock()
+
+			opts := testOptions("srv01")
+			opts.Resume = recorded
+			res, err := UpgradeSingleVM(f, opts)
+			if err != nil || !res.Success {
+				t.Fatalf("resumed upgrade failed in %s: %v", res.FailedStep(), err)
+			}
+			if n := setupRuns(f); n != 1 {
+				t.Errorf("setup.exe started %d times, want 1", n)
+			}
+			if tt.interrupt == StepVerifyOS {
+				f.mu.Lock()
+				again := !f.bootTime.Equal(booted)
+				f.mu.Unlock()
+				if again {
+					t.Errorf("guest rebooted again")
+				}
+			}
+		})
+	}
+}
+
+func TestUpgradeCancelDuringWaitExit(t *testing.T) {
+	f := newTestVM()
+	f.SetupDuration = 10 * time.Minute // still running when cancelled
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := testOptions("srv01")
+	opts.Context = ctx
+	opts.Observers = []Observer{ObserverFunc(func(e Event) {
+		// waitExit announces the PID it waits for once setup runs
+		if e.Kind == EventMessage && e.Step == StepWaitExit {
+			cancel()
+		}
+	})}
+
+	res, err := UpgradeSingleVM(f, opts)
+	if !errors.Is(err, ErrCancelled) || !res.Cancelled {
+		t.Fatalf("error %v, want %v", err, ErrCancelled)
+	}
+	if got := stepStatus(t, res, StepWaitExit); got != StatusCancelled {
+		t.Errorf("wait-exit is %s, want %s", got, StatusCancelled)
+	}
+
+	// The cleanup undoes setup, the signal task and the mount, a resumed
+	// run does them again
+	for _, name := range []string{StepMount, StepUpload, StepSignal, StepSetup} {
+		if got := stepStatus(t, res, name); got != StatusPending {
+			t.Errorf("step %s is %s after cleanup, want %s", name, got, StatusPending)
+		}
+	}
+	if res.GuestPID != 0 {
+		t.Errorf("guest PID %d kept after setup was terminated", res.GuestPID)
+	}
+	if iso := mountedISO(f); iso != "" {
+		t.Errorf("ISO %s still mounted", iso)
+	}
+	f.mu.Lock()
+	for _, p := range f.procs {
+		if p.info.EndTime == nil {
+			t.Errorf("%s (PID %d) still running", p.info.Name, p.info.Pid)
+		}
+	}
+	signalTask := f.signalTask
+	f.mu.Unlock()
+	if signalTask {
+		t.Errorf("signal task not removed")
+	}
+	if a := res.Attempts; len(a) != 1 || a[0].Status != StatusCancelled {
+		t.Errorf("attempts %+v, want one cancelled", a)
+	}
+
+	// Resuming the cancelled VM starts setup again and completes
+	opts = testOptions("srv01")
+	opts.Resume = res
+	f.SetupDuration = 3 * time.Second
+	res, err = UpgradeSingleVM(f, opts)
+	if err != nil || !res.Success {
+		t.Fatalf("resumed upgrade failed in %s: %v", res.FailedStep(), err)
+	}
+	if n := setupRuns(f); n != 2 {
+		t.Errorf("setup.exe started %d times, want 2", n)
+	}
+}
+
+// TestUpgradeRetryAfterSetupFailure retries a VM whose setup exited with an
+// error code, once the cause is fixed. Setup has to run again: the old
+// process is gone.
+func TestUpgradeRetryAfterSetupFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		stalePID bool // result recorded while the exited PID was still kept
+	}{
+		{name: "retry"},
+		{name: "result with the exited PID", stalePID: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := newTestVM()
+			f.SetupExitCode = 0x80070070
+			var pid int64
+			opts := testOptions("srv01")
+			opts.Observers = []Observer{ObserverFunc(func(e Event) {
+				if e.Kind == EventStepFinished && e.Step == StepSetup {
+					pid = e.Result.GuestPID
+				}
+			})}
+			res, err := UpgradeSingleVM(f, opts)
+			if got := res.FailedStep(); got != StepWaitExit {
+				t.Fatalf("failed step %q, want %s (error: %v)", got, StepWaitExit, err)
+			}
+			if tt.stalePID {
+				res.GuestPID = pid
+			}
+
+			// Disk space freed in the guest
+			f.mu.Lock()
+			f.SetupExitCode = 0
+			f.mu.Unlock()
+
+			opts = testOptions("srv01")
+			opts.Resume = res
+			res, err = UpgradeSingleVM(f, opts)
+			if err != nil || !res.Success {
+				t.Fatalf("retry failed in %s: %v", res.FailedStep(), err)
+			}
+			if n := setupRuns(f); n != 2 {
+				t.Errorf("setup.exe started %d times, want 2", n)
+			}
+			if res.GuestPID == pid {
+				t.Errorf("retry waited for the exited PID %d", pid)
+			}
+			if got := guestOS(f); got != f.TargetOS {
+				t.Errorf("guest OS %q, want %q", got, f.TargetOS)
+			}
+			if a := res.Attempts; len(a) != 2 || a[1].FromStep != StepSignal {
+				t.Errorf("attempts %+v, want the retry from %s", a, StepSignal)
+			}
+		})
+	}
+}
+
+// TestGuestHookTimeout lets a pre hook hang in the guest. Its timeout has to
+// stop it before setup starts, with on_failure continue the upgrade goes on.
+func TestGuestHookTimeout(t *testing.T) {
+	script := t.TempDir() + "/drain.ps1"
+	if err := os.WriteFile(script, []byte("Start-Sleep 3600"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestVM()
+	f.HangingHooks = []string{"drain"}
+	opts := testOptions("srv01")
+	opts.Config.Hooks = []config.HookConfig{
+		{Name: "drain", When: HookPre, GuestScript: script, TimeoutMinutes: 1, OnFailure: HookContinue},
+	}
+
+	res, err := UpgradeSingleVM(f, opts)
+	if err != nil || !res.Success {
+		t.Fatalf("upgrade failed in %s: %v", res.FailedStep(), err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var hookEnd, setupStart time.Time
+	for _, p := range f.procs {
+		if p.info.EndTime == nil {
+			t.Errorf("%s (PID %d) still running", p.info.Name, p.info.Pid)
+			continue
+		}
+		switch {
+		case p.info.Name == "setup.exe":
+			setupStart = p.info.StartTime
+		case p.info.ExitCode != 0 && strings.Contains(p.info.CmdLine, "-EncodedCommand"):
+			hookEnd = *p.info.EndTime
+		}
+	}
+	if hookEnd.IsZero() {
+		t.Fatal("hanging hook was not terminated")
+	}
+	if !hookEnd.Before(setupStart) {
+		t.Errorf("hook terminated at %v, after setup started at %v", hookEnd, setupStart)
+	}
+}
+
+// TestLocalHookTimeout times out a local hook whose background process
+// keeps its output open. The hook has to return at its timeout all the same,
+// with the background process killed rather than waited for.
+func TestLocalHookTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r := &upgradeRun{ctx: ctx, opts: testOptions("srv01"), result: NewUpgradeResult("srv01")}
+
+	start := time.Now()
+	err := r.runLocalHook(config.HookConfig{Name: "drain", When: HookPre, Command: "sleep 30 & sleep 30"})
+	if err == nil {
+		t.Fatal("hook succeeded, want a timeout")
+	}
+	if d := time.Since(start); d >= localHookWaitDelay {
+		t.Errorf("hook returned after %v", d.Round(time.Second))
+	}
+}
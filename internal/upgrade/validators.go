@@ -8,44 +8,22 @@ import (
 	"time"
 
 	"github.com/skabbio1976/osupgrader-gui/internal/vcenter"
-	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/view"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
 // ValidateISOPath checks that ISO path has correct format and that the datastore exists
-// NOTE: Does NOT check if the file actually exists - that happens at mount step
+// NOTE: Does NOT check if the file actually exists - ReadISOMetadata does
 func ValidateISOPath(ctx context.Context, isoPath string) error {
 	c := vcenter.GetCachedClient()
 	if c == nil {
 		return errors.New("no active govmomi client")
 	}
 
-	// Parse datastore path format: [datastore1] path/to/file.iso
-	isoPath = strings.TrimSpace(isoPath)
-	if !strings.HasPrefix(isoPath, "[") || !strings.Contains(isoPath, "]") {
-		return fmt.Errorf("invalid ISO path format (expects [datastore] path/file.iso): %s", isoPath)
-	}
-
-	// Extract datastore name and path
-	parts := strings.SplitN(isoPath, "]", 2)
-	if len(parts) != 2 {
-		return fmt.Errorf("could not parse ISO path: %s", isoPath)
-	}
-	dsName := strings.TrimSpace(strings.TrimPrefix(parts[0], "["))
-	filePath := strings.TrimSpace(parts[1])
-
-	// Remove leading slash if present for normalization
-	filePath = strings.TrimPrefix(filePath, "/")
-
-	if dsName == "" || filePath == "" {
-		return fmt.Errorf("datastore name or file path is empty: %s", isoPath)
-	}
-
-	// Check that filePath ends with .iso
-	if !strings.HasSuffix(strings.ToLower(filePath), ".iso") {
-		return fmt.Errorf("file path must end with .iso: %s", filePath)
+	dsName, _, err := parseISOPath(isoPath)
+	if err != nil {
+		return err
 	}
 
 	// Use view.Manager to find all datastores (official method)
@@ -84,14 +62,46 @@ func ValidateISOPath(ctx context.Context, isoPath string) error {
 	}
 
 	// If we got here, format is OK and datastore exists
-	// The actual file is checked at mount step
+	// The actual file is read by ReadISOMetadata
 	return nil
 }
 
+// parseISOPath splits "[datastore] path/file.iso" into datastore name and
+// file path
+func parseISOPath(isoPath string) (string, string, error) {
+	// Parse datastore path format: [datastore1] path/to/file.iso
+	isoPath = strings.TrimSpace(isoPath)
+	if !strings.HasPrefix(isoPath, "[") || !strings.Contains(isoPath, "]") {
+		return "", "", fmt.Errorf("invalid ISO path format (expects [datastore] path/file.iso): %s", isoPath)
+	}
+
+	// Extract datastore name and path
+	parts := strings.SplitN(isoPath, "]", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("could not parse ISO path: %s", isoPath)
+	}
+	dsName := strings.TrimSpace(strings.TrimPrefix(parts[0], "["))
+	filePath := strings.TrimSpace(parts[1])
+
+	// Remove leading slash if present for normalization
+	filePath = strings.TrimPrefix(filePath, "/")
+
+	if dsName == "" || filePath == "" {
+		return "", "", fmt.Errorf("datastore name or file path is empty: %s", isoPath)
+	}
+
+	// Check that filePath ends with .iso
+	if !strings.HasSuffix(strings.ToLower(filePath), ".iso") {
+		return "", "", fmt.Errorf("file path must end with .iso: %s", filePath)
+	}
+
+	return dsName, filePath, nil
+}
+
 // CheckUpgradeInProgress checks if an upgrade is already in progress on the VM
-func CheckUpgradeInProgress(ctx context.Context, vm *object.VirtualMachine) (bool, error) {
+func CheckUpgradeInProgress(ctx context.Context, vm VM) (bool, error) {
 	var o mo.VirtualMachine
-	if err := vm.Properties(ctx, vm.Reference(), []string{"guest.guestOperationsReady", "runtime.powerState"}, &o); err != nil {
+	if err := vm.Properties(ctx, []string{"guest.guestOperationsReady", "runtime.powerState"}, &o); err != nil {
 		return false, err
 	}
 
@@ -104,9 +114,9 @@ func CheckUpgradeInProgress(ctx context.Context, vm *object.VirtualMachine) (boo
 }
 
 // GetSystemDrive finds system drive (usually C:\) but can be other
-func GetSystemDrive(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+func GetSystemDrive(ctx context.Context, vm VM) (string, error) {
 	var o mo.VirtualMachine
-	if err := vm.Properties(ctx, vm.Reference(), []string{"guest.disk", "config.guestId"}, &o); err != nil {
+	if err := vm.Properties(ctx, []string{"guest.disk", "config.guestId"}, &o); err != nil {
 		return "", err
 	}
 
@@ -134,14 +144,14 @@ func GetSystemDrive(ctx context.Context, vm *object.VirtualMachine) (string, err
 }
 
 // WaitForToolsRunningWithTimeout waits for VMware Tools to become ready with timeout
-func WaitForToolsRunningWithTimeout(ctx context.Context, vm *object.VirtualMachine) error {
+func WaitForToolsRunningWithTimeout(ctx context.Context, vm VM) error {
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 			var o mo.VirtualMachine
-			if err := vm.Properties(ctx, vm.Reference(), []string{"guest.toolsRunningStatus"}, &o); err != nil {
+			if err := vm.Properties(ctx, []string{"guest.toolsRunningStatus"}, &o); err != nil {
 				return err
 			}
 			if o.Guest != nil && o.Guest.ToolsRunningStatus == "guestToolsRunning" {
@@ -151,16 +161,16 @@ func WaitForToolsRunningWithTimeout(ctx context.Context, vm *object.VirtualMachi
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(5 * time.Second):
+			case <-time.After(timeScaleOf(vm).scaled(5 * time.Second)):
 			}
 		}
 	}
 }
 
 // GetDiskFreeGB fetches free disk space in GB for a specific drive
-func GetDiskFreeGB(ctx context.Context, vm *object.VirtualMachine, drive string) (int64, error) {
+func GetDiskFreeGB(ctx context.Context, vm VM, drive string) (int64, error) {
 	var o mo.VirtualMachine
-	if err := vm.Properties(ctx, vm.Reference(), []string{"guest.disk"}, &o); err != nil {
+	if err := vm.Properties(ctx, []string{"guest.disk"}, &o); err != nil {
 		return 0, err
 	}
 	if o.Guest == nil || o.Guest.Disk == nil {
@@ -0,0 +1,78 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+)
+
+// ErrWaveHalted is reported for a VM that was not started because the
+// rollout halted after an earlier wave or stage
+var ErrWaveHalted = errors.New("rollout halted")
+
+// defaultWaveGrowth is how much each wave grows when no factor is set
+const defaultWaveGrowth = 2
+
+// ValidateWaves checks the wave settings before a run starts
+func ValidateWaves(cfg config.WaveConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch {
+	case cfg.CanarySize < 0:
+		return fmt.Errorf("negative canary_size")
+	case cfg.GrowthFactor < 0:
+		return fmt.Errorf("negative growth_factor")
+	case cfg.MaxWaveSize < 0:
+		return fmt.Errorf("negative max_wave_size")
+	case cfg.MaxFailures < 0:
+		return fmt.Errorf("negative max_failures")
+	case cfg.MaxFailurePercent < 0 || cfg.MaxFailurePercent > 100:
+		return fmt.Errorf("max_failure_percent must be 0-100, not %d", cfg.MaxFailurePercent)
+	}
+	return nil
+}
+
+// PlanWaves splits the VMs of a run into rollout waves, in the order given.
+// The first wave is the canary group and every following wave is the
+// growth factor times the previous, up to the largest wave size. Without
+// waves the whole run is one wave.
+func PlanWaves(names []string, cfg config.WaveConfig) [][]string {
+	if len(names) == 0 {
+		return nil
+	}
+	if !cfg.Enabled {
+		return [][]string{names}
+	}
+
+	size := max(cfg.CanarySize, 1)
+	growth := cfg.GrowthFactor
+	if growth == 0 {
+		growth = defaultWaveGrowth
+	}
+
+	var waves [][]string
+	for rest := names; len(rest) > 0; size *= growth {
+		if cfg.MaxWaveSize > 0 && size > cfg.MaxWaveSize {
+			size = cfg.MaxWaveSize
+		}
+		n := min(size, len(rest))
+		waves = append(waves, rest[:n])
+		rest = rest[n:]
+	}
+	return waves
+}
+
+// CheckWave is the circuit breaker between waves. It returns an error
+// wrapping ErrWaveHalted when failed of the attempted VMs in a wave is more
+// than the settings allow. Cancelled VMs are not counted as attempted.
+func CheckWave(cfg config.WaveConfig, failed, attempted int) error {
+	if failed > cfg.MaxFailures {
+		return fmt.Errorf("%w: %d of %d VMs failed, %d allowed", ErrWaveHalted, failed, attempted, cfg.MaxFailures)
+	}
+	if cfg.MaxFailurePercent > 0 && attempted > 0 && failed*100 > cfg.MaxFailurePercent*attempted {
+		return fmt.Errorf("%w: %d%% of the wave failed, %d%% allowed", ErrWaveHalted, failed*100/attempted, cfg.MaxFailurePercent)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+)
+
+func TestPlanWaves(t *testing.T) {
+	names := func(n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = fmt.Sprintf("srv%02d", i+1)
+		}
+		return out
+	}
+	// sizes returns the number of VMs in each wave
+	sizes := func(waves [][]string) []int {
+		var out []int
+		for _, w := range waves {
+			out = append(out, len(w))
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		vms  int
+		cfg  config.WaveConfig
+		want []int
+	}{
+		{name: "disabled", vms: 10, cfg: config.WaveConfig{CanarySize: 2}, want: []int{10}},
+		{name: "no VMs", vms: 0, cfg: config.WaveConfig{Enabled: true}, want: nil},
+		{name: "default canary and growth", vms: 10, cfg: config.WaveConfig{Enabled: true}, want: []int{1, 2, 4, 3}},
+		{name: "canary", vms: 10, cfg: config.WaveConfig{Enabled: true, CanarySize: 3}, want: []int{3, 6, 1}},
+		{name: "growth", vms: 20, cfg: config.WaveConfig{Enabled: true, CanarySize: 1, GrowthFactor: 3}, want: []int{1, 3, 9, 7}},
+		{name: "no growth", vms: 5, cfg: config.WaveConfig{Enabled: true, CanarySize: 2, GrowthFactor: 1}, want: []int{2, 2, 1}},
+		{name: "max size", vms: 20, cfg: config.WaveConfig{Enabled: true, CanarySize: 2, MaxWaveSize: 5}, want: []int{2, 4, 5, 5, 4}},
+		{name: "canary above max size", vms: 7, cfg: config.WaveConfig{Enabled: true, CanarySize: 4, MaxWaveSize: 3}, want: []int{3, 3, 1}},
+		{name: "canary covers all", vms: 3, cfg: config.WaveConfig{Enabled: true, CanarySize: 5}, want: []int{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vms := names(tt.vms)
+			waves := PlanWaves(vms, tt.cfg)
+			if got := sizes(waves); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("wave sizes %v, want %v", got, tt.want)
+			}
+			// Every VM once, in the order given
+			var all []string
+			for _, w := range waves {
+				all = append(all, w...)
+			}
+			if len(vms) > 0 && !reflect.DeepEqual(all, vms) {
+				t.Errorf("waves %v, want the VMs in order", waves)
+			}
+		})
+	}
+}
+
+func TestCheckWave(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.WaveConfig
+		failed    int
+		attempted int
+		halt      bool
+	}{
+		{name: "no failures", cfg: config.WaveConfig{}, failed: 0, attempted: 5},
+		{name: "one failure, none allowed", cfg: config.WaveConfig{}, failed: 1, attempted: 5, halt: true},
+		{name: "failures within number", cfg: config.WaveConfig{MaxFailures: 2}, failed: 2, attempted: 5},
+		{name: "failures above number", cfg: config.WaveConfig{MaxFailures: 2}, failed: 3, attempted: 5, halt: true},
+		{name: "within percentage", cfg: config.WaveConfig{MaxFailures: 10, MaxFailurePercent: 25}, failed: 1, attempted: 4},
+		{name: "above percentage", cfg: config.WaveConfig{MaxFailures: 10, MaxFailurePercent: 25}, failed: 2, attempted: 4, halt: true},
+		// One of the four VMs was cancelled: one failure of three is a third
+		{name: "cancelled VMs not attempted", cfg: config.WaveConfig{MaxFailures: 10, MaxFailurePercent: 25}, failed: 1, attempted: 3, halt: true},
+		{name: "all cancelled", cfg: config.WaveConfig{MaxFailurePercent: 25}, failed: 0, attempted: 0},
+		{name: "number before percentage", cfg: config.WaveConfig{MaxFailures: 1, MaxFailurePercent: 50}, failed: 2, attempted: 10, halt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckWave(tt.cfg, tt.failed, tt.attempted)
+			if tt.halt && !errors.Is(err, ErrWaveHalted) {
+				t.Errorf("error %v, want %v", err, ErrWaveHalted)
+			}
+			if !tt.halt && err != nil {
+				t.Errorf("error %v, want none", err)
+			}
+		})
+	}
+}
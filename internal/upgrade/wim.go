@@ -0,0 +1,113 @@
+package upgrade
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+)
+
+// WIM header layout (WIMHEADER_V1_PACKED). The XML data resource header is
+// at offset 72: 7 bytes size, 1 byte flags, 8 bytes offset, 8 bytes size.
+const (
+	wimHeaderSize         = 208
+	wimXMLResource        = 72
+	wimResourceCompressed = 0x04
+	wimMaxXMLSize         = 16 << 20
+)
+
+var wimMagic = []byte("MSWIM\x00\x00\x00")
+
+// wimXML is the part of the WIM XML metadata that describes the images
+type wimXML struct {
+	Images []struct {
+		Index       string `xml:"INDEX,attr"`
+		Name        string `xml:"NAME"`
+		DisplayName string `xml:"DISPLAYNAME"`
+		Windows     struct {
+			EditionID        string `xml:"EDITIONID"`
+			InstallationType string `xml:"INSTALLATIONTYPE"`
+			Languages        struct {
+				Language []string `xml:"LANGUAGE"`
+				Default  string   `xml:"DEFAULT"`
+			} `xml:"LANGUAGES"`
+			Version struct {
+				Major   int `xml:"MAJOR"`
+				Minor   int `xml:"MINOR"`
+				Build   int `xml:"BUILD"`
+				SPBuild int `xml:"SPBUILD"`
+			} `xml:"VERSION"`
+		} `xml:"WINDOWS"`
+	} `xml:"IMAGE"`
+}
+
+// readWIMXML reads the XML metadata of a WIM (or ESD) file on the image
+func readWIMXML(r io.ReaderAt, f *isoFile) ([]byte, error) {
+	h := make([]byte, wimHeaderSize)
+	if _, err := f.readAt(r, h, 0); err != nil {
+		return nil, fmt.Errorf("read WIM header: %w", err)
+	}
+	if !bytes.Equal(h[:8], wimMagic) {
+		return nil, errors.New("not a WIM file")
+	}
+
+	size := int64(le.Uint64(h[wimXMLResource:]) & 0x00FFFFFFFFFFFFFF)
+	flags := h[wimXMLResource+7]
+	offset := int64(le.Uint64(h[wimXMLResource+8:]))
+	if flags&wimResourceCompressed != 0 {
+		return nil, errors.New("compressed WIM XML metadata is not supported")
+	}
+	if size <= 0 || size > wimMaxXMLSize {
+		return nil, fmt.Errorf("unexpected WIM XML size %d", size)
+	}
+	if offset < wimHeaderSize || offset > f.size-size {
+		return nil, fmt.Errorf("WIM XML at %d is outside the file", offset)
+	}
+
+	data := make([]byte, size)
+	if _, err := f.readAt(r, data, offset); err != nil {
+		return nil, fmt.Errorf("read WIM XML: %w", err)
+	}
+	return data, nil
+}
+
+// parseWIMXML decodes the UTF-16LE XML metadata into the images on the ISO
+func parseWIMXML(data []byte) ([]ISOImage, error) {
+	data = bytes.TrimPrefix(data, []byte{0xFF, 0xFE})
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		u = append(u, le.Uint16(data[i:]))
+	}
+
+	var doc wimXML
+	if err := xml.Unmarshal([]byte(string(utf16.Decode(u))), &doc); err != nil {
+		return nil, fmt.Errorf("parse WIM XML: %w", err)
+	}
+
+	var images []ISOImage
+	for _, img := range doc.Images {
+		index, err := strconv.Atoi(img.Index)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WIM image index %q", img.Index)
+		}
+		v := img.Windows.Version
+		images = append(images, ISOImage{
+			Index:            index,
+			Name:             img.Name,
+			DisplayName:      img.DisplayName,
+			EditionID:        img.Windows.EditionID,
+			InstallationType: img.Windows.InstallationType,
+			Version:          fmt.Sprintf("%d.%d.%d.%d", v.Major, v.Minor, v.Build, v.SPBuild),
+			Build:            v.Build,
+			Languages:        img.Windows.Languages.Language,
+			DefaultLanguage:  img.Windows.Languages.Default,
+		})
+	}
+	if len(images) == 0 {
+		return nil, errors.New("WIM XML lists no images")
+	}
+	return images, nil
+}
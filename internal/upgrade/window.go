@@ -0,0 +1,91 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrWindowClosed is reported for a VM that was not started because the
+// run's maintenance window had closed
+var ErrWindowClosed = errors.New("maintenance window closed")
+
+// WindowTimeFormat is how schedule times are written and read
+const WindowTimeFormat = "2006-01-02 15:04"
+
+// MaintenanceWindow is when a run may start upgrading VMs. A zero Start
+// starts at once, a zero End never closes. VMs already upgrading when the
+// window closes are finished, only new VMs are held back.
+type MaintenanceWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ParseWindowTime reads a schedule time as "2006-01-02 15:04" or as "15:04",
+// the first time that clock time comes after the given time
+func ParseWindowTime(s string, after time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.ParseInLocation(WindowTimeFormat, s, time.Local); err == nil {
+		return t, nil
+	}
+	clock, err := time.ParseInLocation("15:04", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use \"YYYY-MM-DD HH:MM\" or \"HH:MM\"", s)
+	}
+	t := time.Date(after.Year(), after.Month(), after.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+	if !t.After(after) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Validate checks that the window can still start VMs at now
+func (w MaintenanceWindow) Validate(now time.Time) error {
+	if w.End.IsZero() {
+		return nil
+	}
+	if !w.Start.IsZero() && !w.End.After(w.Start) {
+		return fmt.Errorf("window end %s is not after the start %s", w.End.Format(WindowTimeFormat), w.Start.Format(WindowTimeFormat))
+	}
+	if !w.End.After(now) {
+		return fmt.Errorf("window end %s has already passed", w.End.Format(WindowTimeFormat))
+	}
+	return nil
+}
+
+// Scheduled reports whether the window starts later than now
+func (w MaintenanceWindow) Scheduled(now time.Time) bool {
+	return !w.Start.IsZero() && w.Start.After(now)
+}
+
+// Closed reports whether no new VM may start at t
+func (w MaintenanceWindow) Closed(t time.Time) bool {
+	return !w.End.IsZero() && !t.Before(w.End)
+}
+
+// WaitStart waits until the window opens. tick is called about once a
+// second with the time left, e.g. for a countdown; stop ends the wait early
+// when it returns true. It returns false if the wait was stopped.
+func (w MaintenanceWindow) WaitStart(ctx context.Context, tick func(left time.Duration), stop func() bool) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		left := time.Until(w.Start)
+		if left <= 0 {
+			return true
+		}
+		if stop != nil && stop() {
+			return false
+		}
+		if tick != nil {
+			tick(left)
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
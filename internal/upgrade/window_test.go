@@ -0,0 +1,112 @@
+package upgrade
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindowTime(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.March, day, hour, minute, 0, 0, time.Local)
+	}
+	after := at(10, 14, 30)
+
+	tests := []struct {
+		name    string
+		in      string
+		after   time.Time // default 2026-03-10 14:30
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "date and time", in: "2026-03-12 22:00", want: at(12, 22, 0)},
+		{name: "date and time in the past", in: "2026-03-01 22:00", want: at(1, 22, 0)},
+		{name: "later today", in: "22:00", want: at(10, 22, 0)},
+		{name: "earlier rolls over to tomorrow", in: "02:00", want: at(11, 2, 0)},
+		{name: "now rolls over to tomorrow", in: "14:30", want: at(11, 14, 30)},
+		// The end of a window is read after its start, 22:00 to 06:00 ends
+		// the next morning
+		{name: "end after an evening start", in: "06:00", after: at(10, 22, 0), want: at(11, 6, 0)},
+		{name: "surrounding spaces", in: "  23:15 ", want: at(10, 23, 15)},
+		{name: "invalid", in: "tonight", wantErr: true},
+		{name: "invalid clock", in: "25:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := tt.after
+			if from.IsZero() {
+				from = after
+			}
+			got, err := ParseWindowTime(tt.in, from)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowValidate(t *testing.T) {
+	now := time.Date(2026, time.March, 10, 14, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		window  MaintenanceWindow
+		wantErr string
+	}{
+		{name: "no window"},
+		{name: "start only", window: MaintenanceWindow{Start: now.Add(time.Hour)}},
+		{name: "end only", window: MaintenanceWindow{End: now.Add(time.Hour)}},
+		{name: "start and end", window: MaintenanceWindow{Start: now.Add(time.Hour), End: now.Add(3 * time.Hour)}},
+		{name: "end at the start", window: MaintenanceWindow{Start: now.Add(time.Hour), End: now.Add(time.Hour)}, wantErr: "is not after the start"},
+		{name: "end before the start", window: MaintenanceWindow{Start: now.Add(2 * time.Hour), End: now.Add(time.Hour)}, wantErr: "is not after the start"},
+		{name: "end passed", window: MaintenanceWindow{End: now.Add(-time.Minute)}, wantErr: "has already passed"},
+		{name: "end now", window: MaintenanceWindow{End: now}, wantErr: "has already passed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.window.Validate(now)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("error %v, want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowClosed(t *testing.T) {
+	end := time.Date(2026, time.March, 11, 6, 0, 0, 0, time.Local)
+	w := MaintenanceWindow{End: end}
+
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		at     time.Time
+		want   bool
+	}{
+		{name: "before the end", window: w, at: end.Add(-time.Minute)},
+		{name: "at the end", window: w, at: end, want: true},
+		{name: "after the end", window: w, at: end.Add(time.Minute), want: true},
+		{name: "no end", at: end.Add(24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Closed(tt.at); got != tt.want {
+				t.Errorf("closed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
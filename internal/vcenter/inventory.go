@@ -28,11 +28,12 @@ func GetVMInfos() ([]VMInfo, error) {
 	defer v.Destroy(ctx)
 
 	var vms []mo.VirtualMachine
-	if err := v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"name", "guest", "parent"}, &vms); err != nil {
+	if err := v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"name", "guest", "parent", "runtime.host", "datastore"}, &vms); err != nil {
 		return nil, fmt.Errorf("retrieve: %w", err)
 	}
 
 	pc := property.DefaultCollector(c)
+	placement := newPlacementResolver(pc)
 	out := make([]VMInfo, 0, len(vms))
 	for _, vm := range vms {
 		if vm.Name == "" {
@@ -40,6 +41,7 @@ func GetVMInfos() ([]VMInfo, error) {
 		}
 		osName := "Unknown"
 		domain := ""
+		ip := ""
 		if vm.Guest != nil {
 			if vm.Guest.GuestFullName != "" {
 				osName = vm.Guest.GuestFullName
@@ -47,6 +49,7 @@ func GetVMInfos() ([]VMInfo, error) {
 			if vm.Guest.HostName != "" {
 				domain = vm.Guest.HostName
 			}
+			ip = vm.Guest.IpAddress
 		}
 		folder := "/"
 		if vm.Parent != nil {
@@ -54,12 +57,70 @@ func GetVMInfos() ([]VMInfo, error) {
 				folder = fp
 			}
 		}
-		out = append(out, VMInfo{Name: vm.Name, Folder: folder, OS: osName, Domain: domain, Ref: vm.Reference()})
+		info := VMInfo{Name: vm.Name, Folder: folder, OS: osName, Domain: domain, IP: ip, Ref: vm.Reference()}
+		if vm.Runtime.Host != nil {
+			info.Host, info.Cluster = placement.host(ctx, *vm.Runtime.Host)
+		}
+		for _, ds := range vm.Datastore {
+			if name := placement.datastore(ctx, ds); name != "" {
+				info.Datastores = append(info.Datastores, name)
+			}
+		}
+		out = append(out, info)
 	}
 	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	return out, nil
 }
 
+// placementResolver looks up host, cluster and datastore names once per
+// object, many VMs share them
+type placementResolver struct {
+	pc         *property.Collector
+	hosts      map[types.ManagedObjectReference][2]string // host → name, cluster
+	datastores map[types.ManagedObjectReference]string
+}
+
+func newPlacementResolver(pc *property.Collector) *placementResolver {
+	return &placementResolver{
+		pc:         pc,
+		hosts:      make(map[types.ManagedObjectReference][2]string),
+		datastores: make(map[types.ManagedObjectReference]string),
+	}
+}
+
+// host returns the name of a host and of its cluster, if it is in one
+func (p *placementResolver) host(ctx context.Context, ref types.ManagedObjectReference) (string, string) {
+	if cached, ok := p.hosts[ref]; ok {
+		return cached[0], cached[1]
+	}
+	var host mo.ManagedEntity
+	if err := p.pc.RetrieveOne(ctx, ref, []string{"name", "parent"}, &host); err != nil {
+		return "", ""
+	}
+	cluster := ""
+	if host.Parent != nil && host.Parent.Type == "ClusterComputeResource" {
+		var cr mo.ManagedEntity
+		if err := p.pc.RetrieveOne(ctx, *host.Parent, []string{"name"}, &cr); err == nil {
+			cluster = cr.Name
+		}
+	}
+	p.hosts[ref] = [2]string{host.Name, cluster}
+	return host.Name, cluster
+}
+
+// datastore returns the name of a datastore
+func (p *placementResolver) datastore(ctx context.Context, ref types.ManagedObjectReference) string {
+	if name, ok := p.datastores[ref]; ok {
+		return name
+	}
+	var ds mo.ManagedEntity
+	if err := p.pc.RetrieveOne(ctx, ref, []string{"name"}, &ds); err != nil {
+		return ""
+	}
+	p.datastores[ref] = ds.Name
+	return ds.Name
+}
+
 // folderPath builds full path by traversing up the tree
 func folderPath(ctx context.Context, pc *property.Collector, ref types.ManagedObjectReference) (string, error) {
 	var segments []string
@@ -0,0 +1,272 @@
+package vcenter
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/skabbio1976/osupgrader-gui/internal/config"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// SimulatorUser is the vCenter user of the simulator
+const SimulatorUser = "administrator@vsphere.local"
+
+// simulatorDatastores are the datastores of the simulated inventory. The
+// first one holds the ISOs of the default profiles.
+var simulatorDatastores = []string{"datastore1", "datastore2"}
+
+// simulatorFolders are the VM folders the simulated VMs are spread over
+var simulatorFolders = []string{"Production/WebServers", "Production/DatabaseServers", "Development/TestServers", "Staging/AppServers"}
+
+// simulatorDomains are deliberately long to exercise the VM list layout
+var simulatorDomains = []string{
+	"verylong.production.subdomain.corporate.infrastructure.example.com",
+	"extremelylong.development.testing.integration.environment.example.com",
+	"superlongname.staging.application.deployment.infrastructure.example.com",
+	"incrediblylong.corporate.business.application.management.example.com",
+}
+
+// simulatorOS is a source OS of the simulated VMs. 2012 R2 is not an
+// allowed source of the default profiles.
+type simulatorOS struct {
+	fullName string
+	build    int
+}
+
+var simulatorOSes = []simulatorOS{
+	{"Microsoft Windows Server 2016 (64-bit)", 14393},
+	{"Microsoft Windows Server 2019 (64-bit)", 17763},
+	{"Microsoft Windows Server 2012 R2 (64-bit)", 9600},
+}
+
+// Simulator is govmomi's embedded vCenter simulator (vcsim) with a
+// generated inventory: VM folders, local datastores backed by a temp
+// directory and powered-on Windows VMs with a CD-ROM and VMware Tools
+// reported running. Guest operations are not simulated.
+type Simulator struct {
+	model    *simulator.Model
+	server   *simulator.Server
+	password string
+	dsDirs   map[string]string // datastore name → local directory
+}
+
+// StartSimulator starts the simulator on a local port with vms VMs named
+// srv001, srv002, ...
+func StartSimulator(vms int) (*Simulator, error) {
+	m := simulator.VPX()
+	m.Host = 0
+	m.ClusterHost = 3
+	m.Datastore = 0
+	m.Machine = 0
+	if err := m.Create(); err != nil {
+		return nil, fmt.Errorf("create simulator model: %w", err)
+	}
+
+	s := &Simulator{model: m, password: randomID(), dsDirs: make(map[string]string)}
+	m.Service.TLS = new(tls.Config)
+	m.Service.Listen = &url.URL{User: url.UserPassword(SimulatorUser, s.password)}
+	s.server = m.Service.NewServer()
+
+	if err := s.populate(vms); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Config returns the vCenter settings that log in to the simulator
+func (s *Simulator) Config() config.VCenterConfig {
+	return config.VCenterConfig{Host: s.server.URL.Host, Username: SimulatorUser, Insecure: true, Mode: "password"}
+}
+
+// Password returns the password of SimulatorUser
+func (s *Simulator) Password() string {
+	return s.password
+}
+
+// datastorePathPattern matches "[datastore] path/file"
+var datastorePathPattern = regexp.MustCompile(`^\[([^\]]+)\]\s*(.+)$`)
+
+// WriteDatastoreFile writes data to a datastore path such as
+// "[datastore1] iso/windows-server-2022.iso"
+func (s *Simulator) WriteDatastoreFile(dsPath string, data []byte) error {
+	m := datastorePathPattern.FindStringSubmatch(strings.TrimSpace(dsPath))
+	if m == nil {
+		return fmt.Errorf("invalid datastore path %q", dsPath)
+	}
+	dir, ok := s.dsDirs[strings.ToLower(m[1])]
+	if !ok {
+		return fmt.Errorf("datastore '%s' not found in simulator", m[1])
+	}
+	p := filepath.Join(dir, filepath.FromSlash(strings.TrimLeft(m[2], "/")))
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0600)
+}
+
+// Close stops the simulator and removes its datastore directories
+func (s *Simulator) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+	s.model.Remove()
+	for _, dir := range s.dsDirs {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// populate creates the datastores, folders and VMs through the simulator's
+// own API, the same way they would be created in vCenter
+func (s *Simulator) populate(vms int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	c, err := vim25.NewClient(ctx, soap.NewClient(s.server.URL, true))
+	if err != nil {
+		return fmt.Errorf("simulator client: %w", err)
+	}
+	if err := session.NewManager(c).Login(ctx, s.server.URL.User); err != nil {
+		return fmt.Errorf("simulator login: %w", err)
+	}
+	finder := find.NewFinder(c)
+	dc, err := finder.DefaultDatacenter(ctx)
+	if err != nil {
+		return err
+	}
+	finder.SetDatacenter(dc)
+
+	hosts, err := finder.HostSystemList(ctx, "*")
+	if err != nil {
+		return err
+	}
+	for _, name := range simulatorDatastores {
+		dir, err := os.MkdirTemp("", "osupgrader-sim-"+name+"-")
+		if err != nil {
+			return err
+		}
+		s.dsDirs[name] = dir
+		for _, host := range hosts {
+			dss, err := host.ConfigManager().DatastoreSystem(ctx)
+			if err != nil {
+				return err
+			}
+			if _, err := dss.CreateLocalDatastore(ctx, name, dir); err != nil {
+				return fmt.Errorf("create datastore %s: %w", name, err)
+			}
+		}
+	}
+
+	dcFolders, err := dc.Folders(ctx)
+	if err != nil {
+		return err
+	}
+	folders := make(map[string]*object.Folder)
+	for _, p := range simulatorFolders {
+		parent := dcFolders.VmFolder
+		for i, name := range strings.Split(p, "/") {
+			key := strings.Join(strings.Split(p, "/")[:i+1], "/")
+			f, ok := folders[key]
+			if !ok {
+				if f, err = parent.CreateFolder(ctx, name); err != nil {
+					return fmt.Errorf("create folder %s: %w", key, err)
+				}
+				folders[key] = f
+			}
+			parent = f
+		}
+	}
+
+	pool, err := finder.DefaultResourcePool(ctx)
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= vms; i++ {
+		name := fmt.Sprintf("srv%03d", i)
+		if err := s.createVM(ctx, c, folders[simulatorFolders[i%len(simulatorFolders)]], pool, name, i); err != nil {
+			return fmt.Errorf("create VM %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// createVM creates a powered-on Windows VM with a CD-ROM. The guest
+// properties VMware Tools would report are set with vcsim's SET.* extra
+// config keys.
+func (s *Simulator) createVM(ctx context.Context, c *vim25.Client, folder *object.Folder, pool *object.ResourcePool, name string, i int) error {
+	guestOS := simulatorOSes[i%len(simulatorOSes)]
+	ds := simulatorDatastores[i%len(simulatorDatastores)]
+	spec := types.VirtualMachineConfigSpec{
+		Name:     name,
+		GuestId:  "windows9Server64Guest",
+		NumCPUs:  2,
+		MemoryMB: 8192,
+		Files:    &types.VirtualMachineFileInfo{VmPathName: fmt.Sprintf("[%s]", ds)},
+	}
+	task, err := folder.CreateVM(ctx, spec, pool, nil)
+	if err != nil {
+		return err
+	}
+	info, err := task.WaitForResult(ctx)
+	if err != nil {
+		return err
+	}
+	vm := object.NewVirtualMachine(c, info.Result.(types.ManagedObjectReference))
+
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		return err
+	}
+	ide, err := devices.FindIDEController("")
+	if err != nil {
+		return err
+	}
+	cdrom, err := devices.CreateCdrom(ide)
+	if err != nil {
+		return err
+	}
+	if err := vm.AddDevice(ctx, cdrom); err != nil {
+		return fmt.Errorf("add CD-ROM: %w", err)
+	}
+
+	const gib = 1024 * 1024 * 1024
+	guest := types.VirtualMachineConfigSpec{ExtraConfig: []types.BaseOptionValue{
+		&types.OptionValue{Key: "SET.guest.guestFullName", Value: guestOS.fullName},
+		&types.OptionValue{Key: "SET.guest.hostName", Value: name + "." + simulatorDomains[i%len(simulatorDomains)]},
+		&types.OptionValue{Key: "SET.guest.ipAddress", Value: fmt.Sprintf("10.20.%d.%d", 30+i/200, 10+i%200)},
+		&types.OptionValue{Key: "SET.guest.toolsRunningStatus", Value: string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)},
+		&types.OptionValue{Key: "SET.guest.toolsStatus", Value: string(types.VirtualMachineToolsStatusToolsOk)},
+		&types.OptionValue{Key: "SET.guest.disk", Value: types.ArrayOfGuestDiskInfo{GuestDiskInfo: []types.GuestDiskInfo{
+			{DiskPath: `C:\`, Capacity: 127 * gib, FreeSpace: int64(40+i%60) * gib},
+		}}},
+		&types.OptionValue{Key: "guestInfo.detailed.data", Value: fmt.Sprintf(
+			"architecture='X86' bitness='64' buildNumber='%d' distroName='Windows' familyName='Windows' prettyName='%s'",
+			guestOS.build, strings.TrimSuffix(guestOS.fullName, " (64-bit)"))},
+	}}
+	task, err = vm.Reconfigure(ctx, guest)
+	if err != nil {
+		return err
+	}
+	if err := task.Wait(ctx); err != nil {
+		return fmt.Errorf("set guest properties: %w", err)
+	}
+
+	task, err = vm.PowerOn(ctx)
+	if err != nil {
+		return err
+	}
+	return task.Wait(ctx)
+}
@@ -75,3 +75,22 @@ func RemoveSnapshot(ctx context.Context, c *vim25.Client, snapRef types.ManagedO
 	task := object.NewTask(c, res.Returnval)
 	return task.Wait(ctx)
 }
+
+// RevertToSnapshot reverts a VM to a snapshot. A snapshot without memory
+// leaves the VM powered off.
+func RevertToSnapshot(ctx context.Context, c *vim25.Client, snapRef types.ManagedObjectReference) error {
+	if c == nil {
+		return errors.New("no active client")
+	}
+
+	req := &types.RevertToSnapshot_Task{This: snapRef}
+	res, err := methods.RevertToSnapshot_Task(ctx, c, req)
+	if err != nil {
+		return fmt.Errorf("RevertToSnapshot_Task: %w", err)
+	}
+	if res == nil || res.Returnval.Type == "" {
+		return fmt.Errorf("empty response from RevertToSnapshot_Task")
+	}
+	task := object.NewTask(c, res.Returnval)
+	return task.Wait(ctx)
+}
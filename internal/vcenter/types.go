@@ -10,7 +10,13 @@ type VMInfo struct {
 	Folder string
 	OS     string
 	Domain string
+	IP     string
 	Ref    types.ManagedObjectReference
+
+	// Placering, för gränserna per host, datastore och kluster
+	Host       string   // ESXi-hosten VM:en körs på
+	Cluster    string   // hostens kluster, tomt för fristående hostar
+	Datastores []string // datastores med VM:ens filer och diskar
 }
 
 // SnapshotEntry representerar en snapshot